@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/docker/distribution/registry/client"
+)
+
+var (
+	commandExport = cli.Command{
+		Name:   "export",
+		Usage:  "Export an image from a registry to an image layout directory or tar archive",
+		Action: imageExport,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "r,registry",
+				Value: "hub.docker.io",
+				Usage: "Registry to use (e.g.: localhost:5000)",
+			},
+			cli.StringFlag{
+				Name:  "o,output",
+				Usage: "Image layout directory, or tar archive if ending in .tar",
+			},
+		},
+	}
+)
+
+func imageExport(c *cli.Context) {
+	if len(c.Args()) != 1 || c.String("output") == "" {
+		log.Fatalln("usage: dist export -o <dir|file.tar> <name:tag>")
+	}
+
+	if err := exportImage(c.String("registry"), c.Args().First(), c.String("output")); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// exportImage pulls the referenced image from the registry into the image
+// layout at output, which is written as a tar archive if it ends in ".tar".
+func exportImage(registry, reference, output string) error {
+	name, tag, err := parseImageReference(reference)
+	if err != nil {
+		return err
+	}
+
+	c, err := newClient(registry)
+	if err != nil {
+		return err
+	}
+
+	dir := output
+	archive := strings.HasSuffix(output, ".tar")
+	if archive {
+		dir, err = ioutil.TempDir("", "dist-export")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	store, err := client.NewLayoutObjectStore(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := client.Pull(c, store, name, tag); err != nil {
+		return fmt.Errorf("error exporting %s: %v", reference, err)
+	}
+
+	if archive {
+		if err := writeLayoutArchive(dir, output); err != nil {
+			return fmt.Errorf("error writing %s: %v", output, err)
+		}
+	}
+
+	log.Infof("exported %s to %s", reference, output)
+	return nil
+}
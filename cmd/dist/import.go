@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/docker/distribution/registry/client"
+)
+
+var (
+	commandImport = cli.Command{
+		Name:   "import",
+		Usage:  "Import images from an image layout directory or tar archive to a registry",
+		Action: imageImport,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "r,registry",
+				Value: "hub.docker.io",
+				Usage: "Registry to use (e.g.: localhost:5000)",
+			},
+		},
+	}
+)
+
+func imageImport(c *cli.Context) {
+	if len(c.Args()) < 1 {
+		log.Fatalln("usage: dist import <dir|file.tar> [name:tag...]")
+	}
+
+	if err := importImages(c.String("registry"), c.Args().First(), c.Args().Tail()); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// importImages pushes the images of the image layout at input, a directory
+// or tar archive, to the registry. If references are given, only the images
+// they name are imported.
+func importImages(registry, input string, references []string) error {
+	selected := make(map[string]bool)
+	for _, reference := range references {
+		name, tag, err := parseImageReference(reference)
+		if err != nil {
+			return err
+		}
+
+		selected[name+":"+tag] = true
+	}
+
+	c, err := newClient(registry)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(input)
+	if err != nil {
+		return err
+	}
+
+	dir := input
+	if !fi.IsDir() {
+		dir, err = ioutil.TempDir("", "dist-import")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		if err := readLayoutArchive(input, dir); err != nil {
+			return fmt.Errorf("error reading %s: %v", input, err)
+		}
+	}
+
+	store, err := client.NewLayoutObjectStore(dir)
+	if err != nil {
+		return err
+	}
+
+	images, err := store.Images()
+	if err != nil {
+		return err
+	}
+
+	imported := 0
+	for _, image := range images {
+		reference := image.Name + ":" + image.Tag
+		if len(selected) > 0 && !selected[reference] {
+			continue
+		}
+
+		if err := client.Push(c, store, image.Name, image.Tag); err != nil {
+			return fmt.Errorf("error importing %s: %v", reference, err)
+		}
+
+		log.Infof("imported %s", reference)
+		imported++
+	}
+
+	if imported < len(selected) {
+		return fmt.Errorf("only %d of %d requested images found in %s", imported, len(selected), input)
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/client"
+)
+
+// tagAnchoredRegexp matches a complete tag.
+var tagAnchoredRegexp = regexp.MustCompile(`^` + v2.TagNameRegexp.String() + `$`)
+
+// newClient returns a client for the registry, assuming https if the
+// registry is given without a scheme.
+func newClient(registry string) (client.Client, error) {
+	if !strings.Contains(registry, "://") {
+		registry = "https://" + registry
+	}
+
+	return client.New(registry)
+}
+
+// parseImageReference splits a reference of the form "name:tag" into its
+// components, validating both.
+func parseImageReference(reference string) (string, string, error) {
+	i := strings.LastIndex(reference, ":")
+	if i < 0 || strings.Contains(reference[i:], "/") {
+		return "", "", fmt.Errorf("invalid image reference %q: expected name:tag", reference)
+	}
+
+	name, tag := reference[:i], reference[i+1:]
+	if err := v2.ValidateRespositoryName(name); err != nil {
+		return "", "", fmt.Errorf("invalid image reference %q: %v", reference, err)
+	}
+
+	if !tagAnchoredRegexp.MatchString(tag) {
+		return "", "", fmt.Errorf("invalid image reference %q: invalid tag", reference)
+	}
+
+	return name, tag, nil
+}
+
+// writeLayoutArchive writes the files of the layout directory to a tar
+// archive at filename.
+func writeLayoutArchive(dir, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// readLayoutArchive extracts the tar archive at filename into the layout
+// directory. Entries escaping the directory are rejected.
+func readLayoutArchive(filename, dir string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path in layout archive: %q", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+
+			dst, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+
+			if _, err := io.Copy(dst, tr); err != nil {
+				dst.Close()
+				return err
+			}
+
+			if err := dst.Close(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported entry in layout archive: %q", hdr.Name)
+		}
+	}
+}
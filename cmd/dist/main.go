@@ -16,6 +16,8 @@ func main() {
 		commandList,
 		commandPull,
 		commandPush,
+		commandExport,
+		commandImport,
 	}
 	app.Run(os.Args)
 }
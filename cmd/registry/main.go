@@ -18,6 +18,7 @@ import (
 	"github.com/docker/distribution/configuration"
 	"github.com/docker/distribution/context"
 	_ "github.com/docker/distribution/health"
+	"github.com/docker/distribution/notifications"
 	_ "github.com/docker/distribution/registry/auth/silly"
 	_ "github.com/docker/distribution/registry/auth/token"
 	"github.com/docker/distribution/registry/handlers"
@@ -70,6 +71,7 @@ func main() {
 	if config.HTTP.TLS.Certificate == "" {
 		context.GetLogger(app).Infof("listening on %v", config.HTTP.Addr)
 		if err := http.ListenAndServe(config.HTTP.Addr, handler); err != nil {
+			app.Close()
 			context.GetLogger(app).Fatalln(err)
 		}
 	} else {
@@ -107,6 +109,7 @@ func main() {
 		}
 
 		if err := server.ListenAndServeTLS(config.HTTP.TLS.Certificate, config.HTTP.TLS.Key); err != nil {
+			app.Close()
 			context.GetLogger(app).Fatalln(err)
 		}
 	}
@@ -257,6 +260,8 @@ func logLevel(level configuration.Loglevel) log.Level {
 // endpoints. The addr should not be exposed externally. For most of these to
 // work, tls cannot be enabled on the endpoint, so it is generally separate.
 func debugServer(addr string) {
+	http.Handle("/debug/notifications/", notifications.DebugHandler())
+
 	log.Infof("debug server listening %v", addr)
 	if err := http.ListenAndServe(addr, nil); err != nil {
 		log.Fatalf("error listening on debug interface: %v", err)
@@ -8,6 +8,8 @@ import (
 	"reflect"
 	"strings"
 	"time"
+
+	"github.com/docker/distribution/glob"
 )
 
 // Configuration is a versioned registry configuration, intended to be provided by a yaml file, and
@@ -92,6 +94,29 @@ type Configuration struct {
 	// registry events are dispatched.
 	Notifications Notifications `yaml:"notifications,omitempty"`
 
+	// RateLimit configures per-user and per-address request rate limiting.
+	RateLimit RateLimit `yaml:"ratelimit,omitempty"`
+
+	// Quota configures storage quotas for repositories.
+	Quota Quota `yaml:"quota,omitempty"`
+
+	// Immutability configures tags which cannot be changed once pushed.
+	Immutability Immutability `yaml:"immutability,omitempty"`
+
+	// Trust configures the keys and certificate authorities manifest
+	// signatures are verified against.
+	Trust Trust `yaml:"trust,omitempty"`
+
+	// Signing configures the key the registry signs accepted manifests with.
+	Signing Signing `yaml:"signing,omitempty"`
+
+	// Digest configures the digests identifying layers.
+	Digest Digest `yaml:"digest,omitempty"`
+
+	// Audit configures the audit trail of authorization decisions and
+	// repository mutations.
+	Audit Audit `yaml:"audit,omitempty"`
+
 	// Redis configures the redis pool available to the registry webapp.
 	Redis struct {
 		// Addr specifies the the redis instance available to the application.
@@ -250,14 +275,21 @@ func (storage Storage) MarshalYAML() (interface{}, error) {
 	return map[string]Parameters(storage), nil
 }
 
-// Auth defines the configuration for registry authorization.
+// Auth defines the configuration for registry authorization. Besides the
+// access controller type, the map may contain an "anonymous" entry, granting
+// access without authentication. See AnonymousAccess.
 type Auth map[string]Parameters
 
-// Type returns the storage driver type, such as filesystem or s3
+// authAnonymous is the key of the anonymous access entry in Auth.
+const authAnonymous = "anonymous"
+
+// Type returns the access controller type, such as token or htpasswd
 func (auth Auth) Type() string {
-	// Return only key in this map
+	// Return only key in this map, other than anonymous access
 	for k := range auth {
-		return k
+		if k != authAnonymous {
+			return k
+		}
 	}
 	return ""
 }
@@ -272,23 +304,84 @@ func (auth Auth) setParameter(key string, value interface{}) {
 	auth[auth.Type()][key] = value
 }
 
+// AnonymousAccess grants actions on matching repositories to requests
+// without authentication. It applies to requests challenged by the access
+// controller.
+type AnonymousAccess struct {
+	// Actions lists the actions granted, such as "pull".
+	Actions []string
+
+	// Repositories is a list of glob patterns matched against the
+	// repository name. An empty list matches all repositories.
+	Repositories []string
+}
+
+// Anonymous returns the anonymous access configured, if any. An error is
+// returned if the "anonymous" entry is malformed.
+func (auth Auth) Anonymous() (anonymous AnonymousAccess, ok bool, err error) {
+	params, ok := auth[authAnonymous]
+	if !ok {
+		return anonymous, false, nil
+	}
+
+	for key, value := range params {
+		var list []string
+		switch value := value.(type) {
+		case []string:
+			list = value
+		case []interface{}:
+			for _, item := range value {
+				str, ok := item.(string)
+				if !ok {
+					return anonymous, false, fmt.Errorf("anonymous access %s must be a list of strings", key)
+				}
+				list = append(list, str)
+			}
+		default:
+			return anonymous, false, fmt.Errorf("anonymous access %s must be a list of strings", key)
+		}
+
+		switch key {
+		case "actions":
+			anonymous.Actions = list
+		case "repositories":
+			anonymous.Repositories = list
+		default:
+			return anonymous, false, fmt.Errorf("unknown anonymous access parameter %q", key)
+		}
+	}
+
+	if err := glob.Validate(anonymous.Repositories); err != nil {
+		return anonymous, false, fmt.Errorf("anonymous access repositories: %v", err)
+	}
+
+	return anonymous, true, nil
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface
 // Unmarshals a single item map into a Storage or a string into a Storage type with no parameters
 func (auth *Auth) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var m map[string]Parameters
 	err := unmarshal(&m)
 	if err == nil {
-		if len(m) > 1 {
-			types := make([]string, 0, len(m))
-			for k := range m {
+		types := make([]string, 0, len(m))
+		for k := range m {
+			if k != authAnonymous {
 				types = append(types, k)
 			}
+		}
 
+		if len(types) > 1 {
 			// TODO(stevvooe): May want to change this slightly for
 			// authorization to allow multiple challenges.
 			return fmt.Errorf("must provide exactly one type. Provided: %v", types)
 
 		}
+
+		if _, _, err := Auth(m).Anonymous(); err != nil {
+			return err
+		}
+
 		*auth = m
 		return nil
 	}
@@ -322,13 +415,290 @@ type Notifications struct {
 // Endpoint describes the configuration of an http webhook notification
 // endpoint.
 type Endpoint struct {
-	Name      string        `yaml:"name"`      // identifies the endpoint in the registry instance.
-	Disabled  bool          `yaml:"disabled"`  // disables the endpoint
-	URL       string        `yaml:"url"`       // post url for the endpoint.
-	Headers   http.Header   `yaml:"headers"`   // static headers that should be added to all requests
-	Timeout   time.Duration `yaml:"timeout"`   // HTTP timeout
-	Threshold int           `yaml:"threshold"` // circuit breaker threshold before backing off on failure
-	Backoff   time.Duration `yaml:"backoff"`   // backoff duration
+	Name        string         `yaml:"name"`                  // identifies the endpoint in the registry instance.
+	Disabled    bool           `yaml:"disabled"`              // disables the endpoint
+	Type        string         `yaml:"type,omitempty"`        // selects the sink delivering events, http if empty
+	URL         string         `yaml:"url"`                   // post url for the endpoint.
+	MediaType   string         `yaml:"mediatype,omitempty"`   // media type of the event envelopes
+	Envelope    string         `yaml:"envelope,omitempty"`    // encoding of the events, registry or cloudevents
+	Parameters  Parameters     `yaml:"parameters,omitempty"`  // type specific parameters of the endpoint
+	Headers     http.Header    `yaml:"headers"`               // static headers that should be added to all requests
+	Secrets     []string       `yaml:"secrets,omitempty"`     // secrets signing requests, all active during rotation
+	TLS         EndpointTLS    `yaml:"tls"`                   // tls configuration of the connections to the endpoint
+	Proxy       string         `yaml:"proxy,omitempty"`       // url of the proxy http requests go through
+	Timeout     time.Duration  `yaml:"timeout"`               // HTTP timeout
+	Threshold   int            `yaml:"threshold"`             // circuit breaker threshold before backing off on failure
+	Backoff     time.Duration  `yaml:"backoff"`               // backoff duration
+	MaxBackoff  time.Duration  `yaml:"maxbackoff,omitempty"`  // maximum backoff duration, as backoff doubles on each failure
+	MaxAttempts int            `yaml:"maxattempts,omitempty"` // attempts to deliver events before abandoning them to the dead letters
+	MaxAge      time.Duration  `yaml:"maxage,omitempty"`      // age of events after which they are abandoned to the dead letters
+	Queue       EndpointQueue  `yaml:"queue"`                 // durable on-disk queue of pending events
+	Filter      EndpointFilter `yaml:"filter"`                // selects the events sent to the endpoint
+	DeadLetters int            `yaml:"deadletters,omitempty"` // maximum number of undelivered events kept for replay
+}
+
+// EndpointFilter selects the events sent to an endpoint. An event is sent if
+// it matches every criteria set; an empty filter sends all events. Glob
+// patterns use the syntax of path.Match and malformed patterns are rejected
+// by Parse.
+type EndpointFilter struct {
+	// Actions lists the event actions to send, such as "push" or "pull".
+	Actions []string `yaml:"actions,omitempty"`
+
+	// MediaTypes lists glob patterns matched against the media type of the
+	// event target.
+	MediaTypes []string `yaml:"mediatypes,omitempty"`
+
+	// Repositories lists glob patterns matched against the repository name.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// RepositoryRegexp is a regular expression the whole repository name
+	// must match.
+	RepositoryRegexp string `yaml:"repositoryregexp,omitempty"`
+
+	// Actors lists glob patterns matched against the name of the user
+	// initiating the event.
+	Actors []string `yaml:"actors,omitempty"`
+
+	// ExcludeActors lists glob patterns of user names whose events are not
+	// sent, even if they match Actors.
+	ExcludeActors []string `yaml:"excludeactors,omitempty"`
+}
+
+// EndpointTLS configures the TLS connections to an endpoint.
+type EndpointTLS struct {
+	// CA is the path to a PEM bundle of the certificate authorities trusted
+	// to verify the endpoint, instead of the system roots.
+	CA string `yaml:"ca,omitempty"`
+
+	// Certificate is the path to the PEM encoded client certificate
+	// presented to the endpoint.
+	Certificate string `yaml:"certificate,omitempty"`
+
+	// Key is the path to the PEM encoded key of the client certificate.
+	Key string `yaml:"key,omitempty"`
+
+	// InsecureSkipVerify disables the verification of the certificate of the
+	// endpoint. It should only be used for testing.
+	InsecureSkipVerify bool `yaml:"insecureskipverify,omitempty"`
+}
+
+// EndpointQueue configures a durable on-disk queue for the events pending
+// delivery to an endpoint. Pending events survive restarts and are
+// delivered once the registry starts again.
+type EndpointQueue struct {
+	// Directory holds the queue files. It must not be shared with other
+	// endpoints. If empty, events are queued in memory.
+	Directory string `yaml:"directory,omitempty"`
+
+	// MaxSize is the maximum number of bytes of pending events. If zero,
+	// the queue is unbounded.
+	MaxSize int64 `yaml:"maxsize,omitempty"`
+
+	// Overflow is the policy applied when the queue is full, either "drop"
+	// to discard the oldest pending events or "block" to wait until events
+	// are delivered. Defaults to "drop".
+	Overflow string `yaml:"overflow,omitempty"`
+}
+
+// RateLimit configures token bucket rate limiting of registry requests.
+// Requests are grouped by the authenticated user name or, for anonymous
+// requests, the remote address. Each group has a separate bucket for each
+// class of request.
+type RateLimit struct {
+	// Backend selects where buckets are kept. Options are "inmemory", which
+	// limits each registry instance independently, and "redis", which shares
+	// buckets across instances using the configured redis pool. Defaults to
+	// "inmemory".
+	Backend string `yaml:"backend,omitempty"`
+
+	// Rules are evaluated in order and the first rule matching a request
+	// determines its limits. Requests matching no rule are not limited.
+	Rules []RateLimitRule `yaml:"rules,omitempty"`
+}
+
+// RateLimitRule sets the limits for requests by matching users against
+// matching repositories.
+type RateLimitRule struct {
+	// Users is a list of glob patterns matched against the user name or,
+	// for anonymous requests, the remote address. An empty list matches all
+	// requests.
+	Users []string `yaml:"users,omitempty"`
+
+	// Repositories is a list of glob patterns matched against the
+	// repository name. An empty list matches all repositories.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Manifest limits manifest and tag requests per second.
+	Manifest RateLimitBucket `yaml:"manifest,omitempty"`
+
+	// Blob limits the number of blob bytes served per second.
+	Blob RateLimitBucket `yaml:"blob,omitempty"`
+
+	// Push limits requests that modify a repository per second.
+	Push RateLimitBucket `yaml:"push,omitempty"`
+}
+
+// RateLimitBucket configures a single token bucket. A zero rate disables
+// limiting.
+type RateLimitBucket struct {
+	// Rate is the sustained number of tokens allowed per second.
+	Rate float64 `yaml:"rate,omitempty"`
+
+	// Burst is the number of tokens that may be used at once. Defaults to
+	// one second worth of tokens.
+	Burst int64 `yaml:"burst,omitempty"`
+}
+
+// Quota configures storage quotas, limiting the number of bytes of layers
+// pushed to repositories. Usage is accounted per scope, either a repository
+// or a namespace, and checked when a layer upload completes.
+type Quota struct {
+	// Backend selects where usage is accounted. Options are "inmemory",
+	// which is rebuilt from storage on startup, and "redis", which shares
+	// usage across instances using the configured redis pool. Defaults to
+	// "inmemory".
+	Backend string `yaml:"backend,omitempty"`
+
+	// Scope selects whether usage is accounted per "repository" or per
+	// "namespace", the first component of the repository name. Defaults to
+	// "repository".
+	Scope string `yaml:"scope,omitempty"`
+
+	// Limits are evaluated in order and the first limit matching a scope
+	// applies. Scopes matching no limit are accounted but not limited.
+	Limits []QuotaLimit `yaml:"limits,omitempty"`
+
+	// RebuildInterval sets how often usage is rebuilt from the layers
+	// linked into each repository. Usage is always rebuilt on startup. If
+	// zero, usage is not rebuilt periodically.
+	RebuildInterval time.Duration `yaml:"rebuildinterval,omitempty"`
+}
+
+// QuotaLimit sets the maximum size of matching quota scopes.
+type QuotaLimit struct {
+	// Scopes is a list of glob patterns matched against the repository or
+	// namespace name, depending on the quota scope. An empty list matches
+	// all scopes.
+	Scopes []string `yaml:"scopes,omitempty"`
+
+	// Size is the maximum number of bytes stored under the scope.
+	Size int64 `yaml:"size"`
+}
+
+// Immutability configures write-once tags. Once set, a protected tag cannot
+// be moved to a different manifest or removed.
+type Immutability struct {
+	// Rules protect matching tags. A tag is immutable if any rule matches.
+	Rules []ImmutabilityRule `yaml:"rules,omitempty"`
+}
+
+// ImmutabilityRule protects tags by repository and tag name patterns.
+type ImmutabilityRule struct {
+	// Repositories is a list of glob patterns matched against the
+	// repository name. An empty list matches all repositories.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Tags is a list of glob patterns matched against the tag.
+	Tags []string `yaml:"tags"`
+}
+
+// Trust configures verification of manifest signatures. Manifests pushed to
+// a repository matched by any rule must carry at least one signature made
+// with a trusted key or by a certificate chaining to a trusted authority.
+type Trust struct {
+	// Mode is either "enforce", rejecting manifests without a trusted
+	// signature, or "log", accepting them and dispatching an unverified
+	// event. Defaults to "enforce".
+	Mode string `yaml:"mode,omitempty"`
+
+	// Rules select the trusted keys and authorities by repository.
+	Rules []TrustRule `yaml:"rules,omitempty"`
+}
+
+// TrustRule trusts keys and certificate authorities for repositories.
+type TrustRule struct {
+	// Repositories is a list of glob patterns matched against the
+	// repository name. An empty list matches all repositories.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Keys is a list of files containing trusted public keys, in PEM or
+	// JWK set format.
+	Keys []string `yaml:"keys,omitempty"`
+
+	// CAs is a list of PEM encoded certificate bundles of trusted
+	// authorities.
+	CAs []string `yaml:"cas,omitempty"`
+}
+
+// Signing configures server-side signing of schema 1 manifests. When a key
+// is set, the registry adds its own signature to every schema 1 manifest it
+// accepts, alongside those provided by the client.
+type Signing struct {
+	// Key is the path to a libtrust private key file, in PEM or JWK format.
+	Key string `yaml:"key,omitempty"`
+}
+
+// Digest configures the digests layers are identified by. Layers are always
+// stored under the digest of their raw content, which can be fetched along
+// with the digest provided by the client on upload.
+type Digest struct {
+	// Algorithm is the digest algorithm identifying layers, such as
+	// "sha256" or "sha512". Defaults to "sha256".
+	Algorithm string `yaml:"algorithm,omitempty"`
+
+	// DisableTarSum rejects layer uploads identified by tarsum digests,
+	// which legacy clients use. Layers already pushed by tarsum remain
+	// available.
+	DisableTarSum bool `yaml:"disabletarsum,omitempty"`
+
+	// Migrate links the layers of every repository under their digest
+	// with the configured algorithm in the background on startup, so that
+	// layers pushed by tarsum or with another algorithm can be fetched by
+	// it.
+	Migrate bool `yaml:"migrate,omitempty"`
+}
+
+// Audit configures an append-only trail recording every authorization
+// decision and every manifest and layer mutation, as JSON lines.
+type Audit struct {
+	// Backend selects where records are written. Options are "file" and
+	// "syslog". If empty, auditing is disabled.
+	Backend string `yaml:"backend,omitempty"`
+
+	// File configures the "file" backend.
+	File AuditFile `yaml:"file,omitempty"`
+
+	// Syslog configures the "syslog" backend.
+	Syslog AuditSyslog `yaml:"syslog,omitempty"`
+
+	// HashChain chains each record to the previous one by hash, so that
+	// modified or removed records can be detected.
+	HashChain bool `yaml:"hashchain,omitempty"`
+}
+
+// AuditFile configures writing audit records to a rotating file.
+type AuditFile struct {
+	// Path is the file records are appended to.
+	Path string `yaml:"path"`
+
+	// MaxSize is the size in bytes at which the file is rotated. If zero,
+	// the file is not rotated.
+	MaxSize int64 `yaml:"maxsize,omitempty"`
+
+	// MaxBackups is the number of rotated files kept.
+	MaxBackups int `yaml:"maxbackups,omitempty"`
+}
+
+// AuditSyslog configures writing audit records to syslog.
+type AuditSyslog struct {
+	// Network and Addr locate the syslog daemon, such as "udp" and
+	// "localhost:514". If Network is empty, the local daemon is used.
+	Network string `yaml:"network,omitempty"`
+	Addr    string `yaml:"addr,omitempty"`
+
+	// Tag is the syslog tag of each record. Defaults to "registry-audit".
+	Tag string `yaml:"tag,omitempty"`
 }
 
 // Reporting defines error reporting methods.
@@ -408,5 +778,52 @@ func Parse(rd io.Reader) (*Configuration, error) {
 		return nil, err
 	}
 
+	if err := config.validatePatterns(); err != nil {
+		return nil, err
+	}
+
 	return config, nil
 }
+
+// validatePatterns returns an error if any of the glob patterns configured
+// is malformed, rather than letting it silently match nothing.
+func (config *Configuration) validatePatterns() error {
+	for _, endpoint := range config.Notifications.Endpoints {
+		filter := endpoint.Filter
+		for _, patterns := range [][]string{filter.MediaTypes, filter.Repositories, filter.Actors, filter.ExcludeActors} {
+			if err := glob.Validate(patterns); err != nil {
+				return fmt.Errorf("notifications endpoint %s filter: %v", endpoint.Name, err)
+			}
+		}
+	}
+
+	for i, rule := range config.RateLimit.Rules {
+		for _, patterns := range [][]string{rule.Users, rule.Repositories} {
+			if err := glob.Validate(patterns); err != nil {
+				return fmt.Errorf("ratelimit rule %d: %v", i, err)
+			}
+		}
+	}
+
+	for i, limit := range config.Quota.Limits {
+		if err := glob.Validate(limit.Scopes); err != nil {
+			return fmt.Errorf("quota limit %d: %v", i, err)
+		}
+	}
+
+	for i, rule := range config.Immutability.Rules {
+		for _, patterns := range [][]string{rule.Repositories, rule.Tags} {
+			if err := glob.Validate(patterns); err != nil {
+				return fmt.Errorf("immutability rule %d: %v", i, err)
+			}
+		}
+	}
+
+	for i, rule := range config.Trust.Rules {
+		if err := glob.Validate(rule.Repositories); err != nil {
+			return fmt.Errorf("trust rule %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
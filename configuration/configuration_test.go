@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 
 	. "gopkg.in/check.v1"
@@ -177,6 +178,89 @@ func (suite *ConfigSuite) TestParseInmemory(c *C) {
 	c.Assert(config, DeepEquals, suite.expectedConfig)
 }
 
+// TestParseAuthAnonymous validates that anonymous access may be configured
+// alongside an access controller, but not in place of a second one.
+func (suite *ConfigSuite) TestParseAuthAnonymous(c *C) {
+	configYaml := strings.Replace(inmemoryConfigYamlV0_1, `auth:
+  silly:`, `auth:
+  anonymous:
+    actions: [pull]
+    repositories: ["public/*"]
+  silly:`, 1)
+
+	config, err := Parse(bytes.NewReader([]byte(configYaml)))
+	c.Assert(err, IsNil)
+	c.Assert(config.Auth.Type(), Equals, "silly")
+	c.Assert(config.Auth.Parameters(), DeepEquals, Parameters{"realm": "silly", "service": "silly"})
+
+	anonymous, ok, err := config.Auth.Anonymous()
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	c.Assert(anonymous, DeepEquals, AnonymousAccess{
+		Actions:      []string{"pull"},
+		Repositories: []string{"public/*"},
+	})
+
+	_, ok, err = suite.expectedConfig.Auth.Anonymous()
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+
+	invalidYaml := strings.Replace(configYaml, `actions: [pull]`, `actions: pull`, 1)
+	_, err = Parse(bytes.NewReader([]byte(invalidYaml)))
+	c.Assert(err, NotNil)
+
+	multipleYaml := strings.Replace(configYaml, `  anonymous:`, `  token:
+    realm: token
+  anonymous:`, 1)
+	_, err = Parse(bytes.NewReader([]byte(multipleYaml)))
+	c.Assert(err, NotNil)
+}
+
+// TestParseInvalidPatterns validates that the parser will fail to parse a
+// configuration with a malformed glob pattern.
+func (suite *ConfigSuite) TestParseInvalidPatterns(c *C) {
+	anonymousYaml := strings.Replace(inmemoryConfigYamlV0_1, `auth:
+  silly:`, `auth:
+  anonymous:
+    actions: [pull]
+    repositories: ["public/[*"]
+  silly:`, 1)
+	_, err := Parse(bytes.NewReader([]byte(anonymousYaml)))
+	c.Assert(err, NotNil)
+
+	rateLimitYaml := inmemoryConfigYamlV0_1 + `ratelimit:
+  rules:
+    - users: ["[a-"]
+      manifest:
+        rate: 10
+`
+	_, err = Parse(bytes.NewReader([]byte(rateLimitYaml)))
+	c.Assert(err, NotNil)
+
+	quotaYaml := inmemoryConfigYamlV0_1 + `quota:
+  limits:
+    - scopes: ["\\"]
+      size: 1024
+`
+	_, err = Parse(bytes.NewReader([]byte(quotaYaml)))
+	c.Assert(err, NotNil)
+
+	immutabilityYaml := inmemoryConfigYamlV0_1 + `immutability:
+  rules:
+    - tags: ["v[0-9"]
+`
+	_, err = Parse(bytes.NewReader([]byte(immutabilityYaml)))
+	c.Assert(err, NotNil)
+
+	trustYaml := inmemoryConfigYamlV0_1 + `trust:
+  rules:
+    - repositories: ["library/[a-z"]
+      keys: [/etc/registry/trusted.pem]
+`
+	_, err = Parse(bytes.NewReader([]byte(trustYaml)))
+	c.Assert(err, NotNil)
+}
+
 // TestParseIncomplete validates that an incomplete yaml configuration cannot
 // be parsed without providing environment variables to fill in the missing
 // components.
@@ -0,0 +1,99 @@
+package digest
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	algorithmsMu sync.RWMutex
+
+	// algorithms maps the names of the supported digest algorithms to the
+	// constructors of their hash functions.
+	algorithms = map[string]func() hash.Hash{
+		"sha256": sha256.New,
+		"sha384": sha512.New384,
+		"sha512": sha512.New,
+	}
+)
+
+// RegisterAlgorithm makes the digest algorithm with the given name available
+// for validating, calculating and verifying digests. It is intended to be
+// called from the init function of packages providing hash functions.
+// Registering a name twice, or a name that could be mistaken for a tarsum,
+// panics.
+func RegisterAlgorithm(name string, newHash func() hash.Hash) {
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+
+	if newHash == nil {
+		panic("digest: RegisterAlgorithm hash function is nil")
+	}
+
+	if strings.HasPrefix(name, "tarsum") {
+		panic(fmt.Sprintf("digest: algorithm name %q is reserved for tarsum", name))
+	}
+
+	if !DigestRegexpAnchored.MatchString(name + ":0") {
+		panic(fmt.Sprintf("digest: invalid algorithm name %q", name))
+	}
+
+	if _, dup := algorithms[name]; dup {
+		panic(fmt.Sprintf("digest: RegisterAlgorithm called twice for %q", name))
+	}
+
+	algorithms[name] = newHash
+}
+
+// Algorithms returns the sorted names of the registered digest algorithms.
+func Algorithms() []string {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// AlgorithmAvailable reports whether the digest algorithm is registered.
+func AlgorithmAvailable(alg string) bool {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+
+	_, ok := algorithms[alg]
+	return ok
+}
+
+// NewDigesterForAlgorithm returns a Digester calculating digests with the
+// registered algorithm. ErrDigestUnsupported is returned if the algorithm is
+// not registered.
+func NewDigesterForAlgorithm(alg string) (Digester, error) {
+	h, err := newHash(alg)
+	if err != nil {
+		return Digester{}, err
+	}
+
+	return NewDigester(alg, h), nil
+}
+
+// newHash returns a new hash for the registered algorithm.
+func newHash(alg string) (hash.Hash, error) {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+
+	newHash, ok := algorithms[alg]
+	if !ok {
+		return nil, ErrDigestUnsupported
+	}
+
+	return newHash(), nil
+}
@@ -0,0 +1,78 @@
+package digest
+
+import (
+	"crypto/md5"
+	"crypto/sha512"
+	"fmt"
+	"testing"
+)
+
+func TestRegisterAlgorithm(t *testing.T) {
+	if _, err := ParseDigest("md5:d41d8cd98f00b204e9800998ecf8427e"); err != ErrDigestUnsupported {
+		t.Fatalf("expected unregistered algorithm to be unsupported: %v", err)
+	}
+
+	RegisterAlgorithm("md5", md5.New)
+
+	dgst, err := ParseDigest("md5:d41d8cd98f00b204e9800998ecf8427e")
+	if err != nil {
+		t.Fatalf("unexpected error parsing registered algorithm: %v", err)
+	}
+
+	digester, err := NewDigesterForAlgorithm("md5")
+	if err != nil {
+		t.Fatalf("unexpected error creating digester: %v", err)
+	}
+
+	if digester.Digest() != dgst {
+		t.Fatalf("unexpected digest of empty content: %q != %q", digester.Digest(), dgst)
+	}
+
+	verifier, err := NewDigestVerifier(dgst)
+	if err != nil {
+		t.Fatalf("unexpected error creating verifier: %v", err)
+	}
+
+	if !verifier.Verified() {
+		t.Fatalf("empty content not verified against %q", dgst)
+	}
+
+	found := false
+	for _, alg := range Algorithms() {
+		found = found || alg == "md5"
+	}
+
+	if !found {
+		t.Fatalf("registered algorithm not listed: %v", Algorithms())
+	}
+
+	for _, name := range []string{"md5", "sha256", "tarsum.v2+sha256", "no:colons"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected panic registering %q", name)
+				}
+			}()
+
+			RegisterAlgorithm(name, md5.New)
+		}()
+	}
+}
+
+func TestNewDigesterForAlgorithm(t *testing.T) {
+	digester, err := NewDigesterForAlgorithm("sha512")
+	if err != nil {
+		t.Fatalf("unexpected error creating digester: %v", err)
+	}
+
+	digester.Write([]byte("foo"))
+
+	expected := Digest(fmt.Sprintf("sha512:%x", sha512.Sum512([]byte("foo"))))
+	if digester.Digest() != expected {
+		t.Fatalf("unexpected digest: %q != %q", digester.Digest(), expected)
+	}
+
+	if _, err := NewDigesterForAlgorithm("tarsum.v1+sha256"); err != ErrDigestUnsupported {
+		t.Fatalf("expected unsupported algorithm error: %v", err)
+	}
+}
@@ -105,16 +105,10 @@ func FromBytes(p []byte) (Digest, error) {
 }
 
 // Validate checks that the contents of d is a valid digest, returning an
-// error if not.
+// error if not. Digests of registered algorithms are accepted, as are tarsum
+// digests for compatibility with legacy clients.
 func (d Digest) Validate() error {
 	s := string(d)
-	// Common case will be tarsum
-	_, err := ParseTarSum(s)
-	if err == nil {
-		return nil
-	}
-
-	// Continue on for general parser
 
 	if !DigestRegexpAnchored.MatchString(s) {
 		return ErrDigestInvalidFormat
@@ -130,14 +124,21 @@ func (d Digest) Validate() error {
 		return ErrDigestInvalidFormat
 	}
 
-	switch s[:i] {
-	case "sha256", "sha384", "sha512":
-		break
-	default:
-		return ErrDigestUnsupported
+	if AlgorithmAvailable(s[:i]) {
+		return nil
 	}
 
-	return nil
+	if d.IsTarSum() {
+		return nil
+	}
+
+	return ErrDigestUnsupported
+}
+
+// IsTarSum reports whether d is a tarsum digest.
+func (d Digest) IsTarSum() bool {
+	_, err := ParseTarSum(string(d))
+	return err == nil
 }
 
 // Algorithm returns the algorithm portion of the digest. This will panic if
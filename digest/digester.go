@@ -22,10 +22,14 @@ func NewDigester(alg string, h hash.Hash) Digester {
 	}
 }
 
+// CanonicalAlgorithm is the digest algorithm used by default to identify
+// content.
+const CanonicalAlgorithm = "sha256"
+
 // NewCanonicalDigester is a convenience function to create a new Digester with
 // our default settings.
 func NewCanonicalDigester() Digester {
-	return NewDigester("sha256", sha256.New())
+	return NewDigester(CanonicalAlgorithm, sha256.New())
 }
 
 // Digest returns the current digest for this digester.
@@ -41,7 +41,7 @@ func NewResumableDigester(alg string) (ResumableDigester, error) {
 // digest algorithm.
 func NewCanonicalResumableDigester() ResumableDigester {
 	return resumableDigester{
-		alg:           "sha256",
+		alg:           CanonicalAlgorithm,
 		ResumableHash: crypto.SHA256.New(),
 	}
 }
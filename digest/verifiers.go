@@ -1,8 +1,6 @@
 package digest
 
 import (
-	"crypto/sha256"
-	"crypto/sha512"
 	"hash"
 	"io"
 	"io/ioutil"
@@ -29,47 +27,45 @@ func NewDigestVerifier(d Digest) (Verifier, error) {
 		return nil, err
 	}
 
-	alg := d.Algorithm()
-	switch alg {
-	case "sha256", "sha384", "sha512":
+	if h, err := newHash(d.Algorithm()); err == nil {
 		return hashVerifier{
-			hash:   newHash(alg),
+			hash:   h,
 			digest: d,
 		}, nil
-	default:
-		// Assume we have a tarsum.
-		version, err := tarsum.GetVersionFromTarsum(string(d))
-		if err != nil {
-			return nil, err
-		}
+	}
 
-		pr, pw := io.Pipe()
+	// Assume we have a tarsum.
+	version, err := tarsum.GetVersionFromTarsum(string(d))
+	if err != nil {
+		return nil, err
+	}
 
-		// TODO(stevvooe): We may actually want to ban the earlier versions of
-		// tarsum. That decision may not be the place of the verifier.
+	pr, pw := io.Pipe()
 
-		ts, err := tarsum.NewTarSum(pr, true, version)
-		if err != nil {
-			return nil, err
-		}
+	// TODO(stevvooe): We may actually want to ban the earlier versions of
+	// tarsum. That decision may not be the place of the verifier.
 
-		// TODO(sday): Ick! A goroutine per digest verification? We'll have to
-		// get the tarsum library to export an io.Writer variant.
-		go func() {
-			if _, err := io.Copy(ioutil.Discard, ts); err != nil {
-				pr.CloseWithError(err)
-			} else {
-				pr.Close()
-			}
-		}()
-
-		return &tarsumVerifier{
-			digest: d,
-			ts:     ts,
-			pr:     pr,
-			pw:     pw,
-		}, nil
+	ts, err := tarsum.NewTarSum(pr, true, version)
+	if err != nil {
+		return nil, err
 	}
+
+	// TODO(sday): Ick! A goroutine per digest verification? We'll have to
+	// get the tarsum library to export an io.Writer variant.
+	go func() {
+		if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+			pr.CloseWithError(err)
+		} else {
+			pr.Close()
+		}
+	}()
+
+	return &tarsumVerifier{
+		digest: d,
+		ts:     ts,
+		pr:     pr,
+		pw:     pw,
+	}, nil
 }
 
 // NewLengthVerifier returns a verifier that returns true when the number of
@@ -95,19 +91,6 @@ func (lv *lengthVerifier) Verified() bool {
 	return lv.expected == lv.len
 }
 
-func newHash(name string) hash.Hash {
-	switch name {
-	case "sha256":
-		return sha256.New()
-	case "sha384":
-		return sha512.New384()
-	case "sha512":
-		return sha512.New()
-	default:
-		panic("unsupport algorithm: " + name)
-	}
-}
-
 type hashVerifier struct {
 	digest Digest
 	hash   hash.Hash
@@ -5,7 +5,6 @@ import (
 	"strings"
 
 	"github.com/docker/distribution/digest"
-	"github.com/docker/distribution/manifest"
 )
 
 var (
@@ -44,6 +43,19 @@ func (err ErrRepositoryNameInvalid) Error() string {
 	return fmt.Sprintf("repository name %q invalid: %v", err.Name, err.Reason)
 }
 
+// ErrQuotaExceeded is returned when storing content would take the usage of
+// a quota scope over its limit.
+type ErrQuotaExceeded struct {
+	Scope string
+	Limit int64
+	Usage int64
+	Size  int64
+}
+
+func (err ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("quota exceeded for %s: %d bytes used of %d, %d bytes requested", err.Scope, err.Usage, err.Limit, err.Size)
+}
+
 // ErrManifestUnknown is returned if the manifest is not known by the
 // registry.
 type ErrManifestUnknown struct {
@@ -55,6 +67,18 @@ func (err ErrManifestUnknown) Error() string {
 	return fmt.Sprintf("unknown manifest name=%s tag=%s", err.Name, err.Tag)
 }
 
+// ErrTagImmutable is returned when attempting to move or remove a tag that an
+// immutability policy protects. Revision is the manifest the tag references.
+type ErrTagImmutable struct {
+	Name     string
+	Tag      string
+	Revision digest.Digest
+}
+
+func (err ErrTagImmutable) Error() string {
+	return fmt.Sprintf("tag is immutable name=%s tag=%s revision=%s", err.Name, err.Tag, err.Revision)
+}
+
 // ErrUnknownManifestRevision is returned when a manifest cannot be found by
 // revision within a repository.
 type ErrUnknownManifestRevision struct {
@@ -74,6 +98,18 @@ func (ErrManifestUnverified) Error() string {
 	return fmt.Sprintf("unverified manifest")
 }
 
+// ErrManifestUntrusted is returned when the trust policy of a repository
+// requires a signature by a trusted key or certificate authority and the
+// manifest carries none. KeyIDs lists the keys of the valid signatures found.
+type ErrManifestUntrusted struct {
+	Name   string
+	KeyIDs []string
+}
+
+func (err ErrManifestUntrusted) Error() string {
+	return fmt.Sprintf("manifest not signed by a trusted key name=%s keys=%v", err.Name, err.KeyIDs)
+}
+
 // ErrManifestVerification provides a type to collect errors encountered
 // during manifest verification. Currently, it accepts errors of all types,
 // but it may be narrowed to those involving manifest verification.
@@ -90,11 +126,11 @@ func (errs ErrManifestVerification) Error() string {
 
 // ErrUnknownLayer returned when layer cannot be found.
 type ErrUnknownLayer struct {
-	FSLayer manifest.FSLayer
+	Digest digest.Digest
 }
 
 func (err ErrUnknownLayer) Error() string {
-	return fmt.Sprintf("unknown layer %v", err.FSLayer.BlobSum)
+	return fmt.Sprintf("unknown layer %v", err.Digest)
 }
 
 // ErrLayerInvalidDigest returned when tarsum check fails.
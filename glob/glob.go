@@ -0,0 +1,49 @@
+// Package glob matches names against lists of patterns, such as the
+// repository, user and tag patterns of the registry configuration. Patterns
+// use the syntax of path.Match.
+//
+// An empty list of patterns matches no names. Where the configuration treats
+// an omitted list as unrestricted, callers check for an empty list
+// explicitly.
+package glob
+
+import (
+	"fmt"
+	"path"
+)
+
+// Validate returns an error if any of the patterns is malformed. Patterns
+// should be validated when they are configured, since MatchAny ignores
+// malformed patterns.
+func Validate(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// MatchAny returns true if name matches any of the patterns. Malformed
+// patterns match no names.
+func MatchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Contains returns true if s is equal to any of the values.
+func Contains(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+
+	return false
+}
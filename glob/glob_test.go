@@ -0,0 +1,50 @@
+package glob
+
+import "testing"
+
+func TestMatchAny(t *testing.T) {
+	for _, testcase := range []struct {
+		patterns []string
+		name     string
+		expected bool
+	}{
+		{patterns: nil, name: "foo", expected: false},
+		{patterns: []string{"foo"}, name: "foo", expected: true},
+		{patterns: []string{"bar", "foo/*"}, name: "foo/bar", expected: true},
+		{patterns: []string{"foo/*"}, name: "foo/bar/baz", expected: false},
+		{patterns: []string{"[", "foo"}, name: "foo", expected: true},
+		{patterns: []string{"["}, name: "[", expected: false},
+	} {
+		if matched := MatchAny(testcase.patterns, testcase.name); matched != testcase.expected {
+			t.Errorf("MatchAny(%q, %q) = %v, expected %v", testcase.patterns, testcase.name, matched, testcase.expected)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(nil); err != nil {
+		t.Fatalf("unexpected error validating no patterns: %v", err)
+	}
+
+	if err := Validate([]string{"foo", "foo/*", "ba[rz]"}); err != nil {
+		t.Fatalf("unexpected error validating patterns: %v", err)
+	}
+
+	if err := Validate([]string{"foo", "ba[rz"}); err == nil {
+		t.Fatalf("expected error validating malformed pattern")
+	}
+}
+
+func TestContains(t *testing.T) {
+	if Contains(nil, "pull") {
+		t.Fatalf("empty list should not contain anything")
+	}
+
+	if !Contains([]string{"pull", "push"}, "push") {
+		t.Fatalf("expected list to contain push")
+	}
+
+	if Contains([]string{"pull"}, "pul*") {
+		t.Fatalf("values should not be matched as patterns")
+	}
+}
@@ -2,7 +2,10 @@ package manifest
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
 
+	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
 	"github.com/docker/libtrust"
 )
@@ -17,6 +20,48 @@ const (
 	ManifestMediaType = "application/vnd.docker.distribution.manifest.v1+json"
 )
 
+func init() {
+	// Schema version 1 predates manifest media types: clients push it as
+	// plain JSON, or without a content type at all.
+	for _, mediaType := range []string{ManifestMediaType, "application/json", ""} {
+		if err := distribution.RegisterManifestSchema(mediaType, unmarshalSignedManifest); err != nil {
+			panic(fmt.Sprintf("Unable to register manifest: %s", err))
+		}
+	}
+}
+
+// unmarshalSignedManifest unmarshals a schema version 1 manifest. The
+// manifest digest is calculated over the signed content, without signatures.
+func unmarshalSignedManifest(b []byte) (distribution.Manifest, distribution.Descriptor, error) {
+	sm := new(SignedManifest)
+	if err := sm.UnmarshalJSON(b); err != nil {
+		return nil, distribution.Descriptor{}, err
+	}
+
+	canonical, err := sm.Canonical()
+	if err != nil {
+		if !strings.Contains(err.Error(), "missing signature key") {
+			return nil, distribution.Descriptor{}, err
+		}
+
+		// NOTE(stevvooe): There are no signatures but we still have a
+		// payload. The manifest will fail verification later but this is
+		// not the responsibility of this part of the code.
+		canonical = sm.Raw
+	}
+
+	dgst, err := digest.FromBytes(canonical)
+	if err != nil {
+		return nil, distribution.Descriptor{}, err
+	}
+
+	return sm, distribution.Descriptor{
+		MediaType: ManifestMediaType,
+		Size:      int64(len(canonical)),
+		Digest:    dgst,
+	}, nil
+}
+
 // Versioned provides a struct with just the manifest schemaVersion. Incoming
 // content with unknown schema version can be decoded against this struct to
 // check the version.
@@ -59,6 +104,8 @@ type SignedManifest struct {
 	Raw []byte `json:"-"`
 }
 
+var _ distribution.Manifest = &SignedManifest{}
+
 // UnmarshalJSON populates a new ImageManifest struct from JSON data.
 func (sm *SignedManifest) UnmarshalJSON(b []byte) error {
 	var manifest Manifest
@@ -73,9 +120,28 @@ func (sm *SignedManifest) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-// Payload returns the raw, signed content of the signed manifest. The
-// contents can be used to calculate the content identifier.
-func (sm *SignedManifest) Payload() ([]byte, error) {
+// References returns the descriptors of the layers referenced by the
+// manifest, as listed in FSLayers.
+func (sm *SignedManifest) References() []distribution.Descriptor {
+	dependencies := make([]distribution.Descriptor, len(sm.FSLayers))
+	for i, fsLayer := range sm.FSLayers {
+		dependencies[i] = distribution.Descriptor{
+			Digest: fsLayer.BlobSum,
+		}
+	}
+
+	return dependencies
+}
+
+// Payload returns the media type and raw bytes of the signed manifest,
+// including signatures. These are the bytes served to clients.
+func (sm *SignedManifest) Payload() (string, []byte, error) {
+	return ManifestMediaType, sm.Raw, nil
+}
+
+// Canonical returns the signed content of the signed manifest, without
+// signatures. The contents are used to calculate the content identifier.
+func (sm *SignedManifest) Canonical() ([]byte, error) {
 	jsig, err := libtrust.ParsePrettySignature(sm.Raw, "signatures")
 	if err != nil {
 		return nil, err
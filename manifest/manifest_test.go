@@ -6,6 +6,8 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
 	"github.com/docker/libtrust"
 )
 
@@ -44,6 +46,51 @@ func TestManifestUnmarshaling(t *testing.T) {
 	}
 }
 
+func TestUnmarshalManifestSchema1(t *testing.T) {
+	env := genEnv(t)
+
+	canonical, err := env.signed.Canonical()
+	if err != nil {
+		t.Fatalf("error getting canonical payload: %v", err)
+	}
+
+	expectedDigest, err := digest.FromBytes(canonical)
+	if err != nil {
+		t.Fatalf("error digesting canonical payload: %v", err)
+	}
+
+	// Schema 1 manifests are pushed with a variety of content types.
+	for _, ctHeader := range []string{"", "application/json; charset=utf-8", ManifestMediaType} {
+		m, descriptor, err := distribution.UnmarshalManifest(ctHeader, env.signed.Raw)
+		if err != nil {
+			t.Fatalf("%q: error unmarshaling manifest: %v", ctHeader, err)
+		}
+
+		if !reflect.DeepEqual(m, env.signed) {
+			t.Fatalf("%q: manifests are different after unmarshaling", ctHeader)
+		}
+
+		if descriptor.Digest != expectedDigest {
+			t.Fatalf("%q: unexpected digest: %v != %v", ctHeader, descriptor.Digest, expectedDigest)
+		}
+	}
+
+	references := env.signed.References()
+	if len(references) != len(env.manifest.FSLayers) {
+		t.Fatalf("unexpected number of references: %d", len(references))
+	}
+
+	for i, fsLayer := range env.manifest.FSLayers {
+		if references[i].Digest != fsLayer.BlobSum {
+			t.Fatalf("unexpected reference %d: %v != %v", i, references[i].Digest, fsLayer.BlobSum)
+		}
+	}
+
+	if _, _, err := distribution.UnmarshalManifest("text/plain", env.signed.Raw); err == nil {
+		t.Fatalf("expected error unmarshaling manifest with unsupported media type")
+	}
+}
+
 func TestManifestVerification(t *testing.T) {
 	env := genEnv(t)
 
@@ -0,0 +1,192 @@
+// Package manifestlist implements manifest lists, which allow a single tag to
+// reference several platform-specific image manifests. Each entry references a
+// manifest in the same repository by digest and describes the platform it
+// targets, letting clients select the image matching the host.
+package manifestlist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+)
+
+// MediaTypeManifestList specifies the mediaType for manifest lists.
+const MediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+// SchemaVersion provides a pre-initialized version structure for this
+// packages version of the manifest list.
+var SchemaVersion = manifest.Versioned{
+	SchemaVersion: 2,
+}
+
+func init() {
+	manifestListFunc := func(b []byte) (distribution.Manifest, distribution.Descriptor, error) {
+		m := new(DeserializedManifestList)
+		if err := m.UnmarshalJSON(b); err != nil {
+			return nil, distribution.Descriptor{}, err
+		}
+
+		dgst, err := digest.FromBytes(b)
+		if err != nil {
+			return nil, distribution.Descriptor{}, err
+		}
+
+		return m, distribution.Descriptor{
+			MediaType: MediaTypeManifestList,
+			Size:      int64(len(b)),
+			Digest:    dgst,
+		}, nil
+	}
+
+	if err := distribution.RegisterManifestSchema(MediaTypeManifestList, manifestListFunc); err != nil {
+		panic(fmt.Sprintf("Unable to register manifest: %s", err))
+	}
+}
+
+// PlatformSpec specifies a platform where a particular image manifest is
+// applicable.
+type PlatformSpec struct {
+	// Architecture field specifies the CPU architecture, for example
+	// `amd64` or `ppc64`.
+	Architecture string `json:"architecture"`
+
+	// OS specifies the operating system, for example `linux` or `windows`.
+	OS string `json:"os"`
+
+	// Variant is an optional field specifying a variant of the CPU, for
+	// example `v7` to specify ARMv7 when architecture is `arm`.
+	Variant string `json:"variant,omitempty"`
+
+	// Features is an optional field specifying an array of strings, each
+	// listing a required CPU feature.
+	Features []string `json:"features,omitempty"`
+}
+
+// ManifestDescriptor references a platform-specific manifest.
+type ManifestDescriptor struct {
+	distribution.Descriptor
+
+	// Platform specifies which platform the manifest pointed to by the
+	// descriptor runs on.
+	Platform PlatformSpec `json:"platform"`
+}
+
+// ManifestList references manifests for various platforms.
+type ManifestList struct {
+	manifest.Versioned
+
+	// MediaType is the media type of this manifest list.
+	MediaType string `json:"mediaType"`
+
+	// Manifests references platform specific manifests.
+	Manifests []ManifestDescriptor `json:"manifests"`
+}
+
+// References returns the distribution descriptors for the referenced image
+// manifests.
+func (m ManifestList) References() []distribution.Descriptor {
+	dependencies := make([]distribution.Descriptor, len(m.Manifests))
+	for i := range m.Manifests {
+		dependencies[i] = m.Manifests[i].Descriptor
+	}
+
+	return dependencies
+}
+
+// Select returns the first manifest descriptor matching the operating system
+// and architecture. If variant is not empty, entries declaring a different
+// variant are skipped.
+func (m ManifestList) Select(os, architecture, variant string) (ManifestDescriptor, bool) {
+	for _, descriptor := range m.Manifests {
+		if descriptor.Platform.OS != os || descriptor.Platform.Architecture != architecture {
+			continue
+		}
+
+		if variant != "" && descriptor.Platform.Variant != "" && descriptor.Platform.Variant != variant {
+			continue
+		}
+
+		return descriptor, true
+	}
+
+	return ManifestDescriptor{}, false
+}
+
+// DeserializedManifestList wraps ManifestList with a copy of the original
+// JSON. It satisfies the distribution.Manifest interface.
+type DeserializedManifestList struct {
+	ManifestList
+
+	// canonical is the canonical byte representation of the ManifestList.
+	canonical []byte
+}
+
+var _ distribution.Manifest = &DeserializedManifestList{}
+
+// FromDescriptors takes a slice of descriptors, and returns a
+// DeserializedManifestList which contains the resulting manifest list and
+// its JSON representation.
+func FromDescriptors(descriptors []ManifestDescriptor) (*DeserializedManifestList, error) {
+	m := ManifestList{
+		Versioned: SchemaVersion,
+		MediaType: MediaTypeManifestList,
+	}
+
+	m.Manifests = make([]ManifestDescriptor, len(descriptors), len(descriptors))
+	copy(m.Manifests, descriptors)
+
+	deserialized := DeserializedManifestList{
+		ManifestList: m,
+	}
+
+	var err error
+	deserialized.canonical, err = json.MarshalIndent(&m, "", "   ")
+	return &deserialized, err
+}
+
+// UnmarshalJSON populates a new ManifestList struct from JSON data.
+func (m *DeserializedManifestList) UnmarshalJSON(b []byte) error {
+	var list ManifestList
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+
+	if list.SchemaVersion != SchemaVersion.SchemaVersion {
+		return fmt.Errorf("unsupported manifest list schema version: %d", list.SchemaVersion)
+	}
+
+	if list.MediaType != MediaTypeManifestList {
+		return fmt.Errorf("unexpected manifest list media type: %q", list.MediaType)
+	}
+
+	for _, descriptor := range list.Manifests {
+		if descriptor.Digest == "" {
+			return errors.New("manifest list entry is missing a digest")
+		}
+	}
+
+	m.ManifestList = list
+	m.canonical = make([]byte, len(b), len(b))
+	copy(m.canonical, b)
+
+	return nil
+}
+
+// MarshalJSON returns the contents of canonical. If canonical is empty,
+// marshals the inner contents.
+func (m *DeserializedManifestList) MarshalJSON() ([]byte, error) {
+	if len(m.canonical) > 0 {
+		return m.canonical, nil
+	}
+
+	return nil, errors.New("JSON representation not initialized in DeserializedManifestList")
+}
+
+// Payload returns the media type and canonical bytes of the manifest list.
+func (m *DeserializedManifestList) Payload() (string, []byte, error) {
+	return MediaTypeManifestList, m.canonical, nil
+}
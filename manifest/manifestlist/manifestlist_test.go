@@ -0,0 +1,171 @@
+package manifestlist
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution"
+)
+
+var expectedManifestListSerialization = []byte(`{
+   "schemaVersion": 2,
+   "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+   "manifests": [
+      {
+         "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+         "size": 985,
+         "digest": "sha256:1a9ec845ee94c202b2d5da74a24f0ed2058318bfa9879fa541efaecba272e86b",
+         "platform": {
+            "architecture": "amd64",
+            "os": "linux",
+            "features": [
+               "sse4"
+            ]
+         }
+      },
+      {
+         "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+         "size": 2392,
+         "digest": "sha256:6346340964309634683409684360934680934608934608934608934068934608",
+         "platform": {
+            "architecture": "arm",
+            "os": "linux",
+            "variant": "v7"
+         }
+      }
+   ]
+}`)
+
+func makeTestDescriptors() []ManifestDescriptor {
+	return []ManifestDescriptor{
+		{
+			Descriptor: distribution.Descriptor{
+				Digest:    "sha256:1a9ec845ee94c202b2d5da74a24f0ed2058318bfa9879fa541efaecba272e86b",
+				Size:      985,
+				MediaType: "application/vnd.docker.distribution.manifest.v2+json",
+			},
+			Platform: PlatformSpec{
+				Architecture: "amd64",
+				OS:           "linux",
+				Features:     []string{"sse4"},
+			},
+		},
+		{
+			Descriptor: distribution.Descriptor{
+				Digest:    "sha256:6346340964309634683409684360934680934608934608934608934068934608",
+				Size:      2392,
+				MediaType: "application/vnd.docker.distribution.manifest.v2+json",
+			},
+			Platform: PlatformSpec{
+				Architecture: "arm",
+				OS:           "linux",
+				Variant:      "v7",
+			},
+		},
+	}
+}
+
+func TestManifestList(t *testing.T) {
+	descriptors := makeTestDescriptors()
+
+	deserialized, err := FromDescriptors(descriptors)
+	if err != nil {
+		t.Fatalf("error creating DeserializedManifestList: %v", err)
+	}
+
+	mediaType, payload, err := deserialized.Payload()
+	if err != nil {
+		t.Fatalf("error getting payload: %v", err)
+	}
+
+	if mediaType != MediaTypeManifestList {
+		t.Fatalf("unexpected media type: %q", mediaType)
+	}
+
+	if !bytes.Equal(payload, expectedManifestListSerialization) {
+		t.Fatalf("manifest list bytes not equal:\nexpected:\n%s\nactual:\n%s\n", string(expectedManifestListSerialization), string(payload))
+	}
+
+	var unmarshalled DeserializedManifestList
+	if err := json.Unmarshal(payload, &unmarshalled); err != nil {
+		t.Fatalf("error unmarshaling manifest list: %v", err)
+	}
+
+	if !reflect.DeepEqual(&unmarshalled, deserialized) {
+		t.Fatalf("manifest lists are different after unmarshaling")
+	}
+
+	// Without a content type, the mediaType field selects the unmarshaler.
+	m, descriptor, err := distribution.UnmarshalManifest("", payload)
+	if err != nil {
+		t.Fatalf("error unmarshaling manifest list by media type: %v", err)
+	}
+
+	if !reflect.DeepEqual(m, deserialized) {
+		t.Fatalf("manifest lists are different after unmarshaling by media type")
+	}
+
+	if descriptor.MediaType != MediaTypeManifestList {
+		t.Fatalf("unexpected descriptor: %#v", descriptor)
+	}
+
+	references := deserialized.References()
+	if len(references) != 2 {
+		t.Fatalf("unexpected number of references: %d", len(references))
+	}
+
+	for i := range references {
+		if !reflect.DeepEqual(references[i], descriptors[i].Descriptor) {
+			t.Fatalf("unexpected reference %d: %#v", i, references[i])
+		}
+	}
+}
+
+func TestManifestListSelect(t *testing.T) {
+	deserialized, err := FromDescriptors(makeTestDescriptors())
+	if err != nil {
+		t.Fatalf("error creating DeserializedManifestList: %v", err)
+	}
+
+	for _, testcase := range []struct {
+		os, architecture, variant string
+		expected                  int // index of the expected entry, -1 for no match
+	}{
+		{"linux", "amd64", "", 0},
+		{"linux", "arm", "", 1},
+		{"linux", "arm", "v7", 1},
+		{"linux", "arm", "v6", -1},
+		{"windows", "amd64", "", -1},
+	} {
+		descriptor, ok := deserialized.Select(testcase.os, testcase.architecture, testcase.variant)
+		if testcase.expected < 0 {
+			if ok {
+				t.Fatalf("%s/%s/%s: unexpected match: %#v", testcase.os, testcase.architecture, testcase.variant, descriptor)
+			}
+			continue
+		}
+
+		if !ok {
+			t.Fatalf("%s/%s/%s: expected a match", testcase.os, testcase.architecture, testcase.variant)
+		}
+
+		if !reflect.DeepEqual(descriptor, deserialized.Manifests[testcase.expected]) {
+			t.Fatalf("%s/%s/%s: unexpected match: %#v", testcase.os, testcase.architecture, testcase.variant, descriptor)
+		}
+	}
+}
+
+func TestManifestListUnmarshalInvalid(t *testing.T) {
+	for _, payload := range []string{
+		`{"schemaVersion": 1, "mediaType": "` + MediaTypeManifestList + `"}`,
+		`{"schemaVersion": 2, "mediaType": "application/vnd.docker.distribution.manifest.v2+json"}`,
+		`{"schemaVersion": 2, "mediaType": "` + MediaTypeManifestList + `", "manifests": [{"platform": {"os": "linux"}}]}`,
+	} {
+		var m DeserializedManifestList
+		if err := json.Unmarshal([]byte(payload), &m); err == nil {
+			t.Fatalf("expected error unmarshaling %s", payload)
+		}
+	}
+}
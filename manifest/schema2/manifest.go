@@ -0,0 +1,146 @@
+// Package schema2 implements version 2 of the image manifest schema. Rather
+// than embedding signatures and v1 compatibility history, a schema 2 manifest
+// references an image configuration blob and an ordered list of layer blobs,
+// each described by its media type, size and digest.
+package schema2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+)
+
+const (
+	// MediaTypeManifest specifies the mediaType for the current version.
+	MediaTypeManifest = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// MediaTypeConfig specifies the mediaType for the image configuration.
+	MediaTypeConfig = "application/vnd.docker.container.image.v1+json"
+
+	// MediaTypeLayer is the mediaType used for layers referenced by the
+	// manifest.
+	MediaTypeLayer = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// SchemaVersion provides a pre-initialized version structure for this
+// packages version of the manifest.
+var SchemaVersion = manifest.Versioned{
+	SchemaVersion: 2,
+}
+
+func init() {
+	schema2Func := func(b []byte) (distribution.Manifest, distribution.Descriptor, error) {
+		m := new(DeserializedManifest)
+		if err := m.UnmarshalJSON(b); err != nil {
+			return nil, distribution.Descriptor{}, err
+		}
+
+		dgst, err := digest.FromBytes(b)
+		if err != nil {
+			return nil, distribution.Descriptor{}, err
+		}
+
+		return m, distribution.Descriptor{
+			MediaType: MediaTypeManifest,
+			Size:      int64(len(b)),
+			Digest:    dgst,
+		}, nil
+	}
+
+	if err := distribution.RegisterManifestSchema(MediaTypeManifest, schema2Func); err != nil {
+		panic(fmt.Sprintf("Unable to register manifest: %s", err))
+	}
+}
+
+// Manifest defines a schema2 manifest.
+type Manifest struct {
+	manifest.Versioned
+
+	// MediaType is the media type of this manifest.
+	MediaType string `json:"mediaType"`
+
+	// Config references the image configuration as a blob.
+	Config distribution.Descriptor `json:"config"`
+
+	// Layers lists descriptors for the layers referenced by the
+	// configuration, from the base layer up.
+	Layers []distribution.Descriptor `json:"layers"`
+}
+
+// References returns the descriptors of this manifest's config and layers.
+func (m Manifest) References() []distribution.Descriptor {
+	references := make([]distribution.Descriptor, 0, len(m.Layers)+1)
+	references = append(references, m.Config)
+	references = append(references, m.Layers...)
+	return references
+}
+
+// DeserializedManifest wraps Manifest with a copy of the original JSON. It
+// satisfies the distribution.Manifest interface.
+type DeserializedManifest struct {
+	Manifest
+
+	// canonical is the canonical byte representation of the Manifest. The
+	// manifest digest is calculated over these bytes.
+	canonical []byte
+}
+
+var _ distribution.Manifest = &DeserializedManifest{}
+
+// FromStruct takes a Manifest structure, marshals it to JSON, and returns a
+// DeserializedManifest which contains the manifest and its JSON
+// representation.
+func FromStruct(m Manifest) (*DeserializedManifest, error) {
+	var deserialized DeserializedManifest
+	deserialized.Manifest = m
+
+	var err error
+	deserialized.canonical, err = json.MarshalIndent(&m, "", "   ")
+	return &deserialized, err
+}
+
+// UnmarshalJSON populates a new Manifest struct from JSON data.
+func (m *DeserializedManifest) UnmarshalJSON(b []byte) error {
+	var mnfst Manifest
+	if err := json.Unmarshal(b, &mnfst); err != nil {
+		return err
+	}
+
+	if mnfst.SchemaVersion != SchemaVersion.SchemaVersion {
+		return fmt.Errorf("unsupported manifest schema version: %d", mnfst.SchemaVersion)
+	}
+
+	if mnfst.MediaType != MediaTypeManifest {
+		return fmt.Errorf("unexpected manifest media type: %q", mnfst.MediaType)
+	}
+
+	if mnfst.Config.Digest == "" {
+		return errors.New("manifest config descriptor is missing a digest")
+	}
+
+	m.Manifest = mnfst
+	m.canonical = make([]byte, len(b), len(b))
+	copy(m.canonical, b)
+
+	return nil
+}
+
+// MarshalJSON returns the contents of canonical. If canonical is empty,
+// marshals the inner contents.
+func (m *DeserializedManifest) MarshalJSON() ([]byte, error) {
+	if len(m.canonical) > 0 {
+		return m.canonical, nil
+	}
+
+	return nil, errors.New("JSON representation not initialized in DeserializedManifest")
+}
+
+// Payload returns the media type and canonical bytes of the manifest. Unlike
+// schema 1, these are the exact bytes the manifest digest is calculated over.
+func (m *DeserializedManifest) Payload() (string, []byte, error) {
+	return MediaTypeManifest, m.canonical, nil
+}
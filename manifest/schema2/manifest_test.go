@@ -0,0 +1,142 @@
+package schema2
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution"
+)
+
+var expectedManifestSerialization = []byte(`{
+   "schemaVersion": 2,
+   "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+   "config": {
+      "mediaType": "application/vnd.docker.container.image.v1+json",
+      "size": 985,
+      "digest": "sha256:1a9ec845ee94c202b2d5da74a24f0ed2058318bfa9879fa541efaecba272e86b"
+   },
+   "layers": [
+      {
+         "mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip",
+         "size": 153263,
+         "digest": "sha256:62d8908bee94c202b2d35224a221aaa2058318bfa9879fa541efaecba272331b"
+      }
+   ]
+}`)
+
+func makeTestManifest() Manifest {
+	return Manifest{
+		Versioned: SchemaVersion,
+		MediaType: MediaTypeManifest,
+		Config: distribution.Descriptor{
+			Digest:    "sha256:1a9ec845ee94c202b2d5da74a24f0ed2058318bfa9879fa541efaecba272e86b",
+			Size:      985,
+			MediaType: MediaTypeConfig,
+		},
+		Layers: []distribution.Descriptor{
+			{
+				Digest:    "sha256:62d8908bee94c202b2d35224a221aaa2058318bfa9879fa541efaecba272331b",
+				Size:      153263,
+				MediaType: MediaTypeLayer,
+			},
+		},
+	}
+}
+
+func TestManifest(t *testing.T) {
+	deserialized, err := FromStruct(makeTestManifest())
+	if err != nil {
+		t.Fatalf("error creating DeserializedManifest: %v", err)
+	}
+
+	mediaType, payload, err := deserialized.Payload()
+	if err != nil {
+		t.Fatalf("error getting payload: %v", err)
+	}
+
+	if mediaType != MediaTypeManifest {
+		t.Fatalf("unexpected media type: %q", mediaType)
+	}
+
+	if !bytes.Equal(payload, expectedManifestSerialization) {
+		t.Fatalf("manifest bytes not equal:\nexpected:\n%s\nactual:\n%s\n", string(expectedManifestSerialization), string(payload))
+	}
+
+	var unmarshalled DeserializedManifest
+	if err := json.Unmarshal(payload, &unmarshalled); err != nil {
+		t.Fatalf("error unmarshaling manifest: %v", err)
+	}
+
+	if !reflect.DeepEqual(&unmarshalled, deserialized) {
+		t.Fatalf("manifests are different after unmarshaling")
+	}
+
+	// The registered unmarshaler must produce the same manifest.
+	m, descriptor, err := distribution.UnmarshalManifest(MediaTypeManifest, payload)
+	if err != nil {
+		t.Fatalf("error unmarshaling manifest by media type: %v", err)
+	}
+
+	if !reflect.DeepEqual(m, deserialized) {
+		t.Fatalf("manifests are different after unmarshaling by media type")
+	}
+
+	if descriptor.MediaType != MediaTypeManifest || descriptor.Size != int64(len(payload)) {
+		t.Fatalf("unexpected descriptor: %#v", descriptor)
+	}
+
+	p, err := json.Marshal(&unmarshalled)
+	if err != nil {
+		t.Fatalf("error marshaling manifest: %v", err)
+	}
+
+	// json.Marshal compacts the output of MarshalJSON.
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, expectedManifestSerialization); err != nil {
+		t.Fatalf("error compacting expected serialization: %v", err)
+	}
+
+	if !bytes.Equal(p, compacted.Bytes()) {
+		t.Fatalf("unexpected marshaled manifest: %s", string(p))
+	}
+
+	references := deserialized.References()
+	if len(references) != 2 {
+		t.Fatalf("unexpected number of references: %d", len(references))
+	}
+
+	if !reflect.DeepEqual(references[0], deserialized.Config) {
+		t.Fatalf("first reference should be config")
+	}
+
+	if !reflect.DeepEqual(references[1], deserialized.Layers[0]) {
+		t.Fatalf("second reference should be layer")
+	}
+}
+
+func TestManifestUnmarshalInvalid(t *testing.T) {
+	for _, testcase := range []struct {
+		name    string
+		payload string
+	}{
+		{
+			name:    "schema1",
+			payload: `{"schemaVersion": 1, "name": "foo/bar", "tag": "latest"}`,
+		},
+		{
+			name:    "media type",
+			payload: `{"schemaVersion": 2, "mediaType": "application/json", "config": {"digest": "sha256:abc"}}`,
+		},
+		{
+			name:    "config",
+			payload: `{"schemaVersion": 2, "mediaType": "` + MediaTypeManifest + `"}`,
+		},
+	} {
+		var m DeserializedManifest
+		if err := json.Unmarshal([]byte(testcase.payload), &m); err == nil {
+			t.Fatalf("%s: expected error unmarshaling invalid manifest", testcase.name)
+		}
+	}
+}
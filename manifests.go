@@ -0,0 +1,97 @@
+package distribution
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"sync"
+)
+
+// Manifest represents a registry object specifying a set of references and
+// the serialized content describing them. Each supported manifest schema
+// provides an implementation, registered with RegisterManifestSchema.
+type Manifest interface {
+	// References returns a list of objects which make up this manifest.
+	// The references are strictly ordered from base to head. A reference
+	// is anything which can be represented by a Descriptor, such as a layer
+	// blob or another manifest.
+	References() []Descriptor
+
+	// Payload provides the serialized format of the manifest, in addition to
+	// the media type. These are the bytes returned to clients.
+	Payload() (mediaType string, payload []byte, err error)
+}
+
+// UnmarshalFunc implements manifest unmarshalling for a given media type. The
+// returned descriptor identifies the manifest: its digest is the digest
+// under which the manifest is stored.
+type UnmarshalFunc func([]byte) (Manifest, Descriptor, error)
+
+var (
+	mappingsMu sync.RWMutex
+	mappings   = make(map[string]UnmarshalFunc, 0)
+)
+
+// ManifestMediaTypes returns the registered manifest media types, excluding
+// the default registered for requests without a content type.
+func ManifestMediaTypes() (mediaTypes []string) {
+	mappingsMu.RLock()
+	defer mappingsMu.RUnlock()
+
+	for t := range mappings {
+		if t != "" {
+			mediaTypes = append(mediaTypes, t)
+		}
+	}
+	return
+}
+
+// UnmarshalManifest looks up the manifest unmarshal function for the media
+// type of the content type header and uses it to unmarshal p. When the header
+// is empty or generic JSON, a mediaType field in the content takes precedence.
+func UnmarshalManifest(ctHeader string, p []byte) (Manifest, Descriptor, error) {
+	var mediaType string
+	if ctHeader != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(ctHeader)
+		if err != nil {
+			return nil, Descriptor{}, err
+		}
+	}
+
+	if mediaType == "" || mediaType == "application/json" {
+		var probe struct {
+			MediaType string `json:"mediaType"`
+		}
+
+		if err := json.Unmarshal(p, &probe); err == nil && probe.MediaType != "" {
+			mediaType = probe.MediaType
+		}
+	}
+
+	mappingsMu.RLock()
+	unmarshalFunc, ok := mappings[mediaType]
+	mappingsMu.RUnlock()
+
+	if !ok {
+		return nil, Descriptor{}, fmt.Errorf("unsupported manifest media type: %q", mediaType)
+	}
+
+	return unmarshalFunc(p)
+}
+
+// RegisterManifestSchema registers an UnmarshalFunc for a given schema type.
+// This should be called from specific manifest schema packages in their
+// init functions. An empty media type registers the default used for
+// content without a type.
+func RegisterManifestSchema(mediaType string, u UnmarshalFunc) error {
+	mappingsMu.Lock()
+	defer mappingsMu.Unlock()
+
+	if _, ok := mappings[mediaType]; ok {
+		return fmt.Errorf("manifest media type registration would overwrite existing: %s", mediaType)
+	}
+
+	mappings[mediaType] = u
+	return nil
+}
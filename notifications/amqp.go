@@ -0,0 +1,248 @@
+package notifications
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// amqpSink publishes events to an AMQP 0-9-1 broker, such as RabbitMQ. Each
+// write publishes a single persistent message holding the envelope of the
+// events and waits for the broker to confirm it. Messages are mandatory: one
+// the broker can't route to any queue is returned and fails the write. The
+// connection is opened on the first write and reopened on the write following
+// any error. Reliability should be provided by the caller.
+type amqpSink struct {
+	url        *url.URL
+	exchange   string
+	routingKey string
+	mediaType  string
+	timeout    time.Duration
+	tlsConfig  *tls.Config
+	listeners  []deliveryListener
+
+	mu     sync.Mutex
+	closed bool
+	conn   *amqpConn
+}
+
+// newAMQPSink returns a sink publishing envelopes of the media type to the
+// exchange of the broker at the amqp or amqps url u, with the routing key.
+// The timeout bounds the connection handshake and each publication. Brokers
+// with the amqps scheme are connected to with tlsConfig, if not nil.
+func newAMQPSink(u *url.URL, exchange, routingKey, mediaType string, timeout time.Duration, tlsConfig *tls.Config, listeners ...deliveryListener) *amqpSink {
+	return &amqpSink{
+		url:        u,
+		exchange:   exchange,
+		routingKey: routingKey,
+		mediaType:  mediaType,
+		timeout:    timeout,
+		tlsConfig:  tlsConfig,
+		listeners:  listeners,
+	}
+}
+
+// Write publishes the events as a single message, returning an error if the
+// broker does not confirm it.
+func (as *amqpSink) Write(events ...Event) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	if as.closed {
+		return ErrSinkClosed
+	}
+
+	if err := as.publish(events...); err != nil {
+		for _, listener := range as.listeners {
+			listener.err(err, events...)
+		}
+		return fmt.Errorf("%v: error publishing events: %v", as, err)
+	}
+
+	for _, listener := range as.listeners {
+		listener.delivered(events...)
+	}
+
+	return nil
+}
+
+func (as *amqpSink) publish(events ...Event) error {
+	encoded := eventsForMediaType(as.mediaType, events)
+	if len(events) > 0 && len(encoded) == 0 {
+		return nil
+	}
+
+	p, err := json.Marshal(Envelope{Events: encoded})
+	if err != nil {
+		return err
+	}
+
+	if as.conn == nil {
+		conn, err := dialAMQP(as.url, as.timeout, as.tlsConfig)
+		if err != nil {
+			return err
+		}
+		as.conn = conn
+	}
+
+	if err := as.conn.publish(as.exchange, as.routingKey, as.mediaType, p, as.timeout); err != nil {
+		// The state of the connection is unknown, start over on the next
+		// write.
+		as.conn.close()
+		as.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+// Close the sink and its connection to the broker.
+func (as *amqpSink) Close() error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	if as.closed {
+		return fmt.Errorf("amqpsink: already closed")
+	}
+
+	as.closed = true
+	if as.conn != nil {
+		as.conn.close()
+		as.conn = nil
+	}
+
+	return nil
+}
+
+func (as *amqpSink) String() string {
+	return fmt.Sprintf("amqpSink{%s://%s, %q, %q}", as.url.Scheme, as.url.Host, as.exchange, as.routingKey)
+}
+
+func init() {
+	RegisterSink("amqp", SinkFactoryFunc(func(config SinkConfig) (Sink, error) {
+		u, err := url.Parse(config.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		if u.Scheme != "amqp" && u.Scheme != "amqps" {
+			return nil, fmt.Errorf("amqp endpoint url must use the amqp or amqps scheme: %q", config.URL)
+		}
+
+		if config.Envelope != EnvelopeRegistry {
+			return nil, fmt.Errorf("amqp endpoints do not support the %s envelope", config.Envelope)
+		}
+
+		exchange, err := stringParameter(config.Parameters, "exchange", "")
+		if err != nil {
+			return nil, err
+		}
+
+		routingKey, err := stringParameter(config.Parameters, "routingkey", "registry.events")
+		if err != nil {
+			return nil, err
+		}
+
+		var tlsConfig *tls.Config
+		if !config.TLS.empty() {
+			if tlsConfig, err = config.TLS.clientConfig(); err != nil {
+				return nil, err
+			}
+		}
+
+		return newAMQPSink(u, exchange, routingKey, config.MediaType, config.Timeout, tlsConfig, config.deliveryListeners()...), nil
+	}))
+}
+
+// amqpConn is a connection to a broker with a channel in confirm mode.
+type amqpConn struct {
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	closes   chan *amqp.Error
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+// dialAMQP connects to the broker at u, authenticating with the credentials
+// of the url, and opens a channel in confirm mode on the virtual host named
+// by the path of the url. Brokers with the amqps scheme are connected to with
+// tlsConfig.
+func dialAMQP(u *url.URL, timeout time.Duration, tlsConfig *tls.Config) (*amqpConn, error) {
+	conn, err := amqp.DialConfig(u.String(), amqp.Config{
+		Dial:            amqp.DefaultDial(timeout),
+		TLSClientConfig: tlsConfig,
+		Properties:      amqp.Table{"product": "docker-distribution"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := channel.Confirm(false); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// A single message is in flight at a time, so the broker is never
+	// blocked by a full notification channel.
+	return &amqpConn{
+		conn:     conn,
+		channel:  channel,
+		closes:   channel.NotifyClose(make(chan *amqp.Error, 1)),
+		confirms: channel.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		returns:  channel.NotifyReturn(make(chan amqp.Return, 1)),
+	}, nil
+}
+
+// publish sends a persistent, mandatory message and waits up to timeout for
+// the broker to confirm it.
+func (c *amqpConn) publish(exchange, routingKey, contentType string, body []byte, timeout time.Duration) error {
+	if err := c.channel.Publish(exchange, routingKey, true, false, amqp.Publishing{
+		ContentType:  contentType,
+		DeliveryMode: amqp.Persistent,
+		Body:         body,
+	}); err != nil {
+		return err
+	}
+
+	select {
+	case confirm, ok := <-c.confirms:
+		if !ok {
+			// The broker closed the channel, report why if it said so.
+			if err, ok := <-c.closes; ok && err != nil {
+				return err
+			}
+			return amqp.ErrClosed
+		}
+
+		if !confirm.Ack {
+			return fmt.Errorf("amqp: message rejected by broker")
+		}
+	case <-time.After(timeout):
+		return fmt.Errorf("amqp: timeout waiting for confirmation")
+	}
+
+	// The broker returns an unroutable message before confirming it.
+	select {
+	case r := <-c.returns:
+		return fmt.Errorf("amqp: message returned by broker: %d %s", r.ReplyCode, r.ReplyText)
+	default:
+	}
+
+	return nil
+}
+
+// close closes the connection gracefully.
+func (c *amqpConn) close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,567 @@
+package notifications
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// TestAMQPSink publishes events to an in-process broker speaking the subset
+// of AMQP 0-9-1 used by the sink.
+func TestAMQPSink(t *testing.T) {
+	broker := newFakeAMQPBroker(t, 4096)
+	defer broker.Close()
+
+	sink, err := CreateSink("amqp", SinkConfig{
+		URL:     "amqp://registry:s3cr3t@" + broker.Addr().String() + "/events",
+		Timeout: time.Second,
+		Parameters: map[string]interface{}{
+			"exchange":   "registry",
+			"routingkey": "registry.push",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating sink: %v", err)
+	}
+
+	// The envelope spans several body frames.
+	var events []Event
+	for i := 0; i < 50; i++ {
+		events = append(events, createTestEvent("push", "library/test", "blob"))
+	}
+
+	if err := sink.Write(events...); err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+
+	broker.mu.Lock()
+	if len(broker.messages) != 1 {
+		t.Fatalf("unexpected number of messages: %d != 1", len(broker.messages))
+	}
+
+	message := broker.messages[0]
+	if broker.auth[0] != "\x00registry\x00s3cr3t" || broker.vhosts[0] != "events" {
+		t.Fatalf("unexpected connection: auth=%q vhost=%q", broker.auth[0], broker.vhosts[0])
+	}
+	broker.mu.Unlock()
+
+	if message.exchange != "registry" || message.routingKey != "registry.push" {
+		t.Fatalf("unexpected destination: %q %q", message.exchange, message.routingKey)
+	}
+
+	if message.contentType != EventsMediaType || message.deliveryMode != amqp.Persistent {
+		t.Fatalf("unexpected properties: %q %d", message.contentType, message.deliveryMode)
+	}
+
+	if !message.mandatory {
+		t.Fatalf("message should be mandatory")
+	}
+
+	if len(message.body) <= 4096 {
+		t.Fatalf("message should span several frames: %d bytes", len(message.body))
+	}
+
+	var envelope Envelope
+	if err := json.Unmarshal(message.body, &envelope); err != nil {
+		t.Fatalf("unexpected error decoding envelope: %v", err)
+	}
+	checkEventIDs(t, envelope.Events, events)
+
+	// A rejected message fails the write and the next write reconnects.
+	broker.mu.Lock()
+	broker.nacks = 1
+	broker.mu.Unlock()
+
+	if err := sink.Write(events[0]); err == nil {
+		t.Fatalf("expected error writing rejected message")
+	}
+
+	if err := sink.Write(events[0]); err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+
+	// So does a message the broker can't route to any queue, even though
+	// the broker confirms it.
+	broker.mu.Lock()
+	broker.unroutable = 1
+	broker.mu.Unlock()
+
+	if err := sink.Write(events[0]); err == nil {
+		t.Fatalf("expected error writing unroutable message")
+	}
+
+	if err := sink.Write(events[0]); err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+
+	checkClose(t, sink)
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if len(broker.messages) != 3 || len(broker.auth) != 3 {
+		t.Fatalf("unexpected messages or connections: %d, %d", len(broker.messages), len(broker.auth))
+	}
+}
+
+func TestAMQPSinkInvalidURL(t *testing.T) {
+	if _, err := CreateSink("amqp", SinkConfig{URL: "http://localhost/"}); err == nil {
+		t.Fatalf("expected error creating sink with http url")
+	}
+}
+
+type amqpTestMessage struct {
+	exchange     string
+	routingKey   string
+	mandatory    bool
+	contentType  string
+	deliveryMode uint8
+	body         []byte
+}
+
+// fakeAMQPBroker accepts connections and confirms published messages,
+// rejecting the number of messages set in nacks first, and returning the
+// number set in unroutable after that.
+type fakeAMQPBroker struct {
+	net.Listener
+	t        *testing.T
+	frameMax uint32
+
+	mu         sync.Mutex
+	nacks      int
+	unroutable int
+	auth       []string
+	vhosts     []string
+	messages   []amqpTestMessage
+}
+
+func newFakeAMQPBroker(t *testing.T, frameMax uint32) *fakeAMQPBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+
+	broker := &fakeAMQPBroker{Listener: l, t: t, frameMax: frameMax}
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(nc)
+		}
+	}()
+
+	return broker
+}
+
+func (b *fakeAMQPBroker) serve(nc net.Conn) {
+	defer nc.Close()
+	c := &fakeAMQPConn{Conn: nc, r: bufio.NewReader(nc)}
+
+	header := make([]byte, len(amqpProtocolHeader))
+	if _, err := io.ReadFull(c.r, header); err != nil || !bytes.Equal(header, amqpProtocolHeader) {
+		b.t.Errorf("unexpected protocol header: %q, %v", header, err)
+		return
+	}
+
+	var start amqpBuffer
+	start.octet(0)
+	start.octet(9)
+	start.table(map[string]string{"product": "fake"})
+	start.longstr("AMQPLAIN PLAIN")
+	start.longstr("en_US")
+	c.writeMethod(0, amqpConnectionStart, start.Bytes())
+
+	args, err := c.expect(amqpConnectionStartOk)
+	if err != nil {
+		b.t.Errorf("unexpected error reading start-ok: %v", err)
+		return
+	}
+	args.table()
+	mechanism := args.shortstr()
+	response := args.longstr()
+	if mechanism != "PLAIN" {
+		b.t.Errorf("unexpected mechanism: %q", mechanism)
+	}
+
+	var tune amqpBuffer
+	tune.short(0)
+	tune.long(b.frameMax)
+	tune.short(60)
+	c.writeMethod(0, amqpConnectionTune, tune.Bytes())
+
+	if args, err = c.expect(amqpConnectionTuneOk); err != nil {
+		b.t.Errorf("unexpected error reading tune-ok: %v", err)
+		return
+	}
+	args.short()
+	if frameMax := args.long(); frameMax != b.frameMax {
+		b.t.Errorf("unexpected frame max: %d != %d", frameMax, b.frameMax)
+	}
+
+	if args, err = c.expect(amqpConnectionOpen); err != nil {
+		b.t.Errorf("unexpected error reading open: %v", err)
+		return
+	}
+	vhost := args.shortstr()
+
+	var openOk amqpBuffer
+	openOk.shortstr("")
+	c.writeMethod(0, amqpConnectionOpenOk, openOk.Bytes())
+
+	if _, err := c.expect(amqpChannelOpen); err != nil {
+		b.t.Errorf("unexpected error reading channel open: %v", err)
+		return
+	}
+
+	var channelOpenOk amqpBuffer
+	channelOpenOk.longstr("")
+	c.writeMethod(amqpChannel, amqpChannelOpenOk, channelOpenOk.Bytes())
+
+	if _, err := c.expect(amqpConfirmSelect); err != nil {
+		b.t.Errorf("unexpected error reading confirm select: %v", err)
+		return
+	}
+	c.writeMethod(amqpChannel, amqpConfirmSelectOk, nil)
+
+	b.mu.Lock()
+	b.auth = append(b.auth, response)
+	b.vhosts = append(b.vhosts, vhost)
+	b.mu.Unlock()
+
+	var tag uint64
+	for {
+		// The connection close of the client is acknowledged by readMethod.
+		args, err := c.expect(amqpBasicPublish)
+		if err != nil {
+			return
+		}
+
+		var message amqpTestMessage
+		args.short()
+		message.exchange = args.shortstr()
+		message.routingKey = args.shortstr()
+		message.mandatory = args.octet()&1 != 0
+
+		_, _, payload, err := c.readFrame()
+		if err != nil {
+			b.t.Errorf("unexpected error reading content header: %v", err)
+			return
+		}
+
+		header := &amqpDecoder{p: payload}
+		header.short()
+		header.short()
+		size := header.longlong()
+		header.short()
+		message.contentType = header.shortstr()
+		message.deliveryMode = header.octet()
+
+		for uint64(len(message.body)) < size {
+			typ, _, payload, err := c.readFrame()
+			if err != nil || typ != amqpFrameBody {
+				b.t.Errorf("unexpected body frame: %d, %v", typ, err)
+				return
+			}
+
+			if len(payload)+amqpFrameOverhead > int(b.frameMax) {
+				b.t.Errorf("body frame exceeds frame max: %d", len(payload))
+			}
+			message.body = append(message.body, payload...)
+		}
+
+		tag++
+		b.mu.Lock()
+		nack := b.nacks > 0
+		unroutable := !nack && b.unroutable > 0
+		switch {
+		case nack:
+			b.nacks--
+		case unroutable:
+			b.unroutable--
+		default:
+			b.messages = append(b.messages, message)
+		}
+		b.mu.Unlock()
+
+		if unroutable && message.mandatory {
+			var ret amqpBuffer
+			ret.short(312)
+			ret.shortstr("NO_ROUTE")
+			ret.shortstr(message.exchange)
+			ret.shortstr(message.routingKey)
+			c.writeMethod(amqpChannel, amqpBasicReturn, ret.Bytes())
+
+			var header amqpBuffer
+			header.short(amqpBasicClass)
+			header.short(0)
+			header.longlong(uint64(len(message.body)))
+			header.short(0)
+			c.writeFrame(amqpFrameHeader, amqpChannel, header.Bytes())
+			c.writeFrame(amqpFrameBody, amqpChannel, message.body)
+		}
+
+		var confirm amqpBuffer
+		confirm.longlong(tag)
+		confirm.octet(0)
+		if nack {
+			c.writeMethod(amqpChannel, amqpBasicNack, confirm.Bytes())
+		} else {
+			c.writeMethod(amqpChannel, amqpBasicAck, confirm.Bytes())
+		}
+	}
+}
+
+// The subset of AMQP 0-9-1 framing needed by the fake broker follows.
+
+const (
+	amqpFrameMethod    = 1
+	amqpFrameHeader    = 2
+	amqpFrameBody      = 3
+	amqpFrameHeartbeat = 8
+	amqpFrameEnd       = 0xce
+
+	// amqpFrameOverhead is the size of the header and end of frames.
+	amqpFrameOverhead = 8
+
+	// amqpChannel is the channel messages are published on.
+	amqpChannel = 1
+
+	// amqpBasicClass is the class of content headers of published messages.
+	amqpBasicClass = 60
+)
+
+// amqpProtocolHeader starts connections to the broker.
+var amqpProtocolHeader = []byte("AMQP\x00\x00\x09\x01")
+
+// amqpMethod identifies a method by class and method id.
+type amqpMethod struct {
+	class, method uint16
+}
+
+func (m amqpMethod) String() string {
+	return fmt.Sprintf("%d.%d", m.class, m.method)
+}
+
+var (
+	amqpConnectionStart   = amqpMethod{10, 10}
+	amqpConnectionStartOk = amqpMethod{10, 11}
+	amqpConnectionTune    = amqpMethod{10, 30}
+	amqpConnectionTuneOk  = amqpMethod{10, 31}
+	amqpConnectionOpen    = amqpMethod{10, 40}
+	amqpConnectionOpenOk  = amqpMethod{10, 41}
+	amqpConnectionClose   = amqpMethod{10, 50}
+	amqpConnectionCloseOk = amqpMethod{10, 51}
+	amqpChannelOpen       = amqpMethod{20, 10}
+	amqpChannelOpenOk     = amqpMethod{20, 11}
+	amqpChannelClose      = amqpMethod{20, 40}
+	amqpBasicPublish      = amqpMethod{60, 40}
+	amqpBasicReturn       = amqpMethod{60, 50}
+	amqpBasicAck          = amqpMethod{60, 80}
+	amqpBasicNack         = amqpMethod{60, 120}
+	amqpConfirmSelect     = amqpMethod{85, 10}
+	amqpConfirmSelectOk   = amqpMethod{85, 11}
+)
+
+// fakeAMQPConn is the broker end of a connection.
+type fakeAMQPConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// writeMethod sends a method frame on the channel.
+func (c *fakeAMQPConn) writeMethod(channel uint16, m amqpMethod, args []byte) error {
+	var payload amqpBuffer
+	payload.short(m.class)
+	payload.short(m.method)
+	payload.Write(args)
+	return c.writeFrame(amqpFrameMethod, channel, payload.Bytes())
+}
+
+// writeFrame sends a frame with the payload on the channel.
+func (c *fakeAMQPConn) writeFrame(typ byte, channel uint16, payload []byte) error {
+	var frame amqpBuffer
+	frame.octet(typ)
+	frame.short(channel)
+	frame.long(uint32(len(payload)))
+	frame.Write(payload)
+	frame.octet(amqpFrameEnd)
+	_, err := c.Write(frame.Bytes())
+	return err
+}
+
+// expect reads the next method, returning an error if it is not m.
+func (c *fakeAMQPConn) expect(m amqpMethod) (*amqpDecoder, error) {
+	received, args, err := c.readMethod()
+	if err != nil {
+		return nil, err
+	}
+
+	if received != m {
+		return nil, fmt.Errorf("amqp: unexpected method %v, expected %v", received, m)
+	}
+
+	return args, nil
+}
+
+// readMethod reads the next method frame, skipping heartbeats. The closing of
+// the connection or the channel by the client is returned as an error, after
+// acknowledging the closing of the connection.
+func (c *fakeAMQPConn) readMethod() (amqpMethod, *amqpDecoder, error) {
+	for {
+		typ, _, payload, err := c.readFrame()
+		if err != nil {
+			return amqpMethod{}, nil, err
+		}
+
+		switch typ {
+		case amqpFrameHeartbeat:
+			continue
+		case amqpFrameMethod:
+		default:
+			return amqpMethod{}, nil, fmt.Errorf("amqp: unexpected frame type %d", typ)
+		}
+
+		args := &amqpDecoder{p: payload}
+		m := amqpMethod{args.short(), args.short()}
+		if args.err != nil {
+			return amqpMethod{}, nil, args.err
+		}
+
+		if m == amqpConnectionClose || m == amqpChannelClose {
+			if m == amqpConnectionClose {
+				c.writeMethod(0, amqpConnectionCloseOk, nil)
+			}
+			return amqpMethod{}, nil, fmt.Errorf("amqp: closed by client")
+		}
+
+		return m, args, nil
+	}
+}
+
+// readFrame reads the next frame.
+func (c *fakeAMQPConn) readFrame() (typ byte, channel uint16, payload []byte, err error) {
+	var header [7]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, 0, nil, err
+	}
+
+	p := make([]byte, binary.BigEndian.Uint32(header[3:])+1)
+	if _, err := io.ReadFull(c.r, p); err != nil {
+		return 0, 0, nil, err
+	}
+
+	if p[len(p)-1] != amqpFrameEnd {
+		return 0, 0, nil, fmt.Errorf("amqp: invalid frame end")
+	}
+
+	return header[0], binary.BigEndian.Uint16(header[1:]), p[:len(p)-1], nil
+}
+
+// amqpBuffer encodes protocol fields.
+type amqpBuffer struct {
+	bytes.Buffer
+}
+
+func (b *amqpBuffer) octet(v byte) {
+	b.WriteByte(v)
+}
+
+func (b *amqpBuffer) short(v uint16) {
+	binary.Write(b, binary.BigEndian, v)
+}
+
+func (b *amqpBuffer) long(v uint32) {
+	binary.Write(b, binary.BigEndian, v)
+}
+
+func (b *amqpBuffer) longlong(v uint64) {
+	binary.Write(b, binary.BigEndian, v)
+}
+
+func (b *amqpBuffer) shortstr(s string) {
+	b.octet(byte(len(s)))
+	b.WriteString(s)
+}
+
+func (b *amqpBuffer) longstr(s string) {
+	b.long(uint32(len(s)))
+	b.WriteString(s)
+}
+
+// table encodes a field table of string values.
+func (b *amqpBuffer) table(fields map[string]string) {
+	var t amqpBuffer
+	for k, v := range fields {
+		t.shortstr(k)
+		t.octet('S')
+		t.longstr(v)
+	}
+
+	b.long(uint32(t.Len()))
+	b.Write(t.Bytes())
+}
+
+// amqpDecoder decodes protocol fields. The first error encountered is kept
+// and zero values are returned after it.
+type amqpDecoder struct {
+	p   []byte
+	err error
+}
+
+func (d *amqpDecoder) next(n int) []byte {
+	if d.err == nil && len(d.p) < n {
+		d.err = io.ErrUnexpectedEOF
+	}
+
+	if d.err != nil {
+		// Large enough for any integer, strings are left empty.
+		if n > 8 {
+			return nil
+		}
+		return make([]byte, n)
+	}
+
+	p := d.p[:n]
+	d.p = d.p[n:]
+	return p
+}
+
+func (d *amqpDecoder) octet() byte {
+	return d.next(1)[0]
+}
+
+func (d *amqpDecoder) short() uint16 {
+	return binary.BigEndian.Uint16(d.next(2))
+}
+
+func (d *amqpDecoder) long() uint32 {
+	return binary.BigEndian.Uint32(d.next(4))
+}
+
+func (d *amqpDecoder) longlong() uint64 {
+	return binary.BigEndian.Uint64(d.next(8))
+}
+
+func (d *amqpDecoder) shortstr() string {
+	return string(d.next(int(d.octet())))
+}
+
+func (d *amqpDecoder) longstr() string {
+	return string(d.next(int(d.long())))
+}
+
+// table skips a field table, returning its encoded fields.
+func (d *amqpDecoder) table() []byte {
+	return d.next(int(d.long()))
+}
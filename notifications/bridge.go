@@ -2,13 +2,13 @@ package notifications
 
 import (
 	"net/http"
+	"sync"
 	"time"
 
 	"code.google.com/p/go-uuid/uuid"
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/context"
 	"github.com/docker/distribution/digest"
-	"github.com/docker/distribution/manifest"
 )
 
 type bridge struct {
@@ -17,6 +17,11 @@ type bridge struct {
 	source  SourceRecord
 	request RequestRecord
 	sink    Sink
+
+	// deferred events are held in pending until flushed.
+	deferred bool
+	mu       sync.Mutex
+	pending  []Event
 }
 
 var _ Listener = &bridge{}
@@ -41,6 +46,47 @@ func NewBridge(ub URLBuilder, source SourceRecord, actor ActorRecord, request Re
 	}
 }
 
+// DeferredBridge is a notification listener holding the events of a request
+// until its response has been written, so that the events can describe the
+// response.
+type DeferredBridge struct {
+	*bridge
+}
+
+// NewDeferredBridge returns a notification listener like NewBridge, except
+// that events are only written to sink when Flush is called.
+func NewDeferredBridge(ub URLBuilder, source SourceRecord, actor ActorRecord, request RequestRecord, sink Sink) *DeferredBridge {
+	return &DeferredBridge{
+		bridge: &bridge{
+			ub:       ub,
+			actor:    actor,
+			source:   source,
+			request:  request,
+			sink:     sink,
+			deferred: true,
+		},
+	}
+}
+
+// Flush writes the events held by the bridge to the sink, recording the
+// response to the request in each of them.
+func (db *DeferredBridge) Flush(response ResponseRecord) error {
+	db.mu.Lock()
+	events := db.pending
+	db.pending = nil
+	db.mu.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	for i := range events {
+		events[i].Response = &response
+	}
+
+	return db.sink.Write(events...)
+}
+
 // NewRequestRecord builds a RequestRecord for use in NewBridge from an
 // http.Request, associating it with a request id.
 func NewRequestRecord(id string, r *http.Request) RequestRecord {
@@ -53,16 +99,57 @@ func NewRequestRecord(id string, r *http.Request) RequestRecord {
 	}
 }
 
-func (b *bridge) ManifestPushed(repo distribution.Repository, sm *manifest.SignedManifest) error {
-	return b.createManifestEventAndWrite(EventActionPush, repo, sm)
+func (b *bridge) ManifestPushed(repo distribution.Repository, m distribution.Manifest, tag string) error {
+	return b.createManifestEventAndWrite(EventActionPush, repo, m, tag)
+}
+
+func (b *bridge) ManifestPulled(repo distribution.Repository, m distribution.Manifest, tag string) error {
+	return b.createManifestEventAndWrite(EventActionPull, repo, m, tag)
+}
+
+func (b *bridge) ManifestDeleted(repo distribution.Repository, m distribution.Manifest) error {
+	return b.createManifestEventAndWrite(EventActionDelete, repo, m, "")
+}
+
+func (b *bridge) ManifestUnverified(repo distribution.Repository, m distribution.Manifest, verification distribution.SignatureVerification) error {
+	return b.createManifestEventAndWrite(EventActionUnverified, repo, m, "")
+}
+
+func (b *bridge) ManifestTagged(repo distribution.Repository, tag string, desc distribution.Descriptor) error {
+	return b.createTagEventAndWrite(EventActionTag, repo, tag, desc)
+}
+
+func (b *bridge) ManifestUntagged(repo distribution.Repository, tag string, desc distribution.Descriptor) error {
+	return b.createTagEventAndWrite(EventActionUntag, repo, tag, desc)
+}
+
+func (b *bridge) TagMoved(repo distribution.Repository, tag string, from, to distribution.Descriptor) error {
+	event, err := b.createTagEvent(EventActionMove, repo, tag, to)
+	if err != nil {
+		return err
+	}
+	event.Target.Previous = &from
+
+	return b.write(*event)
+}
+
+func (b *bridge) UploadStarted(repo distribution.Repository, upload distribution.LayerUpload) error {
+	return b.createUploadEventAndWrite(EventActionUpload, repo.Name(), upload.UUID())
+}
+
+func (b *bridge) UploadCancelled(repo distribution.Repository, upload distribution.LayerUpload) error {
+	return b.createUploadEventAndWrite(EventActionCancel, repo.Name(), upload.UUID())
 }
 
-func (b *bridge) ManifestPulled(repo distribution.Repository, sm *manifest.SignedManifest) error {
-	return b.createManifestEventAndWrite(EventActionPull, repo, sm)
+func (b *bridge) UploadExpired(repo, uuid string, startedAt time.Time) error {
+	return b.createUploadEventAndWrite(EventActionExpire, repo, uuid)
 }
 
-func (b *bridge) ManifestDeleted(repo distribution.Repository, sm *manifest.SignedManifest) error {
-	return b.createManifestEventAndWrite(EventActionDelete, repo, sm)
+func (b *bridge) RepositoryCreated(repo distribution.Repository) error {
+	event := b.createEvent(EventActionCreate)
+	event.Target.Repository = repo.Name()
+
+	return b.write(*event)
 }
 
 func (b *bridge) LayerPushed(repo distribution.Repository, layer distribution.Layer) error {
@@ -77,35 +164,98 @@ func (b *bridge) LayerDeleted(repo distribution.Repository, layer distribution.L
 	return b.createLayerEventAndWrite(EventActionDelete, repo, layer)
 }
 
-func (b *bridge) createManifestEventAndWrite(action string, repo distribution.Repository, sm *manifest.SignedManifest) error {
-	manifestEvent, err := b.createManifestEvent(action, repo, sm)
+func (b *bridge) createManifestEventAndWrite(action string, repo distribution.Repository, m distribution.Manifest, tag string) error {
+	manifestEvent, err := b.createManifestEvent(action, repo, m, tag)
 	if err != nil {
 		return err
 	}
 
-	return b.sink.Write(*manifestEvent)
+	return b.write(*manifestEvent)
 }
 
-func (b *bridge) createManifestEvent(action string, repo distribution.Repository, sm *manifest.SignedManifest) (*Event, error) {
+func (b *bridge) createManifestEvent(action string, repo distribution.Repository, m distribution.Manifest, tag string) (*Event, error) {
 	event := b.createEvent(action)
-	event.Target.MediaType = manifest.ManifestMediaType
 	event.Target.Repository = repo.Name()
+	event.Target.Tag = tag
+
+	mediaType, p, err := m.Payload()
+	if err != nil {
+		return nil, err
+	}
 
-	p, err := sm.Payload()
+	// Unmarshal the payload through the registered schema to find the
+	// canonical descriptor, since the digest of a manifest does not always
+	// cover the payload bytes verbatim.
+	_, desc, err := distribution.UnmarshalManifest(mediaType, p)
 	if err != nil {
 		return nil, err
 	}
 
-	event.Target.Length = int64(len(p))
+	event.Target.MediaType = mediaType
+	event.Target.Length = desc.Size
+	event.Target.Size = desc.Size
+	event.Target.Digest = desc.Digest
+	// The repository passed to listeners is not wrapped by Listen, so
+	// sizing the references does not count as pulling them.
+	event.Target.References, event.Target.ImageSize = manifestReferences(repo.Layers(), m, desc.Size)
 
-	event.Target.Digest, err = digest.FromBytes(p)
+	event.Target.URL, err = b.ub.BuildManifestURL(repo.Name(), desc.Digest.String())
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO(stevvooe): Currently, the is the "tag" url: once the digest url is
-	// implemented, this should be replaced.
-	event.Target.URL, err = b.ub.BuildManifestURL(sm.Name, sm.Tag)
+	return event, nil
+}
+
+// manifestReferences returns the references of the manifest and the size of
+// the image: the sum of the sizes of the manifest and its references.
+// References without a size, such as the layers of signed manifests, are
+// sized by their blob in layers, which must not dispatch pull events. The
+// image size is zero if one of them cannot be found.
+func manifestReferences(layers distribution.LayerService, m distribution.Manifest, size int64) ([]distribution.Descriptor, int64) {
+	references := m.References()
+	imageSize := size
+
+	for i, reference := range references {
+		if reference.Size == 0 {
+			layer, err := layers.Fetch(reference.Digest)
+			if err != nil {
+				return references, 0
+			}
+			references[i].Size = layer.Length()
+			layer.Close()
+		}
+		imageSize += references[i].Size
+	}
+
+	return references, imageSize
+}
+
+func (b *bridge) createTagEventAndWrite(action string, repo distribution.Repository, tag string, desc distribution.Descriptor) error {
+	event, err := b.createTagEvent(action, repo, tag, desc)
+	if err != nil {
+		return err
+	}
+
+	return b.write(*event)
+}
+
+func (b *bridge) createTagEvent(action string, repo distribution.Repository, tag string, desc distribution.Descriptor) (*Event, error) {
+	event := b.createEvent(action)
+	event.Target.Descriptor = desc
+	event.Target.Length = desc.Size
+	event.Target.Repository = repo.Name()
+	event.Target.Tag = tag
+
+	// The tag url only resolves while the tag exists: untag events link to
+	// the manifest by digest instead.
+	reference := tag
+	if action == EventActionUntag {
+		reference = desc.Digest.String()
+	}
+
+	var err error
+	event.Target.URL, err = b.ub.BuildManifestURL(repo.Name(), reference)
 	if err != nil {
 		return nil, err
 	}
@@ -119,15 +269,16 @@ func (b *bridge) createLayerEventAndWrite(action string, repo distribution.Repos
 		return err
 	}
 
-	return b.sink.Write(*event)
+	return b.write(*event)
 }
 
 func (b *bridge) createLayerEvent(action string, repo distribution.Repository, layer distribution.Layer) (*Event, error) {
 	event := b.createEvent(action)
-	event.Target.MediaType = layerMediaType
+	event.Target.MediaType = LayerMediaType
 	event.Target.Repository = repo.Name()
 
 	event.Target.Length = layer.Length()
+	event.Target.Size = layer.Length()
 
 	dgst := layer.Digest()
 	event.Target.Digest = dgst
@@ -141,6 +292,29 @@ func (b *bridge) createLayerEvent(action string, repo distribution.Repository, l
 	return event, nil
 }
 
+func (b *bridge) createUploadEventAndWrite(action string, repo, uuid string) error {
+	event := b.createEvent(action)
+	event.Target.MediaType = LayerMediaType
+	event.Target.Repository = repo
+	event.Target.UUID = uuid
+
+	return b.write(*event)
+}
+
+// write writes the event to the sink, or holds it until flushed if the bridge
+// is deferred.
+func (b *bridge) write(event Event) error {
+	if !b.deferred {
+		return b.sink.Write(event)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending = append(b.pending, event)
+	return nil
+}
+
 // createEvent creates an event with actor and source populated.
 func (b *bridge) createEvent(action string) *Event {
 	event := createEvent(action)
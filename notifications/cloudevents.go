@@ -0,0 +1,156 @@
+package notifications
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Envelopes select how events are encoded in the requests to http
+// endpoints.
+const (
+	// EnvelopeRegistry posts the events in an Envelope of the media type of
+	// the endpoint.
+	EnvelopeRegistry = "registry"
+
+	// EnvelopeCloudEvents posts each event as a CloudEvent in structured
+	// content mode, the attributes and the event being encoded together in
+	// a body of CloudEventsMediaType.
+	EnvelopeCloudEvents = "cloudevents"
+
+	// EnvelopeCloudEventsBinary posts each event as a CloudEvent in binary
+	// content mode, the attributes being carried by ce- headers and the
+	// event by a json body. As signatures do not cover the headers, it
+	// cannot be combined with secrets.
+	EnvelopeCloudEventsBinary = "cloudevents-binary"
+)
+
+const (
+	// CloudEventsMediaType is the media type of CloudEvents in structured
+	// content mode.
+	CloudEventsMediaType = "application/cloudevents+json"
+
+	// CloudEventsSpecVersion is the version of the CloudEvents specification
+	// implemented by the cloudevents envelopes.
+	CloudEventsSpecVersion = "1.0"
+
+	// CloudEventsTypePrefix prefixes the action of an event to form the type
+	// of its CloudEvent, such as "com.docker.distribution.push".
+	CloudEventsTypePrefix = "com.docker.distribution."
+
+	// cloudEventsDataContentType is the content type of the data of
+	// CloudEvents, which is the event itself.
+	cloudEventsDataContentType = "application/json"
+
+	// cloudEventsDefaultSource is the source of events without a source
+	// address.
+	cloudEventsDefaultSource = "docker-distribution"
+)
+
+// validEnvelope returns true if envelope is a supported envelope, the empty
+// string selecting EnvelopeRegistry.
+func validEnvelope(envelope string) bool {
+	switch envelope {
+	case "", EnvelopeRegistry, EnvelopeCloudEvents, EnvelopeCloudEventsBinary:
+		return true
+	}
+
+	return false
+}
+
+// cloudEvent is a CloudEvent in structured content mode. The event is
+// carried whole as the data of the CloudEvent, with its identifier, time,
+// action, source and target mapped to the attributes.
+type cloudEvent struct {
+	SpecVersion     string     `json:"specversion"`
+	ID              string     `json:"id"`
+	Source          string     `json:"source"`
+	Type            string     `json:"type"`
+	Time            *time.Time `json:"time,omitempty"`
+	Subject         string     `json:"subject,omitempty"`
+	DataContentType string     `json:"datacontenttype"`
+	Data            Event      `json:"data"`
+}
+
+// newCloudEvent maps event to the attributes of a CloudEvent:
+//
+//	id      the id of the event
+//	source  //<source addr>, the registry node that generated the event
+//	type    CloudEventsTypePrefix followed by the action
+//	time    the timestamp of the event, if set
+//	subject the repository of the target, followed by :<tag> if the event
+//	        has a tag or @<digest> otherwise, if it has a digest
+func newCloudEvent(event Event) cloudEvent {
+	ce := cloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              event.ID,
+		Source:          cloudEventSource(event.Source),
+		Type:            CloudEventsTypePrefix + event.Action,
+		Subject:         event.Target.Repository,
+		DataContentType: cloudEventsDataContentType,
+		Data:            event,
+	}
+
+	if !event.Timestamp.IsZero() {
+		timestamp := event.Timestamp.UTC()
+		ce.Time = &timestamp
+	}
+
+	if ce.Subject != "" {
+		switch {
+		case event.Target.Tag != "":
+			ce.Subject += ":" + event.Target.Tag
+		case event.Target.Digest != "":
+			ce.Subject += "@" + string(event.Target.Digest)
+		}
+	}
+
+	return ce
+}
+
+// cloudEventSource returns the source attribute of events generated by the
+// registry node of source, as a network-path reference.
+func cloudEventSource(source SourceRecord) string {
+	if source.Addr == "" {
+		return cloudEventsDefaultSource
+	}
+
+	return "//" + source.Addr
+}
+
+// encodeCloudEvent returns the body and headers of a request delivering the
+// event as a CloudEvent with the envelope, either EnvelopeCloudEvents or
+// EnvelopeCloudEventsBinary.
+func encodeCloudEvent(envelope string, event Event) ([]byte, http.Header, error) {
+	ce := newCloudEvent(event)
+	header := make(http.Header)
+
+	if envelope != EnvelopeCloudEventsBinary {
+		p, err := json.MarshalIndent(ce, "", "   ")
+		if err != nil {
+			return nil, nil, err
+		}
+
+		header.Set("Content-Type", CloudEventsMediaType)
+		return p, header, nil
+	}
+
+	p, err := json.MarshalIndent(ce.Data, "", "   ")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header.Set("Content-Type", ce.DataContentType)
+	header.Set("ce-specversion", ce.SpecVersion)
+	header.Set("ce-id", ce.ID)
+	header.Set("ce-source", ce.Source)
+	header.Set("ce-type", ce.Type)
+	if ce.Time != nil {
+		header.Set("ce-time", ce.Time.Format(time.RFC3339Nano))
+	}
+	if ce.Subject != "" {
+		header.Set("ce-subject", ce.Subject)
+	}
+
+	return p, header, nil
+}
@@ -0,0 +1,229 @@
+package notifications
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+)
+
+func createTestCloudEvent(action string) Event {
+	event := createTestEvent(action, "library/test", manifest.ManifestMediaType)
+	event.Timestamp = time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	event.Target.Digest = digest.Digest("sha256:0123456789abcdef0")
+	event.Source = SourceRecord{Addr: "registry.example.com:5000", InstanceID: "instance"}
+	return event
+}
+
+// TestCloudEventsStructured ensures that each event is posted as a
+// CloudEvent with its attributes and data in the body.
+func TestCloudEventsStructured(t *testing.T) {
+	var received []cloudEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		if r.Header.Get("Content-Type") != CloudEventsMediaType {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var ce cloudEvent
+		if err := json.NewDecoder(r.Body).Decode(&ce); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, ce)
+	}))
+	defer server.Close()
+
+	sink := newHTTPSink(server.URL, EnvelopeCloudEvents, EventsMediaType, 0, nil, nil, nil)
+
+	pushed := createTestCloudEvent(EventActionPush)
+	tagged := createTestCloudEvent(EventActionTag)
+	tagged.Target.Tag = "latest"
+
+	if err := sink.Write(pushed, tagged); err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("unexpected number of requests: %d != 2", len(received))
+	}
+
+	for i, expected := range []struct {
+		event   Event
+		typ     string
+		subject string
+	}{
+		{pushed, "com.docker.distribution.push", "library/test@sha256:0123456789abcdef0"},
+		{tagged, "com.docker.distribution.tag", "library/test:latest"},
+	} {
+		ce := received[i]
+		if ce.SpecVersion != CloudEventsSpecVersion || ce.ID != expected.event.ID ||
+			ce.Source != "//registry.example.com:5000" || ce.Type != expected.typ ||
+			ce.Subject != expected.subject || ce.DataContentType != "application/json" {
+			t.Fatalf("unexpected attributes: %#v", ce)
+		}
+
+		if ce.Time == nil || !ce.Time.Equal(expected.event.Timestamp) {
+			t.Fatalf("unexpected time: %v != %v", ce.Time, expected.event.Timestamp)
+		}
+
+		if ce.Data.ID != expected.event.ID || ce.Data.Target.Repository != "library/test" ||
+			ce.Data.Source != expected.event.Source {
+			t.Fatalf("unexpected data: %#v", ce.Data)
+		}
+	}
+}
+
+// TestCloudEventsBinary ensures that each event is posted as a CloudEvent
+// with its attributes in headers and the event as the body.
+func TestCloudEventsBinary(t *testing.T) {
+	var (
+		headers []http.Header
+		bodies  []Event
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		headers = append(headers, r.Header)
+		bodies = append(bodies, event)
+	}))
+	defer server.Close()
+
+	sink := newHTTPSink(server.URL, EnvelopeCloudEventsBinary, EventsMediaTypeV1, 0, nil, nil, nil)
+
+	pushed := createTestCloudEvent(EventActionPush)
+	pushed.Response = &ResponseRecord{Status: http.StatusCreated}
+	pushed.Source = SourceRecord{}
+
+	if err := sink.Write(pushed); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+
+	if len(headers) != 1 {
+		t.Fatalf("unexpected number of requests: %d != 1", len(headers))
+	}
+
+	for name, expected := range map[string]string{
+		"Content-Type":   "application/json",
+		"ce-specversion": CloudEventsSpecVersion,
+		"ce-id":          pushed.ID,
+		"ce-source":      cloudEventsDefaultSource,
+		"ce-type":        "com.docker.distribution.push",
+		"ce-time":        "2016-01-02T03:04:05Z",
+		"ce-subject":     "library/test@sha256:0123456789abcdef0",
+	} {
+		if value := headers[0].Get(name); value != expected {
+			t.Fatalf("unexpected %s header: %q != %q", name, value, expected)
+		}
+	}
+
+	// The media type of the endpoint selects the version of the event.
+	if bodies[0].ID != pushed.ID || bodies[0].Response != nil {
+		t.Fatalf("unexpected body: %#v", bodies[0])
+	}
+}
+
+// TestCloudEventsEndpointRetries ensures that endpoints posting CloudEvents
+// retry and abandon each event on its own, without posting accepted events
+// again or abandoning them along with a rejected one.
+func TestCloudEventsEndpointRetries(t *testing.T) {
+	events := []Event{
+		createTestCloudEvent(EventActionPush),
+		createTestCloudEvent(EventActionPush),
+		createTestCloudEvent(EventActionPush),
+	}
+	rejected := events[1]
+
+	var (
+		mu       sync.Mutex
+		received []Event
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var ce cloudEvent
+		if err := json.NewDecoder(r.Body).Decode(&ce); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		received = append(received, ce.Data)
+		mu.Unlock()
+
+		if ce.ID == rejected.ID {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	endpoint, err := NewEndpoint("cloudevents-test", server.URL, EndpointConfig{Envelope: EnvelopeCloudEvents})
+	if err != nil {
+		t.Fatalf("unexpected error creating endpoint: %v", err)
+	}
+	defer endpoint.Close()
+
+	if err := endpoint.Write(events...); err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+
+	waitForDelivery(t, endpoint.metrics)
+
+	mu.Lock()
+	checkEventIDs(t, received, events)
+	mu.Unlock()
+
+	letters := endpoint.DeadLetters()
+	if len(letters) != 1 || letters[0].Event.ID != rejected.ID {
+		t.Fatalf("unexpected dead letters: %#v", letters)
+	}
+}
+
+// TestCloudEventsRejected ensures that CloudEvents do not fall back to
+// another media type and that sinks without CloudEvents support refuse them.
+func TestCloudEventsRejected(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		ioutil.ReadAll(r.Body)
+
+		requests++
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+	}))
+	defer server.Close()
+
+	sink := newHTTPSink(server.URL, EnvelopeCloudEvents, EventsMediaType, 0, nil, nil, nil)
+	err := sink.Write(createTestCloudEvent(EventActionPush), createTestCloudEvent(EventActionPull))
+	if !isPermanent(err) {
+		t.Fatalf("expected a permanent error: %v", err)
+	}
+
+	if requests != 1 {
+		t.Fatalf("unexpected number of requests: %d != 1", requests)
+	}
+
+	if _, err := NewEndpoint("test", server.URL, EndpointConfig{Envelope: "xml"}); err == nil {
+		t.Fatalf("expected an error creating an endpoint with an unsupported envelope")
+	}
+
+	if _, err := NewEndpoint("test", server.URL, EndpointConfig{Envelope: EnvelopeCloudEventsBinary, Secrets: []string{"secret"}}); err == nil {
+		t.Fatalf("expected an error creating a signed endpoint with binary cloudevents")
+	}
+
+	if _, err := CreateSink("amqp", SinkConfig{URL: "amqp://localhost", Envelope: EnvelopeCloudEvents}); err == nil {
+		t.Fatalf("expected an error creating an amqp sink with cloudevents")
+	}
+}
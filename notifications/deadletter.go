@@ -0,0 +1,156 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// deadLetterFile is the file of the disk queue directory holding the dead
+// letters of an endpoint.
+const deadLetterFile = "deadletters.json"
+
+// DeadLetter records an event that could not be delivered to an endpoint.
+type DeadLetter struct {
+	// Event is the undelivered event.
+	Event Event `json:"event"`
+
+	// Time is when delivery of the event was abandoned.
+	Time time.Time `json:"time"`
+
+	// Error is the error that caused the event to be abandoned.
+	Error string `json:"error"`
+}
+
+// deadLetterStore keeps the most recent events that could not be delivered
+// to an endpoint, so that they can be inspected and replayed. If a path is
+// set, the dead letters are saved to it and survive restarts.
+type deadLetterStore struct {
+	path string
+	max  int
+
+	mu      sync.Mutex
+	letters []DeadLetter // oldest first
+}
+
+// newDeadLetterStore returns a store keeping up to max dead letters, loading
+// those previously saved to path, if not empty.
+func newDeadLetterStore(path string, max int) (*deadLetterStore, error) {
+	s := &deadLetterStore{
+		path: path,
+		max:  max,
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	p, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(p, &s.letters); err != nil {
+		return nil, fmt.Errorf("deadletters: error decoding %s: %v", path, err)
+	}
+
+	return s, nil
+}
+
+// add records the events as dead letters, evicting the oldest ones beyond
+// the maximum.
+func (s *deadLetterStore) add(err error, events ...Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, event := range events {
+		// Replayed events start over with a new count of attempts.
+		event.attempts = nil
+		s.letters = append(s.letters, DeadLetter{
+			Event: event,
+			Time:  now,
+			Error: err.Error(),
+		})
+	}
+
+	if s.max > 0 && len(s.letters) > s.max {
+		evicted := len(s.letters) - s.max
+		logrus.Warnf("deadletters: evicting %d dead letters, these events will be lost", evicted)
+		s.letters = append([]DeadLetter(nil), s.letters[evicted:]...)
+	}
+
+	s.save()
+}
+
+// abandoned records the events abandoned by a retrying sink.
+func (s *deadLetterStore) abandoned(err error, events ...Event) {
+	s.add(err, events...)
+}
+
+// list returns the dead letters, oldest first.
+func (s *deadLetterStore) list() []DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]DeadLetter(nil), s.letters...)
+}
+
+// remove removes the dead letters matched by match, returning their events.
+func (s *deadLetterStore) remove(match func(DeadLetter) bool) []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		kept    []DeadLetter
+		removed []Event
+	)
+
+	for _, letter := range s.letters {
+		if match(letter) {
+			removed = append(removed, letter.Event)
+		} else {
+			kept = append(kept, letter)
+		}
+	}
+
+	if len(removed) > 0 {
+		s.letters = kept
+		s.save()
+	}
+
+	return removed
+}
+
+// save writes the dead letters to the path of the store, if any. Errors are
+// logged, as the dead letters are still kept in memory.
+func (s *deadLetterStore) save() {
+	if s.path == "" {
+		return
+	}
+
+	p, err := json.Marshal(s.letters)
+	if err != nil {
+		logrus.Errorf("deadletters: error encoding dead letters: %v", err)
+		return
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, p, 0644); err != nil {
+		logrus.Errorf("deadletters: error saving dead letters: %v", err)
+		return
+	}
+
+	if err := os.Rename(tmp, filepath.Clean(s.path)); err != nil {
+		logrus.Errorf("deadletters: error saving dead letters: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package notifications
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDeadLetters ensures that the events abandoned by a retrying sink are
+// kept as dead letters.
+func TestDeadLetters(t *testing.T) {
+	store, err := newDeadLetterStore("", 2)
+	if err != nil {
+		t.Fatalf("unexpected error creating dead letter store: %v", err)
+	}
+
+	sink := newRetryingSink(&flakySink{Sink: &testSink{}, rate: 1}, retryPolicy{threshold: 10, maxAttempts: 3}, store)
+
+	events := []Event{
+		createTestEvent("push", "library/first", "blob"),
+		createTestEvent("push", "library/second", "blob"),
+		createTestEvent("push", "library/third", "blob"),
+	}
+
+	if err := sink.Write(events...); err != nil {
+		t.Fatalf("abandoned events should be kept as dead letters: %v", err)
+	}
+
+	// Only the most recent dead letters are kept.
+	letters := store.list()
+	if len(letters) != 2 || letters[0].Event.ID != events[1].ID || letters[1].Event.ID != events[2].ID {
+		t.Fatalf("unexpected dead letters: %#v", letters)
+	}
+
+	if letters[0].Error != fmt.Sprintf("giving up after 3 attempts: error writing %d events", len(events)) || letters[0].Time.IsZero() {
+		t.Fatalf("unexpected dead letter: %#v", letters[0])
+	}
+
+	// A closed sink is not a delivery failure.
+	closed := newRetryingSink(&failingSink{}, retryPolicy{threshold: 10, maxAttempts: 3}, store)
+	if err := closed.Write(events...); err != ErrSinkClosed {
+		t.Fatalf("unexpected error writing to closed sink: %v", err)
+	}
+
+	if len(store.list()) != 2 {
+		t.Fatalf("events written to a closed sink should not be dead letters: %#v", store.list())
+	}
+}
+
+func TestDeadLetterStorePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletters")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, deadLetterFile)
+	store, err := newDeadLetterStore(path, 10)
+	if err != nil {
+		t.Fatalf("unexpected error creating dead letter store: %v", err)
+	}
+
+	events := []Event{
+		createTestEvent("push", "library/first", "blob"),
+		createTestEvent("pull", "library/second", "blob"),
+	}
+	store.add(fmt.Errorf("endpoint down"), events...)
+
+	removed := store.remove(func(letter DeadLetter) bool {
+		return letter.Event.ID == events[0].ID
+	})
+	checkEventIDs(t, removed, events[:1])
+
+	reopened, err := newDeadLetterStore(path, 10)
+	if err != nil {
+		t.Fatalf("unexpected error reopening dead letter store: %v", err)
+	}
+
+	letters := reopened.list()
+	if len(letters) != 1 || letters[0].Event.ID != events[1].ID ||
+		letters[0].Event.Target.Repository != "library/second" || letters[0].Error != "endpoint down" {
+		t.Fatalf("unexpected dead letters after reopening: %#v", letters)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatalf("unexpected error corrupting dead letters: %v", err)
+	}
+
+	if _, err := newDeadLetterStore(path, 10); err == nil {
+		t.Fatalf("expected error opening corrupted dead letters")
+	}
+}
@@ -0,0 +1,161 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// debugPrefix is the path under which DebugHandler serves its resources.
+const debugPrefix = "/debug/notifications/endpoints"
+
+// DebugHandler returns a handler exposing the deliveries and dead letters of
+// the registered endpoints, to be served by the debug server of the registry.
+// It serves the following resources, where times are in RFC 3339 format:
+//
+//	GET  /debug/notifications/endpoints
+//	GET  /debug/notifications/endpoints/<name>/deliveries
+//	GET  /debug/notifications/endpoints/<name>/deadletters?from=<time>&to=<time>
+//	POST /debug/notifications/endpoints/<name>/replay?id=<id>&from=<time>&to=<time>
+//
+// A replay requires at least one id or time bound and responds with the
+// number of replayed events. Like the rest of the debug server, the handler
+// must not be exposed externally.
+func DebugHandler() http.Handler {
+	return http.HandlerFunc(serveDebug)
+}
+
+func serveDebug(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, debugPrefix), "/")
+	if path == "" {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		serveDebugEndpoints(w)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	endpoint := registeredEndpoint(parts[0])
+	if endpoint == nil {
+		http.Error(w, fmt.Sprintf("unknown endpoint %q", parts[0]), http.StatusNotFound)
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case parts[1] == "deliveries" && r.Method == "GET":
+		serveDebugJSON(w, endpoint.Deliveries())
+	case parts[1] == "deadletters" && r.Method == "GET":
+		letters := []DeadLetter{}
+		for _, letter := range endpoint.DeadLetters() {
+			if (from.IsZero() || !letter.Time.Before(from)) && (to.IsZero() || !letter.Time.After(to)) {
+				letters = append(letters, letter)
+			}
+		}
+		serveDebugJSON(w, letters)
+	case parts[1] == "replay" && r.Method == "POST":
+		ids := r.URL.Query()["id"]
+		if len(ids) == 0 && from.IsZero() && to.IsZero() {
+			http.Error(w, "replay requires an id or a time range", http.StatusBadRequest)
+			return
+		}
+
+		replayed, err := endpoint.Replay(ids, from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		serveDebugJSON(w, struct {
+			Replayed int `json:"replayed"`
+		}{replayed})
+	case parts[1] == "deliveries" || parts[1] == "deadletters" || parts[1] == "replay":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveDebugEndpoints lists the registered endpoints with their number of
+// dead letters.
+func serveDebugEndpoints(w http.ResponseWriter) {
+	endpoints.mu.Lock()
+	registered := append([]*Endpoint(nil), endpoints.registered...)
+	endpoints.mu.Unlock()
+
+	type endpointJSON struct {
+		Name        string `json:"name"`
+		URL         string `json:"url"`
+		Type        string `json:"type"`
+		DeadLetters int    `json:"deadLetters"`
+	}
+
+	list := []endpointJSON{}
+	for _, endpoint := range registered {
+		list = append(list, endpointJSON{
+			Name:        endpoint.Name(),
+			URL:         endpoint.URL(),
+			Type:        endpoint.Type,
+			DeadLetters: len(endpoint.DeadLetters()),
+		})
+	}
+
+	serveDebugJSON(w, list)
+}
+
+func serveDebugJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// registeredEndpoint returns the most recently registered endpoint with the
+// name, or nil if there is none.
+func registeredEndpoint(name string) *Endpoint {
+	endpoints.mu.Lock()
+	defer endpoints.mu.Unlock()
+
+	for i := len(endpoints.registered) - 1; i >= 0; i-- {
+		if endpoints.registered[i].Name() == name {
+			return endpoints.registered[i]
+		}
+	}
+
+	return nil
+}
+
+// parseTimeRange parses the optional from and to query parameters of r.
+func parseTimeRange(r *http.Request) (from, to time.Time, err error) {
+	query := r.URL.Query()
+
+	if v := query.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, fmt.Errorf("invalid from time: %v", err)
+		}
+	}
+
+	if v := query.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, fmt.Errorf("invalid to time: %v", err)
+		}
+	}
+
+	return from, to, nil
+}
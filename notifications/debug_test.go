@@ -0,0 +1,155 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDebugHandler(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []Event
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var envelope Envelope
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		received = append(received, envelope.Events...)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	endpoint, err := NewEndpoint("debug-test", server.URL, EndpointConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error creating endpoint: %v", err)
+	}
+	defer endpoint.Close()
+
+	delivered := createTestEvent("push", "library/delivered", "blob")
+	if err := endpoint.Write(delivered); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+
+	waitForDelivery(t, endpoint.metrics)
+
+	debug := httptest.NewServer(DebugHandler())
+	defer debug.Close()
+
+	var deliveries []Delivery
+	checkDebugRequest(t, "GET", debug.URL+debugPrefix+"/debug-test/deliveries", http.StatusOK, &deliveries)
+	if len(deliveries) != 1 || len(deliveries[0].Events) != 1 || deliveries[0].Events[0] != delivered.ID ||
+		deliveries[0].Status != http.StatusAccepted || deliveries[0].Error != "" {
+		t.Fatalf("unexpected deliveries: %#v", deliveries)
+	}
+
+	failed := []Event{
+		createTestEvent("push", "library/first", "blob"),
+		createTestEvent("push", "library/second", "blob"),
+	}
+	endpoint.deadLetters.add(fmt.Errorf("endpoint down"), failed...)
+
+	var letters []DeadLetter
+	checkDebugRequest(t, "GET", debug.URL+debugPrefix+"/debug-test/deadletters", http.StatusOK, &letters)
+	if len(letters) != 2 || letters[0].Event.ID != failed[0].ID || letters[0].Error != "endpoint down" {
+		t.Fatalf("unexpected dead letters: %#v", letters)
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	checkDebugRequest(t, "GET", debug.URL+debugPrefix+"/debug-test/deadletters?from="+future, http.StatusOK, &letters)
+	if len(letters) != 0 {
+		t.Fatalf("unexpected dead letters after %s: %#v", future, letters)
+	}
+
+	checkDebugRequest(t, "POST", debug.URL+debugPrefix+"/debug-test/replay", http.StatusBadRequest, nil)
+	checkDebugRequest(t, "GET", debug.URL+debugPrefix+"/debug-test/replay?id="+failed[0].ID, http.StatusMethodNotAllowed, nil)
+	checkDebugRequest(t, "GET", debug.URL+debugPrefix+"/debug-test/deadletters?from=yesterday", http.StatusBadRequest, nil)
+	checkDebugRequest(t, "GET", debug.URL+debugPrefix+"/nonexistent/deliveries", http.StatusNotFound, nil)
+
+	var replay struct {
+		Replayed int `json:"replayed"`
+	}
+	checkDebugRequest(t, "POST", debug.URL+debugPrefix+"/debug-test/replay?id="+failed[1].ID, http.StatusOK, &replay)
+	if replay.Replayed != 1 {
+		t.Fatalf("unexpected number of replayed events: %d", replay.Replayed)
+	}
+
+	remaining := endpoint.DeadLetters()
+	if len(remaining) != 1 || remaining[0].Event.ID != failed[0].ID {
+		t.Fatalf("unexpected dead letters after replay: %#v", remaining)
+	}
+
+	waitForDelivery(t, endpoint.metrics)
+
+	mu.Lock()
+	defer mu.Unlock()
+	checkEventIDs(t, received, []Event{delivered, failed[1]})
+
+	var list []struct {
+		Name        string `json:"name"`
+		DeadLetters int    `json:"deadLetters"`
+	}
+	checkDebugRequest(t, "GET", debug.URL+debugPrefix, http.StatusOK, &list)
+	for _, entry := range list {
+		if entry.Name == "debug-test" && entry.DeadLetters == 1 {
+			return
+		}
+	}
+	t.Fatalf("endpoint not listed: %#v", list)
+}
+
+// TestEndpointClose ensures that closed endpoints are no longer registered.
+func TestEndpointClose(t *testing.T) {
+	endpoint, err := NewEndpoint("close-test", "http://localhost", EndpointConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error creating endpoint: %v", err)
+	}
+
+	if registeredEndpoint("close-test") != endpoint {
+		t.Fatalf("endpoint not registered")
+	}
+
+	if err := endpoint.Close(); err != nil {
+		t.Fatalf("unexpected error closing endpoint: %v", err)
+	}
+
+	if registeredEndpoint("close-test") != nil {
+		t.Fatalf("closed endpoint still registered")
+	}
+}
+
+// checkDebugRequest sends a request to the debug handler, checking the status
+// of the response and decoding its body into v, if not nil.
+func checkDebugRequest(t *testing.T, method, u string, status int, v interface{}) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating request: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error requesting %s %s: %v", method, u, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != status {
+		t.Fatalf("unexpected status for %s %s: %d != %d", method, u, resp.StatusCode, status)
+	}
+
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("unexpected error decoding response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package notifications
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxDeliveries is the number of recent deliveries kept per endpoint.
+const maxDeliveries = 100
+
+// Delivery describes an attempt to deliver events to an endpoint.
+type Delivery struct {
+	// Time is when the attempt started.
+	Time time.Time `json:"time"`
+
+	// Events lists the identifiers of the delivered events.
+	Events []string `json:"events"`
+
+	// Status is the status code of the response, for http endpoints that
+	// responded.
+	Status int `json:"status,omitempty"`
+
+	// Latency is the duration of the attempt.
+	Latency time.Duration `json:"latency"`
+
+	// Error is the error of a failed attempt.
+	Error string `json:"error,omitempty"`
+}
+
+// deliveryLog keeps the most recent deliveries to an endpoint. It listens to
+// the http sink for the status code of the attempt in progress.
+type deliveryLog struct {
+	mu         sync.Mutex
+	deliveries []Delivery // ring buffer of the last maxDeliveries
+	next       int        // index of the next delivery in the ring
+	status     int        // status of the attempt in progress
+}
+
+var _ httpStatusListener = &deliveryLog{}
+
+func (dl *deliveryLog) success(status int, events ...Event) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.status = status
+}
+
+func (dl *deliveryLog) failure(status int, events ...Event) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.status = status
+}
+
+func (dl *deliveryLog) err(err error, events ...Event) {}
+
+// record adds a delivery of the events that started at start, with the status
+// reported by the sink since.
+func (dl *deliveryLog) record(start time.Time, err error, events ...Event) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	delivery := Delivery{
+		Time:    start.UTC(),
+		Events:  make([]string, 0, len(events)),
+		Status:  dl.status,
+		Latency: time.Since(start),
+	}
+	dl.status = 0
+
+	for _, event := range events {
+		delivery.Events = append(delivery.Events, event.ID)
+	}
+
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	if len(dl.deliveries) < maxDeliveries {
+		dl.deliveries = append(dl.deliveries, delivery)
+	} else {
+		dl.deliveries[dl.next] = delivery
+	}
+	dl.next = (dl.next + 1) % maxDeliveries
+}
+
+// list returns the recent deliveries, most recent first.
+func (dl *deliveryLog) list() []Delivery {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	deliveries := make([]Delivery, 0, len(dl.deliveries))
+	for i := 1; i <= len(dl.deliveries); i++ {
+		deliveries = append(deliveries, dl.deliveries[(dl.next-i+len(dl.deliveries))%len(dl.deliveries)])
+	}
+
+	return deliveries
+}
+
+// recordingSink records every write to a sink in a delivery log. It should
+// wrap the sink created for the endpoint, so that each attempt is recorded.
+type recordingSink struct {
+	Sink
+	log *deliveryLog
+}
+
+func newRecordingSink(sink Sink, log *deliveryLog) *recordingSink {
+	return &recordingSink{
+		Sink: sink,
+		log:  log,
+	}
+}
+
+// Write writes the events to the sink and records the attempt.
+func (rs *recordingSink) Write(events ...Event) error {
+	start := time.Now()
+	err := rs.Sink.Write(events...)
+	if err != ErrSinkClosed {
+		rs.log.record(start, err, events...)
+	}
+
+	return err
+}
+
+func (rs *recordingSink) String() string {
+	return fmt.Sprintf("%v", rs.Sink)
+}
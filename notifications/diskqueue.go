@@ -0,0 +1,677 @@
+package notifications
+
+import (
+	"container/list"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// QueueOverflowDrop drops the oldest pending events to make room for
+	// new events when a disk queue is full, or the new events if there are
+	// no pending events left to drop. It never blocks writers.
+	QueueOverflowDrop = "drop"
+
+	// QueueOverflowBlock blocks writers until the pending events fit into a
+	// full disk queue. As the broadcaster writes to its sinks in turn, this
+	// stalls all endpoints of the broadcaster and the writers of events.
+	QueueOverflowBlock = "block"
+)
+
+const (
+	// diskQueueSegmentSize is the size after which the disk queue starts
+	// writing a new segment file.
+	diskQueueSegmentSize = 4 << 20
+
+	// diskQueueRecordHeaderSize is the size of the header preceding every
+	// record, holding the payload length and its crc32 checksum.
+	diskQueueRecordHeaderSize = 8
+
+	diskQueueSegmentExt = ".log"
+	diskQueueCursorFile = "cursor"
+)
+
+// QueueConfig configures the durable on-disk queue of an endpoint.
+type QueueConfig struct {
+	// Directory holds the queue files. If empty, events are queued in
+	// memory and lost on restart.
+	Directory string
+
+	// MaxSize is the maximum number of bytes of pending events. If zero,
+	// the queue is unbounded.
+	MaxSize int64
+
+	// Overflow is the policy applied when the queue is full, either
+	// QueueOverflowDrop or QueueOverflowBlock. Defaults to
+	// QueueOverflowDrop.
+	Overflow string
+}
+
+// diskQueueListener is called when various events happen on a disk queue.
+type diskQueueListener interface {
+	eventQueueListener
+
+	// dropped is called with the number of pending events dropped on
+	// overflow or because they could not be read back.
+	dropped(events int)
+
+	// depth is called with the events and bytes pending on disk whenever
+	// they change.
+	depth(events int, bytes int64)
+}
+
+// diskQueueCursor is the position of the oldest record not yet delivered.
+// While a record is delivered, Next is the position of the oldest pending
+// record, so that records dropped in between are not replayed, and Attempts
+// the attempts to deliver each of its events so far.
+type diskQueueCursor struct {
+	Segment  uint64           `json:"segment"`
+	Offset   int64            `json:"offset"`
+	Attempts []int            `json:"attempts,omitempty"`
+	Next     *diskQueueCursor `json:"next,omitempty"`
+}
+
+// diskQueueRecord locates a block of events in a segment.
+type diskQueueRecord struct {
+	segment uint64
+	offset  int64
+	size    int64
+	events  int
+
+	// attempts are the attempts to deliver the events of the record made
+	// before the queue was opened.
+	attempts []int
+}
+
+// diskQueue accepts all messages into a write-ahead log on local disk for
+// asynchronous consumption by a sink. The log is split into segment files,
+// which are removed once all of their events are delivered. Events not yet
+// delivered when the queue is closed, or when the process dies, are replayed
+// when the queue is opened again. Like eventQueue, events the sink fails to
+// accept are dropped, so the sink should be reliable.
+type diskQueue struct {
+	sink      Sink
+	config    QueueConfig
+	listeners []diskQueueListener
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	closed bool
+	done   chan struct{}
+
+	records  *list.List // records pending delivery, oldest first
+	inflight *diskQueueRecord
+	attempts []*eventAttempts // attempts of the events of the inflight record
+	size     int64            // bytes of the pending and inflight records
+	events   int              // number of the pending and inflight events
+
+	segment uint64   // segment currently written
+	file    *os.File // file of the segment currently written
+	offset  int64    // write offset in the current segment
+}
+
+// newDiskQueue opens the disk queue in the configured directory, replaying
+// any events pending from a previous run to the sink.
+func newDiskQueue(sink Sink, config QueueConfig, listeners ...diskQueueListener) (*diskQueue, error) {
+	switch config.Overflow {
+	case "":
+		config.Overflow = QueueOverflowDrop
+	case QueueOverflowDrop, QueueOverflowBlock:
+	default:
+		return nil, fmt.Errorf("diskqueue: unknown overflow policy %q", config.Overflow)
+	}
+
+	if err := os.MkdirAll(config.Directory, 0755); err != nil {
+		return nil, err
+	}
+
+	dq := &diskQueue{
+		sink:      sink,
+		config:    config,
+		listeners: listeners,
+		done:      make(chan struct{}),
+		records:   list.New(),
+	}
+	dq.cond = sync.NewCond(&dq.mu)
+
+	if err := dq.replay(); err != nil {
+		return nil, err
+	}
+
+	go dq.run()
+	return dq, nil
+}
+
+// Write appends the events to the log, failing if the queue has been closed
+// or the events cannot be written to disk. If the queue is full, the oldest
+// pending events are dropped or the call blocks, depending on the overflow
+// policy. When dropping, the new events are dropped if only the events being
+// delivered are left in the queue.
+func (dq *diskQueue) Write(events ...Event) error {
+	payload, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("diskqueue: error marshaling events: %v", err)
+	}
+	size := int64(diskQueueRecordHeaderSize + len(payload))
+
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+
+	if dq.config.MaxSize > 0 && size > dq.config.MaxSize {
+		return fmt.Errorf("diskqueue: %d bytes of events exceed the queue size", size)
+	}
+
+	for {
+		if dq.closed {
+			return ErrSinkClosed
+		}
+
+		if dq.config.MaxSize <= 0 || dq.size+size <= dq.config.MaxSize {
+			break
+		}
+
+		if dq.config.Overflow == QueueOverflowDrop {
+			if dq.records.Len() == 0 {
+				// Only the events being delivered hold the space, and they
+				// are never dropped. Drop the new events instead of waiting
+				// on a delivery that may never finish.
+				logrus.Warnf("diskqueue: dropping %d events", len(events))
+				for _, listener := range dq.listeners {
+					listener.dropped(len(events))
+				}
+				return nil
+			}
+
+			dq.drop(dq.records.Remove(dq.records.Front()).(*diskQueueRecord))
+			if err := dq.commit(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Wait for delivery to free up space.
+		dq.cond.Wait()
+	}
+
+	if dq.offset > 0 && dq.offset+size > diskQueueSegmentSize {
+		if err := dq.openSegment(dq.segment + 1); err != nil {
+			return err
+		}
+	}
+
+	record := make([]byte, size)
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[diskQueueRecordHeaderSize:], payload)
+
+	if _, err := dq.file.Write(record); err != nil {
+		dq.rewind()
+		return err
+	}
+
+	if err := dq.file.Sync(); err != nil {
+		dq.rewind()
+		return err
+	}
+
+	dq.push(&diskQueueRecord{
+		segment: dq.segment,
+		offset:  dq.offset,
+		size:    size,
+		events:  len(events),
+	}, events)
+	dq.offset += size
+
+	return nil
+}
+
+// rewind discards a partially written record from the current segment. The
+// caller must hold the mutex.
+func (dq *diskQueue) rewind() {
+	if err := dq.file.Truncate(dq.offset); err != nil {
+		logrus.Errorf("diskqueue: error truncating segment %d: %v", dq.segment, err)
+	}
+
+	if _, err := dq.file.Seek(dq.offset, os.SEEK_SET); err != nil {
+		logrus.Errorf("diskqueue: error seeking segment %d: %v", dq.segment, err)
+	}
+}
+
+// Close stops delivering events and closes the sink. Events still pending
+// remain on disk, to be replayed when the queue is opened again.
+func (dq *diskQueue) Close() error {
+	dq.mu.Lock()
+	if dq.closed {
+		dq.mu.Unlock()
+		return fmt.Errorf("diskqueue: already closed")
+	}
+
+	dq.closed = true
+	dq.cond.Broadcast()
+	dq.mu.Unlock()
+
+	// Closing the sink interrupts the delivery in progress, if any.
+	err := dq.sink.Close()
+	<-dq.done
+
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+
+	if cerr := dq.file.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// run is the main goroutine to flush events to the target sink.
+func (dq *diskQueue) run() {
+	defer close(dq.done)
+
+	for {
+		record, block := dq.next()
+		if record == nil {
+			return // nil record means the queue is closed.
+		}
+
+		if err := dq.sink.Write(block...); err != nil {
+			if err == ErrSinkClosed {
+				// Leave the events for the next run.
+				return
+			}
+
+			logrus.Warnf("diskqueue: error writing events to %v, these events will be lost: %v", dq.sink, err)
+		}
+
+		dq.ack(record, block)
+	}
+}
+
+// next returns the oldest pending record and its events, blocking until one
+// is available. When closed, a nil record is returned.
+func (dq *diskQueue) next() (*diskQueueRecord, []Event) {
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+
+	for {
+		for dq.records.Len() < 1 && !dq.closed {
+			dq.cond.Wait()
+		}
+
+		if dq.closed {
+			return nil, nil
+		}
+
+		record := dq.records.Remove(dq.records.Front()).(*diskQueueRecord)
+
+		block, err := dq.read(record)
+		if err != nil {
+			logrus.Errorf("diskqueue: error reading events from segment %d, these events will be lost: %v", record.segment, err)
+			dq.drop(record)
+			if err := dq.commit(); err != nil {
+				logrus.Errorf("diskqueue: error committing queue position: %v", err)
+			}
+			continue
+		}
+
+		dq.inflight = record
+		dq.attempts = make([]*eventAttempts, len(block))
+		for i := range block {
+			dq.attempts[i] = &eventAttempts{recorder: dq}
+			if i < len(record.attempts) {
+				dq.attempts[i].count = int32(record.attempts[i])
+			}
+			block[i].attempts = dq.attempts[i]
+		}
+
+		return record, block
+	}
+}
+
+// ack removes the delivered record from the queue.
+func (dq *diskQueue) ack(record *diskQueueRecord, block []Event) {
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+
+	dq.inflight = nil
+	dq.attempts = nil
+	dq.size -= record.size
+	dq.events -= record.events
+
+	if err := dq.commit(); err != nil {
+		logrus.Errorf("diskqueue: error committing queue position: %v", err)
+	}
+
+	for _, listener := range dq.listeners {
+		listener.egress(block...)
+		listener.depth(dq.events, dq.size)
+	}
+
+	dq.cond.Broadcast()
+}
+
+// recordAttempts commits the attempts to deliver the inflight events, so
+// that the retry policy carries on from them after a restart.
+func (dq *diskQueue) recordAttempts() {
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+
+	if err := dq.commit(); err != nil {
+		logrus.Errorf("diskqueue: error committing delivery attempts: %v", err)
+	}
+}
+
+// push adds a record to the pending records. The caller must hold the
+// mutex.
+func (dq *diskQueue) push(record *diskQueueRecord, events []Event) {
+	dq.records.PushBack(record)
+	dq.size += record.size
+	dq.events += record.events
+
+	for _, listener := range dq.listeners {
+		listener.ingress(events...)
+		listener.depth(dq.events, dq.size)
+	}
+
+	dq.cond.Broadcast()
+}
+
+// drop discards a record removed from the pending records. The caller must
+// hold the mutex.
+func (dq *diskQueue) drop(record *diskQueueRecord) {
+	logrus.Warnf("diskqueue: dropping %d events", record.events)
+
+	dq.size -= record.size
+	dq.events -= record.events
+
+	for _, listener := range dq.listeners {
+		listener.dropped(record.events)
+		listener.depth(dq.events, dq.size)
+	}
+}
+
+// read reads the events of the record from its segment. The caller must
+// hold the mutex.
+func (dq *diskQueue) read(record *diskQueueRecord) ([]Event, error) {
+	f, err := os.Open(dq.segmentPath(record.segment))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := make([]byte, record.size)
+	if _, err := f.ReadAt(p, record.offset); err != nil {
+		return nil, err
+	}
+
+	payload, err := decodeDiskQueueRecord(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	if err := json.Unmarshal(payload, &events); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// commit persists the position of the oldest undelivered record and removes
+// the segments before it. The caller must hold the mutex.
+func (dq *diskQueue) commit() error {
+	cursor := diskQueueCursor{Segment: dq.segment, Offset: dq.offset}
+	if front := dq.records.Front(); front != nil {
+		record := front.Value.(*diskQueueRecord)
+		cursor = diskQueueCursor{Segment: record.segment, Offset: record.offset}
+	}
+
+	if dq.inflight != nil {
+		next := cursor
+		cursor = diskQueueCursor{Segment: dq.inflight.segment, Offset: dq.inflight.offset, Next: &next}
+		for _, attempts := range dq.attempts {
+			cursor.Attempts = append(cursor.Attempts, attempts.value())
+		}
+	}
+
+	p, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+
+	cursorPath := filepath.Join(dq.config.Directory, diskQueueCursorFile)
+	if err := ioutil.WriteFile(cursorPath+".tmp", p, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(cursorPath+".tmp", cursorPath); err != nil {
+		return err
+	}
+
+	segments, err := dq.segments()
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		if segment >= cursor.Segment {
+			break
+		}
+
+		if err := os.Remove(dq.segmentPath(segment)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replay restores the records pending from a previous run and opens the
+// segment to append to. Records after a corrupted or partially written
+// record of a segment are discarded.
+func (dq *diskQueue) replay() error {
+	var cursor diskQueueCursor
+	p, err := ioutil.ReadFile(filepath.Join(dq.config.Directory, diskQueueCursorFile))
+	if err == nil {
+		if err := json.Unmarshal(p, &cursor); err != nil {
+			return fmt.Errorf("diskqueue: invalid cursor: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	segments, err := dq.segments()
+	if err != nil {
+		return err
+	}
+
+	first := cursor.Segment
+	if cursor.Next != nil {
+		// Replay the record delivered when the queue stopped, skipping the
+		// records dropped after it.
+		if _, err := dq.replaySegment(cursor.Segment, cursor.Offset, 1); err != nil {
+			return err
+		}
+		if back := dq.records.Back(); back != nil {
+			back.Value.(*diskQueueRecord).attempts = cursor.Attempts
+		}
+		cursor = *cursor.Next
+	}
+
+	dq.segment = cursor.Segment
+	for _, segment := range segments {
+		if segment < cursor.Segment {
+			if segment < first {
+				if err := os.Remove(dq.segmentPath(segment)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		var offset int64
+		if segment == cursor.Segment {
+			offset = cursor.Offset
+		}
+
+		end, err := dq.replaySegment(segment, offset, 0)
+		if err != nil {
+			return err
+		}
+
+		dq.segment, dq.offset = segment, end
+	}
+
+	if err := dq.openSegment(dq.segment); err != nil {
+		return err
+	}
+
+	// Drop anything written after the last valid record.
+	if err := dq.file.Truncate(dq.offset); err != nil {
+		return err
+	}
+
+	if _, err := dq.file.Seek(dq.offset, os.SEEK_SET); err != nil {
+		return err
+	}
+
+	if dq.records.Len() > 0 {
+		logrus.Infof("diskqueue: replaying %d events from %s", dq.events, dq.config.Directory)
+	}
+
+	return nil
+}
+
+// replaySegment restores up to max records of the segment starting at
+// offset, or all of them if max is zero, returning the offset following the
+// last valid record.
+func (dq *diskQueue) replaySegment(segment uint64, offset int64, max int) (int64, error) {
+	p, err := ioutil.ReadFile(dq.segmentPath(segment))
+	if err != nil {
+		return 0, err
+	}
+
+	if offset > int64(len(p)) {
+		logrus.Warnf("diskqueue: cursor beyond the end of segment %d", segment)
+		offset = int64(len(p))
+	}
+
+	for replayed := 0; offset < int64(len(p)) && (max <= 0 || replayed < max); replayed++ {
+		if int64(len(p))-offset < diskQueueRecordHeaderSize {
+			break
+		}
+
+		size := diskQueueRecordHeaderSize + int64(binary.BigEndian.Uint32(p[offset:offset+4]))
+		if offset+size > int64(len(p)) {
+			break
+		}
+
+		payload, err := decodeDiskQueueRecord(p[offset : offset+size])
+		if err != nil {
+			break
+		}
+
+		var events []Event
+		if err := json.Unmarshal(payload, &events); err != nil {
+			break
+		}
+
+		dq.push(&diskQueueRecord{
+			segment: segment,
+			offset:  offset,
+			size:    size,
+			events:  len(events),
+		}, events)
+		offset += size
+	}
+
+	if max <= 0 && offset < int64(len(p)) {
+		logrus.Warnf("diskqueue: discarding %d bytes of invalid records in segment %d", int64(len(p))-offset, segment)
+	}
+
+	return offset, nil
+}
+
+// openSegment makes the segment the one written by the queue.
+func (dq *diskQueue) openSegment(segment uint64) error {
+	f, err := os.OpenFile(dq.segmentPath(segment), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	if dq.file != nil {
+		if err := dq.file.Close(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	dq.file = f
+	if segment != dq.segment {
+		dq.segment, dq.offset = segment, 0
+	}
+
+	return nil
+}
+
+// segments returns the sorted sequence numbers of the segments on disk.
+func (dq *diskQueue) segments() ([]uint64, error) {
+	names, err := filepath.Glob(filepath.Join(dq.config.Directory, "*"+diskQueueSegmentExt))
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []uint64
+	for _, name := range names {
+		segment, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), diskQueueSegmentExt), 10, 64)
+		if err != nil {
+			continue // not a segment
+		}
+
+		segments = append(segments, segment)
+	}
+
+	sort.Sort(segmentSequence(segments))
+	return segments, nil
+}
+
+func (dq *diskQueue) segmentPath(segment uint64) string {
+	return filepath.Join(dq.config.Directory, fmt.Sprintf("%020d%s", segment, diskQueueSegmentExt))
+}
+
+// decodeDiskQueueRecord returns the payload of the record, checking it
+// against its checksum.
+func decodeDiskQueueRecord(p []byte) ([]byte, error) {
+	if len(p) < diskQueueRecordHeaderSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	payload := p[diskQueueRecordHeaderSize:]
+	if int(binary.BigEndian.Uint32(p[0:4])) != len(payload) {
+		return nil, fmt.Errorf("diskqueue: invalid record length")
+	}
+
+	if binary.BigEndian.Uint32(p[4:8]) != crc32.ChecksumIEEE(payload) {
+		return nil, fmt.Errorf("diskqueue: record checksum mismatch")
+	}
+
+	return payload, nil
+}
+
+type segmentSequence []uint64
+
+func (s segmentSequence) Len() int           { return len(s) }
+func (s segmentSequence) Less(i, j int) bool { return s[i] < s[j] }
+func (s segmentSequence) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
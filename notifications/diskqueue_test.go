@@ -0,0 +1,491 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiskQueue(t *testing.T) {
+	const nevents = 1000
+
+	dir, err := ioutil.TempDir("", "diskqueue")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var ts testSink
+	metrics := newSafeMetrics()
+	dq, err := newDiskQueue(
+		&delayedSink{
+			Sink:  &ts,
+			delay: time.Millisecond * 1,
+		}, QueueConfig{Directory: dir}, metrics.diskQueueListener())
+	if err != nil {
+		t.Fatalf("unexpected error opening disk queue: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var block []Event
+	for i := 1; i <= nevents; i++ {
+		block = append(block, createTestEvent("push", "library/test", "blob"))
+		if i%10 == 0 && i > 0 {
+			wg.Add(1)
+			go func(block ...Event) {
+				if err := dq.Write(block...); err != nil {
+					t.Errorf("error writing event block: %v", err)
+				}
+				wg.Done()
+			}(block...)
+
+			block = nil
+		}
+	}
+
+	wg.Wait()
+	waitForDelivery(t, metrics)
+	checkClose(t, dq)
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	if len(ts.events) != nevents {
+		t.Fatalf("events did not make it to the sink: %d != %d", len(ts.events), nevents)
+	}
+
+	if !ts.closed {
+		t.Fatalf("sink should have been closed")
+	}
+
+	if metrics.Events != nevents {
+		t.Fatalf("unexpected ingress count: %d != %d", metrics.Events, nevents)
+	}
+
+	if metrics.DiskBytes != 0 {
+		t.Fatalf("unexpected bytes pending on disk: %d", metrics.DiskBytes)
+	}
+}
+
+func TestDiskQueueReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskqueue")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dq, err := newDiskQueue(newBlockingSink(), QueueConfig{Directory: dir})
+	if err != nil {
+		t.Fatalf("unexpected error opening disk queue: %v", err)
+	}
+
+	var expected []Event
+	for i := 0; i < 3; i++ {
+		block := []Event{
+			createTestEvent("push", "library/test", "manifest"),
+			createTestEvent("pull", "library/test", "blob"),
+		}
+		expected = append(expected, block...)
+
+		if err := dq.Write(block...); err != nil {
+			t.Fatalf("unexpected error writing events: %v", err)
+		}
+	}
+
+	// Events not delivered before closing are kept on disk.
+	if err := dq.Close(); err != nil {
+		t.Fatalf("unexpected error closing disk queue: %v", err)
+	}
+
+	// A record partially written before a crash is discarded.
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+diskQueueSegmentExt))
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("unexpected segments: %v, %v", segments, err)
+	}
+
+	f, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("unexpected error opening segment: %v", err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 0xde, 0xad})
+	f.Close()
+
+	var ts testSink
+	metrics := newSafeMetrics()
+	dq, err = newDiskQueue(&ts, QueueConfig{Directory: dir}, metrics.diskQueueListener())
+	if err != nil {
+		t.Fatalf("unexpected error reopening disk queue: %v", err)
+	}
+
+	// Events written after the replay follow the replayed events.
+	event := createTestEvent("delete", "library/test", "manifest")
+	expected = append(expected, event)
+	if err := dq.Write(event); err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+
+	waitForDelivery(t, metrics)
+	checkClose(t, dq)
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	checkEventIDs(t, ts.events, expected)
+
+	// Everything was delivered, so nothing is replayed again.
+	var empty testSink
+	dq, err = newDiskQueue(&empty, QueueConfig{Directory: dir})
+	if err != nil {
+		t.Fatalf("unexpected error reopening disk queue: %v", err)
+	}
+	checkClose(t, dq)
+
+	if len(empty.events) != 0 {
+		t.Fatalf("unexpected events replayed: %v", empty.events)
+	}
+}
+
+func TestDiskQueueOverflowDrop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskqueue")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks := make([][]Event, 5)
+	for i := range blocks {
+		blocks[i] = []Event{createFixedSizeTestEvent(i)}
+	}
+
+	p, err := json.Marshal(blocks[0])
+	if err != nil {
+		t.Fatalf("unexpected error marshaling events: %v", err)
+	}
+	recordSize := int64(diskQueueRecordHeaderSize + len(p))
+
+	metrics := newSafeMetrics()
+	dq, err := newDiskQueue(newBlockingSink(), QueueConfig{
+		Directory: dir,
+		MaxSize:   3 * recordSize,
+		Overflow:  QueueOverflowDrop,
+	}, metrics.diskQueueListener())
+	if err != nil {
+		t.Fatalf("unexpected error opening disk queue: %v", err)
+	}
+
+	for i, block := range blocks {
+		if err := dq.Write(block...); err != nil {
+			t.Fatalf("unexpected error writing events: %v", err)
+		}
+
+		if i == 0 {
+			waitForInflight(t, dq)
+		}
+	}
+
+	if err := dq.Close(); err != nil {
+		t.Fatalf("unexpected error closing disk queue: %v", err)
+	}
+
+	metrics.Lock()
+	if metrics.Dropped != 2 || metrics.DiskPending != 3 || metrics.DiskBytes != 3*recordSize {
+		t.Fatalf("unexpected metrics: %#v", metrics.EndpointMetrics)
+	}
+	metrics.Unlock()
+
+	var ts testSink
+	metrics = newSafeMetrics()
+	dq, err = newDiskQueue(&ts, QueueConfig{Directory: dir}, metrics.diskQueueListener())
+	if err != nil {
+		t.Fatalf("unexpected error reopening disk queue: %v", err)
+	}
+
+	waitForDelivery(t, metrics)
+	checkClose(t, dq)
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	// The events being delivered are kept, but not the events dropped after
+	// them.
+	checkEventIDs(t, ts.events, []Event{blocks[0][0], blocks[3][0], blocks[4][0]})
+}
+
+func TestDiskQueueOverflowDropInflight(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskqueue")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	block := []Event{createFixedSizeTestEvent(0)}
+	p, err := json.Marshal(block)
+	if err != nil {
+		t.Fatalf("unexpected error marshaling events: %v", err)
+	}
+
+	// The sink never succeeds and is retried forever, so the events being
+	// delivered hold the space of the queue for good.
+	failing := newRetryingSink(&flakySink{Sink: &testSink{}, rate: 1}, retryPolicy{threshold: 1, backoff: 10 * time.Millisecond})
+	metrics := newSafeMetrics()
+	dq, err := newDiskQueue(failing, QueueConfig{
+		Directory: dir,
+		MaxSize:   int64(diskQueueRecordHeaderSize+len(p)) + 1,
+		Overflow:  QueueOverflowDrop,
+	}, metrics.diskQueueListener())
+	if err != nil {
+		t.Fatalf("unexpected error opening disk queue: %v", err)
+	}
+
+	if err := dq.Write(block...); err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+	waitForInflight(t, dq)
+
+	written := make(chan error)
+	go func() {
+		written <- dq.Write(block...)
+	}()
+
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatalf("unexpected error writing events: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("write to a queue full of events being delivered blocked")
+	}
+
+	checkClose(t, dq)
+
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	if metrics.Dropped != 1 || metrics.DiskPending != 1 {
+		t.Fatalf("unexpected metrics: %#v", metrics.EndpointMetrics)
+	}
+}
+
+// TestDiskQueueAttempts ensures that the attempts to deliver events carry on
+// when the queue is opened again.
+func TestDiskQueueAttempts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskqueue")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	policy := retryPolicy{threshold: 10, backoff: time.Millisecond, maxAttempts: 3}
+
+	// The second attempt is interrupted, as if the registry stopped.
+	stopping := &closingSink{failures: 1}
+	dq, err := newDiskQueue(newRetryingSink(stopping, policy), QueueConfig{Directory: dir})
+	if err != nil {
+		t.Fatalf("unexpected error opening disk queue: %v", err)
+	}
+
+	event := createTestEvent("push", "library/test", "blob")
+	if err := dq.Write(event); err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+
+	<-dq.done
+	if err := dq.Close(); err != nil {
+		t.Fatalf("unexpected error closing disk queue: %v", err)
+	}
+
+	var abandoned abandonRecorder
+	failing := &closingSink{failures: 100}
+	metrics := newSafeMetrics()
+	dq, err = newDiskQueue(newRetryingSink(failing, policy, &abandoned), QueueConfig{Directory: dir}, metrics.diskQueueListener())
+	if err != nil {
+		t.Fatalf("unexpected error reopening disk queue: %v", err)
+	}
+
+	waitForDelivery(t, metrics)
+	checkClose(t, dq)
+
+	if failing.attempts() != 1 {
+		t.Fatalf("unexpected number of attempts after reopening: %d != 1", failing.attempts())
+	}
+
+	checkEventIDs(t, abandoned.events, []Event{event})
+}
+
+func TestDiskQueueOverflowBlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskqueue")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	block := []Event{createFixedSizeTestEvent(0)}
+	p, err := json.Marshal(block)
+	if err != nil {
+		t.Fatalf("unexpected error marshaling events: %v", err)
+	}
+
+	var ts testSink
+	release := make(chan struct{})
+	metrics := newSafeMetrics()
+	dq, err := newDiskQueue(&gatedSink{Sink: &ts, gate: release}, QueueConfig{
+		Directory: dir,
+		MaxSize:   2 * int64(diskQueueRecordHeaderSize+len(p)),
+		Overflow:  QueueOverflowBlock,
+	}, metrics.diskQueueListener())
+	if err != nil {
+		t.Fatalf("unexpected error opening disk queue: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := dq.Write(block...); err != nil {
+			t.Fatalf("unexpected error writing events: %v", err)
+		}
+	}
+
+	written := make(chan error)
+	go func() {
+		written <- dq.Write(block...)
+	}()
+
+	select {
+	case err := <-written:
+		t.Fatalf("write to a full queue did not block: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-written; err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+
+	waitForDelivery(t, metrics)
+	checkClose(t, dq)
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if len(ts.events) != 3 {
+		t.Fatalf("unexpected number of events delivered: %d != 3", len(ts.events))
+	}
+}
+
+// blockingSink blocks writes until it is closed.
+// waitForInflight waits until the disk queue is delivering events.
+func waitForInflight(t *testing.T, dq *diskQueue) {
+	for i := 0; i < 1000; i++ {
+		dq.mu.Lock()
+		inflight := dq.inflight
+		dq.mu.Unlock()
+
+		if inflight != nil {
+			return
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("events not delivered")
+}
+
+type blockingSink struct {
+	closed chan struct{}
+}
+
+func newBlockingSink() *blockingSink {
+	return &blockingSink{closed: make(chan struct{})}
+}
+
+func (bs *blockingSink) Write(events ...Event) error {
+	<-bs.closed
+	return ErrSinkClosed
+}
+
+func (bs *blockingSink) Close() error {
+	close(bs.closed)
+	return nil
+}
+
+// gatedSink blocks writes until the gate is closed.
+// closingSink fails the first writes, then reports that it is closed.
+type closingSink struct {
+	testSink
+	failures int
+
+	mu    sync.Mutex
+	count int
+}
+
+func (cs *closingSink) Write(events ...Event) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.count++
+	if cs.count > cs.failures {
+		return ErrSinkClosed
+	}
+
+	return fmt.Errorf("error writing %d events", len(events))
+}
+
+func (cs *closingSink) attempts() int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return cs.count
+}
+
+type gatedSink struct {
+	Sink
+	gate chan struct{}
+}
+
+func (gs *gatedSink) Write(events ...Event) error {
+	<-gs.gate
+	return gs.Sink.Write(events...)
+}
+
+// createFixedSizeTestEvent returns an event with the same encoded size for
+// any n below 1e8.
+func createFixedSizeTestEvent(n int) Event {
+	event := createTestEvent("push", "library/test", "blob")
+	event.ID = fmt.Sprintf("%08d", n)
+	event.Timestamp = time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	return event
+}
+
+// waitForDelivery waits until no events are pending.
+func waitForDelivery(t *testing.T, metrics *safeMetrics) {
+	for i := 0; i < 1000; i++ {
+		metrics.Lock()
+		pending := metrics.Pending
+		metrics.Unlock()
+
+		if pending == 0 {
+			return
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("events not delivered")
+}
+
+func checkEventIDs(t *testing.T, events, expected []Event) {
+	if len(events) != len(expected) {
+		t.Fatalf("unexpected number of events: %d != %d", len(events), len(expected))
+	}
+
+	for i := range events {
+		if events[i].ID != expected[i].ID {
+			t.Fatalf("unexpected event %d: %s != %s", i, events[i].ID, expected[i].ID)
+		}
+	}
+}
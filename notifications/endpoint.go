@@ -1,21 +1,92 @@
 package notifications
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
 	"time"
+
+	"github.com/garyburd/redigo/redis"
 )
 
 // EndpointConfig covers the optional configuration parameters for an active
 // endpoint.
 type EndpointConfig struct {
+	// Type selects the sink factory delivering events to the endpoint. If
+	// empty, events are delivered with http requests.
+	Type string
+
+	// Parameters holds the type specific parameters of the endpoint. They
+	// may hold credentials, so they are never exported with metrics.
+	Parameters map[string]interface{} `json:"-"`
+
+	// Redis is the redis pool of the registry, made available to sinks.
+	Redis *redis.Pool `json:"-"`
+
+	// Envelope selects how events are encoded for the endpoint, either
+	// EnvelopeRegistry, EnvelopeCloudEvents or EnvelopeCloudEventsBinary.
+	Envelope string
+
+	// MediaType is the media type of the envelopes delivered to the
+	// endpoint, either EventsMediaType or EventsMediaTypeV1. In CloudEvents,
+	// it selects the version of the events.
+	MediaType string
+
 	Headers   http.Header
 	Timeout   time.Duration
 	Threshold int
 	Backoff   time.Duration
+
+	// MaxBackoff caps the backoff, which doubles on each consecutive
+	// failure.
+	MaxBackoff time.Duration
+
+	// MaxAttempts is the number of attempts to deliver an event and MaxAge
+	// the age of events after which they are abandoned to the dead letters.
+	// If zero, they are not limited.
+	MaxAttempts int
+	MaxAge      time.Duration
+
+	// Secrets sign the requests to the endpoint. Each request carries a
+	// signature for every secret. They are never exported with metrics.
+	Secrets []string `json:"-"`
+
+	// TLS configures the TLS connections to the endpoint.
+	TLS TLSConfig
+
+	// Proxy is the url of the proxy requests to http endpoints go through.
+	// It may hold credentials, so it is never exported with metrics.
+	Proxy string `json:"-"`
+
+	// Queue configures a durable on-disk queue for events pending delivery.
+	// If its directory is empty, events are queued in memory.
+	Queue QueueConfig
+
+	// Filter selects the events sent to the endpoint. If empty, all events
+	// are sent.
+	Filter EventFilter
+
+	// MaxDeadLetters is the maximum number of undelivered events kept for
+	// replay. The oldest are discarded beyond it.
+	MaxDeadLetters int
 }
 
 // defaults set any zero-valued fields to a reasonable default.
 func (ec *EndpointConfig) defaults() {
+	if ec.Type == "" {
+		ec.Type = DefaultSinkType
+	}
+
+	if ec.Envelope == "" {
+		ec.Envelope = EnvelopeRegistry
+	}
+
+	if ec.MediaType == "" {
+		ec.MediaType = EventsMediaType
+	}
+
 	if ec.Timeout <= 0 {
 		ec.Timeout = time.Second
 	}
@@ -27,11 +98,21 @@ func (ec *EndpointConfig) defaults() {
 	if ec.Backoff <= 0 {
 		ec.Backoff = time.Second
 	}
+
+	if ec.MaxBackoff <= 0 {
+		ec.MaxBackoff = time.Minute
+	}
+
+	if ec.MaxDeadLetters <= 0 {
+		ec.MaxDeadLetters = 1000
+	}
 }
 
-// Endpoint is a reliable, queued, thread-safe sink that notify external http
-// services when events are written. Writes are non-blocking and always
-// succeed for callers but events may be queued internally.
+// Endpoint is a reliable, queued, thread-safe sink that notify external
+// services when events are written. The sink delivering the events is created
+// by the factory registered for the type of the endpoint. Writes are
+// non-blocking and always succeed for callers but events may be queued
+// internally.
 type Endpoint struct {
 	Sink
 	url  string
@@ -39,27 +120,110 @@ type Endpoint struct {
 
 	EndpointConfig
 
-	metrics *safeMetrics
+	metrics     *safeMetrics
+	deliveries  *deliveryLog
+	deadLetters *deadLetterStore
 }
 
-// NewEndpoint returns a running endpoint, ready to receive events.
-func NewEndpoint(name, url string, config EndpointConfig) *Endpoint {
+// NewEndpoint returns a running endpoint, ready to receive events. An error is
+// returned if the envelope or media type is not supported, the sink of the endpoint
+// cannot be created, the disk queue or the dead letters of the endpoint
+// cannot be opened or the filter is invalid.
+func NewEndpoint(name, url string, config EndpointConfig) (*Endpoint, error) {
 	var endpoint Endpoint
 	endpoint.name = name
 	endpoint.url = url
 	endpoint.EndpointConfig = config
 	endpoint.defaults()
 	endpoint.metrics = newSafeMetrics()
+	endpoint.deliveries = &deliveryLog{}
+
+	if endpoint.MediaType != EventsMediaType && endpoint.MediaType != EventsMediaTypeV1 {
+		return nil, fmt.Errorf("unsupported events media type: %q", endpoint.MediaType)
+	}
+
+	if !validEnvelope(endpoint.Envelope) {
+		return nil, fmt.Errorf("unsupported events envelope: %q", endpoint.Envelope)
+	}
+
+	// Configures the queue, retry, sink pipeline.
+	sink, err := CreateSink(endpoint.Type, SinkConfig{
+		Name:       endpoint.name,
+		URL:        endpoint.url,
+		Headers:    endpoint.Headers,
+		Timeout:    endpoint.Timeout,
+		Secrets:    endpoint.Secrets,
+		TLS:        endpoint.TLS,
+		Proxy:      endpoint.Proxy,
+		Envelope:   endpoint.Envelope,
+		MediaType:  endpoint.MediaType,
+		Parameters: endpoint.Parameters,
+		Redis:      endpoint.Redis,
+		metrics:    endpoint.metrics,
+		deliveries: endpoint.deliveries,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	// Configures the inmemory queue, retry, http pipeline.
-	endpoint.Sink = newHTTPSink(
-		endpoint.url, endpoint.Timeout, endpoint.Headers,
-		endpoint.metrics.httpStatusListener())
-	endpoint.Sink = newRetryingSink(endpoint.Sink, endpoint.Threshold, endpoint.Backoff)
-	endpoint.Sink = newEventQueue(endpoint.Sink, endpoint.metrics.eventQueueListener())
+	// Dead letters are kept next to the disk queue, if any, so that they
+	// survive restarts as well.
+	var deadLetterPath string
+	if endpoint.Queue.Directory != "" {
+		if err := os.MkdirAll(endpoint.Queue.Directory, 0755); err != nil {
+			return nil, err
+		}
+		deadLetterPath = filepath.Join(endpoint.Queue.Directory, deadLetterFile)
+	}
+
+	endpoint.deadLetters, err = newDeadLetterStore(deadLetterPath, endpoint.MaxDeadLetters)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint.Sink = newRetryingSink(newRecordingSink(sink, endpoint.deliveries), retryPolicy{
+		threshold:   endpoint.Threshold,
+		backoff:     endpoint.Backoff,
+		maxBackoff:  endpoint.MaxBackoff,
+		maxAttempts: endpoint.MaxAttempts,
+		maxAge:      endpoint.MaxAge,
+	}, endpoint.deadLetters)
+
+	// CloudEvents are posted one at a time, so they are retried and
+	// abandoned one at a time as well: an event rejected by the endpoint
+	// neither holds back nor drags along the events written with it.
+	if endpoint.Envelope != EnvelopeRegistry {
+		endpoint.Sink = newSplittingSink(endpoint.Sink)
+	}
+
+	if endpoint.Queue.Directory != "" {
+		dq, err := newDiskQueue(endpoint.Sink, endpoint.Queue, endpoint.metrics.diskQueueListener())
+		if err != nil {
+			return nil, err
+		}
+		endpoint.Sink = dq
+	} else {
+		endpoint.Sink = newEventQueue(endpoint.Sink, endpoint.metrics.eventQueueListener())
+	}
+
+	// Filter events before queueing, so that dropped events take no space.
+	if !endpoint.Filter.empty() {
+		fs, err := newFilteringSink(endpoint.Sink, endpoint.Filter, endpoint.metrics.filterListener())
+		if err != nil {
+			endpoint.Sink.Close()
+			return nil, err
+		}
+		endpoint.Sink = fs
+	}
 
 	register(&endpoint)
-	return &endpoint
+	return &endpoint, nil
+}
+
+// Close unregisters the endpoint and closes its sink.
+func (e *Endpoint) Close() error {
+	unregister(e)
+	return e.Sink.Close()
 }
 
 // Name returns the name of the endpoint, generally used for debugging.
@@ -67,9 +231,18 @@ func (e *Endpoint) Name() string {
 	return e.name
 }
 
-// URL returns the url of the endpoint.
+// URL returns the url of the endpoint, with any password redacted.
 func (e *Endpoint) URL() string {
-	return e.url
+	u, err := url.Parse(e.url)
+	if err != nil || u.User == nil {
+		return e.url
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+
+	return u.String()
 }
 
 // ReadMetrics populates em with metrics from the endpoint.
@@ -84,3 +257,48 @@ func (e *Endpoint) ReadMetrics(em *EndpointMetrics) {
 		em.Statuses[k] = v
 	}
 }
+
+// Deliveries returns the recent deliveries to the endpoint, most recent first.
+func (e *Endpoint) Deliveries() []Delivery {
+	return e.deliveries.list()
+}
+
+// DeadLetters returns the events that could not be delivered to the endpoint,
+// oldest first.
+func (e *Endpoint) DeadLetters() []DeadLetter {
+	return e.deadLetters.list()
+}
+
+// Replay writes the dead letters selected by ids and the time range back to
+// the endpoint, removing them from the dead letters. If ids is empty, dead
+// letters are selected by time only; zero from and to times leave the range
+// open. The number of replayed events is returned.
+func (e *Endpoint) Replay(ids []string, from, to time.Time) (int, error) {
+	selected := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		selected[id] = struct{}{}
+	}
+
+	events := e.deadLetters.remove(func(letter DeadLetter) bool {
+		if len(selected) > 0 {
+			if _, ok := selected[letter.Event.ID]; !ok {
+				return false
+			}
+		}
+
+		return (from.IsZero() || !letter.Time.Before(from)) &&
+			(to.IsZero() || !letter.Time.After(to))
+	})
+
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	if err := e.Sink.Write(events...); err != nil {
+		// Keep the events for a later replay.
+		e.deadLetters.add(err, events...)
+		return 0, err
+	}
+
+	return len(events), nil
+}
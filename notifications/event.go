@@ -12,16 +12,44 @@ const (
 	EventActionPull   = "pull"
 	EventActionPush   = "push"
 	EventActionDelete = "delete"
+	EventActionTag    = "tag"
+	EventActionUntag  = "untag"
+
+	// EventActionUnverified marks a manifest accepted without a trusted
+	// signature, when the trust policy is not enforced.
+	EventActionUnverified = "unverified"
+
+	// EventActionMove marks a tag moved from one manifest to another. The
+	// target describes the new manifest and the previous one.
+	EventActionMove = "move"
+
+	// EventActionCreate marks the creation of a repository by its first
+	// tagged manifest push.
+	EventActionCreate = "create"
+
+	// EventActionUpload, EventActionCancel and EventActionExpire mark a
+	// layer upload started, cancelled by the client or removed by the upload
+	// purger, respectively.
+	EventActionUpload = "upload"
+	EventActionCancel = "cancel"
+	EventActionExpire = "expire"
 )
 
 const (
 	// EventsMediaType is the mediatype for the json event envelope. If the
 	// Event, ActorRecord, SourceRecord or Envelope structs change, the version
 	// number should be incremented.
-	EventsMediaType = "application/vnd.docker.distribution.events.v1+json"
+	EventsMediaType = "application/vnd.docker.distribution.events.v2+json"
+
+	// EventsMediaTypeV1 is the mediatype for the json event envelope of the
+	// first version, which only has push, pull and delete events. These
+	// lack the size of their target, the tag and references of manifests,
+	// the image size and the response record.
+	EventsMediaTypeV1 = "application/vnd.docker.distribution.events.v1+json"
+
 	// LayerMediaType is the media type for image rootfs diffs (aka "layers")
 	// used by Docker. We don't expect this to change for quite a while.
-	layerMediaType = "application/vnd.docker.container.image.rootfs.diff+x-gtar"
+	LayerMediaType = "application/vnd.docker.container.image.rootfs.diff+x-gtar"
 )
 
 // Envelope defines the fields of a json event envelope message that can hold
@@ -54,16 +82,46 @@ type Event struct {
 
 		distribution.Descriptor
 
+		// Length in bytes of content. Same as Size field in Descriptor.
+		// Provided for backwards compatibility.
+		Length int64 `json:"length,omitempty"`
+
 		// Repository identifies the named repository.
 		Repository string `json:"repository,omitempty"`
 
+		// Tag is set on tag, untag and move events to the tag that was
+		// changed, and on manifest events to the tag the manifest was pushed
+		// with or pulled by.
+		Tag string `json:"tag,omitempty"`
+
+		// Previous describes the manifest a tag referenced before it was
+		// moved, on move events.
+		Previous *distribution.Descriptor `json:"previous,omitempty"`
+
+		// UUID identifies the layer upload of upload, cancel and expire
+		// events.
+		UUID string `json:"uuid,omitempty"`
+
 		// URL provides a direct link to the content.
 		URL string `json:"url,omitempty"`
+
+		// References lists the descriptors of the objects making up a
+		// manifest, such as its layers, from base to head.
+		References []distribution.Descriptor `json:"references,omitempty"`
+
+		// ImageSize is the size in bytes of a manifest and all of its
+		// references. It is not set if one of the references cannot be
+		// found.
+		ImageSize int64 `json:"imageSize,omitempty"`
 	} `json:"target,omitempty"`
 
 	// Request covers the request that generated the event.
 	Request RequestRecord `json:"request,omitempty"`
 
+	// Response covers the response to the request that generated the event,
+	// if the event was emitted once it was written.
+	Response *ResponseRecord `json:"response,omitempty"`
+
 	// Actor specifies the agent that initiated the event. For most
 	// situations, this could be from the authorizaton context of the request.
 	Actor ActorRecord `json:"actor,omitempty"`
@@ -72,6 +130,11 @@ type Event struct {
 	// differently, while the actor "initiates" the event, the source
 	// "generates" it.
 	Source SourceRecord `json:"source,omitempty"`
+
+	// attempts counts the attempts to deliver the event to an endpoint. It
+	// is not part of the event but of its delivery, set by the queue or the
+	// retrying sink of the endpoint.
+	attempts *eventAttempts
 }
 
 // ActorRecord specifies the agent that initiated the event. For most
@@ -113,6 +176,19 @@ type RequestRecord struct {
 	UserAgent string `json:"useragent"`
 }
 
+// ResponseRecord covers the response to the request that generated the
+// event. A pull redirected to the storage backend has a redirect status,
+// while an interrupted pull has written fewer bytes than the size of its
+// target.
+type ResponseRecord struct {
+	// Status is the http status code of the response.
+	Status int `json:"status"`
+
+	// Written is the number of bytes of the response body written to the
+	// client.
+	Written int64 `json:"written"`
+}
+
 // SourceRecord identifies the registry node that generated the event. Put
 // differently, while the actor "initiates" the event, the source "generates"
 // it.
@@ -127,6 +203,37 @@ type SourceRecord struct {
 	InstanceID string `json:"instanceID,omitempty"`
 }
 
+// eventsForMediaType returns the events as encoded in envelopes of the media
+// type, which must be EventsMediaType or EventsMediaTypeV1. The first version
+// only describes pushes, pulls and deletes, so events of other actions are
+// left out of it.
+func eventsForMediaType(mediaType string, events []Event) []Event {
+	if mediaType != EventsMediaTypeV1 {
+		return events
+	}
+
+	var v1 []Event
+	for _, event := range events {
+		switch event.Action {
+		case EventActionPush, EventActionPull, EventActionDelete:
+		default:
+			continue
+		}
+
+		event.Target.Size = 0
+		event.Target.Tag = ""
+		event.Target.Previous = nil
+		event.Target.UUID = ""
+		event.Target.References = nil
+		event.Target.ImageSize = 0
+		event.Response = nil
+
+		v1 = append(v1, event)
+	}
+
+	return v1
+}
+
 var (
 	// ErrSinkClosed is returned if a write is issued to a sink that has been
 	// closed. If encountered, the error should be considered terminal and
@@ -139,10 +246,27 @@ type Sink interface {
 	// Write writes one or more events to the sink. If no error is returned,
 	// the caller will assume that all events have been committed and will not
 	// try to send them again. If an error is received, the caller may retry
-	// sending the event. The caller should cede the slice of memory to the
-	// sink and not modify it after calling this method.
+	// sending the event, unless it is a PermanentError. The caller should
+	// cede the slice of memory to the sink and not modify it after calling
+	// this method.
 	Write(events ...Event) error
 
 	// Close the sink, possibly waiting for pending events to flush.
 	Close() error
 }
+
+// PermanentError is returned by sinks rejecting events that would be rejected
+// again if retried, such as malformed events.
+type PermanentError struct {
+	Err error
+}
+
+func (err PermanentError) Error() string {
+	return err.Err.Error()
+}
+
+// isPermanent returns true if err is a PermanentError.
+func isPermanent(err error) bool {
+	_, ok := err.(PermanentError)
+	return ok
+}
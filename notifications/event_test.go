@@ -2,10 +2,12 @@ package notifications
 
 import (
 	"encoding/json"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/docker/distribution"
 	"github.com/docker/distribution/manifest"
 )
 
@@ -22,8 +24,9 @@ func TestEventEnvelopeJSONFormat(t *testing.T) {
          "action": "push",
          "target": {
             "mediaType": "application/vnd.docker.distribution.manifest.v1+json",
-            "length": 1,
+            "size": 1,
             "digest": "sha256:0123456789abcdef0",
+            "length": 1,
             "repository": "library/test",
             "url": "http://example.com/v2/library/test/manifests/latest"
          },
@@ -47,8 +50,9 @@ func TestEventEnvelopeJSONFormat(t *testing.T) {
          "action": "push",
          "target": {
             "mediaType": "application/vnd.docker.container.image.rootfs.diff+x-gtar",
-            "length": 2,
+            "size": 2,
             "digest": "tarsum.v2+sha256:0123456789abcdef1",
+            "length": 2,
             "repository": "library/test",
             "url": "http://example.com/v2/library/test/manifests/latest"
          },
@@ -72,8 +76,9 @@ func TestEventEnvelopeJSONFormat(t *testing.T) {
          "action": "push",
          "target": {
             "mediaType": "application/vnd.docker.container.image.rootfs.diff+x-gtar",
-            "length": 3,
+            "size": 3,
             "digest": "tarsum.v2+sha256:0123456789abcdef2",
+            "length": 3,
             "repository": "library/test",
             "url": "http://example.com/v2/library/test/manifests/latest"
          },
@@ -90,6 +95,33 @@ func TestEventEnvelopeJSONFormat(t *testing.T) {
          "source": {
             "addr": "hostname.local:port"
          }
+      },
+      {
+         "id": "asdf-asdf-asdf-asdf-3",
+         "timestamp": "2006-01-02T15:04:05Z",
+         "action": "tag",
+         "target": {
+            "mediaType": "application/vnd.docker.distribution.manifest.v1+json",
+            "size": 1,
+            "digest": "sha256:0123456789abcdef0",
+            "length": 1,
+            "repository": "library/test",
+            "tag": "stable",
+            "url": "http://example.com/v2/library/test/manifests/stable"
+         },
+         "request": {
+            "id": "asdfasdf",
+            "addr": "client.local",
+            "host": "registrycluster.local",
+            "method": "PUT",
+            "useragent": "test/0.1"
+         },
+         "actor": {
+            "name": "test-actor"
+         },
+         "source": {
+            "addr": "hostname.local:port"
+         }
       }
    ]
 }
@@ -115,6 +147,7 @@ func TestEventEnvelopeJSONFormat(t *testing.T) {
 	manifestPush = prototype
 	manifestPush.ID = "asdf-asdf-asdf-asdf-0"
 	manifestPush.Target.Digest = "sha256:0123456789abcdef0"
+	manifestPush.Target.Size = int64(1)
 	manifestPush.Target.Length = int64(1)
 	manifestPush.Target.MediaType = manifest.ManifestMediaType
 	manifestPush.Target.Repository = "library/test"
@@ -124,8 +157,9 @@ func TestEventEnvelopeJSONFormat(t *testing.T) {
 	layerPush0 = prototype
 	layerPush0.ID = "asdf-asdf-asdf-asdf-1"
 	layerPush0.Target.Digest = "tarsum.v2+sha256:0123456789abcdef1"
+	layerPush0.Target.Size = 2
 	layerPush0.Target.Length = 2
-	layerPush0.Target.MediaType = layerMediaType
+	layerPush0.Target.MediaType = LayerMediaType
 	layerPush0.Target.Repository = "library/test"
 	layerPush0.Target.URL = "http://example.com/v2/library/test/manifests/latest"
 
@@ -133,13 +167,26 @@ func TestEventEnvelopeJSONFormat(t *testing.T) {
 	layerPush1 = prototype
 	layerPush1.ID = "asdf-asdf-asdf-asdf-2"
 	layerPush1.Target.Digest = "tarsum.v2+sha256:0123456789abcdef2"
+	layerPush1.Target.Size = 3
 	layerPush1.Target.Length = 3
-	layerPush1.Target.MediaType = layerMediaType
+	layerPush1.Target.MediaType = LayerMediaType
 	layerPush1.Target.Repository = "library/test"
 	layerPush1.Target.URL = "http://example.com/v2/library/test/manifests/latest"
 
+	var manifestTag Event
+	manifestTag = prototype
+	manifestTag.ID = "asdf-asdf-asdf-asdf-3"
+	manifestTag.Action = EventActionTag
+	manifestTag.Target.Digest = "sha256:0123456789abcdef0"
+	manifestTag.Target.Size = int64(1)
+	manifestTag.Target.Length = int64(1)
+	manifestTag.Target.MediaType = manifest.ManifestMediaType
+	manifestTag.Target.Repository = "library/test"
+	manifestTag.Target.Tag = "stable"
+	manifestTag.Target.URL = "http://example.com/v2/library/test/manifests/stable"
+
 	var envelope Envelope
-	envelope.Events = append(envelope.Events, manifestPush, layerPush0, layerPush1)
+	envelope.Events = append(envelope.Events, manifestPush, layerPush0, layerPush1, manifestTag)
 
 	p, err := json.MarshalIndent(envelope, "", "   ")
 	if err != nil {
@@ -149,3 +196,54 @@ func TestEventEnvelopeJSONFormat(t *testing.T) {
 		t.Fatalf("format has changed\n%s\n != \n%s", string(p), expected)
 	}
 }
+
+// TestEventsForMediaType ensures that events encoded for the first version of
+// the envelope lack the fields added by later versions.
+func TestEventsForMediaType(t *testing.T) {
+	push := createTestEvent(EventActionPush, "library/test", manifest.ManifestMediaType)
+	push.Target.Tag = "latest"
+	push.Target.References = []distribution.Descriptor{{Digest: "sha256:0123456789abcdef0", Size: 1}}
+	push.Target.ImageSize = 2
+	push.Response = &ResponseRecord{Status: 202}
+
+	tag := createTestEvent(EventActionTag, "library/test", manifest.ManifestMediaType)
+	tag.Target.Tag = "stable"
+
+	events := []Event{push, tag}
+	if v2 := eventsForMediaType(EventsMediaType, events); !reflect.DeepEqual(v2, events) {
+		t.Fatalf("unexpected events for %s: %#v", EventsMediaType, v2)
+	}
+
+	// Tag events are not part of the first version.
+	v1 := eventsForMediaType(EventsMediaTypeV1, events)
+	if len(v1) != 1 {
+		t.Fatalf("unexpected number of events for %s: %d != 1", EventsMediaTypeV1, len(v1))
+	}
+
+	if v1[0].Target.Tag != "" || v1[0].Target.References != nil || v1[0].Target.ImageSize != 0 || v1[0].Response != nil {
+		t.Fatalf("unexpected push event for %s: %#v", EventsMediaTypeV1, v1[0])
+	}
+
+	p, err := json.Marshal(v1[0].Target)
+	if err != nil {
+		t.Fatalf("unexpected error marshaling target: %v", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(p, &fields); err != nil {
+		t.Fatalf("unexpected error unmarshaling target: %v", err)
+	}
+
+	for field := range fields {
+		switch field {
+		case "mediaType", "length", "digest", "repository", "url":
+		default:
+			t.Fatalf("unexpected %s field in target for %s: %s", field, EventsMediaTypeV1, p)
+		}
+	}
+
+	// The events passed in are left untouched.
+	if events[0].Target.Tag != "latest" || events[0].Response == nil {
+		t.Fatalf("events should not be modified: %#v", events[0])
+	}
+}
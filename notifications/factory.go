@@ -0,0 +1,234 @@
+package notifications
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// DefaultSinkType is the type of endpoints configured without a type, which
+// deliver events with http requests.
+const DefaultSinkType = "http"
+
+// sinkFactories maps endpoint types to the factories of their sinks.
+var sinkFactories = struct {
+	sync.Mutex
+	factories map[string]SinkFactory
+}{factories: make(map[string]SinkFactory)}
+
+// SinkFactory creates the sinks delivering events to endpoints of a type.
+// Sink implementations should call RegisterSink with a factory to make the
+// type available to endpoints.
+type SinkFactory interface {
+	// Create returns a new sink delivering events to the endpoint described
+	// by config. The sink only needs to make a single attempt per write:
+	// queueing and retries are provided by the endpoint.
+	Create(config SinkConfig) (Sink, error)
+}
+
+// SinkFactoryFunc adapts a function to the SinkFactory interface.
+type SinkFactoryFunc func(config SinkConfig) (Sink, error)
+
+// Create calls f(config).
+func (f SinkFactoryFunc) Create(config SinkConfig) (Sink, error) {
+	return f(config)
+}
+
+// SinkConfig describes the endpoint a sink is created for.
+type SinkConfig struct {
+	Name    string
+	URL     string
+	Headers http.Header
+	Timeout time.Duration
+	Secrets []string
+
+	// Envelope selects how events are encoded for the endpoint, either
+	// EnvelopeRegistry, EnvelopeCloudEvents or EnvelopeCloudEventsBinary.
+	// Only http sinks support CloudEvents.
+	Envelope string
+
+	// MediaType is the media type of the envelopes delivered to the
+	// endpoint, either EventsMediaType or EventsMediaTypeV1.
+	MediaType string
+
+	// TLS configures the TLS connections to the endpoint.
+	TLS TLSConfig
+
+	// Proxy is the url of the proxy requests to http endpoints go through.
+	// If empty, the proxy of the environment is used.
+	Proxy string
+
+	// Parameters holds the type specific parameters of the endpoint.
+	Parameters map[string]interface{}
+
+	// Redis is the redis pool of the registry, or nil if redis is not
+	// configured.
+	Redis *redis.Pool
+
+	// metrics is set for endpoints created by NewEndpoint, so that built-in
+	// sinks can report the outcome of deliveries.
+	metrics *safeMetrics
+
+	// deliveries is set for endpoints created by NewEndpoint, so that the
+	// http sink can report the status codes of deliveries.
+	deliveries *deliveryLog
+}
+
+// RegisterSink makes a sink factory available to endpoints of the provided
+// type. If RegisterSink is called twice with the same type or if factory is
+// nil, it panics.
+func RegisterSink(typ string, factory SinkFactory) {
+	if factory == nil {
+		panic("Must not provide nil SinkFactory")
+	}
+
+	sinkFactories.Lock()
+	defer sinkFactories.Unlock()
+
+	if _, registered := sinkFactories.factories[typ]; registered {
+		panic(fmt.Sprintf("SinkFactory of type %s already registered", typ))
+	}
+
+	sinkFactories.factories[typ] = factory
+}
+
+// CreateSink returns a new sink of the provided type for the endpoint
+// described by config. If no factory is registered for the type, an
+// InvalidSinkTypeError is returned. An empty type selects DefaultSinkType,
+// an empty envelope EnvelopeRegistry and an empty media type
+// EventsMediaType.
+func CreateSink(typ string, config SinkConfig) (Sink, error) {
+	if typ == "" {
+		typ = DefaultSinkType
+	}
+
+	if config.Envelope == "" {
+		config.Envelope = EnvelopeRegistry
+	}
+
+	if config.MediaType == "" {
+		config.MediaType = EventsMediaType
+	}
+
+	sinkFactories.Lock()
+	factory, ok := sinkFactories.factories[typ]
+	sinkFactories.Unlock()
+
+	if !ok {
+		return nil, InvalidSinkTypeError{typ}
+	}
+
+	return factory.Create(config)
+}
+
+// InvalidSinkTypeError records an attempt to create a sink of an unregistered
+// type.
+type InvalidSinkTypeError struct {
+	Type string
+}
+
+func (err InvalidSinkTypeError) Error() string {
+	return fmt.Sprintf("SinkFactory not registered: %s", err.Type)
+}
+
+// deliveryListener is called on the outcome of deliveries by sinks without
+// status codes.
+type deliveryListener interface {
+	delivered(events ...Event)
+	err(err error, events ...Event)
+}
+
+// deliveryListeners returns the listeners for the sink created with config.
+func (config SinkConfig) deliveryListeners() []deliveryListener {
+	if config.metrics == nil {
+		return nil
+	}
+
+	return []deliveryListener{config.metrics.deliveryListener()}
+}
+
+// httpStatusListeners returns the listeners for the http sink created with
+// config.
+func (config SinkConfig) httpStatusListeners() []httpStatusListener {
+	var listeners []httpStatusListener
+	if config.metrics != nil {
+		listeners = append(listeners, config.metrics.httpStatusListener())
+	}
+
+	if config.deliveries != nil {
+		listeners = append(listeners, config.deliveries)
+	}
+
+	return listeners
+}
+
+func init() {
+	RegisterSink(DefaultSinkType, SinkFactoryFunc(func(config SinkConfig) (Sink, error) {
+		if !validEnvelope(config.Envelope) {
+			return nil, fmt.Errorf("unsupported events envelope: %q", config.Envelope)
+		}
+
+		// Signatures cover the body only, which leaves the attributes of
+		// binary CloudEvents in ce- headers open to tampering.
+		if config.Envelope == EnvelopeCloudEventsBinary && len(config.Secrets) > 0 {
+			return nil, fmt.Errorf("secrets cannot sign the %s envelope, use %s", EnvelopeCloudEventsBinary, EnvelopeCloudEvents)
+		}
+
+		transport, err := newHTTPTransport(config.TLS, config.Proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		return newHTTPSink(config.URL, config.Envelope, config.MediaType, config.Timeout, config.Headers, transport, config.Secrets, config.httpStatusListeners()...), nil
+	}))
+}
+
+// stringParameter returns the string parameter key, or defaultValue if it is
+// not set.
+func stringParameter(parameters map[string]interface{}, key, defaultValue string) (string, error) {
+	value, ok := parameters[key]
+	if !ok || value == nil {
+		return defaultValue, nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case int, int64, uint64, float64, bool:
+		return fmt.Sprint(v), nil
+	}
+
+	return "", fmt.Errorf("parameter %s must be a string, not %T", key, value)
+}
+
+// intParameter returns the integer parameter key, or defaultValue if it is
+// not set.
+func intParameter(parameters map[string]interface{}, key string, defaultValue int64) (int64, error) {
+	value, ok := parameters[key]
+	if !ok || value == nil {
+		return defaultValue, nil
+	}
+
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint64:
+		return int64(v), nil
+	case float64:
+		return int64(v), nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parameter %s must be an integer: %v", key, err)
+		}
+		return n, nil
+	}
+
+	return 0, fmt.Errorf("parameter %s must be an integer, not %T", key, value)
+}
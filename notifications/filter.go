@@ -0,0 +1,135 @@
+package notifications
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/docker/distribution/glob"
+)
+
+// EventFilter selects the events sent to an endpoint. An event is selected if
+// it matches every criteria set. Glob patterns are matched with path.Match.
+type EventFilter struct {
+	// Actions lists the event actions to select.
+	Actions []string
+
+	// MediaTypes lists glob patterns matched against the media type of the
+	// event target.
+	MediaTypes []string
+
+	// Repositories lists glob patterns matched against the repository of
+	// the event target.
+	Repositories []string
+
+	// RepositoryRegexp is a regular expression the repository of the event
+	// target must match.
+	RepositoryRegexp string
+
+	// Actors lists glob patterns matched against the name of the actor of
+	// the event.
+	Actors []string
+
+	// ExcludeActors lists glob patterns of actor names whose events are not
+	// selected, even if they match Actors.
+	ExcludeActors []string
+}
+
+// empty returns true if the filter selects all events.
+func (ef EventFilter) empty() bool {
+	return len(ef.Actions) == 0 && len(ef.MediaTypes) == 0 &&
+		len(ef.Repositories) == 0 && ef.RepositoryRegexp == "" &&
+		len(ef.Actors) == 0 && len(ef.ExcludeActors) == 0
+}
+
+// filterListener is called with the events dropped by a filtering sink.
+type filterListener interface {
+	filtered(events ...Event)
+}
+
+// filteringSink forwards the events selected by a filter to a sink, dropping
+// the others.
+type filteringSink struct {
+	Sink
+	filter     EventFilter
+	repository *regexp.Regexp
+	listeners  []filterListener
+}
+
+// newFilteringSink returns a sink forwarding the events selected by filter
+// to sink. An error is returned if the repository regular expression of the
+// filter is invalid.
+func newFilteringSink(sink Sink, filter EventFilter, listeners ...filterListener) (*filteringSink, error) {
+	fs := &filteringSink{
+		Sink:      sink,
+		filter:    filter,
+		listeners: listeners,
+	}
+
+	if filter.RepositoryRegexp != "" {
+		re, err := regexp.Compile("^(?:" + filter.RepositoryRegexp + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository regexp %q: %v", filter.RepositoryRegexp, err)
+		}
+		fs.repository = re
+	}
+
+	return fs, nil
+}
+
+// Write forwards the selected events to the sink. If no events are selected,
+// the sink is not called.
+func (fs *filteringSink) Write(events ...Event) error {
+	var selected, dropped []Event
+	for _, event := range events {
+		if fs.match(event) {
+			selected = append(selected, event)
+		} else {
+			dropped = append(dropped, event)
+		}
+	}
+
+	if len(dropped) > 0 {
+		for _, listener := range fs.listeners {
+			listener.filtered(dropped...)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil
+	}
+
+	return fs.Sink.Write(selected...)
+}
+
+func (fs *filteringSink) String() string {
+	return fmt.Sprintf("filteringSink{%v}", fs.Sink)
+}
+
+// match returns true if the event is selected by the filter.
+func (fs *filteringSink) match(event Event) bool {
+	if len(fs.filter.Actions) > 0 && !glob.Contains(fs.filter.Actions, event.Action) {
+		return false
+	}
+
+	if len(fs.filter.MediaTypes) > 0 && !glob.MatchAny(fs.filter.MediaTypes, event.Target.MediaType) {
+		return false
+	}
+
+	if len(fs.filter.Repositories) > 0 && !glob.MatchAny(fs.filter.Repositories, event.Target.Repository) {
+		return false
+	}
+
+	if fs.repository != nil && !fs.repository.MatchString(event.Target.Repository) {
+		return false
+	}
+
+	if len(fs.filter.Actors) > 0 && !glob.MatchAny(fs.filter.Actors, event.Actor.Name) {
+		return false
+	}
+
+	if glob.MatchAny(fs.filter.ExcludeActors, event.Actor.Name) {
+		return false
+	}
+
+	return true
+}
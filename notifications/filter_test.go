@@ -0,0 +1,115 @@
+package notifications
+
+import (
+	"testing"
+)
+
+func TestFilteringSink(t *testing.T) {
+	createActorEvent := func(action, repo, typ, actor string) Event {
+		event := createTestEvent(action, repo, typ)
+		event.Actor.Name = actor
+		return event
+	}
+
+	events := []Event{
+		createActorEvent("push", "prod/app", "application/vnd.docker.distribution.manifest.v1+json", "ci"),
+		createActorEvent("push", "prod/app", LayerMediaType, "ci"),
+		createActorEvent("pull", "prod/app", LayerMediaType, "alice"),
+		createActorEvent("push", "dev/app", "application/vnd.docker.distribution.manifest.v1+json", "bob"),
+		createActorEvent("pull", "dev/tools/app", LayerMediaType, "scanner"),
+	}
+
+	for _, testcase := range []struct {
+		description string
+		filter      EventFilter
+		expected    []int // indexes of the selected events
+	}{
+		{
+			description: "empty filter",
+			expected:    []int{0, 1, 2, 3, 4},
+		},
+		{
+			description: "actions",
+			filter:      EventFilter{Actions: []string{"pull"}},
+			expected:    []int{2, 4},
+		},
+		{
+			description: "manifest pushes to prod",
+			filter: EventFilter{
+				Actions:      []string{"push"},
+				MediaTypes:   []string{"application/vnd.docker.distribution.manifest.*"},
+				Repositories: []string{"prod/*"},
+			},
+			expected: []int{0},
+		},
+		{
+			description: "repository regexp",
+			filter:      EventFilter{RepositoryRegexp: "dev/.+"},
+			expected:    []int{3, 4},
+		},
+		{
+			description: "repository regexp is anchored",
+			filter:      EventFilter{RepositoryRegexp: "app"},
+		},
+		{
+			description: "actors",
+			filter:      EventFilter{Actors: []string{"ci", "b*"}},
+			expected:    []int{0, 1, 3},
+		},
+		{
+			description: "excluded actors",
+			filter:      EventFilter{Actors: []string{"*"}, ExcludeActors: []string{"scanner", "ci"}},
+			expected:    []int{2, 3},
+		},
+	} {
+		var ts testSink
+		metrics := newSafeMetrics()
+		fs, err := newFilteringSink(&ts, testcase.filter, metrics.filterListener())
+		if err != nil {
+			t.Fatalf("%s: unexpected error creating filtering sink: %v", testcase.description, err)
+		}
+
+		if err := fs.Write(events...); err != nil {
+			t.Fatalf("%s: unexpected error writing events: %v", testcase.description, err)
+		}
+
+		var expected []Event
+		for _, i := range testcase.expected {
+			expected = append(expected, events[i])
+		}
+
+		checkEventIDs(t, ts.events, expected)
+
+		if metrics.Filtered != len(events)-len(expected) {
+			t.Fatalf("%s: unexpected number of filtered events: %d != %d", testcase.description, metrics.Filtered, len(events)-len(expected))
+		}
+	}
+
+	if _, err := newFilteringSink(&testSink{}, EventFilter{RepositoryRegexp: "("}); err == nil {
+		t.Fatalf("expected error creating filtering sink with invalid regexp")
+	}
+}
+
+func TestFilteringSinkSkipsEmptyWrites(t *testing.T) {
+	fs, err := newFilteringSink(&failingSink{}, EventFilter{Actions: []string{"delete"}})
+	if err != nil {
+		t.Fatalf("unexpected error creating filtering sink: %v", err)
+	}
+
+	if err := fs.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+		t.Fatalf("unexpected error writing filtered events: %v", err)
+	}
+
+	if err := fs.Write(createTestEvent("delete", "library/test", "blob")); err == nil {
+		t.Fatalf("expected error writing selected events")
+	}
+}
+
+// failingSink fails every write.
+type failingSink struct {
+	testSink
+}
+
+func (fs *failingSink) Write(events ...Event) error {
+	return ErrSinkClosed
+}
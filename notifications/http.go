@@ -7,32 +7,48 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/Sirupsen/logrus"
 )
 
 // httpSink implements a single-flight, http notification endpoint. This is
 // very lightweight in that it only makes an attempt at an http request.
 // Reliability should be provided by the caller.
 type httpSink struct {
-	url string
+	url       string
+	envelope  string
+	mediaType string
 
 	mu        sync.Mutex
 	closed    bool
 	client    *http.Client
+	secrets   []string
 	listeners []httpStatusListener
-
-	// TODO(stevvooe): Allow one to configure the media type accepted by this
-	// sink and choose the serialization based on that.
 }
 
-// newHTTPSink returns an unreliable, single-flight http sink. Wrap in other
+// newHTTPSink returns an unreliable, single-flight http sink, posting events
+// of the media type in the envelope, EnvelopeRegistry if empty. Wrap in other
 // sinks for increased reliability.
-func newHTTPSink(u string, timeout time.Duration, headers http.Header, listeners ...httpStatusListener) *httpSink {
+// Requests go through the transport, or the default transport if nil. If any
+// secrets are provided, requests are signed with each of them.
+func newHTTPSink(u, envelope, mediaType string, timeout time.Duration, headers http.Header, transport *http.Transport, secrets []string, listeners ...httpStatusListener) *httpSink {
+	if transport == nil {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+
+	if envelope == "" {
+		envelope = EnvelopeRegistry
+	}
+
 	return &httpSink{
 		url:       u,
+		envelope:  envelope,
+		mediaType: mediaType,
+		secrets:   secrets,
 		listeners: listeners,
 		client: &http.Client{
 			Transport: &headerRoundTripper{
-				Transport: http.DefaultTransport.(*http.Transport),
+				Transport: transport,
 				headers:   headers,
 			},
 			Timeout: timeout,
@@ -49,7 +65,15 @@ type httpStatusListener interface {
 
 // Accept makes an attempt to notify the endpoint, returning an error if it
 // fails. It is the caller's responsibility to retry on error. The events are
-// accepted or rejected as a group.
+// accepted or rejected as a group. If the endpoint rejects the media type of
+// a registry envelope with 415 Unsupported Media Type, the sink falls back to
+// EventsMediaTypeV1 for this and all later writes. Other 4xx responses, except
+// 408 Request Timeout and 429 Too Many Requests, are PermanentErrors.
+//
+// CloudEvents carry a single event, so they are posted one request at a
+// time. If a request fails, the events already accepted are sent again on
+// retry: endpoints split writes with a splittingSink so that this does not
+// happen.
 func (hs *httpSink) Write(events ...Event) error {
 	hs.mu.Lock()
 	defer hs.mu.Unlock()
@@ -58,51 +82,121 @@ func (hs *httpSink) Write(events ...Event) error {
 		return ErrSinkClosed
 	}
 
-	envelope := Envelope{
-		Events: events,
+	if hs.envelope == EnvelopeRegistry {
+		return hs.write(events...)
+	}
+
+	for _, event := range events {
+		if err := hs.write(event); err != nil {
+			return err
+		}
 	}
 
+	return nil
+}
+
+// write posts the events in a single request, falling back to
+// EventsMediaTypeV1 if needed.
+func (hs *httpSink) write(events ...Event) error {
+	for {
+		// Events the media type cannot describe are not sent at all.
+		if len(events) > 0 && len(eventsForMediaType(hs.mediaType, events)) == 0 {
+			return nil
+		}
+
+		status, err := hs.post(events...)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		// The notifier will treat any 2xx or 3xx response as accepted by the
+		// endpoint.
+		case status >= 200 && status < 400:
+			for _, listener := range hs.listeners {
+				listener.success(status, events...)
+			}
+
+			return nil
+		case status == http.StatusUnsupportedMediaType && hs.envelope == EnvelopeRegistry && hs.mediaType != EventsMediaTypeV1:
+			logrus.Warnf("%v: %s unsupported, falling back to %s", hs, hs.mediaType, EventsMediaTypeV1)
+			hs.mediaType = EventsMediaTypeV1
+		default:
+			for _, listener := range hs.listeners {
+				listener.failure(status, events...)
+			}
+
+			err := fmt.Errorf("%v: response status %v unaccepted", hs, status)
+
+			// Client errors other than timeouts and throttling are caused by
+			// the events, which would be rejected again.
+			if status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests {
+				return PermanentError{err}
+			}
+
+			return err
+		}
+	}
+}
+
+// post sends the envelope of the events to the endpoint, returning the status
+// of the response.
+func (hs *httpSink) post(events ...Event) (int, error) {
 	// TODO(stevvooe): It is not ideal to keep re-encoding the request body on
 	// retry but we are going to do it to keep the code simple. It is likely
 	// we could change the event struct to manage its own buffer.
 
-	p, err := json.MarshalIndent(envelope, "", "   ")
+	p, header, err := hs.encode(eventsForMediaType(hs.mediaType, events))
 	if err != nil {
 		for _, listener := range hs.listeners {
 			listener.err(err, events...)
 		}
-		return fmt.Errorf("%v: error marshaling event envelope: %v", hs, err)
+		return 0, fmt.Errorf("%v: error marshaling event envelope: %v", hs, err)
 	}
 
-	body := bytes.NewReader(p)
-	resp, err := hs.client.Post(hs.url, EventsMediaType, body)
+	req, err := http.NewRequest("POST", hs.url, bytes.NewReader(p))
 	if err != nil {
 		for _, listener := range hs.listeners {
 			listener.err(err, events...)
 		}
+		return 0, fmt.Errorf("%v: error creating request: %v", hs, err)
+	}
 
-		return fmt.Errorf("%v: error posting: %v", hs, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if len(hs.secrets) > 0 {
+		req.Header.Set(SignatureHeader, signatureHeaderValue(time.Now(), p, hs.secrets))
 	}
 
-	// The notifier will treat any 2xx or 3xx response as accepted by the
-	// endpoint.
-	switch {
-	case resp.StatusCode >= 200 && resp.StatusCode < 400:
+	resp, err := hs.client.Do(req)
+	if err != nil {
 		for _, listener := range hs.listeners {
-			listener.success(resp.StatusCode, events...)
+			listener.err(err, events...)
 		}
 
-		// TODO(stevvooe): This is a little accepting: we may want to support
-		// unsupported media type responses with retries using the correct
-		// media type. There may also be cases that will never work.
+		return 0, fmt.Errorf("%v: error posting: %v", hs, err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
 
-		return nil
-	default:
-		for _, listener := range hs.listeners {
-			listener.failure(resp.StatusCode, events...)
-		}
-		return fmt.Errorf("%v: response status %v unaccepted", hs, resp.Status)
+// encode returns the body and headers of the request delivering the events in
+// the envelope of the sink. CloudEvents envelopes take a single event.
+func (hs *httpSink) encode(events []Event) ([]byte, http.Header, error) {
+	if hs.envelope != EnvelopeRegistry {
+		return encodeCloudEvent(hs.envelope, events[0])
 	}
+
+	p, err := json.MarshalIndent(Envelope{Events: events}, "", "   ")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := make(http.Header)
+	header.Set("Content-Type", hs.mediaType)
+	return p, header, nil
 }
 
 // Close the endpoint
@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/docker/distribution"
 	"github.com/docker/distribution/manifest"
 )
 
@@ -60,7 +61,7 @@ func TestHTTPSink(t *testing.T) {
 	}))
 
 	metrics := newSafeMetrics()
-	sink := newHTTPSink(server.URL, 0, nil,
+	sink := newHTTPSink(server.URL, EnvelopeRegistry, EventsMediaType, 0, nil, nil, nil,
 		&endpointMetricsHTTPStatusListener{safeMetrics: metrics})
 
 	var expectedMetrics EndpointMetrics
@@ -70,6 +71,7 @@ func TestHTTPSink(t *testing.T) {
 		events     []Event // events to send
 		url        string
 		failure    bool // true if there should be a failure.
+		permanent  bool // true if the failure should not be retried.
 		statusCode int  // if not set, no status code should be incremented.
 	}{
 		{
@@ -81,8 +83,8 @@ func TestHTTPSink(t *testing.T) {
 			statusCode: http.StatusOK,
 			events: []Event{
 				createTestEvent("push", "library/test", manifest.ManifestMediaType),
-				createTestEvent("push", "library/test", layerMediaType),
-				createTestEvent("push", "library/test", layerMediaType),
+				createTestEvent("push", "library/test", LayerMediaType),
+				createTestEvent("push", "library/test", LayerMediaType),
 			},
 		},
 		{
@@ -91,6 +93,19 @@ func TestHTTPSink(t *testing.T) {
 		{
 			statusCode: http.StatusBadRequest,
 			failure:    true,
+			permanent:  true,
+		},
+		{
+			statusCode: http.StatusRequestTimeout,
+			failure:    true,
+		},
+		{
+			statusCode: http.StatusTooManyRequests,
+			failure:    true,
+		},
+		{
+			statusCode: http.StatusInternalServerError,
+			failure:    true,
 		},
 		{
 			// Case where connection never goes through.
@@ -129,6 +144,10 @@ func TestHTTPSink(t *testing.T) {
 			if err == nil {
 				t.Fatalf("the endpoint should have rejected the request")
 			}
+
+			if isPermanent(err) != tc.permanent {
+				t.Fatalf("unexpected permanence of error %v: %v != %v", err, isPermanent(err), tc.permanent)
+			}
 		}
 
 		if !reflect.DeepEqual(metrics.EndpointMetrics, expectedMetrics) {
@@ -155,3 +174,52 @@ func createTestEvent(action, repo, typ string) Event {
 
 	return *event
 }
+
+// TestHTTPSinkMediaTypeFallback ensures that the sink falls back to the first
+// version of the envelope for endpoints rejecting the current one.
+func TestHTTPSinkMediaTypeFallback(t *testing.T) {
+	var mediaTypes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		mediaType := r.Header.Get("Content-Type")
+		mediaTypes = append(mediaTypes, mediaType)
+		if mediaType != EventsMediaTypeV1 {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var envelope Envelope
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if len(envelope.Events) != 1 || envelope.Events[0].Target.References != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}))
+	defer server.Close()
+
+	sink := newHTTPSink(server.URL, EnvelopeRegistry, EventsMediaType, 0, nil, nil, nil)
+
+	event := createTestEvent("push", "library/test", manifest.ManifestMediaType)
+	event.Target.References = []distribution.Descriptor{{Digest: "sha256:0123456789abcdef0", Size: 1}}
+
+	for i := 0; i < 2; i++ {
+		if err := sink.Write(event); err != nil {
+			t.Fatalf("unexpected error writing event: %v", err)
+		}
+	}
+
+	// The first version has no tag events, which are not sent at all.
+	if err := sink.Write(createTestEvent(EventActionTag, "library/test", manifest.ManifestMediaType)); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+
+	expected := []string{EventsMediaType, EventsMediaTypeV1, EventsMediaTypeV1}
+	if !reflect.DeepEqual(mediaTypes, expected) {
+		t.Fatalf("unexpected media types: %v != %v", mediaTypes, expected)
+	}
+}
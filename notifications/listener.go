@@ -1,21 +1,72 @@
 package notifications
 
 import (
+	"time"
+
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
-	"github.com/docker/distribution/manifest"
 )
 
 // ManifestListener describes a set of methods for listening to events related to manifests.
 type ManifestListener interface {
-	ManifestPushed(repo distribution.Repository, sm *manifest.SignedManifest) error
-	ManifestPulled(repo distribution.Repository, sm *manifest.SignedManifest) error
+	// ManifestPushed is called after the manifest has been pushed. The tag
+	// is empty if the manifest was pushed without one.
+	ManifestPushed(repo distribution.Repository, m distribution.Manifest, tag string) error
+
+	// ManifestPulled is called after the manifest has been fetched. The tag
+	// is empty if the manifest was fetched by digest.
+	ManifestPulled(repo distribution.Repository, m distribution.Manifest, tag string) error
 
 	// TODO(stevvooe): Please note that delete support is still a little shaky
 	// and we'll need to propagate these in the future.
 
-	ManifestDeleted(repo distribution.Repository, sm *manifest.SignedManifest) error
+	ManifestDeleted(repo distribution.Repository, m distribution.Manifest) error
+
+	// ManifestUnverified is called when a manifest without a signature
+	// trusted by the repository's trust policy has been accepted, which
+	// only happens if the policy is not enforced.
+	ManifestUnverified(repo distribution.Repository, m distribution.Manifest, verification distribution.SignatureVerification) error
+}
+
+// TagListener describes a listener that can respond to tags being created,
+// moved or removed independently of manifest pushes.
+type TagListener interface {
+	// ManifestTagged is called after tag has been pointed at the manifest
+	// described by desc.
+	ManifestTagged(repo distribution.Repository, tag string, desc distribution.Descriptor) error
+
+	// ManifestUntagged is called after tag, which referenced the manifest
+	// described by desc, has been removed.
+	ManifestUntagged(repo distribution.Repository, tag string, desc distribution.Descriptor) error
+
+	// TagMoved is called after tag, which referenced the manifest described
+	// by from, has been pointed at the manifest described by to, either
+	// directly or by a manifest push.
+	TagMoved(repo distribution.Repository, tag string, from, to distribution.Descriptor) error
+}
+
+// UploadListener describes a listener that can respond to the lifecycle of
+// layer uploads.
+type UploadListener interface {
+	// UploadStarted is called after a layer upload has been started.
+	UploadStarted(repo distribution.Repository, upload distribution.LayerUpload) error
+
+	// UploadCancelled is called after a layer upload has been cancelled.
+	UploadCancelled(repo distribution.Repository, upload distribution.LayerUpload) error
+
+	// UploadExpired is called after the upload uuid of the named repository,
+	// left unfinished since startedAt, has been removed by the upload
+	// purger.
+	UploadExpired(repo, uuid string, startedAt time.Time) error
+}
+
+// NamespaceListener describes a listener that can respond to repositories
+// being created.
+type NamespaceListener interface {
+	// RepositoryCreated is called after the first tagged manifest has been
+	// pushed to a repository without tags.
+	RepositoryCreated(repo distribution.Repository) error
 }
 
 // LayerListener describes a listener that can respond to layer related events.
@@ -32,7 +83,10 @@ type LayerListener interface {
 // Listener combines all repository events into a single interface.
 type Listener interface {
 	ManifestListener
+	TagListener
 	LayerListener
+	UploadListener
+	NamespaceListener
 }
 
 type repositoryListener struct {
@@ -55,6 +109,13 @@ func (rl *repositoryListener) Manifests() distribution.ManifestService {
 	}
 }
 
+func (rl *repositoryListener) Tags() distribution.TagService {
+	return &tagServiceListener{
+		TagService: rl.Repository.Tags(),
+		parent:     rl,
+	}
+}
+
 func (rl *repositoryListener) Layers() distribution.LayerService {
 	return &layerServiceListener{
 		LayerService: rl.Repository.Layers(),
@@ -67,38 +128,158 @@ type manifestServiceListener struct {
 	parent *repositoryListener
 }
 
-func (msl *manifestServiceListener) Get(dgst digest.Digest) (*manifest.SignedManifest, error) {
-	sm, err := msl.ManifestService.Get(dgst)
+func (msl *manifestServiceListener) Get(dgst digest.Digest) (distribution.Manifest, error) {
+	m, err := msl.ManifestService.Get(dgst)
 	if err == nil {
-		if err := msl.parent.listener.ManifestPulled(msl.parent.Repository, sm); err != nil {
-			logrus.Errorf("error dispatching manifest pull to listener: %v", err)
-		}
+		msl.manifestPulled(m, "")
 	}
 
-	return sm, err
+	return m, err
 }
 
-func (msl *manifestServiceListener) Put(sm *manifest.SignedManifest) error {
-	err := msl.ManifestService.Put(sm)
+func (msl *manifestServiceListener) Put(m distribution.Manifest, tag string) error {
+	// Record the manifest the tag referenced before the push, to tell created
+	// tags from moved ones. Only a new tag can create the repository, which
+	// is the case if it has never been tagged. Concurrent pushes may race
+	// these checks, so tag and create events are best effort.
+	var (
+		previous distribution.Descriptor
+		created  bool
+	)
+	if tag != "" {
+		var err error
+		if previous, err = msl.parent.Repository.Tags().Get(tag); err != nil {
+			any, err := msl.parent.Repository.Tags().Any()
+			created = err == nil && !any
+		}
+	}
+
+	err := msl.ManifestService.Put(m, tag)
 
 	if err == nil {
-		if err := msl.parent.listener.ManifestPushed(msl.parent.Repository, sm); err != nil {
+		if err := msl.parent.listener.ManifestPushed(msl.parent.Repository, m, tag); err != nil {
 			logrus.Errorf("error dispatching manifest push to listener: %v", err)
 		}
+
+		if desc, err := manifestDescriptor(m); err != nil {
+			logrus.Errorf("error resolving pushed manifest for listener: %v", err)
+		} else {
+			msl.checkTrust(m, desc)
+
+			if tag != "" {
+				msl.parent.tagged(tag, previous, desc, false)
+			}
+		}
+
+		if created {
+			if err := msl.parent.listener.RepositoryCreated(msl.parent.Repository); err != nil {
+				logrus.Errorf("error dispatching repository creation to listener: %v", err)
+			}
+		}
+	}
+
+	return err
+}
+
+// manifestDescriptor returns the descriptor of the manifest, unmarshaling its
+// payload through the registered schema to find the canonical digest.
+func manifestDescriptor(m distribution.Manifest) (distribution.Descriptor, error) {
+	mediaType, p, err := m.Payload()
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	_, desc, err := distribution.UnmarshalManifest(mediaType, p)
+	return desc, err
+}
+
+// checkTrust dispatches an unverified event if the pushed manifest, described
+// by desc, lacks a signature trusted by the repository.
+func (msl *manifestServiceListener) checkTrust(m distribution.Manifest, desc distribution.Descriptor) {
+	verification, err := msl.parent.Repository.Signatures().Verify(desc.Digest)
+	if err != nil {
+		logrus.Errorf("error verifying manifest signatures: %v", err)
+		return
+	}
+
+	if verification.Trusted() {
+		return
+	}
+
+	if err := msl.parent.listener.ManifestUnverified(msl.parent.Repository, m, verification); err != nil {
+		logrus.Errorf("error dispatching unverified manifest to listener: %v", err)
+	}
+}
+
+func (msl *manifestServiceListener) GetByTag(tag string) (distribution.Manifest, error) {
+	m, err := msl.ManifestService.GetByTag(tag)
+	if err == nil {
+		msl.manifestPulled(m, tag)
+	}
+
+	return m, err
+}
+
+func (msl *manifestServiceListener) manifestPulled(m distribution.Manifest, tag string) {
+	if err := msl.parent.listener.ManifestPulled(msl.parent.Repository, m, tag); err != nil {
+		logrus.Errorf("error dispatching manifest pull to listener: %v", err)
+	}
+}
+
+// tagged dispatches the change of tag to the manifest described by desc from
+// the one described by previous, if any. A tag pointed again at the same
+// manifest is only dispatched if always is set.
+func (rl *repositoryListener) tagged(tag string, previous, desc distribution.Descriptor, always bool) {
+	switch {
+	case previous.Digest != "" && previous.Digest != desc.Digest:
+		if err := rl.listener.TagMoved(rl.Repository, tag, previous, desc); err != nil {
+			logrus.Errorf("error dispatching tag move to listener: %v", err)
+		}
+	case previous.Digest == "" || always:
+		if err := rl.listener.ManifestTagged(rl.Repository, tag, desc); err != nil {
+			logrus.Errorf("error dispatching tag to listener: %v", err)
+		}
+	}
+}
+
+type tagServiceListener struct {
+	distribution.TagService
+	parent *repositoryListener
+}
+
+func (tsl *tagServiceListener) Tag(tag string, desc distribution.Descriptor) error {
+	// Resolve the manifest the tag is moved from, if any.
+	previous, _ := tsl.TagService.Get(tag)
+
+	err := tsl.TagService.Tag(tag, desc)
+	if err == nil {
+		// Callers may only provide the digest, so resolve the complete
+		// descriptor of the tagged manifest for the event.
+		desc, err := tsl.TagService.Get(tag)
+		if err != nil {
+			logrus.Errorf("error resolving tag %q for listener: %v", tag, err)
+			return nil
+		}
+
+		tsl.parent.tagged(tag, previous, desc, true)
 	}
 
 	return err
 }
 
-func (msl *manifestServiceListener) GetByTag(tag string) (*manifest.SignedManifest, error) {
-	sm, err := msl.ManifestService.GetByTag(tag)
+func (tsl *tagServiceListener) Untag(tag string) error {
+	// Resolve the manifest before the tag goes away. Any error here will be
+	// reported by the untag itself.
+	desc, _ := tsl.TagService.Get(tag)
+
+	err := tsl.TagService.Untag(tag)
 	if err == nil {
-		if err := msl.parent.listener.ManifestPulled(msl.parent.Repository, sm); err != nil {
-			logrus.Errorf("error dispatching manifest pull to listener: %v", err)
+		if err := tsl.parent.listener.ManifestUntagged(tsl.parent.Repository, tag, desc); err != nil {
+			logrus.Errorf("error dispatching untag to listener: %v", err)
 		}
 	}
 
-	return sm, err
+	return err
 }
 
 type layerServiceListener struct {
@@ -119,6 +300,12 @@ func (lsl *layerServiceListener) Fetch(dgst digest.Digest) (distribution.Layer,
 
 func (lsl *layerServiceListener) Upload() (distribution.LayerUpload, error) {
 	lu, err := lsl.LayerService.Upload()
+	if err == nil {
+		if err := lsl.parent.listener.UploadStarted(lsl.parent.Repository, lu); err != nil {
+			logrus.Errorf("error dispatching upload start to listener: %v", err)
+		}
+	}
+
 	return lsl.decorateUpload(lu), err
 }
 
@@ -149,3 +336,14 @@ func (lul *layerUploadListener) Finish(dgst digest.Digest) (distribution.Layer,
 
 	return layer, err
 }
+
+func (lul *layerUploadListener) Cancel() error {
+	err := lul.LayerUpload.Cancel()
+	if err == nil {
+		if err := lul.parent.parent.listener.UploadCancelled(lul.parent.parent.Repository, lul.LayerUpload); err != nil {
+			logrus.Errorf("error dispatching upload cancellation to listener: %v", err)
+		}
+	}
+
+	return err
+}
@@ -1,9 +1,11 @@
 package notifications
 
 import (
+	"fmt"
 	"io"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
@@ -35,8 +37,12 @@ func TestListener(t *testing.T) {
 		"manifest:push": 1,
 		"manifest:pull": 2,
 		// "manifest:delete": 0, // deletes not supported for now
-		"layer:push": 2,
-		"layer:pull": 2,
+		"manifest:tag":      2,
+		"manifest:untag":    1,
+		"layer:push":        2,
+		"layer:pull":        2,
+		"upload:start":      2,
+		"repository:create": 1,
 		// "layer:delete":    0, // deletes not supported for now
 	}
 
@@ -46,26 +52,130 @@ func TestListener(t *testing.T) {
 
 }
 
+func TestListenerUnverified(t *testing.T) {
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	// The policy trusts a key the exercised manifest is not signed with.
+	registry := storage.NewRegistryWithDriver(inmemory.New(), cache.NewInMemoryLayerInfoCache(), storage.VerifyTrust(storage.TrustPolicy{
+		Rules: []storage.TrustRule{
+			{Keys: []libtrust.PublicKey{pk.PublicKey()}},
+		},
+	}))
+	tl := &testListener{
+		ops: make(map[string]int),
+	}
+	repository, err := registry.Repository(context.Background(), "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	repository = Listen(repository, tl)
+
+	checkExerciseRepository(t, repository)
+
+	if tl.ops["manifest:push"] != 1 || tl.ops["manifest:unverified"] != 1 {
+		t.Fatalf("expected unverified manifest push: %v", tl.ops)
+	}
+}
+
+// TestListenerLifecycle ensures that moved tags, cancelled uploads and
+// repository creations are dispatched.
+func TestListenerLifecycle(t *testing.T) {
+	registry := storage.NewRegistryWithDriver(inmemory.New(), cache.NewInMemoryLayerInfoCache())
+	tl := &testListener{
+		ops: make(map[string]int),
+	}
+	repository, err := registry.Repository(context.Background(), "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	repository = Listen(repository, tl)
+
+	// The second run pushes a new manifest with the same tag.
+	checkExerciseRepository(t, repository)
+	checkExerciseRepository(t, repository)
+
+	upload, err := repository.Layers().Upload()
+	if err != nil {
+		t.Fatalf("error creating layer upload: %v", err)
+	}
+
+	if err := upload.Cancel(); err != nil {
+		t.Fatalf("unexpected error cancelling upload: %v", err)
+	}
+
+	if tl.ops["tag:move"] != 1 || tl.ops["repository:create"] != 1 || tl.ops["manifest:tag"] != 3 ||
+		tl.ops["upload:start"] != 5 || tl.ops["upload:cancel"] != 1 {
+		t.Fatalf("unexpected lifecycle counts: %v", tl.ops)
+	}
+}
+
 type testListener struct {
 	ops map[string]int
 }
 
-func (tl *testListener) ManifestPushed(repo distribution.Repository, sm *manifest.SignedManifest) error {
+func (tl *testListener) ManifestPushed(repo distribution.Repository, m distribution.Manifest, tag string) error {
 	tl.ops["manifest:push"]++
 
 	return nil
 }
 
-func (tl *testListener) ManifestPulled(repo distribution.Repository, sm *manifest.SignedManifest) error {
+func (tl *testListener) ManifestPulled(repo distribution.Repository, m distribution.Manifest, tag string) error {
 	tl.ops["manifest:pull"]++
 	return nil
 }
 
-func (tl *testListener) ManifestDeleted(repo distribution.Repository, sm *manifest.SignedManifest) error {
+func (tl *testListener) ManifestDeleted(repo distribution.Repository, m distribution.Manifest) error {
 	tl.ops["manifest:delete"]++
 	return nil
 }
 
+func (tl *testListener) ManifestUnverified(repo distribution.Repository, m distribution.Manifest, verification distribution.SignatureVerification) error {
+	tl.ops["manifest:unverified"]++
+	return nil
+}
+
+func (tl *testListener) ManifestTagged(repo distribution.Repository, tag string, desc distribution.Descriptor) error {
+	tl.ops["manifest:tag"]++
+	return nil
+}
+
+func (tl *testListener) ManifestUntagged(repo distribution.Repository, tag string, desc distribution.Descriptor) error {
+	tl.ops["manifest:untag"]++
+	return nil
+}
+
+func (tl *testListener) TagMoved(repo distribution.Repository, tag string, from, to distribution.Descriptor) error {
+	if from.Digest == to.Digest {
+		return fmt.Errorf("tag %s moved to the same manifest %s", tag, to.Digest)
+	}
+
+	tl.ops["tag:move"]++
+	return nil
+}
+
+func (tl *testListener) UploadStarted(repo distribution.Repository, upload distribution.LayerUpload) error {
+	tl.ops["upload:start"]++
+	return nil
+}
+
+func (tl *testListener) UploadCancelled(repo distribution.Repository, upload distribution.LayerUpload) error {
+	tl.ops["upload:cancel"]++
+	return nil
+}
+
+func (tl *testListener) UploadExpired(repo, uuid string, startedAt time.Time) error {
+	tl.ops["upload:expire"]++
+	return nil
+}
+
+func (tl *testListener) RepositoryCreated(repo distribution.Repository) error {
+	tl.ops["repository:create"]++
+	return nil
+}
+
 func (tl *testListener) LayerPushed(repo distribution.Repository, layer distribution.Layer) error {
 	tl.ops["layer:push"]++
 	return nil
@@ -144,11 +254,11 @@ func checkExerciseRepository(t *testing.T, repository distribution.Repository) {
 
 	manifests := repository.Manifests()
 
-	if err := manifests.Put(sm); err != nil {
+	if err := manifests.Put(sm, sm.Tag); err != nil {
 		t.Fatalf("unexpected error putting the manifest: %v", err)
 	}
 
-	p, err := sm.Payload()
+	p, err := sm.Canonical()
 	if err != nil {
 		t.Fatalf("unexpected error getting manifest payload: %v", err)
 	}
@@ -163,7 +273,12 @@ func checkExerciseRepository(t *testing.T, repository distribution.Repository) {
 		t.Fatalf("unexpected error fetching manifest: %v", err)
 	}
 
-	if fetchedByManifest.Tag != sm.Tag {
+	fetchedSignedByManifest, ok := fetchedByManifest.(*manifest.SignedManifest)
+	if !ok {
+		t.Fatalf("unexpected manifest type: %T", fetchedByManifest)
+	}
+
+	if fetchedSignedByManifest.Tag != sm.Tag {
 		t.Fatalf("retrieved unexpected manifest: %v", err)
 	}
 
@@ -172,7 +287,21 @@ func checkExerciseRepository(t *testing.T, repository distribution.Repository) {
 		t.Fatalf("unexpected error fetching manifest: %v", err)
 	}
 
-	if fetched.Tag != fetchedByManifest.Tag {
+	fetchedSigned, ok := fetched.(*manifest.SignedManifest)
+	if !ok {
+		t.Fatalf("unexpected manifest type: %T", fetched)
+	}
+
+	if fetchedSigned.Tag != fetchedSignedByManifest.Tag {
 		t.Fatalf("retrieved unexpected manifest: %v", err)
 	}
+
+	tags := repository.Tags()
+	if err := tags.Tag("another", distribution.Descriptor{Digest: dgst}); err != nil {
+		t.Fatalf("unexpected error tagging manifest: %v", err)
+	}
+
+	if err := tags.Untag("another"); err != nil {
+		t.Fatalf("unexpected error untagging manifest: %v", err)
+	}
 }
@@ -11,12 +11,16 @@ import (
 // number of events. The goal of this to export it via expvar but we may find
 // some other future solution to be better.
 type EndpointMetrics struct {
-	Pending   int            // events pending in queue
-	Events    int            // total events incoming
-	Successes int            // total events written successfully
-	Failures  int            // total events failed
-	Errors    int            // total events errored
-	Statuses  map[string]int // status code histogram, per call event
+	Pending     int            // events pending in queue
+	Events      int            // total events incoming
+	Successes   int            // total events written successfully
+	Failures    int            // total events failed
+	Errors      int            // total events errored
+	Statuses    map[string]int // status code histogram, per call event
+	Dropped     int            // total events dropped from a full disk queue
+	Filtered    int            // total events not selected by the endpoint filter
+	DiskPending int            // events pending in the disk queue
+	DiskBytes   int64          // bytes of events pending in the disk queue
 }
 
 // safeMetrics guards the metrics implementation with a lock and provides a
@@ -48,6 +52,30 @@ func (sm *safeMetrics) eventQueueListener() eventQueueListener {
 	}
 }
 
+// diskQueueListener returns a listener that maintains queue related counters,
+// including the depth of the disk queue.
+func (sm *safeMetrics) diskQueueListener() diskQueueListener {
+	return &endpointMetricsEventQueueListener{
+		safeMetrics: sm,
+	}
+}
+
+// filterListener returns a listener counting the events dropped by the
+// endpoint filter.
+func (sm *safeMetrics) filterListener() filterListener {
+	return &endpointMetricsFilterListener{
+		safeMetrics: sm,
+	}
+}
+
+// deliveryListener returns the listener for sinks without status codes that
+// updates the success and error counters.
+func (sm *safeMetrics) deliveryListener() deliveryListener {
+	return &endpointMetricsDeliveryListener{
+		safeMetrics: sm,
+	}
+}
+
 // endpointMetricsHTTPStatusListener increments counters related to http sinks
 // for the relevent events.
 type endpointMetricsHTTPStatusListener struct {
@@ -76,6 +104,24 @@ func (emsl *endpointMetricsHTTPStatusListener) err(err error, events ...Event) {
 	emsl.Errors += len(events)
 }
 
+// endpointMetricsDeliveryListener increments the success and error counters
+// of sinks without status codes.
+type endpointMetricsDeliveryListener struct {
+	*safeMetrics
+}
+
+func (emdl *endpointMetricsDeliveryListener) delivered(events ...Event) {
+	emdl.safeMetrics.Lock()
+	defer emdl.safeMetrics.Unlock()
+	emdl.Successes += len(events)
+}
+
+func (emdl *endpointMetricsDeliveryListener) err(err error, events ...Event) {
+	emdl.safeMetrics.Lock()
+	defer emdl.safeMetrics.Unlock()
+	emdl.Errors += len(events)
+}
+
 // endpointMetricsEventQueueListener maintains the incoming events counter and
 // the queues pending count.
 type endpointMetricsEventQueueListener struct {
@@ -95,6 +141,31 @@ func (eqc *endpointMetricsEventQueueListener) egress(events ...Event) {
 	eqc.Pending -= len(events)
 }
 
+func (eqc *endpointMetricsEventQueueListener) dropped(events int) {
+	eqc.Lock()
+	defer eqc.Unlock()
+	eqc.Pending -= events
+	eqc.Dropped += events
+}
+
+func (eqc *endpointMetricsEventQueueListener) depth(events int, bytes int64) {
+	eqc.Lock()
+	defer eqc.Unlock()
+	eqc.DiskPending = events
+	eqc.DiskBytes = bytes
+}
+
+// endpointMetricsFilterListener maintains the filtered events counter.
+type endpointMetricsFilterListener struct {
+	*safeMetrics
+}
+
+func (efl *endpointMetricsFilterListener) filtered(events ...Event) {
+	efl.Lock()
+	defer efl.Unlock()
+	efl.Filtered += len(events)
+}
+
 // endpoints is global registry of endpoints used to report metrics to expvar
 var endpoints struct {
 	registered []*Endpoint
@@ -109,6 +180,19 @@ func register(e *Endpoint) {
 	endpoints.registered = append(endpoints.registered, e)
 }
 
+// unregister removes the endpoint from expvar and the debug handler.
+func unregister(e *Endpoint) {
+	endpoints.mu.Lock()
+	defer endpoints.mu.Unlock()
+
+	for i, registered := range endpoints.registered {
+		if registered == e {
+			endpoints.registered = append(endpoints.registered[:i], endpoints.registered[i+1:]...)
+			return
+		}
+	}
+}
+
 func init() {
 	// NOTE(stevvooe): Setup registry metrics structure to report to expvar.
 	// Ideally, we do more metrics through logging but we need some nice
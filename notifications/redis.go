@@ -0,0 +1,143 @@
+package notifications
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// redisStreamSink appends events to a redis stream, one entry per event. It
+// makes a single attempt per write, using a connection of the redis pool of
+// the registry. Reliability should be provided by the caller.
+type redisStreamSink struct {
+	pool      *redis.Pool
+	stream    string
+	maxLen    int64
+	mediaType string
+	listeners []deliveryListener
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// newRedisStreamSink returns a sink appending events to stream, encoded as in
+// envelopes of the media type. If maxLen is positive, the stream is trimmed to
+// approximately maxLen entries.
+func newRedisStreamSink(pool *redis.Pool, stream string, maxLen int64, mediaType string, listeners ...deliveryListener) *redisStreamSink {
+	return &redisStreamSink{
+		pool:      pool,
+		stream:    stream,
+		maxLen:    maxLen,
+		mediaType: mediaType,
+		listeners: listeners,
+	}
+}
+
+// Write appends the events to the stream with a pipeline of XADD commands.
+// An error is returned if any event is not appended, in which case the events
+// preceding it may have been appended already.
+func (rs *redisStreamSink) Write(events ...Event) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.closed {
+		return ErrSinkClosed
+	}
+
+	if err := rs.write(events...); err != nil {
+		for _, listener := range rs.listeners {
+			listener.err(err, events...)
+		}
+		return fmt.Errorf("%v: error appending events: %v", rs, err)
+	}
+
+	for _, listener := range rs.listeners {
+		listener.delivered(events...)
+	}
+
+	return nil
+}
+
+func (rs *redisStreamSink) write(events ...Event) error {
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	events = eventsForMediaType(rs.mediaType, events)
+	for _, event := range events {
+		p, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		args := redis.Args{rs.stream}
+		if rs.maxLen > 0 {
+			args = args.Add("MAXLEN", "~", rs.maxLen)
+		}
+		args = args.Add("*",
+			"id", event.ID,
+			"action", event.Action,
+			"repository", event.Target.Repository,
+			"event", p)
+
+		if err := conn.Send("XADD", args...); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for range events {
+		if _, err := conn.Receive(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Close the sink. The connections belong to the pool and are left open.
+func (rs *redisStreamSink) Close() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.closed {
+		return fmt.Errorf("redisStreamSink: already closed")
+	}
+
+	rs.closed = true
+	return nil
+}
+
+func (rs *redisStreamSink) String() string {
+	return fmt.Sprintf("redisStreamSink{%s}", rs.stream)
+}
+
+func init() {
+	RegisterSink("redis", SinkFactoryFunc(func(config SinkConfig) (Sink, error) {
+		if config.Redis == nil {
+			return nil, errors.New("redis endpoints require the redis configuration of the registry")
+		}
+
+		if config.Envelope != EnvelopeRegistry {
+			return nil, fmt.Errorf("redis endpoints do not support the %s envelope", config.Envelope)
+		}
+
+		stream, err := stringParameter(config.Parameters, "stream", "registry:events")
+		if err != nil {
+			return nil, err
+		}
+
+		maxLen, err := intParameter(config.Parameters, "maxlen", 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return newRedisStreamSink(config.Redis, stream, maxLen, config.MediaType, config.deliveryListeners()...), nil
+	}))
+}
@@ -0,0 +1,197 @@
+package notifications
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// TestRedisStreamSink appends events to an in-process server speaking the
+// subset of the redis protocol used by the sink.
+func TestRedisStreamSink(t *testing.T) {
+	server := newFakeRedisServer(t)
+	defer server.Close()
+
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", server.Addr().String())
+		},
+	}
+	defer pool.Close()
+
+	if _, err := CreateSink("redis", SinkConfig{}); err == nil {
+		t.Fatalf("expected error creating sink without redis")
+	}
+
+	sink, err := CreateSink("redis", SinkConfig{
+		Redis: pool,
+		Parameters: map[string]interface{}{
+			"stream": "registry:test",
+			"maxlen": 1000,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating sink: %v", err)
+	}
+
+	events := []Event{
+		createTestEvent("push", "library/test", "manifest"),
+		createTestEvent("push", "library/test", "blob"),
+		createTestEvent("pull", "library/other", "blob"),
+	}
+
+	if err := sink.Write(events...); err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+
+	server.mu.Lock()
+	entries := server.streams["registry:test"]
+	server.mu.Unlock()
+
+	if len(entries) != len(events) {
+		t.Fatalf("unexpected number of entries: %d != %d", len(entries), len(events))
+	}
+
+	for i, entry := range entries {
+		expected := []string{"MAXLEN", "~", "1000", "*", "id", events[i].ID, "action", events[i].Action, "repository", events[i].Target.Repository}
+		if len(entry) != len(expected)+2 || strings.Join(entry[:len(expected)], " ") != strings.Join(expected, " ") {
+			t.Fatalf("unexpected entry: %q", entry)
+		}
+
+		var event Event
+		if err := json.Unmarshal([]byte(entry[len(entry)-1]), &event); err != nil {
+			t.Fatalf("unexpected error decoding event: %v", err)
+		}
+
+		if event.ID != events[i].ID || event.Target.Digest != events[i].Target.Digest {
+			t.Fatalf("unexpected event: %#v != %#v", event, events[i])
+		}
+	}
+
+	checkClose(t, sink)
+
+	broken, err := CreateSink("redis", SinkConfig{
+		Redis:      pool,
+		Parameters: map[string]interface{}{"stream": "broken"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating sink: %v", err)
+	}
+
+	if err := broken.Write(events...); err == nil {
+		t.Fatalf("expected error writing to broken stream")
+	}
+}
+
+// fakeRedisServer appends the arguments of XADD commands to streams, failing
+// commands on the stream named broken.
+type fakeRedisServer struct {
+	net.Listener
+	t *testing.T
+
+	mu      sync.Mutex
+	streams map[string][][]string
+}
+
+func newFakeRedisServer(t *testing.T) *fakeRedisServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+
+	server := &fakeRedisServer{
+		Listener: l,
+		t:        t,
+		streams:  make(map[string][][]string),
+	}
+
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(nc)
+		}
+	}()
+
+	return server
+}
+
+func (s *fakeRedisServer) serve(nc net.Conn) {
+	defer nc.Close()
+	br := bufio.NewReader(nc)
+
+	for {
+		args, err := readRedisCommand(br)
+		if err != nil {
+			if err != io.EOF {
+				s.t.Errorf("unexpected error reading command: %v", err)
+			}
+			return
+		}
+
+		var reply string
+		switch {
+		case strings.ToUpper(args[0]) == "XADD" && len(args) > 2 && args[1] == "broken":
+			reply = "-ERR broken stream\r\n"
+		case strings.ToUpper(args[0]) == "XADD" && len(args) > 2:
+			s.mu.Lock()
+			s.streams[args[1]] = append(s.streams[args[1]], args[2:])
+			id := fmt.Sprintf("%d-0", len(s.streams[args[1]]))
+			s.mu.Unlock()
+			reply = fmt.Sprintf("$%d\r\n%s\r\n", len(id), id)
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+
+		if _, err := io.WriteString(nc, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readRedisCommand reads a command sent as an array of bulk strings.
+func readRedisCommand(br *bufio.Reader) ([]string, error) {
+	n, err := readRedisLength(br, '*')
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		size, err := readRedisLength(br, '$')
+		if err != nil {
+			return nil, err
+		}
+
+		p := make([]byte, size+2)
+		if _, err := io.ReadFull(br, p); err != nil {
+			return nil, err
+		}
+		args[i] = string(p[:size])
+	}
+
+	return args, nil
+}
+
+func readRedisLength(br *bufio.Reader, prefix byte) (int, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+
+	if len(line) < 3 || line[0] != prefix {
+		return 0, fmt.Errorf("unexpected line: %q", line)
+	}
+
+	return strconv.Atoi(strings.TrimSpace(line[1:]))
+}
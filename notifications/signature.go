@@ -0,0 +1,126 @@
+package notifications
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader is the header of notification requests carrying the
+// signatures of the request body, made with the secrets configured for the
+// endpoint. Its value has the form
+//
+//	t=<unix timestamp>,v1=<hex signature>[,v1=<hex signature>...]
+//
+// where each signature is the HMAC-SHA256 of the timestamp, a period and the
+// request body, keyed by one of the secrets. A signature is included for
+// every secret, so that receivers can rotate secrets without interruption.
+const SignatureHeader = "Docker-Distribution-Signature"
+
+// signatureScheme is the key of signatures in the signature header.
+const signatureScheme = "v1"
+
+var (
+	// ErrSignatureMissing is returned when verifying a request without a
+	// signature.
+	ErrSignatureMissing = errors.New("notification signature missing")
+
+	// ErrSignatureInvalid is returned when no signature of a request
+	// matches the body with any of the secrets.
+	ErrSignatureInvalid = errors.New("notification signature invalid")
+
+	// ErrSignatureExpired is returned when the timestamp of a request is
+	// outside of the tolerated window.
+	ErrSignatureExpired = errors.New("notification signature expired")
+)
+
+// signatureHeaderValue returns the value of the signature header for the
+// body sent at timestamp, signed with each of the secrets.
+func signatureHeaderValue(timestamp time.Time, body []byte, secrets []string) string {
+	ts := timestamp.Unix()
+	parts := []string{fmt.Sprintf("t=%d", ts)}
+	for _, secret := range secrets {
+		parts = append(parts, signatureScheme+"="+hex.EncodeToString(computeSignature(ts, body, secret)))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// computeSignature returns the HMAC-SHA256 of the timestamp and the body.
+func computeSignature(timestamp int64, body []byte, secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// VerifySignature checks the value of the signature header of a notification
+// request against its body, returning nil if any of the signatures was made
+// with any of the secrets. Receivers should pass all of their currently
+// active secrets. If tolerance is positive, signatures with a timestamp
+// further than tolerance from the current time are rejected with
+// ErrSignatureExpired, which protects against replayed requests.
+func VerifySignature(header string, body []byte, secrets []string, tolerance time.Duration) error {
+	if header == "" {
+		return ErrSignatureMissing
+	}
+
+	var (
+		timestamp  int64
+		hasTime    bool
+		signatures [][]byte
+	)
+
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return ErrSignatureInvalid
+		}
+
+		switch kv[0] {
+		case "t":
+			ts, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				return ErrSignatureInvalid
+			}
+			timestamp, hasTime = ts, true
+		case signatureScheme:
+			signature, err := hex.DecodeString(kv[1])
+			if err != nil {
+				continue // ignore malformed signatures
+			}
+			signatures = append(signatures, signature)
+		}
+	}
+
+	if !hasTime {
+		return ErrSignatureInvalid
+	}
+
+	if len(signatures) == 0 {
+		return ErrSignatureMissing
+	}
+
+	if tolerance > 0 {
+		age := time.Since(time.Unix(timestamp, 0))
+		if age > tolerance || age < -tolerance {
+			return ErrSignatureExpired
+		}
+	}
+
+	for _, secret := range secrets {
+		expected := computeSignature(timestamp, body, secret)
+		for _, signature := range signatures {
+			if hmac.Equal(expected, signature) {
+				return nil
+			}
+		}
+	}
+
+	return ErrSignatureInvalid
+}
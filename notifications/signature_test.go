@@ -0,0 +1,95 @@
+package notifications
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPSinkSignature(t *testing.T) {
+	type request struct {
+		header string
+		body   []byte
+	}
+	requests := make(chan request, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unexpected error reading body: %v", err)
+		}
+
+		requests <- request{header: r.Header.Get(SignatureHeader), body: body}
+	}))
+	defer server.Close()
+
+	// During rotation, requests are signed with both the old and new secret.
+	sink := newHTTPSink(server.URL, EnvelopeRegistry, EventsMediaType, 0, nil, nil, []string{"old-secret", "new-secret"})
+	if err := sink.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+
+	req := <-requests
+	for _, secrets := range [][]string{
+		{"old-secret"},
+		{"new-secret"},
+		{"other-secret", "new-secret"},
+	} {
+		if err := VerifySignature(req.header, req.body, secrets, time.Minute); err != nil {
+			t.Fatalf("unexpected error verifying signature with %v: %v", secrets, err)
+		}
+	}
+
+	for _, testcase := range []struct {
+		description string
+		header      string
+		body        []byte
+		expected    error
+	}{
+		{
+			description: "unknown secret",
+			header:      req.header,
+			body:        req.body,
+			expected:    ErrSignatureInvalid,
+		},
+		{
+			description: "tampered body",
+			header:      req.header,
+			body:        append([]byte(" "), req.body...),
+			expected:    ErrSignatureInvalid,
+		},
+		{
+			description: "missing header",
+			body:        req.body,
+			expected:    ErrSignatureMissing,
+		},
+		{
+			description: "missing timestamp",
+			header:      "v1=abcdef",
+			body:        req.body,
+			expected:    ErrSignatureInvalid,
+		},
+		{
+			description: "expired",
+			header:      signatureHeaderValue(time.Now().Add(-time.Hour), req.body, []string{"third-secret"}),
+			body:        req.body,
+			expected:    ErrSignatureExpired,
+		},
+	} {
+		if err := VerifySignature(testcase.header, testcase.body, []string{"third-secret"}, time.Minute); err != testcase.expected {
+			t.Fatalf("%s: unexpected error verifying signature: %v != %v", testcase.description, err, testcase.expected)
+		}
+	}
+
+	// Requests are not signed without secrets.
+	sink = newHTTPSink(server.URL, EnvelopeRegistry, EventsMediaType, 0, nil, nil, nil)
+	if err := sink.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+
+	if req := <-requests; req.header != "" {
+		t.Fatalf("unexpected signature without secrets: %q", req.header)
+	}
+}
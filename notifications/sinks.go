@@ -3,7 +3,9 @@ package notifications
 import (
 	"container/list"
 	"fmt"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -210,76 +212,165 @@ func (eq *eventQueue) next() []Event {
 	return block
 }
 
-// retryingSink retries the write until success or an ErrSinkClosed is
-// returned. Underlying sink must have p > 0 of succeeding or the sink will
-// block. Internally, it is a circuit breaker retries to manage reset.
-// Concurrent calls to a retrying sink are serialized through the sink,
-// meaning that if one is in-flight, another will not proceed.
+// retryingSink retries the write until success, an ErrSinkClosed is returned
+// or the retry policy abandons the events. Every retry waits for a backoff
+// growing exponentially, with jitter, with the consecutive failures. Past the
+// failure threshold, it is also a circuit breaker: new events wait for the
+// backoff as well. Abandoned events are passed to the listeners, typically
+// the dead letters of the endpoint. Concurrent calls to a retrying sink are
+// serialized through the sink, meaning that if one is in-flight, another will
+// not proceed.
 type retryingSink struct {
-	mu     sync.Mutex
-	sink   Sink
-	closed bool
+	mu        sync.Mutex
+	sink      Sink
+	policy    retryPolicy
+	listeners []abandonListener
+	closed    bool
 
 	// circuit breaker heuristics
 	failures struct {
-		threshold int
-		recent    int
-		last      time.Time
-		backoff   time.Duration // time after which we retry after failure.
+		recent int
+		last   time.Time
+		delay  time.Duration // time after which we retry after failure.
 	}
 }
 
+// retryPolicy configures when and for how long a retrying sink retries.
+type retryPolicy struct {
+	// threshold is the number of consecutive failures after which new
+	// events wait for the backoff too.
+	threshold int
+
+	// backoff is the delay before retrying after a failure, doubled on each
+	// consecutive failure up to maxBackoff.
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	// maxAttempts is the number of attempts to write an event and maxAge the
+	// age of events after which they are abandoned. If zero, they are not
+	// limited.
+	maxAttempts int
+	maxAge      time.Duration
+}
+
+// eventAttempts counts the attempts to deliver an event. The copies of an
+// event share it, so that the count follows the event through the sinks.
+type eventAttempts struct {
+	count int32
+
+	// recorder, if set, persists the count after each attempt.
+	recorder attemptRecorder
+}
+
+// attemptRecorder persists the attempts of the events it delivers, so that
+// they survive restarts.
+type attemptRecorder interface {
+	recordAttempts()
+}
+
+// attempted increments the count of attempts of the event, returning the
+// new count.
+func (ea *eventAttempts) attempted() int {
+	return int(atomic.AddInt32(&ea.count, 1))
+}
+
+// value returns the count of attempts of the event.
+func (ea *eventAttempts) value() int {
+	return int(atomic.LoadInt32(&ea.count))
+}
+
 type retryingSinkListener interface {
 	active(events ...Event)
 	retry(events ...Event)
 }
 
-// TODO(stevvooe): We are using circuit break here, which actually doesn't
-// make a whole lot of sense for this use case, since we always retry. Move
-// this to use bounded exponential backoff.
+// abandonListener is called with the events a retrying sink gives up on.
+type abandonListener interface {
+	abandoned(err error, events ...Event)
+}
 
 // newRetryingSink returns a sink that will retry writes to a sink, backing
-// off on failure. Parameters threshold and backoff adjust the behavior of the
-// circuit breaker.
-func newRetryingSink(sink Sink, threshold int, backoff time.Duration) *retryingSink {
-	rs := &retryingSink{
-		sink: sink,
+// off on failure as configured by the policy.
+func newRetryingSink(sink Sink, policy retryPolicy, listeners ...abandonListener) *retryingSink {
+	if policy.maxBackoff < policy.backoff {
+		policy.maxBackoff = policy.backoff
 	}
-	rs.failures.threshold = threshold
-	rs.failures.backoff = backoff
 
-	return rs
+	return &retryingSink{
+		sink:      sink,
+		policy:    policy,
+		listeners: listeners,
+	}
 }
 
 // Write attempts to flush the events to the downstream sink until it succeeds
-// or the sink is closed.
+// or the sink is closed. Events that fail permanently, exceed the maximum
+// number of attempts or the maximum age are abandoned to the listeners,
+// without returning an error. If a block of events fails permanently, its
+// events are retried one at a time, so that only the events rejected are
+// abandoned.
 func (rs *retryingSink) Write(events ...Event) error {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
 
-retry:
-
-	if rs.closed {
-		return ErrSinkClosed
+	// The events may be shared with other sinks, so they are copied before
+	// counting their attempts.
+	events = append([]Event(nil), events...)
+	for i := range events {
+		if events[i].attempts == nil {
+			events[i].attempts = &eventAttempts{}
+		}
 	}
 
-	if !rs.proceed() {
-		logrus.Warnf("%v encountered too many errors, backing off", rs.sink)
-		rs.wait(rs.failures.backoff)
-		goto retry
-	}
+	return rs.retry(events)
+}
 
-	if err := rs.write(events...); err != nil {
-		if err == ErrSinkClosed {
+// retry writes the events until they are written or abandoned. The caller
+// must hold the mutex.
+func (rs *retryingSink) retry(events []Event) error {
+	for {
+		if rs.closed {
+			return ErrSinkClosed
+		}
+
+		if events = rs.expire(events); len(events) == 0 {
+			return nil
+		}
+
+		if !rs.proceed() {
+			logrus.Warnf("%v encountered too many errors, backing off for %v", rs.sink, rs.failures.delay)
+			rs.wait(rs.failures.delay)
+			continue
+		}
+
+		err := rs.write(events...)
+		switch {
+		case err == nil:
+			return nil
+		case err == ErrSinkClosed:
 			// terminal!
 			return err
+		case isPermanent(err) && len(events) > 1:
+			logrus.Errorf("retryingsink: error writing events: %v, retrying %d events one at a time", err, len(events))
+			for _, event := range events {
+				if err := rs.retry([]Event{event}); err != nil {
+					return err
+				}
+			}
+			return nil
+		case isPermanent(err):
+			logrus.Errorf("retryingsink: error writing events: %v, not retrying", err)
+			rs.abandon(err, events...)
+			return nil
 		}
 
-		logrus.Errorf("retryingsink: error writing events: %v, retrying", err)
-		goto retry
-	}
+		if events = rs.exhausted(err, events); len(events) == 0 {
+			return nil
+		}
 
-	return nil
+		logrus.Errorf("retryingsink: error writing events: %v, retrying in %v", err, rs.failures.delay)
+		rs.wait(rs.failures.delay)
+	}
 }
 
 // Close closes the sink and the underlying sink.
@@ -296,10 +387,25 @@ func (rs *retryingSink) Close() error {
 }
 
 // write provides a helper that dispatches failure and success properly. Used
-// by write as the single-flight write call.
+// by write as the single-flight write call. Permanent errors are not failures
+// of the endpoint, which did respond.
 func (rs *retryingSink) write(events ...Event) error {
+	var recorders []attemptRecorder
+	for _, event := range events {
+		event.attempts.attempted()
+		if recorder := event.attempts.recorder; recorder != nil && !containsRecorder(recorders, recorder) {
+			recorders = append(recorders, recorder)
+		}
+	}
+
+	for _, recorder := range recorders {
+		recorder.recordAttempts()
+	}
+
 	if err := rs.sink.Write(events...); err != nil {
-		rs.failure()
+		if !isPermanent(err) {
+			rs.failure()
+		}
 		return err
 	}
 
@@ -307,6 +413,81 @@ func (rs *retryingSink) write(events ...Event) error {
 	return nil
 }
 
+// containsRecorder returns true if recorder is one of the recorders.
+func containsRecorder(recorders []attemptRecorder, recorder attemptRecorder) bool {
+	for _, r := range recorders {
+		if r == recorder {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exhausted abandons the events that failed with err as many times as the
+// maximum number of attempts, returning the others.
+func (rs *retryingSink) exhausted(err error, events []Event) []Event {
+	if rs.policy.maxAttempts <= 0 {
+		return events
+	}
+
+	var (
+		live      []Event
+		exhausted []Event
+	)
+
+	for _, event := range events {
+		if event.attempts.value() >= rs.policy.maxAttempts {
+			exhausted = append(exhausted, event)
+		} else {
+			live = append(live, event)
+		}
+	}
+
+	if len(exhausted) > 0 {
+		logrus.Errorf("retryingsink: error writing events: %v, giving up on %d events after %d attempts", err, len(exhausted), rs.policy.maxAttempts)
+		rs.abandon(fmt.Errorf("giving up after %d attempts: %v", rs.policy.maxAttempts, err), exhausted...)
+	}
+
+	return live
+}
+
+// expire abandons the events older than the maximum age, returning the
+// others.
+func (rs *retryingSink) expire(events []Event) []Event {
+	if rs.policy.maxAge <= 0 {
+		return events
+	}
+
+	var (
+		live    []Event
+		expired []Event
+		now     = time.Now()
+	)
+
+	for _, event := range events {
+		if !event.Timestamp.IsZero() && now.Sub(event.Timestamp) > rs.policy.maxAge {
+			expired = append(expired, event)
+		} else {
+			live = append(live, event)
+		}
+	}
+
+	if len(expired) > 0 {
+		logrus.Errorf("retryingsink: %d events older than %v, giving up", len(expired), rs.policy.maxAge)
+		rs.abandon(fmt.Errorf("giving up on events older than %v", rs.policy.maxAge), expired...)
+	}
+
+	return live
+}
+
+// abandon passes the events to the listeners.
+func (rs *retryingSink) abandon(err error, events ...Event) {
+	for _, listener := range rs.listeners {
+		listener.abandoned(err, events...)
+	}
+}
+
 // wait backoff time against the sink, unlocking so others can proceed. Should
 // only be called by methods that currently have the mutex.
 func (rs *retryingSink) wait(backoff time.Duration) {
@@ -321,17 +502,59 @@ func (rs *retryingSink) wait(backoff time.Duration) {
 func (rs *retryingSink) reset() {
 	rs.failures.recent = 0
 	rs.failures.last = time.Time{}
+	rs.failures.delay = 0
 }
 
-// failure records a failure.
+// failure records a failure. The delay before the next attempt starts at the
+// backoff and doubles with each consecutive failure, up to the maximum
+// backoff. Half of the delay is random, so that endpoints recovering are not
+// flooded by registries retrying in lockstep.
 func (rs *retryingSink) failure() {
 	rs.failures.recent++
 	rs.failures.last = time.Now().UTC()
+
+	delay := rs.policy.backoff
+	for i := 1; i < rs.failures.recent && delay < rs.policy.maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > rs.policy.maxBackoff {
+		delay = rs.policy.maxBackoff
+	}
+
+	if delay > 1 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+
+	rs.failures.delay = delay
 }
 
 // proceed returns true if the call should proceed based on circuit breaker
 // heuristics.
 func (rs *retryingSink) proceed() bool {
-	return rs.failures.recent < rs.failures.threshold ||
-		time.Now().UTC().After(rs.failures.last.Add(rs.failures.backoff))
+	return rs.failures.recent < rs.policy.threshold ||
+		time.Now().UTC().After(rs.failures.last.Add(rs.failures.delay))
+}
+
+// splittingSink writes events to the sink one at a time, for sinks delivering
+// each event on its own. Wrapping a retrying sink, each event is then retried
+// and abandoned independently of the events written along with it.
+type splittingSink struct {
+	Sink
+}
+
+// newSplittingSink returns a sink writing events to sink one at a time.
+func newSplittingSink(sink Sink) *splittingSink {
+	return &splittingSink{Sink: sink}
+}
+
+// Write writes each event to the sink, stopping at the first error.
+func (ss *splittingSink) Write(events ...Event) error {
+	for _, event := range events {
+		if err := ss.Sink.Write(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
@@ -121,7 +121,7 @@ func TestRetryingSink(t *testing.T) {
 		rate: 1.0, // start out always failing.
 		Sink: &ts,
 	}
-	s := newRetryingSink(flaky, 3, 10*time.Millisecond)
+	s := newRetryingSink(flaky, retryPolicy{threshold: 3, backoff: 10 * time.Millisecond})
 
 	var wg sync.WaitGroup
 	var block []Event
@@ -159,6 +159,111 @@ func TestRetryingSink(t *testing.T) {
 	}
 }
 
+// TestRetryingSinkAbandon ensures that events failing permanently or older
+// than the maximum age are abandoned without being retried.
+func TestRetryingSinkAbandon(t *testing.T) {
+	var (
+		ts        testSink
+		abandoned abandonRecorder
+	)
+
+	rejecting := &rejectingSink{Sink: &ts, rejected: 1}
+	s := newRetryingSink(rejecting, retryPolicy{threshold: 1, backoff: time.Hour, maxAge: time.Hour}, &abandoned)
+
+	rejected := createTestEvent("push", "library/rejected", "blob")
+	if err := s.Write(rejected); err != nil {
+		t.Fatalf("unexpected error writing rejected event: %v", err)
+	}
+
+	if rejecting.attempts != 1 || s.failures.recent != 0 {
+		t.Fatalf("permanent errors should not be retried: %d attempts, %d failures", rejecting.attempts, s.failures.recent)
+	}
+
+	expired := createTestEvent("push", "library/expired", "blob")
+	expired.Timestamp = time.Now().Add(-2 * time.Hour)
+	fresh := createTestEvent("push", "library/fresh", "blob")
+	if err := s.Write(expired, fresh); err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+
+	checkEventIDs(t, abandoned.events, []Event{rejected, expired})
+	checkEventIDs(t, ts.events, []Event{fresh})
+	checkClose(t, s)
+}
+
+// TestRetryingSinkBackoff ensures that the backoff doubles with each failure,
+// up to the maximum backoff, with jitter, and that new events only wait for
+// it past the threshold.
+func TestRetryingSinkBackoff(t *testing.T) {
+	s := newRetryingSink(&testSink{}, retryPolicy{threshold: 2, backoff: 100 * time.Millisecond, maxBackoff: 400 * time.Millisecond})
+
+	for i, expected := range []time.Duration{100, 200, 400, 400, 400, 400} {
+		s.failure()
+
+		if i == 0 && !s.proceed() {
+			t.Fatalf("failure %d: should proceed below the threshold", i+1)
+		}
+
+		expected *= time.Millisecond
+		if s.failures.delay < expected/2 || s.failures.delay > expected {
+			t.Fatalf("failure %d: unexpected delay %v, expected between %v and %v", i+1, s.failures.delay, expected/2, expected)
+		}
+	}
+
+	if s.proceed() {
+		t.Fatalf("should back off past the threshold")
+	}
+
+	s.reset()
+	if !s.proceed() || s.failures.delay != 0 {
+		t.Fatalf("success should reset the backoff")
+	}
+}
+
+// TestRetryingSinkRetryDelay ensures that every retry waits for the backoff,
+// below the threshold as well.
+func TestRetryingSinkRetryDelay(t *testing.T) {
+	var abandoned abandonRecorder
+	s := newRetryingSink(&flakySink{Sink: &testSink{}, rate: 1}, retryPolicy{threshold: 10, backoff: 20 * time.Millisecond, maxBackoff: time.Second, maxAttempts: 3}, &abandoned)
+
+	start := time.Now()
+	if err := s.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+
+	// The two retries wait for at least half of 20ms and 40ms.
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("retries did not back off: %v", elapsed)
+	}
+
+	if len(abandoned.events) != 1 {
+		t.Fatalf("unexpected abandoned events: %#v", abandoned.events)
+	}
+}
+
+// TestRetryingSinkRejectedBlock ensures that the events of a block rejected
+// permanently are retried one at a time, so that only the rejected events
+// are abandoned.
+func TestRetryingSinkRejectedBlock(t *testing.T) {
+	var (
+		ts        testSink
+		abandoned abandonRecorder
+	)
+
+	s := newRetryingSink(&poisonedSink{Sink: &ts, poison: "library/poison"}, retryPolicy{threshold: 1, backoff: time.Hour}, &abandoned)
+
+	first := createTestEvent("push", "library/first", "blob")
+	poison := createTestEvent("push", "library/poison", "blob")
+	last := createTestEvent("push", "library/last", "blob")
+	if err := s.Write(first, poison, last); err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+
+	checkEventIDs(t, ts.events, []Event{first, last})
+	checkEventIDs(t, abandoned.events, []Event{poison})
+	checkClose(t, s)
+}
+
 type testSink struct {
 	events []Event
 	mu     sync.Mutex
@@ -221,3 +326,44 @@ func checkClose(t *testing.T, sink Sink) {
 		t.Fatalf("error should be ErrSinkClosed")
 	}
 }
+
+// rejectingSink rejects the first events written with a permanent error.
+type rejectingSink struct {
+	Sink
+	rejected int
+	attempts int
+}
+
+func (rs *rejectingSink) Write(events ...Event) error {
+	rs.attempts++
+	if rs.attempts <= rs.rejected {
+		return PermanentError{fmt.Errorf("rejected %d events", len(events))}
+	}
+
+	return rs.Sink.Write(events...)
+}
+
+// poisonedSink rejects blocks holding an event of the poison repository.
+type poisonedSink struct {
+	Sink
+	poison string
+}
+
+func (ps *poisonedSink) Write(events ...Event) error {
+	for _, event := range events {
+		if event.Target.Repository == ps.poison {
+			return PermanentError{fmt.Errorf("rejected %s", ps.poison)}
+		}
+	}
+
+	return ps.Sink.Write(events...)
+}
+
+// abandonRecorder records the events abandoned by a retrying sink.
+type abandonRecorder struct {
+	events []Event
+}
+
+func (ar *abandonRecorder) abandoned(err error, events ...Event) {
+	ar.events = append(ar.events, events...)
+}
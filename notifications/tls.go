@@ -0,0 +1,106 @@
+package notifications
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TLSConfig configures the TLS connections to an endpoint.
+type TLSConfig struct {
+	// CA is the path to a PEM bundle of the certificate authorities trusted
+	// to verify the endpoint, instead of the system roots.
+	CA string
+
+	// Certificate and Key are the paths to the PEM encoded certificate and
+	// key presented to the endpoint as a client certificate.
+	Certificate string
+	Key         string
+
+	// InsecureSkipVerify disables the verification of the certificate of the
+	// endpoint. It should only be used for testing.
+	InsecureSkipVerify bool
+}
+
+// empty returns true if the default TLS configuration should be used.
+func (tc TLSConfig) empty() bool {
+	return tc == TLSConfig{}
+}
+
+// clientConfig loads the files of the configuration into a tls.Config.
+func (tc TLSConfig) clientConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: tc.InsecureSkipVerify,
+	}
+
+	if tc.CA != "" {
+		p, err := ioutil.ReadFile(tc.CA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if ok := pool.AppendCertsFromPEM(p); !ok {
+			return nil, fmt.Errorf("no certificate found in CA bundle %s", tc.CA)
+		}
+		config.RootCAs = pool
+	}
+
+	if tc.Certificate != "" || tc.Key != "" {
+		if tc.Certificate == "" || tc.Key == "" {
+			return nil, fmt.Errorf("client certificate and key must be configured together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(tc.Certificate, tc.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// newHTTPTransport returns the transport of http sinks with the TLS
+// configuration, sending requests through the proxy url, if not empty, or the
+// proxy of the environment otherwise. If both are empty, the default
+// transport is returned.
+func newHTTPTransport(tc TLSConfig, proxy string) (*http.Transport, error) {
+	if tc.empty() && proxy == "" {
+		return http.DefaultTransport.(*http.Transport), nil
+	}
+
+	tlsConfig, err := tc.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	proxyFunc := http.ProxyFromEnvironment
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %v", err)
+		}
+
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy url: scheme and host required")
+		}
+		proxyFunc = http.ProxyURL(u)
+	}
+
+	// Mirrors the settings of http.DefaultTransport.
+	return &http.Transport{
+		Proxy: proxyFunc,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     tlsConfig,
+	}, nil
+}
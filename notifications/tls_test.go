@@ -0,0 +1,171 @@
+package notifications
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestHTTPSinkTLS ensures that http sinks verify endpoints with the
+// configured CA and present the configured client certificate.
+func TestHTTPSinkTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notifications-tls")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	clientCA, clientCert, clientKey := createClientCertificate(t, dir)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "registry" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCA,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	// The certificate of the test server is its own CA.
+	serverCA := filepath.Join(dir, "server-ca.pem")
+	writePEM(t, serverCA, "CERTIFICATE", server.TLS.Certificates[0].Certificate[0])
+
+	for _, testcase := range []struct {
+		tls     TLSConfig
+		success bool
+	}{
+		{
+			// The server is not trusted by default.
+			tls: TLSConfig{Certificate: clientCert, Key: clientKey},
+		},
+		{
+			// The server requires a client certificate.
+			tls: TLSConfig{CA: serverCA},
+		},
+		{
+			tls:     TLSConfig{CA: serverCA, Certificate: clientCert, Key: clientKey},
+			success: true,
+		},
+		{
+			tls:     TLSConfig{InsecureSkipVerify: true, Certificate: clientCert, Key: clientKey},
+			success: true,
+		},
+	} {
+		sink, err := CreateSink("http", SinkConfig{URL: server.URL, TLS: testcase.tls, Timeout: 5 * time.Second})
+		if err != nil {
+			t.Fatalf("unexpected error creating sink with %#v: %v", testcase.tls, err)
+		}
+
+		err = sink.Write(createTestEvent("push", "library/test", "blob"))
+		if testcase.success && err != nil {
+			t.Fatalf("unexpected error writing with %#v: %v", testcase.tls, err)
+		} else if !testcase.success && err == nil {
+			t.Fatalf("expected error writing with %#v", testcase.tls)
+		}
+
+		checkClose(t, sink)
+	}
+
+	for _, invalid := range []TLSConfig{
+		{Certificate: clientCert},
+		{CA: filepath.Join(dir, "nonexistent.pem")},
+		{CA: clientKey},
+	} {
+		if _, err := CreateSink("http", SinkConfig{URL: server.URL, TLS: invalid}); err == nil {
+			t.Fatalf("expected error creating sink with %#v", invalid)
+		}
+	}
+}
+
+// TestHTTPSinkProxy ensures that http sinks send requests through the
+// configured proxy.
+func TestHTTPSinkProxy(t *testing.T) {
+	proxied := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.String()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	sink, err := CreateSink("http", SinkConfig{URL: "http://webhook.invalid/events", Proxy: proxy.URL})
+	if err != nil {
+		t.Fatalf("unexpected error creating sink: %v", err)
+	}
+	defer sink.Close()
+
+	if err := sink.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+		t.Fatalf("unexpected error writing through proxy: %v", err)
+	}
+
+	if u := <-proxied; u != "http://webhook.invalid/events" {
+		t.Fatalf("unexpected proxied url: %q", u)
+	}
+
+	if _, err := CreateSink("http", SinkConfig{URL: "http://webhook.invalid/events", Proxy: "proxy:3128"}); err == nil {
+		t.Fatalf("expected error creating sink with proxy without scheme")
+	}
+}
+
+// createClientCertificate writes a client certificate for "registry" and its
+// key to dir, returning the pool of its self-signed CA and the paths to the
+// files.
+func createClientCertificate(t *testing.T, dir string) (*x509.CertPool, string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error generating key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "registry"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error creating certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("unexpected error parsing certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	certPath := filepath.Join(dir, "client.pem")
+	keyPath := filepath.Join(dir, "client-key.pem")
+	writePEM(t, certPath, "CERTIFICATE", der)
+	writePEM(t, keyPath, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+
+	return pool, certPath, keyPath
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	p := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := ioutil.WriteFile(path, p, 0600); err != nil {
+		t.Fatalf("unexpected error writing %s: %v", path, err)
+	}
+}
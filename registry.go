@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/docker/distribution/digest"
-	"github.com/docker/distribution/manifest"
 	"golang.org/x/net/context"
 )
 
@@ -49,6 +48,9 @@ type Repository interface {
 	// Manifests returns a reference to this repository's manifest service.
 	Manifests() ManifestService
 
+	// Tags returns a reference to this repository's tag service.
+	Tags() TagService
+
 	// Layers returns a reference to this repository's layers service.
 	Layers() LayerService
 
@@ -65,39 +67,72 @@ type ManifestService interface {
 	// Exists returns true if the manifest exists.
 	Exists(dgst digest.Digest) (bool, error)
 
-	// Get retrieves the identified by the digest, if it exists.
-	Get(dgst digest.Digest) (*manifest.SignedManifest, error)
+	// Get retrieves the manifest identified by the digest, if it exists. The
+	// concrete type of the result depends on the schema version the manifest
+	// was stored with.
+	Get(dgst digest.Digest) (Manifest, error)
 
 	// Delete removes the manifest, if it exists.
 	Delete(dgst digest.Digest) error
 
-	// Put creates or updates the manifest.
-	Put(manifest *manifest.SignedManifest) error
-
-	// TODO(stevvooe): The methods after this message should be moved to a
-	// discrete TagService, per active proposals.
-
-	// Tags lists the tags under the named repository.
-	Tags() ([]string, error)
+	// Put creates or updates the manifest. If tag is not empty, the tag is
+	// updated to point at the manifest.
+	Put(manifest Manifest, tag string) error
 
-	// ExistsByTag returns true if the manifest exists.
-	ExistsByTag(tag string) (bool, error)
-
-	// GetByTag retrieves the named manifest, if it exists.
-	GetByTag(tag string) (*manifest.SignedManifest, error)
+	// GetByTag retrieves the manifest currently referenced by tag, if it
+	// exists.
+	GetByTag(tag string) (Manifest, error)
 
 	// TODO(stevvooe): There are several changes that need to be done to this
 	// interface:
 	//
-	//	1. Allow explicit tagging with Tag(digest digest.Digest, tag string)
-	//	2. Support reading tags with a re-entrant reader to avoid large
+	//	1. Support reading tags with a re-entrant reader to avoid large
 	//       allocations in the registry.
-	//	3. Long-term: Provide All() method that lets one scroll through all of
+	//	2. Long-term: Provide All() method that lets one scroll through all of
 	//       the manifest entries.
-	//	4. Long-term: break out concept of signing from manifests. This is
+	//	3. Long-term: break out concept of signing from manifests. This is
 	//       really a part of the distribution sprint.
-	//	5. Long-term: Manifest should be an interface. This code shouldn't
-	//       really be concerned with the storage format.
+}
+
+// TagService provides access to the tags of a repository. Tags can be
+// created and moved independently of uploading the manifests they reference.
+type TagService interface {
+	// Get returns the descriptor of the manifest currently referenced by
+	// tag.
+	Get(tag string) (Descriptor, error)
+
+	// Tag points tag at the manifest identified by desc. The manifest must
+	// already exist in the repository.
+	Tag(tag string, desc Descriptor) error
+
+	// Untag removes tag from the repository. The manifest it referenced is
+	// left in place.
+	Untag(tag string) error
+
+	// All lists the tags under the repository.
+	All() ([]string, error)
+
+	// Any returns true if the repository has ever been tagged, without
+	// listing its tags.
+	Any() (bool, error)
+
+	// History returns the revisions tag has referenced, most recently
+	// tagged first. A revision is listed each time tag was pointed at it.
+	History(tag string) ([]TagHistoryEntry, error)
+}
+
+// TagHistoryEntry describes a manifest revision referenced by a tag.
+type TagHistoryEntry struct {
+	// Digest identifies the manifest revision.
+	Digest digest.Digest `json:"digest"`
+
+	// Timestamp is the time the tag was pointed at the revision. It is zero
+	// if the revision was tagged before timestamps were recorded.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Actor is the name of the authenticated user who pointed the tag at
+	// the revision, if any.
+	Actor string `json:"actor,omitempty"`
 }
 
 // LayerService provides operations on layer files in a backend storage.
@@ -171,6 +206,30 @@ type SignatureService interface {
 
 	// Put stores the signature for the provided digest.
 	Put(dgst digest.Digest, signatures ...[]byte) error
+
+	// Verify checks the stored signatures of the manifest revision against
+	// the trust policy of the repository.
+	Verify(dgst digest.Digest) (SignatureVerification, error)
+}
+
+// SignatureVerification describes the signatures of a manifest revision, as
+// checked against the trust policy of its repository.
+type SignatureVerification struct {
+	// KeyIDs lists the keys of all valid signatures.
+	KeyIDs []string
+
+	// TrustedKeyIDs lists the keys of valid signatures made with a trusted
+	// key or by a certificate chaining to a trusted certificate authority.
+	TrustedKeyIDs []string
+
+	// Required is true if a trust policy applies to the repository.
+	Required bool
+}
+
+// Trusted returns true if the signatures satisfy the trust policy: either no
+// policy applies or at least one signature is trusted.
+func (sv SignatureVerification) Trusted() bool {
+	return !sv.Required || len(sv.TrustedKeyIDs) > 0
 }
 
 // Descriptor describes targeted content. Used in conjunction with a blob
@@ -182,8 +241,13 @@ type Descriptor struct {
 	// encoded as utf-8.
 	MediaType string `json:"mediaType,omitempty"`
 
-	// Length in bytes of content.
-	Length int64 `json:"length,omitempty"`
+	// Size in bytes of content.
+	Size int64 `json:"size,omitempty"`
+
+	// Length in bytes of content. Deprecated: it is kept for existing users
+	// of the field, Size should be used instead. It is not serialized, so
+	// that descriptors carry their size once on the wire.
+	Length int64 `json:"-"`
 
 	// Digest uniquely identifies the content. A byte stream can be verified
 	// against against this digest.
@@ -55,6 +55,22 @@ var (
 		Examples:    []string{"Bearer dGhpcyBpcyBhIGZha2UgYmVhcmVyIHRva2VuIQ=="},
 	}
 
+	manifestAcceptHeader = ParameterDescriptor{
+		Name:        "Accept",
+		Type:        "string",
+		Description: "Media types of manifests understood by the client. Manifests of schema version 2 and manifest lists are only returned by tag if `application/vnd.docker.distribution.manifest.v2+json` or `application/vnd.docker.distribution.manifest.list.v2+json`, respectively, is listed, either exactly or through a `*/*` or `application/*` range.",
+		Format:      "<media type>[, <media type>...]",
+		Examples:    []string{"application/vnd.docker.distribution.manifest.v2+json"},
+	}
+
+	manifestContentTypeHeader = ParameterDescriptor{
+		Name:        "Content-Type",
+		Type:        "string",
+		Description: "Media type of the manifest body. Set to `application/vnd.docker.distribution.manifest.v2+json` for schema version 2 manifests or `application/vnd.docker.distribution.manifest.list.v2+json` for manifest lists. If absent or `application/json`, the schema is identified by the `mediaType` field of the body, defaulting to schema version 1. Unknown media types are rejected.",
+		Format:      "<media type>",
+		Examples:    []string{"application/vnd.docker.distribution.manifest.v2+json"},
+	}
+
 	authChallengeHeader = ParameterDescriptor{
 		Name:        "WWW-Authenticate",
 		Type:        "string",
@@ -127,6 +143,31 @@ var (
 			Format:      unauthorizedErrorsBody,
 		},
 	}
+
+	tagImmutableResponse = ResponseDescriptor{
+		Name:        "Immutable Tag",
+		Description: "The tag is protected by an immutability policy and already references a different manifest. It cannot be moved or removed.",
+		StatusCode:  http.StatusConflict,
+		ErrorCodes: []ErrorCode{
+			ErrorCodeTagImmutable,
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json; charset=utf-8",
+			Format:      errorsBody,
+		},
+	}
+
+	quotaExceededResponse = ResponseDescriptor{
+		Description: "Storing the blob would exceed the storage quota of the repository. The upload must be restarted once space is available.",
+		StatusCode:  http.StatusForbidden,
+		ErrorCodes: []ErrorCode{
+			ErrorCodeQuotaExceeded,
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json; charset=utf-8",
+			Format:      errorsBody,
+		},
+	}
 )
 
 const (
@@ -144,6 +185,61 @@ const (
    "signature": <JWS>
 }`
 
+	manifestSchema2Body = `{
+   "schemaVersion": 2,
+   "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+   "config": {
+      "mediaType": "application/vnd.docker.container.image.v1+json",
+      "size": <size>,
+      "digest": <digest>
+   },
+   "layers": [
+      {
+         "mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip",
+         "size": <size>,
+         "digest": <digest>
+      },
+      ...
+   ]
+}`
+
+	manifestListBody = `{
+   "schemaVersion": 2,
+   "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+   "manifests": [
+      {
+         "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+         "size": <size>,
+         "digest": <digest>,
+         "platform": {
+            "architecture": <architecture>,
+            "os": <os>,
+            "variant": <variant>
+         }
+      },
+      ...
+   ]
+}`
+
+	tagBody = `{
+   "mediaType": <manifest media type>,
+   "size": <size>,
+   "digest": <digest>
+}`
+
+	tagHistoryBody = `{
+   "name": <name>,
+   "tag": <tag>,
+   "history": [
+      {
+         "digest": <digest>,
+         "timestamp": <time tagged>,
+         "actor": <user>
+      },
+      ...
+   ]
+}`
+
 	errorsBody = `{
 	"errors:" [
 	    {
@@ -459,6 +555,360 @@ var routeDescriptors = []RouteDescriptor{
 			},
 		},
 	},
+	{
+		Name:        RouteNameTag,
+		Path:        "/v2/{name:" + RepositoryNameRegexp.String() + "}/tags/{tag:" + TagNameRegexp.String() + "}",
+		Entity:      "Tag",
+		Description: "Create, move, retrieve and remove individual tags, without uploading manifests. The tag `list` is shadowed by the tags listing endpoint and cannot be managed here.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Fetch the descriptor of the manifest referenced by `tag` in the repository identified by `name`.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							tagParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "The descriptor of the manifest currently referenced by the tag.",
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+									digestHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      tagBody,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusNotFound,
+								Description: "The tag is not known to the repository.",
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+							},
+							unauthorizedResponse,
+						},
+					},
+				},
+			},
+			{
+				Method:      "PUT",
+				Description: "Point `tag` at a manifest already stored in the repository identified by `name`, creating the tag or moving it from its current manifest. Only the `digest` field of the request body is required.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							tagParameterDescriptor,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json; charset=utf-8",
+							Format: `{
+    "digest": <manifest digest>
+}`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The tag now references the manifest.",
+								StatusCode:  http.StatusCreated,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Location",
+										Type:        "url",
+										Description: "The location url of the manifest by tag.",
+										Format:      "<url>",
+									},
+									contentLengthZeroHeader,
+									digestHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Tag Request",
+								Description: "The request body could not be decoded or did not contain a valid digest.",
+								StatusCode:  http.StatusBadRequest,
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []ErrorCode{
+									ErrorCodeDigestInvalid,
+								},
+							},
+							{
+								Name:        "Unknown Manifest",
+								Description: "The digest does not identify a manifest stored in the repository. Push the manifest first.",
+								StatusCode:  http.StatusNotFound,
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+							},
+							tagImmutableResponse,
+							unauthorizedResponsePush,
+						},
+					},
+				},
+			},
+			{
+				Method:      "DELETE",
+				Description: "Remove `tag` from the repository identified by `name`. The manifest it referenced remains available by digest.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							tagParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusAccepted,
+								Headers: []ParameterDescriptor{
+									contentLengthZeroHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusNotFound,
+								Description: "The tag is not known to the repository.",
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+							},
+							tagImmutableResponse,
+							unauthorizedResponse,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameTagHistory,
+		Path:        "/v2/{name:" + RepositoryNameRegexp.String() + "}/tags/{tag:" + TagNameRegexp.String() + "}/history",
+		Entity:      "Tag History",
+		Description: "Retrieve the manifests a tag has referenced and roll the tag back to one of them.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Fetch the manifest digests `tag` has referenced in the repository identified by `name`, most recently tagged first. A digest is listed each time the tag was pointed at it, with the time and the user who did so. Digests tagged before the registry recorded history have a zero timestamp and are listed last.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							tagParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "The history of the tag.",
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      tagHistoryBody,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusNotFound,
+								Description: "The tag is not known to the repository.",
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+							},
+							unauthorizedResponse,
+						},
+					},
+				},
+			},
+			{
+				Method:      "POST",
+				Description: "Roll `tag` back to a manifest it previously referenced. If the request body provides a `digest`, it must be listed in the history of the tag. Otherwise, the tag is pointed at the most recently tagged manifest other than the current one, so that repeated rollbacks alternate between the last two manifests.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							tagParameterDescriptor,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json; charset=utf-8",
+							Format: `{
+    "digest": <manifest digest>
+}`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The tag now references the manifest identified by the digest header.",
+								StatusCode:  http.StatusCreated,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Location",
+										Type:        "url",
+										Description: "The location url of the manifest by tag.",
+										Format:      "<url>",
+									},
+									contentLengthZeroHeader,
+									digestHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Rollback Request",
+								Description: "The request body could not be decoded or did not contain a valid digest.",
+								StatusCode:  http.StatusBadRequest,
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []ErrorCode{
+									ErrorCodeDigestInvalid,
+								},
+							},
+							{
+								Name:        "Unknown Revision",
+								Description: "The tag is not known to the repository, the digest is not in its history or the tag has no previous manifest.",
+								StatusCode:  http.StatusNotFound,
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+							},
+							tagImmutableResponse,
+							unauthorizedResponsePush,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameQuota,
+		Path:        "/v2/{name:" + RepositoryNameRegexp.String() + "}/quota",
+		Entity:      "Quota",
+		Description: "Retrieve storage quota usage.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Fetch the storage usage and limit of the quota scope containing the repository identified by `name`. Depending on the registry configuration, the scope is either the repository or its namespace.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "The quota usage of the named repository. A limit of zero indicates that the scope is not limited.",
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format: `{
+    "name": <name>,
+    "scope": <scope>,
+    "used": <bytes used>,
+    "limit": <bytes allowed>
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusNotFound,
+								Description: "Storage quotas are not enabled on the registry.",
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []ErrorCode{
+									ErrorCodeUnsupported,
+								},
+							},
+							{
+								StatusCode:  http.StatusUnauthorized,
+								Description: "The client does not have access to the repository.",
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []ErrorCode{
+									ErrorCodeUnauthorized,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
 	{
 		Name:        RouteNameManifest,
 		Path:        "/v2/{name:" + RepositoryNameRegexp.String() + "}/manifests/{reference:" + TagNameRegexp.String() + "|" + digest.DigestRegexp.String() + "}",
@@ -473,6 +923,7 @@ var routeDescriptors = []RouteDescriptor{
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
+							manifestAcceptHeader,
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
@@ -490,6 +941,30 @@ var routeDescriptors = []RouteDescriptor{
 									Format:      manifestBody,
 								},
 							},
+							{
+								Name:        "Schema 2 Manifest",
+								Description: "The schema version 2 manifest identified by `name` and `reference`. Returned when fetching by digest, or by tag if the client accepts the schema 2 media type.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									digestHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/vnd.docker.distribution.manifest.v2+json",
+									Format:      manifestSchema2Body,
+								},
+							},
+							{
+								Name:        "Manifest List",
+								Description: "The manifest list identified by `name` and `reference`, referencing a manifest per platform. Returned when fetching by digest, or by tag if the client accepts the manifest list media type.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									digestHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/vnd.docker.distribution.manifest.list.v2+json",
+									Format:      manifestListBody,
+								},
+							},
 						},
 						Failures: []ResponseDescriptor{
 							{
@@ -527,18 +1002,30 @@ var routeDescriptors = []RouteDescriptor{
 									Format:      errorsBody,
 								},
 							},
+							{
+								Description: "The tag refers to a manifest whose media type is not accepted by the client, such as a schema version 2 manifest or manifest list.",
+								StatusCode:  http.StatusNotAcceptable,
+								ErrorCodes: []ErrorCode{
+									ErrorCodeManifestNotAcceptable,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+							},
 						},
 					},
 				},
 			},
 			{
 				Method:      "PUT",
-				Description: "Put the manifest identified by `name` and `reference` where `reference` can be a tag or digest.",
+				Description: "Put the manifest identified by `name` and `reference` where `reference` can be a tag or digest. The `Content-Type` header selects the manifest schema version.",
 				Requests: []RequestDescriptor{
 					{
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
+							manifestContentTypeHeader,
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
@@ -578,6 +1065,7 @@ var routeDescriptors = []RouteDescriptor{
 									ErrorCodeTagInvalid,
 									ErrorCodeManifestInvalid,
 									ErrorCodeManifestUnverified,
+									ErrorCodeManifestUnknown,
 									ErrorCodeBlobUnknown,
 								},
 							},
@@ -592,6 +1080,7 @@ var routeDescriptors = []RouteDescriptor{
 									ErrorCodeUnauthorized,
 								},
 							},
+							tagImmutableResponse,
 							{
 								Name:        "Missing Layer(s)",
 								Description: "One or more layers may be missing during a manifest upload. If so, the missing layers will be enumerated in the error response.",
@@ -1221,6 +1710,7 @@ var routeDescriptors = []RouteDescriptor{
 								},
 							},
 							unauthorizedResponsePush,
+							quotaExceededResponse,
 							{
 								Description: "The upload is unknown to the registry. The upload must be restarted.",
 								StatusCode:  http.StatusNotFound,
@@ -1404,7 +1894,9 @@ var errorDescriptors = []ErrorDescriptor{
 		Value:   "MANIFEST_UNVERIFIED",
 		Message: "manifest failed signature verification",
 		Description: `During manifest upload, if the manifest fails signature
-		verification, this error will be returned.`,
+		verification, this error will be returned. It is also returned if the
+		registry enforces a trust policy and none of the signatures were made
+		by a trusted key, in which case the detail lists the signing keys.`,
 		HTTPStatusCodes: []int{http.StatusBadRequest},
 	},
 	{
@@ -1434,6 +1926,44 @@ var errorDescriptors = []ErrorDescriptor{
 		longer proceed.`,
 		HTTPStatusCodes: []int{http.StatusNotFound},
 	},
+	{
+		Code:    ErrorCodeTooManyRequests,
+		Value:   "TOOMANYREQUESTS",
+		Message: "too many requests",
+		Description: `Returned when a client has exceeded the rate limit for
+		a class of requests. The Retry-After header indicates how many
+		seconds to wait before making another request of the same class.`,
+		HTTPStatusCodes: []int{http.StatusTooManyRequests},
+	},
+	{
+		Code:    ErrorCodeQuotaExceeded,
+		Value:   "QUOTA_EXCEEDED",
+		Message: "storage quota exceeded",
+		Description: `Returned when storing a blob would take the storage usage
+		of the repository or its namespace over the configured quota. The
+		detail contains the quota scope, its limit and current usage.`,
+		HTTPStatusCodes: []int{http.StatusForbidden},
+	},
+	{
+		Code:    ErrorCodeTagImmutable,
+		Value:   "TAG_IMMUTABLE",
+		Message: "tag is immutable",
+		Description: `Returned when a manifest push or tag operation would move
+		or remove a tag protected by an immutability policy. Pushing the
+		revision the tag already references is permitted. The detail
+		contains the tag and the digest it references.`,
+		HTTPStatusCodes: []int{http.StatusConflict},
+	},
+	{
+		Code:    ErrorCodeManifestNotAcceptable,
+		Value:   "MANIFEST_NOT_ACCEPTABLE",
+		Message: "manifest media type not acceptable",
+		Description: `Returned when a tag references a manifest whose media
+		type is not listed in the Accept header of the request, either
+		exactly or through a wildcard range. The detail contains the
+		media type the tag requires.`,
+		HTTPStatusCodes: []int{http.StatusNotAcceptable},
+	},
 }
 
 var errorCodeToDescriptors map[ErrorCode]ErrorDescriptor
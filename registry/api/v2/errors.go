@@ -60,6 +60,22 @@ const (
 
 	// ErrorCodeBlobUploadInvalid is returned when an upload is invalid.
 	ErrorCodeBlobUploadInvalid
+
+	// ErrorCodeTooManyRequests is returned when a client has exceeded its
+	// rate limit.
+	ErrorCodeTooManyRequests
+
+	// ErrorCodeQuotaExceeded is returned when storing content would exceed
+	// the storage quota of the repository.
+	ErrorCodeQuotaExceeded
+
+	// ErrorCodeTagImmutable is returned when attempting to move or remove a
+	// tag protected by an immutability policy.
+	ErrorCodeTagImmutable
+
+	// ErrorCodeManifestNotAcceptable is returned when fetching a manifest by
+	// tag whose media type is not accepted by the client.
+	ErrorCodeManifestNotAcceptable
 )
 
 // ParseErrorCode attempts to parse the error code string, returning
@@ -8,6 +8,9 @@ const (
 	RouteNameBase            = "base"
 	RouteNameManifest        = "manifest"
 	RouteNameTags            = "tags"
+	RouteNameTag             = "tag"
+	RouteNameTagHistory      = "tag-history"
+	RouteNameQuota           = "quota"
 	RouteNameBlob            = "blob"
 	RouteNameBlobUpload      = "blob-upload"
 	RouteNameBlobUploadChunk = "blob-upload-chunk"
@@ -16,6 +19,9 @@ const (
 var allEndpoints = []string{
 	RouteNameManifest,
 	RouteNameTags,
+	RouteNameTag,
+	RouteNameTagHistory,
+	RouteNameQuota,
 	RouteNameBlob,
 	RouteNameBlobUpload,
 	RouteNameBlobUploadChunk,
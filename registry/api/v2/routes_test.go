@@ -66,6 +66,38 @@ func TestRouter(t *testing.T) {
 				"name": "foo/bar",
 			},
 		},
+		{
+			RouteName:  RouteNameTag,
+			RequestURI: "/v2/foo/bar/tags/latest",
+			Vars: map[string]string{
+				"name": "foo/bar",
+				"tag":  "latest",
+			},
+		},
+		{
+			RouteName:  RouteNameTagHistory,
+			RequestURI: "/v2/foo/bar/tags/latest/history",
+			Vars: map[string]string{
+				"name": "foo/bar",
+				"tag":  "latest",
+			},
+		},
+		{
+			// Repositories with a component named "tags" remain routable.
+			RouteName:  RouteNameTag,
+			RequestURI: "/v2/foo/tags/bar/tags/history",
+			Vars: map[string]string{
+				"name": "foo/tags/bar",
+				"tag":  "history",
+			},
+		},
+		{
+			RouteName:  RouteNameQuota,
+			RequestURI: "/v2/foo/bar/quota",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
 		{
 			RouteName:  RouteNameBlob,
 			RequestURI: "/v2/foo/bar/blobs/tarsum.dev+foo:abcdef0919234",
@@ -107,6 +107,45 @@ func (ub *URLBuilder) BuildTagsURL(name string) (string, error) {
 	return tagsURL.String(), nil
 }
 
+// BuildTagURL constructs a url to manage a single tag of the named
+// repository.
+func (ub *URLBuilder) BuildTagURL(name, tag string) (string, error) {
+	route := ub.cloneRoute(RouteNameTag)
+
+	tagURL, err := route.URL("name", name, "tag", tag)
+	if err != nil {
+		return "", err
+	}
+
+	return tagURL.String(), nil
+}
+
+// BuildTagHistoryURL constructs a url to retrieve the history of a tag of the
+// named repository and roll it back.
+func (ub *URLBuilder) BuildTagHistoryURL(name, tag string) (string, error) {
+	route := ub.cloneRoute(RouteNameTagHistory)
+
+	historyURL, err := route.URL("name", name, "tag", tag)
+	if err != nil {
+		return "", err
+	}
+
+	return historyURL.String(), nil
+}
+
+// BuildQuotaURL constructs a url to retrieve the storage quota usage of the
+// named repository.
+func (ub *URLBuilder) BuildQuotaURL(name string) (string, error) {
+	route := ub.cloneRoute(RouteNameQuota)
+
+	quotaURL, err := route.URL("name", name)
+	if err != nil {
+		return "", err
+	}
+
+	return quotaURL.String(), nil
+}
+
 // BuildManifestURL constructs a url for the manifest identified by name and
 // reference. The argument reference may be either a tag or digest.
 func (ub *URLBuilder) BuildManifestURL(name, reference string) (string, error) {
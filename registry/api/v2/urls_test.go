@@ -26,6 +26,27 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 				return urlBuilder.BuildTagsURL("foo/bar")
 			},
 		},
+		{
+			description:  "test tag url",
+			expectedPath: "/v2/foo/bar/tags/latest",
+			build: func() (string, error) {
+				return urlBuilder.BuildTagURL("foo/bar", "latest")
+			},
+		},
+		{
+			description:  "test tag history url",
+			expectedPath: "/v2/foo/bar/tags/latest/history",
+			build: func() (string, error) {
+				return urlBuilder.BuildTagHistoryURL("foo/bar", "latest")
+			},
+		},
+		{
+			description:  "test quota url",
+			expectedPath: "/v2/foo/bar/quota",
+			build: func() (string, error) {
+				return urlBuilder.BuildQuotaURL("foo/bar")
+			},
+		},
 		{
 			description:  "test manifest url",
 			expectedPath: "/v2/foo/bar/manifests/tag",
@@ -0,0 +1,194 @@
+// Package audit provides an append-only trail of authorization decisions and
+// repository mutations. Records are written as JSON, one per line, to a
+// Logger. Optionally, each record can be chained to the previous one by a
+// hash, so that modification or removal of records can be detected with
+// Verify.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Record types.
+const (
+	// RecordTypeAuthorization records the result of an authorization check.
+	RecordTypeAuthorization = "authorization"
+
+	// RecordTypeMutation records a modification of a repository.
+	RecordTypeMutation = "mutation"
+)
+
+// Authorization results.
+const (
+	ResultAllowed = "allowed"
+	ResultDenied  = "denied"
+	ResultError   = "error"
+)
+
+// Record is a single entry in the audit trail.
+type Record struct {
+	// Timestamp is the time at which the record was created, in UTC.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Type is either RecordTypeAuthorization or RecordTypeMutation.
+	Type string `json:"type"`
+
+	// RequestID identifies the request that caused the record.
+	RequestID string `json:"requestID,omitempty"`
+
+	// Actor is the user making the request, if known.
+	Actor string `json:"actor,omitempty"`
+
+	// Addr is the remote address of the request.
+	Addr string `json:"addr,omitempty"`
+
+	// Method and URI identify the request.
+	Method string `json:"method,omitempty"`
+	URI    string `json:"uri,omitempty"`
+
+	// Access lists the access requested, for authorization records.
+	Access []Access `json:"access,omitempty"`
+
+	// Result is the outcome of an authorization check.
+	Result string `json:"result,omitempty"`
+
+	// Reason explains a denied or failed authorization check, such as the
+	// challenge issued to the client.
+	Reason string `json:"reason,omitempty"`
+
+	// Action is the modification made, for mutation records, such as
+	// "push" or "delete".
+	Action string `json:"action,omitempty"`
+
+	// Target describes the object modified, for mutation records.
+	Target *Target `json:"target,omitempty"`
+
+	// PrevHash and Hash chain records together, if enabled. Hash covers the
+	// record with PrevHash set and Hash empty.
+	PrevHash string `json:"prevHash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// Access describes a requested action on a resource.
+type Access struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// Target describes an object modified in a repository.
+type Target struct {
+	Repository string `json:"repository"`
+	MediaType  string `json:"mediaType,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+	Length     int64  `json:"length,omitempty"`
+}
+
+// Logger writes audit records. Implementations must be safe for concurrent
+// use.
+type Logger interface {
+	// Log writes the record, returning an error if it could not be
+	// persisted.
+	Log(record Record) error
+
+	// Close flushes and releases any resources held by the logger.
+	Close() error
+}
+
+// chainLogger sets the hash fields of each record before writing it.
+type chainLogger struct {
+	logger Logger
+	mu     sync.Mutex
+	prev   string
+}
+
+// NewChainLogger returns a Logger that chains each record to the previous
+// one by hash before writing it to logger. The first record is chained to
+// prev, which should be the hash of the last record previously written, if
+// any.
+func NewChainLogger(logger Logger, prev string) Logger {
+	return &chainLogger{
+		logger: logger,
+		prev:   prev,
+	}
+}
+
+func (cl *chainLogger) Log(record Record) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	record.PrevHash = cl.prev
+	hash, err := hashRecord(record)
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+
+	if err := cl.logger.Log(record); err != nil {
+		return err
+	}
+
+	cl.prev = hash
+	return nil
+}
+
+func (cl *chainLogger) Close() error {
+	return cl.logger.Close()
+}
+
+// hashRecord returns the hash of the record, excluding its Hash field.
+func hashRecord(record Record) (string, error) {
+	record.Hash = ""
+	p, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(p)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// Verify reads JSON-lines records from r, checking that each is chained to
+// the previous one, starting from prev. The hash of the last record is
+// returned, so that verification may continue in a subsequent file. An error
+// is returned identifying the first record that breaks the chain.
+func Verify(r io.Reader, prev string) (string, error) {
+	br := bufio.NewReader(r)
+
+	for line := 1; ; line++ {
+		p, err := br.ReadBytes('\n')
+		if err == io.EOF && len(p) == 0 {
+			return prev, nil
+		} else if err != nil && err != io.EOF {
+			return prev, err
+		}
+
+		var record Record
+		if err := json.Unmarshal(p, &record); err != nil {
+			return prev, fmt.Errorf("record %d: %v", line, err)
+		}
+
+		if record.PrevHash != prev {
+			return prev, fmt.Errorf("record %d: chained to %q, expected %q", line, record.PrevHash, prev)
+		}
+
+		hash, err := hashRecord(record)
+		if err != nil {
+			return prev, fmt.Errorf("record %d: %v", line, err)
+		}
+
+		if record.Hash != hash {
+			return prev, fmt.Errorf("record %d: hash %q does not match content %q", line, record.Hash, hash)
+		}
+
+		prev = hash
+	}
+}
@@ -0,0 +1,122 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bufferLogger writes records as JSON lines to a buffer.
+type bufferLogger struct {
+	bytes.Buffer
+}
+
+func (bl *bufferLogger) Log(record Record) error {
+	return json.NewEncoder(&bl.Buffer).Encode(record)
+}
+
+func (bl *bufferLogger) Close() error {
+	return nil
+}
+
+func testRecords() []Record {
+	now := time.Date(2015, 5, 1, 12, 0, 0, 0, time.UTC)
+	return []Record{
+		{
+			Timestamp: now,
+			Type:      RecordTypeAuthorization,
+			RequestID: "req-1",
+			Actor:     "alice",
+			Access: []Access{
+				{Type: "repository", Name: "foo/bar", Action: "pull"},
+			},
+			Result: ResultAllowed,
+		},
+		{
+			Timestamp: now.Add(time.Second),
+			Type:      RecordTypeAuthorization,
+			RequestID: "req-2",
+			Access: []Access{
+				{Type: "repository", Name: "foo/bar", Action: "push"},
+			},
+			Result: ResultDenied,
+			Reason: "authentication required",
+		},
+		{
+			Timestamp: now.Add(2 * time.Second),
+			Type:      RecordTypeMutation,
+			RequestID: "req-3",
+			Actor:     "alice",
+			Action:    "push",
+			Target: &Target{
+				Repository: "foo/bar",
+				Digest:     "sha256:abcdef",
+				Tag:        "latest",
+			},
+		},
+	}
+}
+
+// TestChainLogger ensures that chained records verify and that modified,
+// removed or reordered records are detected.
+func TestChainLogger(t *testing.T) {
+	var buf bufferLogger
+	logger := NewChainLogger(&buf, "")
+
+	for _, record := range testRecords() {
+		if err := logger.Log(record); err != nil {
+			t.Fatalf("unexpected error logging record: %v", err)
+		}
+	}
+
+	lines := strings.SplitAfter(buf.String(), "\n")
+	lines = lines[:len(lines)-1] // trailing empty element
+
+	last, err := Verify(strings.NewReader(buf.String()), "")
+	if err != nil {
+		t.Fatalf("unexpected error verifying chain: %v", err)
+	}
+
+	var record Record
+	if err := json.Unmarshal([]byte(lines[2]), &record); err != nil {
+		t.Fatalf("unexpected error decoding record: %v", err)
+	}
+
+	if last != record.Hash {
+		t.Fatalf("unexpected last hash: %q != %q", last, record.Hash)
+	}
+
+	// Continuing the chain from the last hash verifies separately.
+	var next bufferLogger
+	if err := NewChainLogger(&next, last).Log(testRecords()[0]); err != nil {
+		t.Fatalf("unexpected error logging record: %v", err)
+	}
+
+	if _, err := Verify(strings.NewReader(next.String()), last); err != nil {
+		t.Fatalf("unexpected error verifying continued chain: %v", err)
+	}
+
+	for _, testcase := range []struct {
+		description string
+		content     string
+	}{
+		{
+			description: "modified record",
+			content:     lines[0] + strings.Replace(lines[1], "denied", "allowed", 1) + lines[2],
+		},
+		{
+			description: "removed record",
+			content:     lines[0] + lines[2],
+		},
+		{
+			description: "reordered records",
+			content:     lines[1] + lines[0] + lines[2],
+		},
+	} {
+		if _, err := Verify(strings.NewReader(testcase.content), ""); err == nil {
+			t.Fatalf("expected error verifying %s", testcase.description)
+		}
+	}
+}
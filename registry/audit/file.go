@@ -0,0 +1,181 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// fileLogger appends records to a file as JSON lines, rotating the file once
+// it reaches a maximum size.
+type fileLogger struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+// NewFileLogger returns a Logger appending records to the file at path.
+// Before a record would take the file over maxSize bytes, the file is
+// renamed to path.1, shifting existing backups up to maxBackups and removing
+// the oldest. A maxSize of zero disables rotation.
+func NewFileLogger(path string, maxSize int64, maxBackups int) (Logger, error) {
+	fl := &fileLogger{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := fl.open(); err != nil {
+		return nil, err
+	}
+
+	return fl, nil
+}
+
+func (fl *fileLogger) Log(record Record) error {
+	p, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	p = append(p, '\n')
+
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.file == nil {
+		return fmt.Errorf("audit log %s: closed", fl.path)
+	}
+
+	if fl.maxSize > 0 && fl.size > 0 && fl.size+int64(len(p)) > fl.maxSize {
+		if err := fl.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := fl.file.Write(p)
+	fl.size += int64(n)
+	return err
+}
+
+func (fl *fileLogger) Close() error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.file == nil {
+		return nil
+	}
+
+	err := fl.file.Close()
+	fl.file = nil
+	return err
+}
+
+func (fl *fileLogger) open() error {
+	file, err := os.OpenFile(fl.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	fl.file = file
+	fl.size = fi.Size()
+	return nil
+}
+
+// rotate moves the current file to the first backup and opens a new file.
+// If no backups are kept, the current file is removed.
+func (fl *fileLogger) rotate() error {
+	if err := fl.file.Close(); err != nil {
+		return err
+	}
+	fl.file = nil
+
+	if fl.maxBackups > 0 {
+		for i := fl.maxBackups - 1; i > 0; i-- {
+			if err := os.Rename(backupPath(fl.path, i), backupPath(fl.path, i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		if err := os.Rename(fl.path, backupPath(fl.path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(fl.path); err != nil {
+		return err
+	}
+
+	return fl.open()
+}
+
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// LastHash returns the hash of the last record written to the audit log at
+// path, looking in the first backup if the file is empty. An empty string
+// is returned if no chained record is found.
+func LastHash(path string) (string, error) {
+	for _, p := range []string{path, backupPath(path, 1)} {
+		hash, found, err := lastHash(p)
+		if err != nil {
+			return "", err
+		}
+
+		if found {
+			return hash, nil
+		}
+	}
+
+	return "", nil
+}
+
+// lastHash returns the hash of the last record in the file, if it has any
+// records.
+func lastHash(path string) (string, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	defer f.Close()
+
+	var last []byte
+	br := bufio.NewReader(f)
+	for {
+		p, err := br.ReadBytes('\n')
+		if len(p) > 1 {
+			last = p
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", false, err
+		}
+	}
+
+	if last == nil {
+		return "", false, nil
+	}
+
+	var record Record
+	if err := json.Unmarshal(last, &record); err != nil {
+		return "", false, fmt.Errorf("error reading last record of %s: %v", path, err)
+	}
+
+	return record.Hash, true, nil
+}
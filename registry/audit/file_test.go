@@ -0,0 +1,122 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFileLoggerRotation ensures that the file is rotated before exceeding
+// its maximum size and that only the configured number of backups is kept.
+func TestFileLoggerRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-test-")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	record := testRecords()[0]
+
+	// Allow two records per file.
+	maxSize := int64(2 * len(mustMarshalLine(t, record)))
+
+	logger, err := NewFileLogger(path, maxSize, 2)
+	if err != nil {
+		t.Fatalf("unexpected error creating file logger: %v", err)
+	}
+
+	for i := 0; i < 7; i++ {
+		if err := logger.Log(record); err != nil {
+			t.Fatalf("unexpected error logging record: %v", err)
+		}
+	}
+
+	if err := logger.Close(); err != nil {
+		t.Fatalf("unexpected error closing logger: %v", err)
+	}
+
+	for path, expected := range map[string]int{
+		path:                1,
+		backupPath(path, 1): 2,
+		backupPath(path, 2): 2,
+	} {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error reading %s: %v", path, err)
+		}
+
+		if lines := strings.Count(string(content), "\n"); lines != expected {
+			t.Fatalf("unexpected number of records in %s: %d != %d", path, lines, expected)
+		}
+	}
+
+	if _, err := os.Stat(backupPath(path, 3)); !os.IsNotExist(err) {
+		t.Fatalf("expected only two backups to be kept: %v", err)
+	}
+}
+
+// TestFileLoggerChain ensures that a chain written to a file can be resumed
+// after reopening the file and verified across rotations.
+func TestFileLoggerChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-test-")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+
+	if hash, err := LastHash(path); err != nil || hash != "" {
+		t.Fatalf("expected no hash for missing log: %q, %v", hash, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		prev, err := LastHash(path)
+		if err != nil {
+			t.Fatalf("unexpected error reading last hash: %v", err)
+		}
+
+		fl, err := NewFileLogger(path, 0, 0)
+		if err != nil {
+			t.Fatalf("unexpected error creating file logger: %v", err)
+		}
+
+		logger := NewChainLogger(fl, prev)
+		for _, record := range testRecords() {
+			if err := logger.Log(record); err != nil {
+				t.Fatalf("unexpected error logging record: %v", err)
+			}
+		}
+
+		if err := logger.Close(); err != nil {
+			t.Fatalf("unexpected error closing logger: %v", err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error opening log: %v", err)
+	}
+	defer f.Close()
+
+	last, err := Verify(f, "")
+	if err != nil {
+		t.Fatalf("unexpected error verifying resumed chain: %v", err)
+	}
+
+	if hash, err := LastHash(path); err != nil || hash != last {
+		t.Fatalf("unexpected last hash: %q != %q, %v", hash, last, err)
+	}
+}
+
+func mustMarshalLine(t *testing.T, record Record) string {
+	var buf bufferLogger
+	if err := buf.Log(record); err != nil {
+		t.Fatalf("unexpected error marshaling record: %v", err)
+	}
+
+	return buf.String()
+}
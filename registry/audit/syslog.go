@@ -0,0 +1,41 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package audit
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// syslogLogger writes records as JSON messages to syslog.
+type syslogLogger struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogLogger returns a Logger writing records to the syslog daemon at
+// addr over network, with the provided tag. If network is empty, the local
+// syslog daemon is used.
+func NewSyslogLogger(network, addr, tag string) (Logger, error) {
+	writer, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &syslogLogger{
+		writer: writer,
+	}, nil
+}
+
+func (sl *syslogLogger) Log(record Record) error {
+	p, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return sl.writer.Info(string(p))
+}
+
+func (sl *syslogLogger) Close() error {
+	return sl.writer.Close()
+}
@@ -0,0 +1,53 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package audit
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSyslogLogger ensures records are sent to the syslog daemon as JSON.
+func TestSyslogLogger(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+	defer conn.Close()
+
+	logger, err := NewSyslogLogger("udp", conn.LocalAddr().String(), "registry-audit")
+	if err != nil {
+		t.Fatalf("unexpected error creating syslog logger: %v", err)
+	}
+	defer logger.Close()
+
+	expected := testRecords()[1]
+	if err := logger.Log(expected); err != nil {
+		t.Fatalf("unexpected error logging record: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	p := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(p)
+	if err != nil {
+		t.Fatalf("unexpected error reading syslog message: %v", err)
+	}
+
+	msg := string(p[:n])
+	if !strings.Contains(msg, "registry-audit") {
+		t.Fatalf("message missing tag: %q", msg)
+	}
+
+	var record Record
+	if err := json.Unmarshal([]byte(msg[strings.Index(msg, "{"):]), &record); err != nil {
+		t.Fatalf("unexpected error decoding record from %q: %v", msg, err)
+	}
+
+	if record.RequestID != expected.RequestID || record.Result != expected.Result || record.Reason != expected.Reason {
+		t.Fatalf("unexpected record: %#v != %#v", record, expected)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package audit
+
+import "fmt"
+
+// NewSyslogLogger is not supported on this platform.
+func NewSyslogLogger(network, addr, tag string) (Logger, error) {
+	return nil, fmt.Errorf("syslog audit logging is not supported on this platform")
+}
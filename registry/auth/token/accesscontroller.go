@@ -1,19 +1,14 @@
 package token
 
 import (
-	"crypto"
-	"crypto/x509"
-	"encoding/pem"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	ctxu "github.com/docker/distribution/context"
 	"github.com/docker/distribution/registry/auth"
-	"github.com/docker/libtrust"
 	"golang.org/x/net/context"
 )
 
@@ -125,11 +120,10 @@ func (ac *authChallenge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // accessController implements the auth.AccessController interface.
 type accessController struct {
-	realm       string
-	issuer      string
-	service     string
-	rootCerts   *x509.CertPool
-	trustedKeys map[string]libtrust.PublicKey
+	realm   string
+	issuer  string
+	service string
+	keys    *keyStore
 }
 
 // tokenAccessOptions is a convenience type for handling
@@ -139,6 +133,8 @@ type tokenAccessOptions struct {
 	issuer         string
 	service        string
 	rootCertBundle string
+	jwksURL        string
+	keyRefresh     time.Duration
 }
 
 // checkOptions gathers the necessary options
@@ -146,7 +142,7 @@ type tokenAccessOptions struct {
 func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 	var opts tokenAccessOptions
 
-	keys := []string{"realm", "issuer", "service", "rootcertbundle"}
+	keys := []string{"realm", "issuer", "service"}
 	vals := make([]string, 0, len(keys))
 	for _, key := range keys {
 		val, ok := options[key].(string)
@@ -156,7 +152,46 @@ func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 		vals = append(vals, val)
 	}
 
-	opts.realm, opts.issuer, opts.service, opts.rootCertBundle = vals[0], vals[1], vals[2], vals[3]
+	opts.realm, opts.issuer, opts.service = vals[0], vals[1], vals[2]
+
+	// The trusted key sources are optional individually, but at least one
+	// must be present.
+	for key, dst := range map[string]*string{
+		"rootcertbundle": &opts.rootCertBundle,
+		"jwks":           &opts.jwksURL,
+	} {
+		val, ok := options[key]
+		if !ok || val == nil {
+			continue
+		}
+
+		if *dst, ok = val.(string); !ok {
+			return opts, fmt.Errorf("token auth requires a valid option string: %q", key)
+		}
+	}
+
+	if opts.rootCertBundle == "" && opts.jwksURL == "" {
+		return opts, fmt.Errorf("token auth requires a valid option string: %q or %q", "rootcertbundle", "jwks")
+	}
+
+	opts.keyRefresh = defaultKeyRefreshInterval
+	switch val := options["keyrefresh"].(type) {
+	case nil:
+	case string:
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return opts, fmt.Errorf("token auth option %q is not a valid duration: %s", "keyrefresh", err)
+		}
+		opts.keyRefresh = d
+	case int:
+		opts.keyRefresh = time.Duration(val) * time.Second
+	default:
+		return opts, fmt.Errorf("token auth option %q is not a valid duration: %v", "keyrefresh", val)
+	}
+
+	if opts.keyRefresh <= 0 {
+		return opts, fmt.Errorf("token auth option %q must be positive", "keyrefresh")
+	}
 
 	return opts, nil
 }
@@ -168,54 +203,30 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		return nil, err
 	}
 
-	fp, err := os.Open(config.rootCertBundle)
-	if err != nil {
-		return nil, fmt.Errorf("unable to open token auth root certificate bundle file %q: %s", config.rootCertBundle, err)
-	}
-	defer fp.Close()
-
-	rawCertBundle, err := ioutil.ReadAll(fp)
+	keys, err := newKeyStore(config.rootCertBundle, config.jwksURL)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read token auth root certificate bundle file %q: %s", config.rootCertBundle, err)
-	}
-
-	var rootCerts []*x509.Certificate
-	pemBlock, rawCertBundle := pem.Decode(rawCertBundle)
-	for pemBlock != nil {
-		cert, err := x509.ParseCertificate(pemBlock.Bytes)
-		if err != nil {
-			return nil, fmt.Errorf("unable to parse token auth root certificate: %s", err)
-		}
-
-		rootCerts = append(rootCerts, cert)
-
-		pemBlock, rawCertBundle = pem.Decode(rawCertBundle)
-	}
-
-	if len(rootCerts) == 0 {
-		return nil, errors.New("token auth requires at least one token signing root certificate")
+		return nil, err
 	}
 
-	rootPool := x509.NewCertPool()
-	trustedKeys := make(map[string]libtrust.PublicKey, len(rootCerts))
-	for _, rootCert := range rootCerts {
-		rootPool.AddCert(rootCert)
-		pubKey, err := libtrust.FromCryptoPublicKey(crypto.PublicKey(rootCert.PublicKey))
-		if err != nil {
-			return nil, fmt.Errorf("unable to get public key from token auth root certificate: %s", err)
-		}
-		trustedKeys[pubKey.KeyID()] = pubKey
-	}
+	// Keep the trusted keys current so that signing keys can be rotated
+	// without restarting the registry.
+	go keys.run(config.keyRefresh)
 
 	return &accessController{
-		realm:       config.realm,
-		issuer:      config.issuer,
-		service:     config.service,
-		rootCerts:   rootPool,
-		trustedKeys: trustedKeys,
+		realm:   config.realm,
+		issuer:  config.issuer,
+		service: config.service,
+		keys:    keys,
 	}, nil
 }
 
+// Close stops the periodic refresh of the trusted keys. Tokens are still
+// verified against the keys last loaded.
+func (ac *accessController) Close() error {
+	ac.keys.close()
+	return nil
+}
+
 // Authorized handles checking whether the given request is authorized
 // for actions on resources described by the given access items.
 func (ac *accessController) Authorized(ctx context.Context, accessItems ...auth.Access) (context.Context, error) {
@@ -245,11 +256,12 @@ func (ac *accessController) Authorized(ctx context.Context, accessItems ...auth.
 		return nil, challenge
 	}
 
+	trusted := ac.keys.get()
 	verifyOpts := VerifyOptions{
 		TrustedIssuers:    []string{ac.issuer},
 		AcceptedAudiences: []string{ac.service},
-		Roots:             ac.rootCerts,
-		TrustedKeys:       ac.trustedKeys,
+		Roots:             trusted.rootCerts,
+		TrustedKeys:       trusted.trustedKeys,
 	}
 
 	if err = token.Verify(verifyOpts); err != nil {
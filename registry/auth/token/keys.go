@@ -0,0 +1,266 @@
+package token
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libtrust"
+)
+
+// defaultKeyRefreshInterval is how often the trusted keys are reloaded from
+// their sources if no interval is configured.
+const defaultKeyRefreshInterval = 5 * time.Minute
+
+// trustedKeySet is an immutable snapshot of the roots and keys trusted to sign
+// tokens. A new set is built on every refresh and swapped in as a whole.
+type trustedKeySet struct {
+	rootCerts   *x509.CertPool
+	trustedKeys map[string]libtrust.PublicKey
+}
+
+// keyStore maintains the current trustedKeySet, rebuilding it from the root
+// certificate bundle file and the JWKS url. Readers never block: the current
+// set is held in an atomic.Value and replaced atomically after each refresh.
+type keyStore struct {
+	rootCertBundle string
+	jwksURL        string
+	client         *http.Client
+
+	current atomic.Value // *trustedKeySet
+
+	mu          sync.Mutex // serializes refreshes
+	bundleMtime time.Time
+	bundleCerts []*x509.Certificate
+	bundleKeys  map[string]libtrust.PublicKey
+	jwksKeys    map[string]libtrust.PublicKey
+
+	stop     chan struct{} // closed to stop periodic refreshes
+	stopOnce sync.Once
+}
+
+// newKeyStore creates a keyStore for the provided sources and performs the
+// initial load. At least one of rootCertBundle or jwksURL must be provided and
+// the initial load of every source must succeed and produce at least one
+// trusted key.
+func newKeyStore(rootCertBundle, jwksURL string) (*keyStore, error) {
+	if rootCertBundle == "" && jwksURL == "" {
+		return nil, errors.New("token auth requires a root certificate bundle or a jwks url")
+	}
+
+	ks := &keyStore{
+		rootCertBundle: rootCertBundle,
+		jwksURL:        jwksURL,
+		client:         &http.Client{Timeout: 30 * time.Second},
+		stop:           make(chan struct{}),
+	}
+
+	if err := ks.refresh(); err != nil {
+		return nil, err
+	}
+
+	if len(ks.get().trustedKeys) == 0 {
+		return nil, errors.New("token auth requires at least one token signing root certificate or key")
+	}
+
+	return ks, nil
+}
+
+// get returns the current set of trusted roots and keys.
+func (ks *keyStore) get() *trustedKeySet {
+	return ks.current.Load().(*trustedKeySet)
+}
+
+// refresh reloads the root certificate bundle, if it has changed since the
+// last load, and fetches the jwks url. Sources are refreshed independently:
+// a source that fails keeps the keys it last loaded while the others are
+// updated. Each failure is logged and the first one is returned.
+func (ks *keyStore) refresh() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	var errs []error
+	if ks.rootCertBundle != "" {
+		if err := ks.refreshBundle(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if ks.jwksURL != "" {
+		keys, err := fetchJWKS(ks.client, ks.jwksURL)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			ks.jwksKeys = keys
+		}
+	}
+
+	rootPool := x509.NewCertPool()
+	for _, rootCert := range ks.bundleCerts {
+		rootPool.AddCert(rootCert)
+	}
+
+	trustedKeys := make(map[string]libtrust.PublicKey, len(ks.bundleKeys)+len(ks.jwksKeys))
+	for keyID, pubKey := range ks.bundleKeys {
+		trustedKeys[keyID] = pubKey
+	}
+
+	for keyID, pubKey := range ks.jwksKeys {
+		trustedKeys[keyID] = pubKey
+	}
+
+	ks.current.Store(&trustedKeySet{
+		rootCerts:   rootPool,
+		trustedKeys: trustedKeys,
+	})
+
+	for _, err := range errs {
+		log.Errorf("error refreshing token auth trusted keys: %v", err)
+	}
+
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+// refreshBundle reloads the root certificate bundle if it has changed since
+// the last load. The caller must hold the mutex.
+func (ks *keyStore) refreshBundle() error {
+	fi, err := os.Stat(ks.rootCertBundle)
+	if err != nil {
+		return fmt.Errorf("unable to open token auth root certificate bundle file %q: %s", ks.rootCertBundle, err)
+	}
+
+	if fi.ModTime().Equal(ks.bundleMtime) && ks.bundleCerts != nil {
+		return nil
+	}
+
+	certs, err := loadCertBundle(ks.rootCertBundle)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]libtrust.PublicKey, len(certs))
+	for _, rootCert := range certs {
+		pubKey, err := libtrust.FromCryptoPublicKey(crypto.PublicKey(rootCert.PublicKey))
+		if err != nil {
+			return fmt.Errorf("unable to get public key from token auth root certificate: %s", err)
+		}
+		keys[pubKey.KeyID()] = pubKey
+	}
+
+	ks.bundleCerts, ks.bundleKeys, ks.bundleMtime = certs, keys, fi.ModTime()
+	return nil
+}
+
+// run refreshes the key store at the given interval until close is called.
+// Failures are logged by refresh and the keys of the failed sources are kept.
+func (ks *keyStore) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ks.refresh()
+		case <-ks.stop:
+			return
+		}
+	}
+}
+
+// close stops the periodic refreshes started by run. It may be called more
+// than once.
+func (ks *keyStore) close() {
+	ks.stopOnce.Do(func() {
+		close(ks.stop)
+	})
+}
+
+// loadCertBundle reads and parses all PEM encoded certificates in the file.
+func loadCertBundle(filename string) ([]*x509.Certificate, error) {
+	rawCertBundle, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token auth root certificate bundle file %q: %s", filename, err)
+	}
+
+	var rootCerts []*x509.Certificate
+	pemBlock, rawCertBundle := pem.Decode(rawCertBundle)
+	for pemBlock != nil {
+		cert, err := x509.ParseCertificate(pemBlock.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse token auth root certificate: %s", err)
+		}
+
+		rootCerts = append(rootCerts, cert)
+
+		pemBlock, rawCertBundle = pem.Decode(rawCertBundle)
+	}
+
+	if len(rootCerts) == 0 {
+		return nil, errors.New("token auth requires at least one token signing root certificate")
+	}
+
+	return rootCerts, nil
+}
+
+// fetchJWKS retrieves a JSON Web Key Set from the url. Each key is indexed by
+// its libtrust fingerprint and, if present, by the "kid" assigned by the
+// issuer, so tokens may reference the key by either identifier.
+func fetchJWKS(client *http.Client, url string) (map[string]libtrust.PublicKey, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch token auth jwks %q: %s", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch token auth jwks %q: unexpected status %s", url, resp.Status)
+	}
+
+	var jwks struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("unable to decode token auth jwks %q: %s", url, err)
+	}
+
+	keys := make(map[string]libtrust.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		// libtrust insists that "kid" is its own fingerprint, which is not
+		// true of most issuers. Strip it before parsing and keep it as an
+		// alias instead.
+		kid, _ := jwk["kid"].(string)
+		delete(jwk, "kid")
+
+		p, err := json.Marshal(jwk)
+		if err != nil {
+			return nil, err
+		}
+
+		pubKey, err := libtrust.UnmarshalPublicKeyJWK(p)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse token auth jwks key %q: %s", kid, err)
+		}
+
+		keys[pubKey.KeyID()] = pubKey
+		if kid != "" {
+			keys[kid] = pubKey
+		}
+	}
+
+	return keys, nil
+}
@@ -8,10 +8,13 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -108,6 +111,24 @@ func makeTestToken(issuer, audience string, access []*ResourceActions, rootKey l
 		RawJWK:     json.RawMessage(rawJWK),
 	}
 
+	return signTestToken(issuer, audience, access, joseHeader, signingKey)
+}
+
+// makeKeyIDTestToken makes a token which identifies the signing key only by
+// the provided key ID.
+func makeKeyIDTestToken(issuer, audience string, access []*ResourceActions, signingKey libtrust.PrivateKey, keyID string) (*Token, error) {
+	joseHeader := &Header{
+		Type:       "JWT",
+		SigningAlg: "ES256",
+		KeyID:      keyID,
+	}
+
+	return signTestToken(issuer, audience, access, joseHeader, signingKey)
+}
+
+func signTestToken(issuer, audience string, access []*ResourceActions, joseHeader *Header, signingKey libtrust.PrivateKey) (*Token, error) {
+	var err error
+
 	now := time.Now()
 
 	randomBytes := make([]byte, 15)
@@ -268,6 +289,7 @@ func TestAccessController(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer accessController.(io.Closer).Close()
 
 	// 1. Make a mock http.Request with no token.
 	req, err := http.NewRequest("GET", "http://example.com/foo", nil)
@@ -384,3 +406,235 @@ func TestAccessController(t *testing.T) {
 		t.Fatalf("expected user name %q, got %q", "foo", userInfo.Name)
 	}
 }
+
+// TestAccessControllerJWKS checks that tokens signed by keys published in a
+// JWKS document are accepted by key ID and that rotating the published keys
+// takes effect after a refresh.
+func TestAccessControllerJWKS(t *testing.T) {
+	rootKeys, err := makeRootKeys(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu        sync.Mutex
+		published = rootKeys[0]
+	)
+
+	server := newTestJWKSServer(&mu, &published)
+	defer server.Close()
+
+	issuer := "test-issuer.example.com"
+	service := "test-service.example.com"
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":   "https://auth.example.com/token/",
+		"issuer":  issuer,
+		"service": service,
+		"jwks":    server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		// Closing again must not panic.
+		ac.(io.Closer).Close()
+		ac.(io.Closer).Close()
+	}()
+
+	testAccess := auth.Access{
+		Resource: auth.Resource{
+			Type: "repository",
+			Name: "foo/bar",
+		},
+		Action: "pull",
+	}
+
+	authorize := func(key libtrust.PrivateKey) error {
+		token, err := makeKeyIDTestToken(
+			issuer, service,
+			[]*ResourceActions{{
+				Type:    testAccess.Type,
+				Name:    testAccess.Name,
+				Actions: []string{testAccess.Action},
+			}},
+			key, "issuer-signing-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest("GET", "http://example.com/foo", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.compactRaw()))
+
+		_, err = ac.Authorized(context.WithValue(nil, "http.request", req), testAccess)
+		return err
+	}
+
+	if err := authorize(rootKeys[0]); err != nil {
+		t.Fatalf("unexpected error authorizing token signed by published key: %v", err)
+	}
+
+	if err := authorize(rootKeys[1]); err == nil {
+		t.Fatalf("expected token signed by unpublished key to be rejected")
+	}
+
+	// Rotate the published key.
+	mu.Lock()
+	published = rootKeys[1]
+	mu.Unlock()
+
+	if err := ac.(*accessController).keys.refresh(); err != nil {
+		t.Fatalf("unexpected error refreshing keys: %v", err)
+	}
+
+	if err := authorize(rootKeys[1]); err != nil {
+		t.Fatalf("unexpected error authorizing token signed by rotated key: %v", err)
+	}
+
+	if err := authorize(rootKeys[0]); err == nil {
+		t.Fatalf("expected token signed by retired key to be rejected")
+	}
+}
+
+// newTestJWKSServer serves a JWKS document publishing the public key of the
+// private key pointed to by published under the key ID "issuer-signing-key".
+func newTestJWKSServer(mu *sync.Mutex, published *libtrust.PrivateKey) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		p, err := (*published).PublicKey().MarshalJSON()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var jwk map[string]interface{}
+		if err := json.Unmarshal(p, &jwk); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jwk["kid"] = "issuer-signing-key"
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []interface{}{jwk},
+		})
+	}))
+}
+
+// TestKeyStoreRefreshSources ensures that a failing source does not prevent
+// the other sources from being refreshed.
+func TestKeyStoreRefreshSources(t *testing.T) {
+	rootKeys, err := makeRootKeys(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootCertBundleFilename, err := writeTempRootCerts(rootKeys[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rootCertBundleFilename)
+
+	var (
+		mu        sync.Mutex
+		published = rootKeys[1]
+	)
+	server := newTestJWKSServer(&mu, &published)
+	defer server.Close()
+
+	ks, err := newKeyStore(rootCertBundleFilename, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Remove the bundle and rotate the published key.
+	if err := os.Remove(rootCertBundleFilename); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	published = rootKeys[2]
+	mu.Unlock()
+
+	if err := ks.refresh(); err == nil {
+		t.Fatalf("expected error refreshing keys without the root certificate bundle")
+	}
+
+	trusted := ks.get().trustedKeys
+	if _, ok := trusted[rootKeys[0].KeyID()]; !ok {
+		t.Fatalf("expected key %s of the missing bundle to remain trusted", rootKeys[0].KeyID())
+	}
+
+	if _, ok := trusted[rootKeys[2].KeyID()]; !ok {
+		t.Fatalf("expected rotated key %s to be trusted", rootKeys[2].KeyID())
+	}
+
+	if _, ok := trusted[rootKeys[1].KeyID()]; ok {
+		t.Fatalf("expected retired key %s to be removed", rootKeys[1].KeyID())
+	}
+}
+
+// TestAccessControllerReloadRootCertBundle ensures that changes to the root
+// certificate bundle are picked up on refresh.
+func TestAccessControllerReloadRootCertBundle(t *testing.T) {
+	rootKeys, err := makeRootKeys(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootCertBundleFilename, err := writeTempRootCerts(rootKeys[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rootCertBundleFilename)
+
+	ks, err := newKeyStore(rootCertBundleFilename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := ks.get()
+	if _, ok := before.trustedKeys[rootKeys[0].KeyID()]; !ok {
+		t.Fatalf("expected key %s to be trusted", rootKeys[0].KeyID())
+	}
+
+	// Replace the bundle with the second key, making sure the modification
+	// time changes.
+	replacement, err := writeTempRootCerts(rootKeys[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(replacement)
+
+	if err := os.Rename(replacement, rootCertBundleFilename); err != nil {
+		t.Fatal(err)
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(rootCertBundleFilename, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.refresh(); err != nil {
+		t.Fatalf("unexpected error refreshing keys: %v", err)
+	}
+
+	after := ks.get()
+	if _, ok := after.trustedKeys[rootKeys[1].KeyID()]; !ok {
+		t.Fatalf("expected key %s to be trusted after reload", rootKeys[1].KeyID())
+	}
+
+	if _, ok := after.trustedKeys[rootKeys[0].KeyID()]; ok {
+		t.Fatalf("expected key %s to be removed after reload", rootKeys[0].KeyID())
+	}
+
+	// The previous snapshot must not have been modified in place.
+	if _, ok := before.trustedKeys[rootKeys[1].KeyID()]; ok {
+		t.Fatalf("previous key set was modified by refresh")
+	}
+}
@@ -5,19 +5,24 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"regexp"
+	"runtime"
 	"strconv"
 
 	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/manifestlist"
 	"github.com/docker/distribution/registry/api/v2"
 )
 
 // Client implements the client interface to the registry http api
 type Client interface {
 	// GetImageManifest returns an image manifest for the image at the given
-	// name, tag pair.
+	// name, tag pair. If the tag references a manifest list, the signed
+	// manifest for the platform of the host is returned; entries of other
+	// manifest schemas are ignored.
 	GetImageManifest(name, tag string) (*manifest.SignedManifest, error)
 
 	// PutImageManifest uploads an image manifest for the image at the given
@@ -39,7 +44,8 @@ type Client interface {
 	// GetBlob returns the blob stored at the given name, digest pair in the
 	// form of an io.ReadCloser with the length of this blob.
 	// A nonzero byteOffset can be provided to receive a partial blob beginning
-	// at the given offset.
+	// at the given offset, in which case the length returned is that of the
+	// remainder of the blob.
 	GetBlob(name string, dgst digest.Digest, byteOffset int) (io.ReadCloser, int, error)
 
 	// InitiateBlobUpload starts a blob upload in the given repository namespace
@@ -95,24 +101,112 @@ type clientImpl struct {
 // TODO(bbland): use consistent route generation between server and client
 
 func (r *clientImpl) GetImageManifest(name, tag string) (*manifest.SignedManifest, error) {
-	manifestURL, err := r.ub.BuildManifestURL(name, tag)
+	response, err := r.getManifest(name, tag)
 	if err != nil {
 		return nil, err
 	}
+	defer response.Body.Close()
+
+	decoder := json.NewDecoder(response.Body)
+
+	if mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type")); mediaType == manifestlist.MediaTypeManifestList {
+		var list manifestlist.DeserializedManifestList
+		if err := decoder.Decode(&list); err != nil {
+			return nil, err
+		}
+
+		// Only signed manifests can be returned, so entries of other
+		// schemas are never selected.
+		var supported manifestlist.ManifestList
+		for _, descriptor := range list.Manifests {
+			if descriptor.MediaType == manifest.ManifestMediaType {
+				supported.Manifests = append(supported.Manifests, descriptor)
+			}
+		}
+
+		descriptor, ok := supported.Select(runtime.GOOS, runtime.GOARCH, "")
+		if !ok {
+			return nil, &NoMatchingPlatformError{
+				Name:         name,
+				Tag:          tag,
+				OS:           runtime.GOOS,
+				Architecture: runtime.GOARCH,
+			}
+		}
+
+		platformResponse, err := r.getManifest(name, descriptor.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+		defer platformResponse.Body.Close()
+
+		if !isSignedManifest(platformResponse) {
+			return nil, &UnsupportedManifestMediaTypeError{
+				Name:      name,
+				Reference: descriptor.Digest.String(),
+				MediaType: platformResponse.Header.Get("Content-Type"),
+			}
+		}
+
+		decoder = json.NewDecoder(platformResponse.Body)
+	}
 
-	response, err := http.Get(manifestURL)
+	manifest := new(manifest.SignedManifest)
+	err = decoder.Decode(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// isSignedManifest reports whether the response carries a schema version 1
+// manifest. Those predate manifest media types, so plain json and a missing
+// content type are accepted as well.
+func isSignedManifest(response *http.Response) bool {
+	contentType := response.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == manifest.ManifestMediaType || mediaType == "application/json"
+}
+
+// getManifest requests the manifest at the given name, reference pair,
+// accepting both signed manifests and manifest lists. The caller must close
+// the body of the returned response.
+func (r *clientImpl) getManifest(name, reference string) (*http.Response, error) {
+	manifestURL, err := r.ub.BuildManifestURL(name, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest("GET", manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Add("Accept", manifest.ManifestMediaType)
+	request.Header.Add("Accept", manifestlist.MediaTypeManifestList)
+
+	response, err := http.DefaultClient.Do(request)
 	if err != nil {
 		return nil, err
 	}
-	defer response.Body.Close()
 
 	// TODO(bbland): handle other status codes, like 5xx errors
 	switch {
 	case response.StatusCode == http.StatusOK:
-		break
+		return response, nil
 	case response.StatusCode == http.StatusNotFound:
-		return nil, &ImageManifestNotFoundError{Name: name, Tag: tag}
+		response.Body.Close()
+		return nil, &ImageManifestNotFoundError{Name: name, Tag: reference}
 	case response.StatusCode >= 400 && response.StatusCode < 500:
+		defer response.Body.Close()
+
 		var errs v2.Errors
 
 		decoder := json.NewDecoder(response.Body)
@@ -122,17 +216,9 @@ func (r *clientImpl) GetImageManifest(name, tag string) (*manifest.SignedManifes
 		}
 		return nil, &errs
 	default:
+		response.Body.Close()
 		return nil, &UnexpectedHTTPStatusError{Status: response.Status}
 	}
-
-	decoder := json.NewDecoder(response.Body)
-
-	manifest := new(manifest.SignedManifest)
-	err = decoder.Decode(manifest)
-	if err != nil {
-		return nil, err
-	}
-	return manifest, nil
 }
 
 func (r *clientImpl) PutImageManifest(name, tag string, manifest *manifest.SignedManifest) error {
@@ -298,7 +384,10 @@ func (r *clientImpl) GetBlob(name string, dgst digest.Digest, byteOffset int) (i
 		return nil, 0, err
 	}
 
-	getRequest.Header.Add("Range", fmt.Sprintf("%d-", byteOffset))
+	if byteOffset > 0 {
+		getRequest.Header.Add("Range", fmt.Sprintf("bytes=%d-", byteOffset))
+	}
+
 	response, err := http.DefaultClient.Do(getRequest)
 	if err != nil {
 		return nil, 0, err
@@ -306,7 +395,7 @@ func (r *clientImpl) GetBlob(name string, dgst digest.Digest, byteOffset int) (i
 
 	// TODO(bbland): handle other status codes, like 5xx errors
 	switch {
-	case response.StatusCode == http.StatusOK:
+	case response.StatusCode == http.StatusOK || response.StatusCode == http.StatusPartialContent:
 		lengthHeader := response.Header.Get("Content-Length")
 		length, err := strconv.ParseInt(lengthHeader, 10, 0)
 		if err != nil {
@@ -1,16 +1,22 @@
 package client
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"runtime"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
 	"github.com/docker/distribution/testutil"
 )
 
@@ -50,6 +56,53 @@ func TestRangeHeaderParser(t *testing.T) {
 
 }
 
+func TestGetBlobOffset(t *testing.T) {
+	name := "hello/world"
+	contents := []byte("some random layer data")
+	dgst, err := digest.FromBytes(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ServeContent rejects malformed ranges, and answers a valid one with a
+	// partial response, as the registry does.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/"+name+"/blobs/"+dgst.String() {
+			http.NotFound(w, r)
+			return
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(contents))
+	}))
+	defer server.Close()
+
+	client, err := New(server.URL)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	for _, offset := range []int{0, 5, len(contents) - 1} {
+		rc, length, err := client.GetBlob(name, dgst, offset)
+		if err != nil {
+			t.Fatalf("unexpected error getting blob at offset %d: %v", offset, err)
+		}
+
+		p, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(p) != string(contents[offset:]) {
+			t.Fatalf("unexpected content at offset %d: %q", offset, p)
+		}
+
+		if length != len(contents)-offset {
+			t.Fatalf("unexpected length at offset %d: %d", offset, length)
+		}
+	}
+}
+
 func TestPush(t *testing.T) {
 	name := "hello/world"
 	tag := "sometag"
@@ -290,6 +343,302 @@ func TestPull(t *testing.T) {
 	}
 }
 
+func TestGetImageManifestList(t *testing.T) {
+	name := "hello/world"
+	tag := "multiarch"
+
+	m := &manifest.SignedManifest{
+		Manifest: manifest.Manifest{
+			Name:         name,
+			Tag:          tag,
+			Architecture: runtime.GOARCH,
+			Versioned: manifest.Versioned{
+				SchemaVersion: 1,
+			},
+		},
+	}
+	manifestBytes, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dgst, err := digest.FromBytes(manifestBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := manifestlist.FromDescriptors([]manifestlist.ManifestDescriptor{
+		{
+			Descriptor: distribution.Descriptor{
+				MediaType: manifest.ManifestMediaType,
+				Digest:    "sha256:6346340964309634683409684360934680934608934608934608934068934608",
+			},
+			Platform: manifestlist.PlatformSpec{
+				Architecture: "other",
+				OS:           runtime.GOOS,
+			},
+		},
+		{
+			Descriptor: distribution.Descriptor{
+				MediaType: manifest.ManifestMediaType,
+				Size:      int64(len(manifestBytes)),
+				Digest:    dgst,
+			},
+			Platform: manifestlist.PlatformSpec{
+				Architecture: runtime.GOARCH,
+				OS:           runtime.GOOS,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, listBytes, err := list.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := testutil.NewHandler(testutil.RequestResponseMap{
+		{
+			Request: testutil.Request{
+				Method: "GET",
+				Route:  "/v2/" + name + "/manifests/" + tag,
+			},
+			Response: testutil.Response{
+				StatusCode: http.StatusOK,
+				Headers: http.Header(map[string][]string{
+					"Content-Type": {manifestlist.MediaTypeManifestList},
+				}),
+				Body: listBytes,
+			},
+		},
+		{
+			Request: testutil.Request{
+				Method: "GET",
+				Route:  "/v2/" + name + "/manifests/" + dgst.String(),
+			},
+			Response: testutil.Response{
+				StatusCode: http.StatusOK,
+				Headers: http.Header(map[string][]string{
+					"Content-Type": {"application/json; charset=utf-8"},
+				}),
+				Body: manifestBytes,
+			},
+		},
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client, err := New(server.URL)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	fetched, err := client.GetImageManifest(name, tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(fetched.Raw) != string(manifestBytes) {
+		t.Fatal("Incorrect manifest")
+	}
+
+	// A list without an entry for the host platform can't be pulled.
+	list, err = manifestlist.FromDescriptors(list.Manifests[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, listBytes, err = list.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler = testutil.NewHandler(testutil.RequestResponseMap{
+		{
+			Request: testutil.Request{
+				Method: "GET",
+				Route:  "/v2/" + name + "/manifests/" + tag,
+			},
+			Response: testutil.Response{
+				StatusCode: http.StatusOK,
+				Headers: http.Header(map[string][]string{
+					"Content-Type": {manifestlist.MediaTypeManifestList},
+				}),
+				Body: listBytes,
+			},
+		},
+	})
+	server2 := httptest.NewServer(handler)
+	defer server2.Close()
+
+	client, err = New(server2.URL)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	if _, err := client.GetImageManifest(name, tag); err == nil {
+		t.Fatal("expected error fetching manifest list without matching platform")
+	} else if _, ok := err.(*NoMatchingPlatformError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestGetImageManifestListSchema2(t *testing.T) {
+	name := "hello/world"
+	tag := "multiarch"
+
+	m := &manifest.SignedManifest{
+		Manifest: manifest.Manifest{
+			Name:         name,
+			Tag:          tag,
+			Architecture: runtime.GOARCH,
+			Versioned: manifest.Versioned{
+				SchemaVersion: 1,
+			},
+		},
+	}
+	manifestBytes, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dgst, err := digest.FromBytes(manifestBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema2Digest := digest.Digest("sha256:6346340964309634683409684360934680934608934608934608934068934608")
+	platform := manifestlist.PlatformSpec{
+		Architecture: runtime.GOARCH,
+		OS:           runtime.GOOS,
+	}
+
+	serve := func(descriptors []manifestlist.ManifestDescriptor) *httptest.Server {
+		list, err := manifestlist.FromDescriptors(descriptors)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, listBytes, err := list.Payload()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return httptest.NewServer(testutil.NewHandler(testutil.RequestResponseMap{
+			{
+				Request: testutil.Request{
+					Method: "GET",
+					Route:  "/v2/" + name + "/manifests/" + tag,
+				},
+				Response: testutil.Response{
+					StatusCode: http.StatusOK,
+					Headers: http.Header(map[string][]string{
+						"Content-Type": {manifestlist.MediaTypeManifestList},
+					}),
+					Body: listBytes,
+				},
+			},
+			{
+				Request: testutil.Request{
+					Method: "GET",
+					Route:  "/v2/" + name + "/manifests/" + schema2Digest.String(),
+				},
+				Response: testutil.Response{
+					StatusCode: http.StatusOK,
+					Headers: http.Header(map[string][]string{
+						"Content-Type": {schema2.MediaTypeManifest},
+					}),
+					Body: []byte(`{"schemaVersion":2}`),
+				},
+			},
+			{
+				Request: testutil.Request{
+					Method: "GET",
+					Route:  "/v2/" + name + "/manifests/" + dgst.String(),
+				},
+				Response: testutil.Response{
+					StatusCode: http.StatusOK,
+					Headers: http.Header(map[string][]string{
+						"Content-Type": {"application/json; charset=utf-8"},
+					}),
+					Body: manifestBytes,
+				},
+			},
+		}))
+	}
+
+	schema2Entry := manifestlist.ManifestDescriptor{
+		Descriptor: distribution.Descriptor{
+			MediaType: schema2.MediaTypeManifest,
+			Digest:    schema2Digest,
+		},
+		Platform: platform,
+	}
+	schema1Entry := manifestlist.ManifestDescriptor{
+		Descriptor: distribution.Descriptor{
+			MediaType: manifest.ManifestMediaType,
+			Size:      int64(len(manifestBytes)),
+			Digest:    dgst,
+		},
+		Platform: platform,
+	}
+
+	// The schema2 entry for the host platform is passed over for the signed
+	// manifest that follows it.
+	server := serve([]manifestlist.ManifestDescriptor{schema2Entry, schema1Entry})
+	defer server.Close()
+
+	client, err := New(server.URL)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	fetched, err := client.GetImageManifest(name, tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(fetched.Raw) != string(manifestBytes) {
+		t.Fatal("Incorrect manifest")
+	}
+
+	// With only a schema2 entry for the host platform, there is nothing the
+	// client can pull.
+	server2 := serve([]manifestlist.ManifestDescriptor{schema2Entry})
+	defer server2.Close()
+
+	client, err = New(server2.URL)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	if _, err := client.GetImageManifest(name, tag); err == nil {
+		t.Fatal("expected error fetching manifest list with only a schema2 entry")
+	} else if _, ok := err.(*NoMatchingPlatformError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// An entry claiming to be a signed manifest that resolves to a schema2
+	// manifest is rejected rather than decoded as an empty manifest.
+	mislabeled := schema2Entry
+	mislabeled.MediaType = manifest.ManifestMediaType
+	server3 := serve([]manifestlist.ManifestDescriptor{mislabeled})
+	defer server3.Close()
+
+	client, err = New(server3.URL)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	if _, err := client.GetImageManifest(name, tag); err == nil {
+		t.Fatal("expected error fetching schema2 manifest")
+	} else if _, ok := err.(*UnsupportedManifestMediaTypeError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 func TestPullResume(t *testing.T) {
 	name := "hello/world"
 	tag := "sometag"
@@ -28,6 +28,33 @@ func (e *ImageManifestNotFoundError) Error() string {
 		e.Name, e.Tag)
 }
 
+// NoMatchingPlatformError is returned when a tag references a manifest list
+// without an entry for the platform of the host.
+type NoMatchingPlatformError struct {
+	Name         string
+	Tag          string
+	OS           string
+	Architecture string
+}
+
+func (e *NoMatchingPlatformError) Error() string {
+	return fmt.Sprintf("No manifest found for platform %s/%s in manifest list with Name: %s, Tag: %s",
+		e.OS, e.Architecture, e.Name, e.Tag)
+}
+
+// UnsupportedManifestMediaTypeError is returned when a manifest list entry
+// resolves to a manifest of a schema the client can't decode.
+type UnsupportedManifestMediaTypeError struct {
+	Name      string
+	Reference string
+	MediaType string
+}
+
+func (e *UnsupportedManifestMediaTypeError) Error() string {
+	return fmt.Sprintf("Unsupported manifest media type %q with Name: %s, Reference: %s",
+		e.MediaType, e.Name, e.Reference)
+}
+
 // BlobNotFoundError is returned when making an operation against a given image
 // layer that does not exist in the registry.
 type BlobNotFoundError struct {
@@ -40,6 +67,16 @@ func (e *BlobNotFoundError) Error() string {
 		e.Name, e.Digest)
 }
 
+// BlobDigestMismatchError is returned when the content of a blob does not
+// match its digest.
+type BlobDigestMismatchError struct {
+	Digest digest.Digest
+}
+
+func (e *BlobDigestMismatchError) Error() string {
+	return fmt.Sprintf("Blob content does not match Digest: %s", e.Digest)
+}
+
 // BlobUploadNotFoundError is returned when making a blob upload operation against an
 // invalid blob upload location url.
 // This may be the result of using a cancelled, completed, or stale upload
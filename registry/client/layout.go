@@ -0,0 +1,474 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+)
+
+const (
+	// layoutVersion is written to the layout marker file of image layouts.
+	layoutVersion = "1.0.0"
+
+	layoutMarkerFile = "oci-layout"
+	layoutIndexFile  = "index.json"
+	layoutBlobsDir   = "blobs"
+)
+
+// LayoutImage is an entry of the index of an image layout, describing the
+// manifest stored for a repository name and tag.
+type LayoutImage struct {
+	distribution.Descriptor
+
+	// Name is the repository name of the image.
+	Name string `json:"name"`
+
+	// Tag is the tag of the image.
+	Tag string `json:"tag"`
+}
+
+// layoutIndex is the json format of the index file of an image layout.
+type layoutIndex struct {
+	SchemaVersion int           `json:"schemaVersion"`
+	Manifests     []LayoutImage `json:"manifests"`
+}
+
+// LayoutObjectStore is an ObjectStore keeping images in a self-contained,
+// content-addressable directory, suitable for moving images between
+// registries without network connectivity. Blobs, including manifests, are
+// stored under "blobs/<algorithm>/<hex digest>" and an index of manifests by
+// name and tag is kept in "index.json". Every blob is verified against its
+// digest when written and read.
+type LayoutObjectStore struct {
+	root   string
+	mutex  sync.Mutex
+	layers map[digest.Digest]*layoutLayer
+}
+
+var _ ObjectStore = &LayoutObjectStore{}
+
+// NewLayoutObjectStore returns an ObjectStore for the image layout at root,
+// creating the layout if it does not exist yet.
+func NewLayoutObjectStore(root string) (*LayoutObjectStore, error) {
+	if err := os.MkdirAll(filepath.Join(root, layoutBlobsDir), 0755); err != nil {
+		return nil, err
+	}
+
+	markerPath := filepath.Join(root, layoutMarkerFile)
+	if _, err := os.Stat(markerPath); os.IsNotExist(err) {
+		p, err := json.Marshal(map[string]string{"imageLayoutVersion": layoutVersion})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := ioutil.WriteFile(markerPath, p, 0644); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &LayoutObjectStore{
+		root:   root,
+		layers: make(map[digest.Digest]*layoutLayer),
+	}, nil
+}
+
+// Images lists the images indexed in the layout.
+func (ls *LayoutObjectStore) Images() ([]LayoutImage, error) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	index, err := ls.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	return index.Manifests, nil
+}
+
+// Manifest reads the manifest indexed for the name and tag, verifying it
+// against its digest.
+func (ls *LayoutObjectStore) Manifest(name, tag string) (*manifest.SignedManifest, error) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	index, err := ls.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, image := range index.Manifests {
+		if image.Name != name || image.Tag != tag {
+			continue
+		}
+
+		p, err := ioutil.ReadFile(ls.blobPath(image.Digest))
+		if err != nil {
+			return nil, err
+		}
+
+		verifier, err := digest.NewDigestVerifier(image.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		verifier.Write(p)
+		if !verifier.Verified() {
+			return nil, &BlobDigestMismatchError{Digest: image.Digest}
+		}
+
+		var sm manifest.SignedManifest
+		if err := json.Unmarshal(p, &sm); err != nil {
+			return nil, err
+		}
+
+		return &sm, nil
+	}
+
+	return nil, &ImageManifestNotFoundError{Name: name, Tag: tag}
+}
+
+// WriteManifest stores the manifest as a blob and indexes it for the name
+// and tag, replacing any manifest previously indexed for them.
+func (ls *LayoutObjectStore) WriteManifest(name, tag string, sm *manifest.SignedManifest) error {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	dgst, err := digest.FromBytes(sm.Raw)
+	if err != nil {
+		return err
+	}
+
+	blobPath := ls.blobPath(dgst)
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(blobPath, sm.Raw, 0644); err != nil {
+		return err
+	}
+
+	index, err := ls.readIndex()
+	if err != nil {
+		return err
+	}
+
+	image := LayoutImage{
+		Descriptor: distribution.Descriptor{
+			MediaType: manifest.ManifestMediaType,
+			Size:      int64(len(sm.Raw)),
+			Digest:    dgst,
+		},
+		Name: name,
+		Tag:  tag,
+	}
+
+	replaced := false
+	for i := range index.Manifests {
+		if index.Manifests[i].Name == name && index.Manifests[i].Tag == tag {
+			index.Manifests[i] = image
+			replaced = true
+		}
+	}
+
+	if !replaced {
+		index.Manifests = append(index.Manifests, image)
+	}
+
+	return ls.writeIndex(index)
+}
+
+// Layer returns a handle to the layer blob with the digest.
+func (ls *LayoutObjectStore) Layer(dgst digest.Digest) (Layer, error) {
+	if err := dgst.Validate(); err != nil {
+		return nil, err
+	}
+
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	layer, ok := ls.layers[dgst]
+	if !ok {
+		layer = &layoutLayer{
+			path:   ls.blobPath(dgst),
+			digest: dgst,
+			cond:   sync.NewCond(new(sync.Mutex)),
+		}
+		ls.layers[dgst] = layer
+	}
+
+	return layer, nil
+}
+
+// blobPath returns the path of the blob with the digest in the layout.
+func (ls *LayoutObjectStore) blobPath(dgst digest.Digest) string {
+	return filepath.Join(ls.root, layoutBlobsDir, dgst.Algorithm(), dgst.Hex())
+}
+
+// readIndex reads the index of the layout. A missing index is empty. The
+// caller must hold the mutex.
+func (ls *LayoutObjectStore) readIndex() (layoutIndex, error) {
+	index := layoutIndex{SchemaVersion: 2}
+
+	p, err := ioutil.ReadFile(filepath.Join(ls.root, layoutIndexFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+
+		return index, err
+	}
+
+	if err := json.Unmarshal(p, &index); err != nil {
+		return index, err
+	}
+
+	return index, nil
+}
+
+// writeIndex replaces the index of the layout. The caller must hold the
+// mutex.
+func (ls *LayoutObjectStore) writeIndex(index layoutIndex) error {
+	p, err := json.MarshalIndent(index, "", "   ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(ls.root, layoutIndexFile), p)
+}
+
+// writeFileAtomic writes the file through a temporary file in the same
+// directory, so that readers never observe partial content.
+func writeFileAtomic(filename string, p []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(p); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
+
+// layoutLayer is a layer blob of an image layout. Layers are written to a
+// temporary file, which is moved in place once its content is verified.
+type layoutLayer struct {
+	path    string
+	digest  digest.Digest
+	cond    *sync.Cond
+	writing bool
+}
+
+func (ll *layoutLayer) Reader() (LayerReader, error) {
+	ll.cond.L.Lock()
+	defer ll.cond.L.Unlock()
+
+	if ll.writing {
+		return nil, ErrLayerLocked
+	}
+
+	f, err := os.Open(ll.path)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	verifier, err := digest.NewDigestVerifier(ll.digest)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &layoutLayerReader{
+		file:     f,
+		digest:   ll.digest,
+		size:     int(fi.Size()),
+		verifier: verifier,
+	}, nil
+}
+
+func (ll *layoutLayer) Writer() (LayerWriter, error) {
+	ll.cond.L.Lock()
+	defer ll.cond.L.Unlock()
+
+	if ll.writing {
+		return nil, ErrLayerLocked
+	}
+
+	if _, err := os.Stat(ll.path); err == nil {
+		return nil, ErrLayerAlreadyExists
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ll.path), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(ll.path), filepath.Base(ll.path))
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := digest.NewDigestVerifier(ll.digest)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	ll.writing = true
+	return &layoutLayerWriter{
+		ll:       ll,
+		file:     f,
+		verifier: verifier,
+	}, nil
+}
+
+func (ll *layoutLayer) Wait() error {
+	ll.cond.L.Lock()
+	defer ll.cond.L.Unlock()
+
+	for ll.writing {
+		ll.cond.Wait()
+	}
+
+	if _, err := os.Stat(ll.path); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// layoutLayerReader reads a layer blob, failing at the end of the content if
+// it does not match the digest of the blob.
+type layoutLayerReader struct {
+	file     *os.File
+	digest   digest.Digest
+	size     int
+	verifier digest.Verifier
+}
+
+func (llr *layoutLayerReader) Read(p []byte) (int, error) {
+	n, err := llr.file.Read(p)
+	llr.verifier.Write(p[:n])
+
+	if err == io.EOF && !llr.verifier.Verified() {
+		return n, &BlobDigestMismatchError{Digest: llr.digest}
+	}
+
+	return n, err
+}
+
+func (llr *layoutLayerReader) Close() error {
+	return llr.file.Close()
+}
+
+func (llr *layoutLayerReader) CurrentSize() int {
+	return llr.size
+}
+
+func (llr *layoutLayerReader) Size() int {
+	return llr.size
+}
+
+// layoutLayerWriter writes a layer blob to a temporary file. The write
+// completing the layer fails if the content does not match the digest.
+type layoutLayerWriter struct {
+	ll       *layoutLayer
+	file     *os.File
+	verifier digest.Verifier
+	size     int
+	written  int
+	verified bool
+}
+
+func (llw *layoutLayerWriter) Write(p []byte) (int, error) {
+	if llw.size == 0 {
+		return 0, fmt.Errorf("Must set size before writing to layer")
+	}
+
+	if llw.written+len(p) > llw.size {
+		return 0, fmt.Errorf("Write exceeds layer size %d", llw.size)
+	}
+
+	n, err := llw.file.Write(p)
+	llw.written += n
+	llw.verifier.Write(p[:n])
+	if err != nil {
+		return n, err
+	}
+
+	if llw.written == llw.size {
+		if !llw.verifier.Verified() {
+			return n, &BlobDigestMismatchError{Digest: llw.ll.digest}
+		}
+
+		llw.verified = true
+	}
+
+	return n, nil
+}
+
+// Close moves the layer in place if its complete content was verified and
+// discards it otherwise.
+func (llw *layoutLayerWriter) Close() error {
+	llw.ll.cond.L.Lock()
+	defer llw.ll.cond.L.Unlock()
+
+	defer func() {
+		llw.ll.writing = false
+		llw.ll.cond.Broadcast()
+	}()
+
+	err := llw.file.Close()
+	if err == nil && llw.verified {
+		err = os.Rename(llw.file.Name(), llw.ll.path)
+	}
+
+	if err != nil || !llw.verified {
+		os.Remove(llw.file.Name())
+	}
+
+	return err
+}
+
+func (llw *layoutLayerWriter) CurrentSize() int {
+	return llw.written
+}
+
+func (llw *layoutLayerWriter) Size() int {
+	return llw.size
+}
+
+func (llw *layoutLayerWriter) SetSize(size int) error {
+	if !llw.ll.writing {
+		return fmt.Errorf("Layer is closed for writing")
+	}
+
+	llw.size = size
+	return nil
+}
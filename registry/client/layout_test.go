@@ -0,0 +1,197 @@
+package client
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/registry/handlers"
+	_ "github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/docker/distribution/testutil"
+	"github.com/docker/libtrust"
+	"golang.org/x/net/context"
+)
+
+// uploadCountingHandler counts the blob uploads started through it.
+type uploadCountingHandler struct {
+	http.Handler
+
+	mu      sync.Mutex
+	uploads int
+}
+
+func (h *uploadCountingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/blobs/uploads/") {
+		h.mu.Lock()
+		h.uploads++
+		h.mu.Unlock()
+	}
+
+	h.Handler.ServeHTTP(w, r)
+}
+
+func newTestRegistry(t *testing.T) (Client, *uploadCountingHandler) {
+	app := handlers.NewApp(context.Background(), configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+	})
+
+	handler := &uploadCountingHandler{Handler: app}
+	server := httptest.NewServer(handler)
+
+	c, err := New(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error creating client: %v", err)
+	}
+
+	return c, handler
+}
+
+func TestLayoutExportImport(t *testing.T) {
+	name := "hello/world"
+	tag := "sometag"
+
+	source, _ := newTestRegistry(t)
+
+	m := manifest.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 1,
+		},
+		Name: name,
+		Tag:  tag,
+	}
+
+	for i := 0; i < 2; i++ {
+		rs, ds, err := testutil.CreateRandomTarFile()
+		if err != nil {
+			t.Fatalf("unexpected error generating test layer file: %v", err)
+		}
+		dgst := digest.Digest(ds)
+
+		length, err := rs.Seek(0, os.SEEK_END)
+		if err != nil {
+			t.Fatalf("unexpected error seeking layer: %v", err)
+		}
+		rs.Seek(0, os.SEEK_SET)
+
+		location, err := source.InitiateBlobUpload(name)
+		if err != nil {
+			t.Fatalf("unexpected error initiating upload: %v", err)
+		}
+
+		if err := source.UploadBlob(location, ioutil.NopCloser(rs), int(length), dgst); err != nil {
+			t.Fatalf("unexpected error uploading layer: %v", err)
+		}
+
+		m.FSLayers = append(m.FSLayers, manifest.FSLayer{BlobSum: dgst})
+		m.History = append(m.History, manifest.History{V1Compatibility: dgst.String()})
+	}
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	sm, err := manifest.Sign(&m, pk)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+
+	if err := source.PutImageManifest(name, tag, sm); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	root, err := ioutil.TempDir("", "layout")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	exported, err := NewLayoutObjectStore(root)
+	if err != nil {
+		t.Fatalf("unexpected error creating layout: %v", err)
+	}
+
+	if err := Pull(source, exported, name, tag); err != nil {
+		t.Fatalf("unexpected error exporting image: %v", err)
+	}
+
+	// A fresh store must find the image through the index alone.
+	imported, err := NewLayoutObjectStore(root)
+	if err != nil {
+		t.Fatalf("unexpected error opening layout: %v", err)
+	}
+
+	images, err := imported.Images()
+	if err != nil {
+		t.Fatalf("unexpected error listing images: %v", err)
+	}
+
+	if len(images) != 1 || images[0].Name != name || images[0].Tag != tag || images[0].MediaType != manifest.ManifestMediaType {
+		t.Fatalf("unexpected images in layout: %#v", images)
+	}
+
+	destination, counter := newTestRegistry(t)
+	for i := 0; i < 2; i++ {
+		if err := Push(destination, imported, name, tag); err != nil {
+			t.Fatalf("unexpected error importing image: %v", err)
+		}
+	}
+
+	// Blobs already present are skipped on the second import.
+	if counter.uploads != len(m.FSLayers) {
+		t.Fatalf("unexpected number of uploads: %d != %d", counter.uploads, len(m.FSLayers))
+	}
+
+	fetched, err := destination.GetImageManifest(name, tag)
+	if err != nil {
+		t.Fatalf("unexpected error fetching imported manifest: %v", err)
+	}
+
+	if !reflect.DeepEqual(fetched.FSLayers, m.FSLayers) {
+		t.Fatalf("unexpected layers in imported manifest: %v != %v", fetched.FSLayers, m.FSLayers)
+	}
+
+	// Corrupting a blob must fail the import.
+	blobPath := imported.blobPath(m.FSLayers[0].BlobSum)
+	p, err := ioutil.ReadFile(blobPath)
+	if err != nil {
+		t.Fatalf("unexpected error reading blob: %v", err)
+	}
+	p[len(p)/2] ^= 0xff
+	if err := ioutil.WriteFile(blobPath, p, 0644); err != nil {
+		t.Fatalf("unexpected error writing blob: %v", err)
+	}
+
+	layer, err := imported.Layer(m.FSLayers[0].BlobSum)
+	if err != nil {
+		t.Fatalf("unexpected error getting layer: %v", err)
+	}
+
+	reader, err := layer.Reader()
+	if err != nil {
+		t.Fatalf("unexpected error reading layer: %v", err)
+	}
+	defer reader.Close()
+
+	if _, err := io.Copy(ioutil.Discard, reader); err == nil {
+		t.Fatalf("expected error reading corrupted layer")
+	} else if _, ok := err.(*BlobDigestMismatchError); !ok {
+		t.Fatalf("unexpected error reading corrupted layer: %v", err)
+	}
+
+	other, _ := newTestRegistry(t)
+	if err := Push(other, imported, name, tag); err == nil {
+		t.Fatalf("expected error importing corrupted layout")
+	}
+}
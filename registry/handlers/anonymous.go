@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"github.com/docker/distribution/glob"
+	"github.com/docker/distribution/registry/auth"
+)
+
+// anonymousAllowed returns true if all of the requested access is granted by
+// the anonymous access rules. Requests without any access, such as the base
+// route, are left to the access controller so that clients can still be
+// challenged to log in.
+func (app *App) anonymousAllowed(accessRecords []auth.Access) bool {
+	if app.anonymous == nil || len(accessRecords) == 0 {
+		return false
+	}
+
+	for _, access := range accessRecords {
+		if access.Type != "repository" ||
+			!glob.Contains(app.anonymous.Actions, access.Action) ||
+			(len(app.anonymous.Repositories) > 0 && !glob.MatchAny(app.anonymous.Repositories, access.Name)) {
+			return false
+		}
+	}
+
+	return true
+}
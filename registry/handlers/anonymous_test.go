@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+)
+
+// TestAnonymousAccess ensures that anonymous access rules grant matching
+// requests without authentication, while other requests are challenged by
+// the access controller.
+func TestAnonymousAccess(t *testing.T) {
+	env := newTestEnvWithConfig(t, &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+			"anonymous": {
+				"actions":      []string{"pull"},
+				"repositories": []string{"public/*"},
+			},
+		},
+	})
+
+	tagsURL := func(name string) string {
+		u, err := env.builder.BuildTagsURL(name)
+		if err != nil {
+			t.Fatalf("unexpected error building tags url: %v", err)
+		}
+		return u
+	}
+
+	uploadURL, err := env.builder.BuildBlobUploadURL("public/foo")
+	if err != nil {
+		t.Fatalf("unexpected error building upload url: %v", err)
+	}
+
+	baseURL, err := env.builder.BuildBaseURL()
+	if err != nil {
+		t.Fatalf("unexpected error building base url: %v", err)
+	}
+
+	for _, testcase := range []struct {
+		description string
+		method      string
+		url         string
+		status      int
+	}{
+		{
+			description: "anonymous pull of public repository",
+			method:      "GET",
+			url:         tagsURL("public/foo"),
+			status:      http.StatusNotFound, // allowed, but the repository is empty
+		},
+		{
+			description: "anonymous pull of private repository",
+			method:      "GET",
+			url:         tagsURL("private/foo"),
+			status:      http.StatusUnauthorized,
+		},
+		{
+			description: "anonymous push to public repository",
+			method:      "POST",
+			url:         uploadURL,
+			status:      http.StatusUnauthorized,
+		},
+		{
+			description: "anonymous base route",
+			method:      "GET",
+			url:         baseURL,
+			status:      http.StatusUnauthorized,
+		},
+	} {
+		req, err := http.NewRequest(testcase.method, testcase.url, nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %v", err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error during %s: %v", testcase.description, err)
+		}
+		resp.Body.Close()
+
+		checkResponse(t, testcase.description, resp, testcase.status)
+	}
+}
+
+// TestAnonymousAccessAuthenticated ensures that authenticated requests to
+// repositories open to anonymous access keep their user.
+func TestAnonymousAccessAuthenticated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anonymous-test-")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	env := newTestEnvWithConfig(t, &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+			"anonymous": {
+				"actions":      []string{"pull"},
+				"repositories": []string{"public/*"},
+			},
+		},
+		Audit: configuration.Audit{
+			Backend:   "file",
+			File:      configuration.AuditFile{Path: path},
+			HashChain: true,
+		},
+	})
+
+	u, err := env.builder.BuildTagsURL("public/foo")
+	if err != nil {
+		t.Fatalf("unexpected error building tags url: %v", err)
+	}
+
+	for _, authorization := range []string{"", "Bearer sillytoken"} {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %v", err)
+		}
+
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error pulling tags: %v", err)
+		}
+		resp.Body.Close()
+
+		checkResponse(t, "pulling public tags", resp, http.StatusNotFound)
+	}
+
+	records := readAuditLog(t, path)
+	if len(records) != 2 {
+		t.Fatalf("unexpected number of audit records: %d != 2: %#v", len(records), records)
+	}
+
+	if records[0].Actor != "" || records[0].Reason != "anonymous" {
+		t.Fatalf("unexpected record of anonymous pull: %#v", records[0])
+	}
+
+	if records[1].Actor != "silly" || records[1].Reason != "" {
+		t.Fatalf("unexpected record of authenticated pull: %#v", records[1])
+	}
+}
@@ -13,12 +13,16 @@ import (
 	"os"
 	"path"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 
+	"github.com/docker/distribution"
 	"github.com/docker/distribution/configuration"
 	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
 	"github.com/docker/distribution/registry/api/v2"
 	_ "github.com/docker/distribution/registry/storage/driver/inmemory"
 	"github.com/docker/distribution/testutil"
@@ -351,7 +355,7 @@ func TestManifestAPI(t *testing.T) {
 		t.Fatalf("unexpected error signing manifest: %v", err)
 	}
 
-	payload, err := signedManifest.Payload()
+	payload, err := signedManifest.Canonical()
 	checkErr(t, err, "getting manifest payload")
 
 	dgst, err := digest.FromBytes(payload)
@@ -450,6 +454,760 @@ func TestManifestAPI(t *testing.T) {
 	}
 }
 
+// TestManifestAPISchema2 pushes a schema 2 manifest and ensures it is only
+// served by tag to clients accepting its media type.
+func TestManifestAPISchema2(t *testing.T) {
+	env := newTestEnv(t)
+
+	imageName := "foo/bar"
+	tag := "schema2"
+
+	manifestURL, err := env.builder.BuildManifestURL(imageName, tag)
+	checkErr(t, err, "building manifest url")
+
+	config := []byte(`{"architecture": "amd64", "os": "linux"}`)
+	configDigest, err := digest.FromBytes(config)
+	checkErr(t, err, "digesting config")
+
+	m := schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		MediaType: schema2.MediaTypeManifest,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeConfig,
+			Size:      int64(len(config)),
+			Digest:    configDigest,
+		},
+	}
+
+	rs, dgstStr, err := testutil.CreateRandomTarFile()
+	checkErr(t, err, "creating random layer")
+	layerDigest := digest.Digest(dgstStr)
+
+	m.Layers = append(m.Layers, distribution.Descriptor{
+		MediaType: schema2.MediaTypeLayer,
+		Digest:    layerDigest,
+	})
+
+	deserialized, err := schema2.FromStruct(m)
+	checkErr(t, err, "creating schema 2 manifest")
+
+	// ------------------------------------
+	// Push with missing blobs should fail.
+	resp := putManifest(t, "putting schema 2 manifest with missing blobs", manifestURL, deserialized)
+	defer resp.Body.Close()
+	checkResponse(t, "putting schema 2 manifest with missing blobs", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "putting schema 2 manifest with missing blobs", resp, v2.ErrorCodeBlobUnknown)
+
+	// ------------------------------------
+	// Push with an unregistered content type should fail.
+	_, payload, err := deserialized.Payload()
+	checkErr(t, err, "getting manifest payload")
+
+	req, err := http.NewRequest("PUT", manifestURL, bytes.NewReader(payload))
+	checkErr(t, err, "creating request")
+	req.Header.Set("Content-Type", "application/vnd.example.unknown+json")
+
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "putting manifest with unknown content type")
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest with unknown content type", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "putting manifest with unknown content type", resp, v2.ErrorCodeManifestInvalid)
+
+	uploadURLBase, _ := startPushLayer(t, env.builder, imageName)
+	pushLayer(t, env.builder, imageName, configDigest, uploadURLBase, bytes.NewReader(config))
+
+	uploadURLBase, _ = startPushLayer(t, env.builder, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, rs)
+
+	dgst, err := digest.FromBytes(payload)
+	checkErr(t, err, "digesting manifest")
+
+	manifestDigestURL, err := env.builder.BuildManifestURL(imageName, dgst.String())
+	checkErr(t, err, "building manifest url")
+
+	resp = putManifest(t, "putting schema 2 manifest", manifestURL, deserialized)
+	defer resp.Body.Close()
+	checkResponse(t, "putting schema 2 manifest", resp, http.StatusAccepted)
+	checkHeaders(t, resp, http.Header{
+		"Location":              []string{manifestDigestURL},
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+
+	// ------------------------------------------------------------
+	// Fetch by tag without accepting schema 2 should not return it.
+	resp, err = http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest by tag")
+	defer resp.Body.Close()
+
+	checkResponse(t, "fetching schema 2 manifest without accept", resp, http.StatusNotAcceptable)
+	checkBodyHasErrorCodes(t, "fetching schema 2 manifest without accept", resp, v2.ErrorCodeManifestNotAcceptable)
+
+	// ------------------------------------------------------------
+	// Wildcard ranges accept schema 2.
+	for _, accept := range []string{"*/*", "application/*; q=0.5"} {
+		req, err := http.NewRequest("GET", manifestURL, nil)
+		checkErr(t, err, "creating request")
+		req.Header.Set("Accept", accept)
+
+		resp, err = http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching manifest by tag")
+		defer resp.Body.Close()
+
+		checkResponse(t, "fetching schema 2 manifest accepting "+accept, resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Content-Type": []string{schema2.MediaTypeManifest},
+		})
+	}
+
+	// ----------------------------------
+	// Fetch by tag accepting schema 2.
+	req, err = http.NewRequest("GET", manifestURL, nil)
+	checkErr(t, err, "creating request")
+	req.Header.Add("Accept", manifest.ManifestMediaType)
+	req.Header.Add("Accept", schema2.MediaTypeManifest+"; q=0.9, application/json")
+
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching manifest by tag")
+	defer resp.Body.Close()
+
+	checkResponse(t, "fetching schema 2 manifest", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Content-Type":          []string{schema2.MediaTypeManifest},
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+
+	body, err := ioutil.ReadAll(resp.Body)
+	checkErr(t, err, "reading response body")
+
+	if !bytes.Equal(body, payload) {
+		t.Fatalf("manifests do not match")
+	}
+
+	// -----------------------------------------------------------
+	// Fetch by digest returns the manifest without negotiation.
+	resp, err = http.Get(manifestDigestURL)
+	checkErr(t, err, "fetching manifest by digest")
+	defer resp.Body.Close()
+
+	checkResponse(t, "fetching schema 2 manifest by digest", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Content-Type":          []string{schema2.MediaTypeManifest},
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+
+	var fetched schema2.DeserializedManifest
+	if err := json.NewDecoder(resp.Body).Decode(&fetched); err != nil {
+		t.Fatalf("error decoding fetched manifest: %v", err)
+	}
+
+	if !reflect.DeepEqual(fetched.Manifest, m) {
+		t.Fatalf("fetched manifest not equal: %#v != %#v", fetched.Manifest, m)
+	}
+}
+
+// TestManifestAPIManifestList pushes platform manifests and a manifest list
+// referencing them, ensuring the list is only accepted once all referenced
+// manifests are present.
+func TestManifestAPIManifestList(t *testing.T) {
+	env := newTestEnv(t)
+
+	imageName := "foo/bar"
+	tag := "multiarch"
+
+	manifestURL, err := env.builder.BuildManifestURL(imageName, tag)
+	checkErr(t, err, "building manifest url")
+
+	var descriptors []manifestlist.ManifestDescriptor
+	for _, architecture := range []string{"amd64", "arm"} {
+		config := []byte(`{"architecture": "` + architecture + `", "os": "linux"}`)
+		configDigest, err := digest.FromBytes(config)
+		checkErr(t, err, "digesting config")
+
+		uploadURLBase, _ := startPushLayer(t, env.builder, imageName)
+		pushLayer(t, env.builder, imageName, configDigest, uploadURLBase, bytes.NewReader(config))
+
+		deserialized, err := schema2.FromStruct(schema2.Manifest{
+			Versioned: schema2.SchemaVersion,
+			MediaType: schema2.MediaTypeManifest,
+			Config: distribution.Descriptor{
+				MediaType: schema2.MediaTypeConfig,
+				Size:      int64(len(config)),
+				Digest:    configDigest,
+			},
+		})
+		checkErr(t, err, "creating schema 2 manifest")
+
+		_, payload, err := deserialized.Payload()
+		checkErr(t, err, "getting manifest payload")
+
+		dgst, err := digest.FromBytes(payload)
+		checkErr(t, err, "digesting manifest")
+
+		descriptors = append(descriptors, manifestlist.ManifestDescriptor{
+			Descriptor: distribution.Descriptor{
+				MediaType: schema2.MediaTypeManifest,
+				Size:      int64(len(payload)),
+				Digest:    dgst,
+			},
+			Platform: manifestlist.PlatformSpec{
+				Architecture: architecture,
+				OS:           "linux",
+			},
+		})
+
+		if architecture == "arm" {
+			// Leave the arm manifest unpushed for now.
+			continue
+		}
+
+		digestURL, err := env.builder.BuildManifestURL(imageName, dgst.String())
+		checkErr(t, err, "building manifest url")
+
+		resp := putManifest(t, "putting platform manifest", digestURL, deserialized)
+		defer resp.Body.Close()
+		checkResponse(t, "putting platform manifest", resp, http.StatusAccepted)
+	}
+
+	list, err := manifestlist.FromDescriptors(descriptors)
+	checkErr(t, err, "creating manifest list")
+
+	resp := putManifest(t, "putting manifest list with unknown manifest", manifestURL, list)
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest list with unknown manifest", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "putting manifest list with unknown manifest", resp, v2.ErrorCodeManifestUnknown)
+
+	list, err = manifestlist.FromDescriptors(descriptors[:1])
+	checkErr(t, err, "creating manifest list")
+
+	_, payload, err := list.Payload()
+	checkErr(t, err, "getting manifest list payload")
+
+	dgst, err := digest.FromBytes(payload)
+	checkErr(t, err, "digesting manifest list")
+
+	resp = putManifest(t, "putting manifest list", manifestURL, list)
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest list", resp, http.StatusAccepted)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+
+	// Clients only accepting schema 2 manifests can't use the list.
+	req, err := http.NewRequest("GET", manifestURL, nil)
+	checkErr(t, err, "creating request")
+	req.Header.Set("Accept", schema2.MediaTypeManifest)
+
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching manifest list")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest list without accept", resp, http.StatusNotAcceptable)
+
+	req.Header.Set("Accept", schema2.MediaTypeManifest+", "+manifestlist.MediaTypeManifestList)
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching manifest list")
+	defer resp.Body.Close()
+
+	checkResponse(t, "fetching manifest list", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Content-Type":          []string{manifestlist.MediaTypeManifestList},
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+
+	body, err := ioutil.ReadAll(resp.Body)
+	checkErr(t, err, "reading response body")
+
+	if !bytes.Equal(body, payload) {
+		t.Fatalf("manifest lists do not match")
+	}
+}
+
+// TestTagAPI pushes a manifest by digest and manages its tags through the
+// tag endpoints, without uploading the manifest again.
+func TestTagAPI(t *testing.T) {
+	env := newTestEnv(t)
+
+	imageName := "foo/bar"
+
+	deserialized, dgst := createSchema2Manifest(t, env, imageName, "amd64")
+
+	_, payload, err := deserialized.Payload()
+	checkErr(t, err, "getting manifest payload")
+
+	manifestDigestURL, err := env.builder.BuildManifestURL(imageName, dgst.String())
+	checkErr(t, err, "building manifest url")
+
+	tagURL, err := env.builder.BuildTagURL(imageName, "stable")
+	checkErr(t, err, "building tag url")
+
+	doTagRequest := func(method string, body string) *http.Response {
+		req, err := http.NewRequest(method, tagURL, strings.NewReader(body))
+		checkErr(t, err, "creating tag request")
+
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "issuing tag request")
+		return resp
+	}
+
+	tagBody := fmt.Sprintf(`{"digest": %q}`, dgst)
+
+	// -----------------------------------------
+	// Tagging an unknown manifest should fail.
+	resp := doTagRequest("PUT", tagBody)
+	defer resp.Body.Close()
+	checkResponse(t, "tagging unknown manifest", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "tagging unknown manifest", resp, v2.ErrorCodeManifestUnknown)
+
+	resp = doTagRequest("PUT", `{"digest": "notadigest"}`)
+	defer resp.Body.Close()
+	checkResponse(t, "tagging with invalid digest", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "tagging with invalid digest", resp, v2.ErrorCodeDigestInvalid)
+
+	// -----------------------------------------------------
+	// Push by digest, then tag the manifest without re-upload.
+	resp = putManifest(t, "putting manifest by digest", manifestDigestURL, deserialized)
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest by digest", resp, http.StatusAccepted)
+
+	resp = doTagRequest("GET", "")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching missing tag", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching missing tag", resp, v2.ErrorCodeManifestUnknown)
+
+	manifestTagURL, err := env.builder.BuildManifestURL(imageName, "stable")
+	checkErr(t, err, "building manifest url")
+
+	resp = doTagRequest("PUT", tagBody)
+	defer resp.Body.Close()
+	checkResponse(t, "tagging manifest", resp, http.StatusCreated)
+	checkHeaders(t, resp, http.Header{
+		"Location":              []string{manifestTagURL},
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+
+	resp = doTagRequest("GET", "")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching tag", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+
+	var desc distribution.Descriptor
+	if err := json.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		t.Fatalf("error decoding tag descriptor: %v", err)
+	}
+
+	expected := distribution.Descriptor{
+		MediaType: schema2.MediaTypeManifest,
+		Size:      int64(len(payload)),
+		Digest:    dgst,
+	}
+
+	if desc != expected {
+		t.Fatalf("unexpected tag descriptor: %#v != %#v", desc, expected)
+	}
+
+	tagsURL, err := env.builder.BuildTagsURL(imageName)
+	checkErr(t, err, "building tags url")
+
+	resp, err = http.Get(tagsURL)
+	checkErr(t, err, "getting tags")
+	defer resp.Body.Close()
+	checkResponse(t, "getting tags", resp, http.StatusOK)
+
+	var tagsResponse tagsAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tagsResponse); err != nil {
+		t.Fatalf("unexpected error decoding tags response: %v", err)
+	}
+
+	if !reflect.DeepEqual(tagsResponse.Tags, []string{"stable"}) {
+		t.Fatalf("unexpected tags: %v", tagsResponse.Tags)
+	}
+
+	// --------------------------------------------------------
+	// Untagging leaves the manifest available by digest only.
+	resp = doTagRequest("DELETE", "")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting tag", resp, http.StatusAccepted)
+
+	resp = doTagRequest("DELETE", "")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting missing tag", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "deleting missing tag", resp, v2.ErrorCodeManifestUnknown)
+
+	resp, err = http.Get(manifestTagURL)
+	checkErr(t, err, "fetching manifest by tag")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest by deleted tag", resp, http.StatusNotFound)
+
+	resp, err = http.Get(manifestDigestURL)
+	checkErr(t, err, "fetching manifest by digest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching untagged manifest by digest", resp, http.StatusOK)
+}
+
+// TestImmutableTagAPI ensures tags protected by an immutability rule cannot be
+// moved or removed through any endpoint, while re-pushing the same manifest
+// succeeds.
+func TestTagHistoryAPI(t *testing.T) {
+	env := newTestEnv(t)
+
+	imageName := "foo/bar"
+
+	first, firstDigest := createSchema2Manifest(t, env, imageName, "amd64")
+	second, secondDigest := createSchema2Manifest(t, env, imageName, "arm64")
+
+	historyURL, err := env.builder.BuildTagHistoryURL(imageName, "latest")
+	checkErr(t, err, "building tag history url")
+
+	resp, err := http.Get(historyURL)
+	checkErr(t, err, "fetching tag history")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching history of missing tag", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching history of missing tag", resp, v2.ErrorCodeManifestUnknown)
+
+	manifestURL, err := env.builder.BuildManifestURL(imageName, "latest")
+	checkErr(t, err, "building manifest url")
+
+	for _, m := range []distribution.Manifest{first, second} {
+		resp := putManifest(t, "putting manifest", manifestURL, m)
+		defer resp.Body.Close()
+		checkResponse(t, "putting manifest", resp, http.StatusAccepted)
+	}
+
+	checkHistory := func(expected ...digest.Digest) {
+		resp, err := http.Get(historyURL)
+		checkErr(t, err, "fetching tag history")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching tag history", resp, http.StatusOK)
+
+		var history tagHistoryAPIResponse
+		checkErr(t, json.NewDecoder(resp.Body).Decode(&history), "decoding tag history")
+
+		if history.Name != imageName || history.Tag != "latest" {
+			t.Fatalf("unexpected tag history response: %#v", history)
+		}
+
+		var digests []digest.Digest
+		for _, entry := range history.History {
+			if entry.Timestamp.IsZero() {
+				t.Fatalf("expected timestamp in history entry: %#v", entry)
+			}
+
+			digests = append(digests, entry.Digest)
+		}
+
+		if !reflect.DeepEqual(digests, expected) {
+			t.Fatalf("unexpected tag history: %v != %v", digests, expected)
+		}
+	}
+
+	checkHistory(secondDigest, firstDigest)
+
+	doRollback := func(body string) *http.Response {
+		resp, err := http.Post(historyURL, "application/json", strings.NewReader(body))
+		checkErr(t, err, "rolling back tag")
+		return resp
+	}
+
+	resp = doRollback(`{"digest": "notadigest"}`)
+	defer resp.Body.Close()
+	checkResponse(t, "rolling back to invalid digest", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "rolling back to invalid digest", resp, v2.ErrorCodeDigestInvalid)
+
+	unknown, err := digest.FromBytes([]byte("unknown"))
+	checkErr(t, err, "digesting")
+
+	resp = doRollback(fmt.Sprintf(`{"digest": %q}`, unknown))
+	defer resp.Body.Close()
+	checkResponse(t, "rolling back to unknown digest", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "rolling back to unknown digest", resp, v2.ErrorCodeManifestUnknown)
+
+	// Without a digest, the tag is rolled back to the previous manifest.
+	resp = doRollback("")
+	defer resp.Body.Close()
+	checkResponse(t, "rolling back tag", resp, http.StatusCreated)
+	checkHeaders(t, resp, http.Header{
+		"Location":              []string{manifestURL},
+		"Docker-Content-Digest": []string{firstDigest.String()},
+	})
+
+	checkHistory(firstDigest, secondDigest, firstDigest)
+
+	resp = doRollback(fmt.Sprintf(`{"digest": %q}`, secondDigest))
+	defer resp.Body.Close()
+	checkResponse(t, "rolling back tag to digest", resp, http.StatusCreated)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{secondDigest.String()},
+	})
+
+	checkHistory(secondDigest, firstDigest, secondDigest, firstDigest)
+}
+
+func TestImmutableTagAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Immutability: configuration.Immutability{
+			Rules: []configuration.ImmutabilityRule{
+				{Tags: []string{"v*"}},
+			},
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+
+	imageName := "foo/bar"
+
+	first, firstDigest := createSchema2Manifest(t, env, imageName, "amd64")
+	second, secondDigest := createSchema2Manifest(t, env, imageName, "arm64")
+
+	manifestURL, err := env.builder.BuildManifestURL(imageName, "v1.0")
+	checkErr(t, err, "building manifest url")
+
+	resp := putManifest(t, "putting immutable tag", manifestURL, first)
+	defer resp.Body.Close()
+	checkResponse(t, "putting immutable tag", resp, http.StatusAccepted)
+
+	resp = putManifest(t, "re-putting immutable tag", manifestURL, first)
+	defer resp.Body.Close()
+	checkResponse(t, "re-putting immutable tag", resp, http.StatusAccepted)
+
+	resp = putManifest(t, "moving immutable tag", manifestURL, second)
+	defer resp.Body.Close()
+	checkResponse(t, "moving immutable tag", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "moving immutable tag", resp, v2.ErrorCodeTagImmutable)
+
+	tagURL, err := env.builder.BuildTagURL(imageName, "v1.0")
+	checkErr(t, err, "building tag url")
+
+	secondURL, err := env.builder.BuildManifestURL(imageName, secondDigest.String())
+	checkErr(t, err, "building manifest url")
+
+	resp = putManifest(t, "putting manifest by digest", secondURL, second)
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest by digest", resp, http.StatusAccepted)
+
+	for _, method := range []string{"PUT", "DELETE"} {
+		req, err := http.NewRequest(method, tagURL, strings.NewReader(fmt.Sprintf(`{"digest": %q}`, secondDigest)))
+		checkErr(t, err, "creating tag request")
+
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "issuing tag request")
+		defer resp.Body.Close()
+
+		msg := fmt.Sprintf("%s on immutable tag", method)
+		checkResponse(t, msg, resp, http.StatusConflict)
+		checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeTagImmutable)
+	}
+
+	resp, err = http.Get(tagURL)
+	checkErr(t, err, "fetching tag")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching immutable tag", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{firstDigest.String()},
+	})
+}
+
+func TestTrustPolicyAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trust")
+	checkErr(t, err, "creating temp dir")
+	defer os.RemoveAll(dir)
+
+	trustedKey, err := libtrust.GenerateECP256PrivateKey()
+	checkErr(t, err, "generating trusted key")
+
+	keyFile := path.Join(dir, "trusted.pem")
+	checkErr(t, libtrust.SavePublicKey(keyFile, trustedKey.PublicKey()), "saving trusted key")
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Trust: configuration.Trust{
+			Rules: []configuration.TrustRule{
+				{Keys: []string{keyFile}},
+			},
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+
+	imageName := "foo/bar"
+	tag := "latest"
+
+	rs, dgstStr, err := testutil.CreateRandomTarFile()
+	checkErr(t, err, "creating random layer")
+	layerDigest := digest.Digest(dgstStr)
+
+	uploadURLBase, _ := startPushLayer(t, env.builder, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, rs)
+
+	unsignedManifest := &manifest.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 1,
+		},
+		Name:     imageName,
+		Tag:      tag,
+		FSLayers: []manifest.FSLayer{{BlobSum: layerDigest}},
+	}
+
+	manifestURL, err := env.builder.BuildManifestURL(imageName, tag)
+	checkErr(t, err, "building manifest url")
+
+	untrusted, err := manifest.Sign(unsignedManifest, env.pk)
+	checkErr(t, err, "signing manifest")
+
+	resp := putManifest(t, "putting untrusted manifest", manifestURL, untrusted)
+	defer resp.Body.Close()
+	checkResponse(t, "putting untrusted manifest", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "putting untrusted manifest", resp, v2.ErrorCodeManifestUnverified)
+
+	trusted, err := manifest.Sign(unsignedManifest, trustedKey)
+	checkErr(t, err, "signing manifest")
+
+	resp = putManifest(t, "putting trusted manifest", manifestURL, trusted)
+	defer resp.Body.Close()
+	checkResponse(t, "putting trusted manifest", resp, http.StatusAccepted)
+}
+
+// TestLayerDigestAPI ensures layers are identified by the configured digest
+// algorithm and tarsum uploads are rejected when disabled.
+func TestLayerDigestAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Digest: configuration.Digest{
+			Algorithm:     "sha512",
+			DisableTarSum: true,
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+
+	imageName := "foo/bar"
+
+	rs, dgstStr, err := testutil.CreateRandomTarFile()
+	checkErr(t, err, "creating random layer")
+	tarSum := digest.Digest(dgstStr)
+
+	p, err := ioutil.ReadAll(rs)
+	checkErr(t, err, "reading random layer")
+
+	uploadURLBase, _ := startPushLayer(t, env.builder, imageName)
+	resp, err := doPushLayer(t, env.builder, imageName, tarSum, uploadURLBase, bytes.NewReader(p))
+	checkErr(t, err, "pushing layer by tarsum")
+	checkResponse(t, "pushing layer by tarsum", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "pushing layer by tarsum", resp, v2.ErrorCodeDigestInvalid)
+
+	sha256Digest, err := digest.FromBytes(p)
+	checkErr(t, err, "digesting layer")
+
+	sha512Digester, err := digest.NewDigesterForAlgorithm("sha512")
+	checkErr(t, err, "creating sha512 digester")
+	sha512Digester.Write(p)
+	sha512Digest := sha512Digester.Digest()
+
+	uploadURLBase, _ = startPushLayer(t, env.builder, imageName)
+	resp, err = doPushLayer(t, env.builder, imageName, sha256Digest, uploadURLBase, bytes.NewReader(p))
+	checkErr(t, err, "pushing layer by sha256")
+	checkResponse(t, "pushing layer by sha256", resp, http.StatusCreated)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{sha512Digest.String()},
+	})
+
+	for _, dgst := range []digest.Digest{sha256Digest, sha512Digest} {
+		layerURL, err := env.builder.BuildBlobURL(imageName, dgst)
+		checkErr(t, err, "building layer url")
+
+		resp, err := http.Get(layerURL)
+		checkErr(t, err, "fetching layer")
+		defer resp.Body.Close()
+
+		checkResponse(t, "fetching layer", resp, http.StatusOK)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		checkErr(t, err, "reading layer")
+		if !bytes.Equal(body, p) {
+			t.Fatalf("unexpected content fetching layer by %s", dgst)
+		}
+	}
+}
+
+func TestManifestSigningAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signing")
+	checkErr(t, err, "creating temp dir")
+	defer os.RemoveAll(dir)
+
+	registryKey, err := libtrust.GenerateECP256PrivateKey()
+	checkErr(t, err, "generating registry key")
+
+	keyFile := path.Join(dir, "registry.pem")
+	checkErr(t, libtrust.SaveKey(keyFile, registryKey), "saving registry key")
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Signing: configuration.Signing{
+			Key: keyFile,
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+
+	imageName := "foo/bar"
+	tag := "latest"
+
+	rs, dgstStr, err := testutil.CreateRandomTarFile()
+	checkErr(t, err, "creating random layer")
+	layerDigest := digest.Digest(dgstStr)
+
+	uploadURLBase, _ := startPushLayer(t, env.builder, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, rs)
+
+	signedManifest, err := manifest.Sign(&manifest.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 1,
+		},
+		Name:     imageName,
+		Tag:      tag,
+		FSLayers: []manifest.FSLayer{{BlobSum: layerDigest}},
+	}, env.pk)
+	checkErr(t, err, "signing manifest")
+
+	manifestURL, err := env.builder.BuildManifestURL(imageName, tag)
+	checkErr(t, err, "building manifest url")
+
+	// Pushing twice must not add a second registry signature.
+	for i := 0; i < 2; i++ {
+		resp := putManifest(t, "putting signed manifest", manifestURL, signedManifest)
+		defer resp.Body.Close()
+		checkResponse(t, "putting signed manifest", resp, http.StatusAccepted)
+	}
+
+	resp, err := http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching signed manifest", resp, http.StatusOK)
+
+	var fetched manifest.SignedManifest
+	checkErr(t, json.NewDecoder(resp.Body).Decode(&fetched), "decoding manifest")
+
+	keys, err := manifest.Verify(&fetched)
+	checkErr(t, err, "verifying fetched manifest")
+
+	var keyIDs []string
+	for _, key := range keys {
+		keyIDs = append(keyIDs, key.KeyID())
+	}
+
+	expected := []string{env.pk.KeyID(), registryKey.KeyID()}
+	sort.Strings(keyIDs)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(keyIDs, expected) {
+		t.Fatalf("unexpected signing keys: %v != %v", keyIDs, expected)
+	}
+}
+
 type testEnv struct {
 	pk      libtrust.PrivateKey
 	ctx     context.Context
@@ -495,10 +1253,51 @@ func newTestEnvWithConfig(t *testing.T, config *configuration.Configuration) *te
 	}
 }
 
+// createSchema2Manifest pushes a config blob for the architecture to the
+// named repository, returning a schema 2 manifest referencing it and the
+// digest of the manifest. The manifest itself is not pushed.
+func createSchema2Manifest(t *testing.T, env *testEnv, name, architecture string) (*schema2.DeserializedManifest, digest.Digest) {
+	config := []byte(fmt.Sprintf(`{"architecture": %q, "os": "linux"}`, architecture))
+	configDigest, err := digest.FromBytes(config)
+	checkErr(t, err, "digesting config")
+
+	uploadURLBase, _ := startPushLayer(t, env.builder, name)
+	pushLayer(t, env.builder, name, configDigest, uploadURLBase, bytes.NewReader(config))
+
+	deserialized, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		MediaType: schema2.MediaTypeManifest,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeConfig,
+			Size:      int64(len(config)),
+			Digest:    configDigest,
+		},
+	})
+	checkErr(t, err, "creating schema 2 manifest")
+
+	_, payload, err := deserialized.Payload()
+	checkErr(t, err, "getting manifest payload")
+
+	dgst, err := digest.FromBytes(payload)
+	checkErr(t, err, "digesting manifest")
+
+	return deserialized, dgst
+}
+
 func putManifest(t *testing.T, msg, url string, v interface{}) *http.Response {
-	var body []byte
+	var (
+		body        []byte
+		contentType string
+	)
+
 	if sm, ok := v.(*manifest.SignedManifest); ok {
 		body = sm.Raw
+	} else if m, ok := v.(distribution.Manifest); ok {
+		var err error
+		contentType, body, err = m.Payload()
+		if err != nil {
+			t.Fatalf("unexpected error getting payload of %v: %v", v, err)
+		}
 	} else {
 		var err error
 		body, err = json.MarshalIndent(v, "", "   ")
@@ -512,6 +1311,10 @@ func putManifest(t *testing.T, msg, url string, v interface{}) *http.Response {
 		t.Fatalf("error creating request for %s: %v", msg, err)
 	}
 
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		t.Fatalf("error doing put request while %s: %v", msg, err)
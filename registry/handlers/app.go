@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"crypto/x509"
 	"expvar"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"net/http"
@@ -12,8 +14,12 @@ import (
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/configuration"
 	ctxu "github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	_ "github.com/docker/distribution/manifest/manifestlist" // register manifest lists
+	_ "github.com/docker/distribution/manifest/schema2"      // register schema version 2
 	"github.com/docker/distribution/notifications"
 	"github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/audit"
 	"github.com/docker/distribution/registry/auth"
 	registrymiddleware "github.com/docker/distribution/registry/middleware/registry"
 	repositorymiddleware "github.com/docker/distribution/registry/middleware/repository"
@@ -22,6 +28,8 @@ import (
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/distribution/registry/storage/driver/factory"
 	storagemiddleware "github.com/docker/distribution/registry/storage/driver/middleware"
+	"github.com/docker/distribution/registry/storage/quota"
+	"github.com/docker/libtrust"
 	"github.com/garyburd/redigo/redis"
 	"github.com/gorilla/mux"
 	"golang.org/x/net/context"
@@ -47,6 +55,18 @@ type App struct {
 	}
 
 	redis *redis.Pool
+
+	// rateLimiter limits requests per user or address, if configured.
+	rateLimiter *rateLimiter
+
+	// quota enforces storage quotas on pushed layers, if configured.
+	quota *quota.Enforcer
+
+	// audit records authorization decisions and mutations, if configured.
+	audit audit.Logger
+
+	// anonymous grants access without authentication, if configured.
+	anonymous *configuration.AnonymousAccess
 }
 
 // NewApp takes a configuration and returns a configured app, ready to serve
@@ -67,6 +87,9 @@ func NewApp(ctx context.Context, configuration configuration.Configuration) *App
 	})
 	app.register(v2.RouteNameManifest, imageManifestDispatcher)
 	app.register(v2.RouteNameTags, tagsDispatcher)
+	app.register(v2.RouteNameTag, tagDispatcher)
+	app.register(v2.RouteNameTagHistory, tagHistoryDispatcher)
+	app.register(v2.RouteNameQuota, quotaDispatcher)
 	app.register(v2.RouteNameBlob, layerDispatcher)
 	app.register(v2.RouteNameBlobUpload, layerUploadDispatcher)
 	app.register(v2.RouteNameBlobUploadChunk, layerUploadDispatcher)
@@ -81,15 +104,48 @@ func NewApp(ctx context.Context, configuration configuration.Configuration) *App
 		panic(err)
 	}
 
-	startUploadPurger(app.driver, ctxu.GetLogger(app))
+	// The upload purger works on the storage driver without middleware.
+	purgeDriver := app.driver
 
 	app.driver, err = applyStorageMiddleware(app.driver, configuration.Middleware["storage"])
 	if err != nil {
 		panic(err)
 	}
 
-	app.configureEvents(&configuration)
 	app.configureRedis(&configuration)
+	app.configureEvents(&configuration)
+	startUploadPurger(purgeDriver, ctxu.GetLogger(app), app.uploadPurgeListener())
+	app.configureAudit(&configuration)
+	app.configureRateLimit(&configuration)
+	app.configureQuota(&configuration)
+
+	var options []storage.RegistryOption
+	if app.quota != nil {
+		options = append(options, storage.EnforceQuota(app.quota))
+	}
+
+	if rules := configuration.Immutability.Rules; len(rules) > 0 {
+		immutableTags := make([]storage.ImmutableTagRule, 0, len(rules))
+		for _, rule := range rules {
+			immutableTags = append(immutableTags, storage.ImmutableTagRule{
+				Repositories: rule.Repositories,
+				Tags:         rule.Tags,
+			})
+		}
+
+		ctxu.GetLogger(app).Infof("enforcing %d tag immutability rules", len(immutableTags))
+		options = append(options, storage.ImmutableTags(immutableTags...))
+	}
+
+	if trust := app.configureTrust(&configuration); trust != nil {
+		options = append(options, trust)
+	}
+
+	if signing := app.configureSigning(&configuration); signing != nil {
+		options = append(options, signing)
+	}
+
+	options = append(options, app.configureDigest(&configuration)...)
 
 	// configure storage caches
 	if cc, ok := configuration.Storage["cache"]; ok {
@@ -98,10 +154,10 @@ func NewApp(ctx context.Context, configuration configuration.Configuration) *App
 			if app.redis == nil {
 				panic("redis configuration required to use for layerinfo cache")
 			}
-			app.registry = storage.NewRegistryWithDriver(app.driver, cache.NewRedisLayerInfoCache(app.redis))
+			app.registry = storage.NewRegistryWithDriver(app.driver, cache.NewRedisLayerInfoCache(app.redis), options...)
 			ctxu.GetLogger(app).Infof("using redis layerinfo cache")
 		case "inmemory":
-			app.registry = storage.NewRegistryWithDriver(app.driver, cache.NewInMemoryLayerInfoCache())
+			app.registry = storage.NewRegistryWithDriver(app.driver, cache.NewInMemoryLayerInfoCache(), options...)
 			ctxu.GetLogger(app).Infof("using inmemory layerinfo cache")
 		default:
 			if cc["layerinfo"] != "" {
@@ -112,7 +168,11 @@ func NewApp(ctx context.Context, configuration configuration.Configuration) *App
 
 	if app.registry == nil {
 		// configure the registry if no cache section is available.
-		app.registry = storage.NewRegistryWithDriver(app.driver, nil)
+		app.registry = storage.NewRegistryWithDriver(app.driver, nil, options...)
+	}
+
+	if app.quota != nil {
+		startQuotaRebuilder(app.driver, app.quota, configuration.Quota.RebuildInterval, ctxu.GetLogger(app))
 	}
 
 	app.registry, err = applyRegistryMiddleware(app.registry, configuration.Middleware["registry"])
@@ -128,6 +188,16 @@ func NewApp(ctx context.Context, configuration configuration.Configuration) *App
 			panic(fmt.Sprintf("unable to configure authorization (%s): %v", authType, err))
 		}
 		app.accessController = accessController
+
+		anonymous, ok, err := configuration.Auth.Anonymous()
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure anonymous access: %v", err))
+		}
+
+		if ok {
+			ctxu.GetLogger(app).Infof("allowing anonymous %v on repositories %v", anonymous.Actions, anonymous.Repositories)
+			app.anonymous = &anonymous
+		}
 	}
 
 	return app
@@ -157,21 +227,54 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 			continue
 		}
 
-		ctxu.GetLogger(app).Infof("configuring endpoint %v (%v), timeout=%s, headers=%v", endpoint.Name, endpoint.URL, endpoint.Timeout, endpoint.Headers)
-		endpoint := notifications.NewEndpoint(endpoint.Name, endpoint.URL, notifications.EndpointConfig{
-			Timeout:   endpoint.Timeout,
-			Threshold: endpoint.Threshold,
-			Backoff:   endpoint.Backoff,
-			Headers:   endpoint.Headers,
+		sink, err := notifications.NewEndpoint(endpoint.Name, endpoint.URL, notifications.EndpointConfig{
+			Type:        endpoint.Type,
+			Envelope:    endpoint.Envelope,
+			MediaType:   endpoint.MediaType,
+			Parameters:  endpoint.Parameters,
+			Redis:       app.redis,
+			Timeout:     endpoint.Timeout,
+			Threshold:   endpoint.Threshold,
+			Backoff:     endpoint.Backoff,
+			MaxBackoff:  endpoint.MaxBackoff,
+			MaxAttempts: endpoint.MaxAttempts,
+			MaxAge:      endpoint.MaxAge,
+			Headers:     endpoint.Headers,
+			Secrets:     endpoint.Secrets,
+			TLS: notifications.TLSConfig{
+				CA:                 endpoint.TLS.CA,
+				Certificate:        endpoint.TLS.Certificate,
+				Key:                endpoint.TLS.Key,
+				InsecureSkipVerify: endpoint.TLS.InsecureSkipVerify,
+			},
+			Proxy: endpoint.Proxy,
+			Queue: notifications.QueueConfig{
+				Directory: endpoint.Queue.Directory,
+				MaxSize:   endpoint.Queue.MaxSize,
+				Overflow:  endpoint.Queue.Overflow,
+			},
+			Filter: notifications.EventFilter{
+				Actions:          endpoint.Filter.Actions,
+				MediaTypes:       endpoint.Filter.MediaTypes,
+				Repositories:     endpoint.Filter.Repositories,
+				RepositoryRegexp: endpoint.Filter.RepositoryRegexp,
+				Actors:           endpoint.Filter.Actors,
+				ExcludeActors:    endpoint.Filter.ExcludeActors,
+			},
+			MaxDeadLetters: endpoint.DeadLetters,
 		})
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure endpoint %s: %v", endpoint.Name, err))
+		}
+
+		ctxu.GetLogger(app).Infof("configured endpoint %v (%v), type=%s, timeout=%s, headers=%v", endpoint.Name, sink.URL(), sink.Type, endpoint.Timeout, endpoint.Headers)
 
-		sinks = append(sinks, endpoint)
+		sinks = append(sinks, sink)
 	}
 
-	// NOTE(stevvooe): Moving to a new queueing implementation is as easy as
-	// replacing broadcaster with a rabbitmq implementation. It's recommended
-	// that the registry instances also act as the workers to keep deployment
-	// simple.
+	// Endpoints deliver events through the sink registered for their type,
+	// so message brokers are configured as endpoints rather than replacing
+	// the broadcaster.
 	app.events.sink = notifications.NewBroadcaster(sinks...)
 
 	// Populate registry event source
@@ -192,6 +295,104 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 	}
 }
 
+// configureTrust returns a registry option verifying manifest signatures
+// against the configured trust rules, or nil if none are configured.
+func (app *App) configureTrust(configuration *configuration.Configuration) storage.RegistryOption {
+	if len(configuration.Trust.Rules) == 0 {
+		return nil
+	}
+
+	var policy storage.TrustPolicy
+	switch configuration.Trust.Mode {
+	case "", "enforce":
+		policy.Enforce = true
+	case "log":
+	default:
+		panic(fmt.Sprintf("unknown trust mode %q", configuration.Trust.Mode))
+	}
+
+	for _, rule := range configuration.Trust.Rules {
+		trustRule := storage.TrustRule{
+			Repositories: rule.Repositories,
+		}
+
+		for _, keyFile := range rule.Keys {
+			keys, err := libtrust.LoadKeySetFile(keyFile)
+			if err != nil {
+				panic(fmt.Sprintf("unable to load trusted keys from %q: %v", keyFile, err))
+			}
+
+			trustRule.Keys = append(trustRule.Keys, keys...)
+		}
+
+		if len(rule.CAs) > 0 {
+			trustRule.CAs = x509.NewCertPool()
+			for _, bundle := range rule.CAs {
+				certs, err := libtrust.LoadCertificateBundle(bundle)
+				if err != nil {
+					panic(fmt.Sprintf("unable to load trusted certificates from %q: %v", bundle, err))
+				}
+
+				for _, cert := range certs {
+					trustRule.CAs.AddCert(cert)
+				}
+			}
+		}
+
+		policy.Rules = append(policy.Rules, trustRule)
+	}
+
+	ctxu.GetLogger(app).Infof("verifying manifest signatures against %d trust rules (enforce=%v)", len(policy.Rules), policy.Enforce)
+	return storage.VerifyTrust(policy)
+}
+
+// configureSigning loads the key accepted manifests are signed with, if
+// configured.
+func (app *App) configureSigning(configuration *configuration.Configuration) storage.RegistryOption {
+	if configuration.Signing.Key == "" {
+		return nil
+	}
+
+	key, err := libtrust.LoadKeyFile(configuration.Signing.Key)
+	if err != nil {
+		panic(fmt.Sprintf("unable to load signing key from %q: %v", configuration.Signing.Key, err))
+	}
+
+	ctxu.GetLogger(app).Infof("signing accepted manifests with key %s", key.KeyID())
+	return storage.SignManifests(key)
+}
+
+// configureDigest selects the digests identifying layers, starting the
+// migration of existing layer links if configured.
+func (app *App) configureDigest(configuration *configuration.Configuration) []storage.RegistryOption {
+	var options []storage.RegistryOption
+
+	alg := configuration.Digest.Algorithm
+	if alg == "" {
+		alg = digest.CanonicalAlgorithm
+	}
+
+	if !digest.AlgorithmAvailable(alg) {
+		panic(fmt.Sprintf("unsupported layer digest algorithm %q, must be one of %v", alg, digest.Algorithms()))
+	}
+
+	if alg != digest.CanonicalAlgorithm {
+		ctxu.GetLogger(app).Infof("identifying layers by %s digests", alg)
+		options = append(options, storage.LayerDigestAlgorithm(alg))
+	}
+
+	if configuration.Digest.DisableTarSum {
+		ctxu.GetLogger(app).Infof("rejecting layer uploads by tarsum")
+		options = append(options, storage.RejectTarSum())
+	}
+
+	if configuration.Digest.Migrate {
+		startLayerLinkMigration(app.driver, alg, ctxu.GetLogger(app))
+	}
+
+	return options
+}
+
 func (app *App) configureRedis(configuration *configuration.Configuration) {
 	if configuration.Redis.Addr == "" {
 		ctxu.GetLogger(app).Infof("redis not configured")
@@ -274,6 +475,21 @@ func (app *App) configureRedis(configuration *configuration.Configuration) {
 	}))
 }
 
+// Close releases the resources held by the application, closing the
+// notification endpoints and stopping the background refresh of the access
+// controller, if any.
+func (app *App) Close() error {
+	err := app.events.sink.Close()
+
+	if closer, ok := app.accessController.(io.Closer); ok {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
 func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close() // ensure that request body is always closed.
 
@@ -320,6 +536,11 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		// Add username to request logging
 		context.Context = ctxu.WithLogger(context.Context, ctxu.GetLogger(context.Context, "auth.user.name"))
 
+		if err := app.rateLimited(w, r, context); err != nil {
+			ctxu.GetLogger(context).Warnf("rejecting request: %v", err)
+			return
+		}
+
 		if app.nameRequired(r) {
 			repository, err := app.registry.Repository(context, getName(context))
 
@@ -338,10 +559,18 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 				return
 			}
 
-			// assign and decorate the authorized repository with an event bridge.
-			context.Repository = notifications.Listen(
-				repository,
-				app.eventBridge(context, r))
+			// assign and decorate the authorized repository with an event
+			// bridge. Events are written once the response is complete.
+			bridge := app.eventBridge(context, r)
+			defer app.flushEvents(context, bridge)
+
+			context.Repository = notifications.Listen(repository, bridge)
+
+			if app.audit != nil {
+				context.Repository = notifications.Listen(
+					context.Repository,
+					&auditListener{app: app, ctx: context, r: r})
+			}
 
 			context.Repository, err = applyRepoMiddleware(context.Repository, app.Config.Middleware["repository"])
 			if err != nil {
@@ -354,6 +583,7 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		}
 
 		dispatch(context, r).ServeHTTP(w, r)
+		app.chargeRateLimit(context, r)
 
 		// Automated error response handling here. Handlers may return their
 		// own errors if they need different behavior (such as range errors
@@ -411,15 +641,17 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 	ctxu.GetLogger(context).Debug("authorizing request")
 	repo := getName(context)
 
+	var accessRecords []auth.Access
+	if repo != "" {
+		accessRecords = appendAccessRecords(accessRecords, r.Method, repo)
+	}
+
 	if app.accessController == nil {
+		app.auditAuthorization(context, r, accessRecords, nil)
 		return nil // access controller is not enabled.
 	}
 
-	var accessRecords []auth.Access
-
-	if repo != "" {
-		accessRecords = appendAccessRecords(accessRecords, r.Method, repo)
-	} else {
+	if repo == "" {
 		// Only allow the name not to be set on the base route.
 		if app.nameRequired(r) {
 			// For this to be properly secured, repo must always be set for a
@@ -434,12 +666,24 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 			var errs v2.Errors
 			errs.Push(v2.ErrorCodeUnauthorized)
 			serveJSON(w, errs)
-			return fmt.Errorf("forbidden: no repository name")
+
+			err := fmt.Errorf("forbidden: no repository name")
+			app.auditAuthorization(context, r, accessRecords, err)
+			return err
 		}
 	}
 
 	ctx, err := app.accessController.Authorized(context.Context, accessRecords...)
 	if err != nil {
+		// Requests challenged by the access controller, such as those
+		// without credentials, may still be granted by the anonymous access
+		// rules. Requests it authorizes keep their user.
+		if _, ok := err.(auth.Challenge); ok && app.anonymousAllowed(accessRecords) {
+			context.Context = auth.WithUser(context.Context, auth.UserInfo{})
+			app.auditAnonymous(context, r, accessRecords)
+			return nil
+		}
+
 		switch err := err.(type) {
 		case auth.Challenge:
 			w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -457,6 +701,7 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 			w.WriteHeader(http.StatusBadRequest)
 		}
 
+		app.auditAuthorization(context, r, accessRecords, err)
 		return err
 	}
 
@@ -464,18 +709,36 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 	// should be replaced by another, rather than replacing the context on a
 	// mutable object.
 	context.Context = ctx
+	app.auditAuthorization(context, r, accessRecords, nil)
 	return nil
 }
 
 // eventBridge returns a bridge for the current request, configured with the
-// correct actor and source.
-func (app *App) eventBridge(ctx *Context, r *http.Request) notifications.Listener {
+// correct actor and source. The events of the request are held until the
+// bridge is flushed.
+func (app *App) eventBridge(ctx *Context, r *http.Request) *notifications.DeferredBridge {
 	actor := notifications.ActorRecord{
 		Name: getUserName(ctx, r),
 	}
 	request := notifications.NewRequestRecord(ctxu.GetRequestID(ctx), r)
 
-	return notifications.NewBridge(ctx.urlBuilder, app.events.source, actor, request, app.events.sink)
+	return notifications.NewDeferredBridge(ctx.urlBuilder, app.events.source, actor, request, app.events.sink)
+}
+
+// flushEvents writes the events held by the bridge, recording the response
+// written for the request.
+func (app *App) flushEvents(ctx *Context, bridge *notifications.DeferredBridge) {
+	var response notifications.ResponseRecord
+	if status, ok := ctx.Value("http.response.status").(int); ok {
+		response.Status = status
+	}
+	if written, ok := ctx.Value("http.response.written").(int64); ok {
+		response.Written = written
+	}
+
+	if err := bridge.Flush(response); err != nil {
+		ctxu.GetLogger(ctx).Errorf("error writing events: %v", err)
+	}
 }
 
 // nameRequired returns true if the route requires a name.
@@ -568,9 +831,43 @@ func applyStorageMiddleware(driver storagedriver.StorageDriver, middlewares []co
 	return driver, nil
 }
 
+// startLayerLinkMigration starts a goroutine linking the layers of every
+// repository under their digests with the algorithm.
+func startLayerLinkMigration(storageDriver storagedriver.StorageDriver, alg string, log ctxu.Logger) {
+	go func() {
+		log.Infof("Starting layer link migration to %s", alg)
+		if err := storage.MigrateLayerLinks(storageDriver, alg); err != nil {
+			log.Errorf("error migrating layer links: %v", err)
+		}
+	}()
+}
+
+// uploadPurgeListener returns a listener sending expire events for the
+// uploads deleted by the upload purger.
+func (app *App) uploadPurgeListener() storage.UploadPurgeListener {
+	return &uploadExpiryListener{
+		listener: notifications.NewBridge(nil, app.events.source, notifications.ActorRecord{}, notifications.RequestRecord{}, app.events.sink),
+		log:      ctxu.GetLogger(app),
+	}
+}
+
+// uploadExpiryListener dispatches the uploads deleted by the upload purger to
+// a notifications listener.
+type uploadExpiryListener struct {
+	listener notifications.UploadListener
+	log      ctxu.Logger
+}
+
+func (uel *uploadExpiryListener) UploadPurged(repo, uuid string, startedAt time.Time) {
+	if err := uel.listener.UploadExpired(repo, uuid, startedAt); err != nil {
+		uel.log.Errorf("error dispatching upload expiry to listener: %v", err)
+	}
+}
+
 // startUploadPurger schedules a goroutine which will periodically
-// check upload directories for old files and delete them
-func startUploadPurger(storageDriver storagedriver.StorageDriver, log ctxu.Logger) {
+// check upload directories for old files and delete them, notifying the
+// listeners of the deleted uploads.
+func startUploadPurger(storageDriver storagedriver.StorageDriver, log ctxu.Logger, listeners ...storage.UploadPurgeListener) {
 	rand.Seed(time.Now().Unix())
 	jitter := time.Duration(rand.Int()%60) * time.Minute
 
@@ -584,7 +881,7 @@ func startUploadPurger(storageDriver storagedriver.StorageDriver, log ctxu.Logge
 		time.Sleep(jitter)
 
 		for {
-			storage.PurgeUploads(storageDriver, time.Now().Add(-purgeAge), true)
+			storage.PurgeUploads(storageDriver, time.Now().Add(-purgeAge), true, listeners...)
 			log.Infof("Starting upload purge in %s", timeBetweenPurges)
 			time.Sleep(timeBetweenPurges)
 		}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	ctxu "github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/notifications"
+	"github.com/docker/distribution/registry/audit"
+	"github.com/docker/distribution/registry/auth"
+)
+
+// configureAudit sets up the audit logger, if a backend is configured.
+func (app *App) configureAudit(configuration *configuration.Configuration) {
+	config := configuration.Audit
+	if config.Backend == "" {
+		return
+	}
+
+	var (
+		logger audit.Logger
+		prev   string
+		err    error
+	)
+
+	switch config.Backend {
+	case "file":
+		if config.File.Path == "" {
+			panic("audit file backend requires a path")
+		}
+
+		if config.HashChain {
+			prev, err = audit.LastHash(config.File.Path)
+			if err != nil {
+				panic(fmt.Sprintf("unable to resume audit hash chain: %v", err))
+			}
+		}
+
+		logger, err = audit.NewFileLogger(config.File.Path, config.File.MaxSize, config.File.MaxBackups)
+	case "syslog":
+		tag := config.Syslog.Tag
+		if tag == "" {
+			tag = "registry-audit"
+		}
+
+		logger, err = audit.NewSyslogLogger(config.Syslog.Network, config.Syslog.Addr, tag)
+	default:
+		panic(fmt.Sprintf("unknown audit backend %q", config.Backend))
+	}
+
+	if err != nil {
+		panic(fmt.Sprintf("unable to configure audit logging (%s): %v", config.Backend, err))
+	}
+
+	if config.HashChain {
+		logger = audit.NewChainLogger(logger, prev)
+	}
+
+	ctxu.GetLogger(app).Infof("using %s audit logging", config.Backend)
+	app.audit = logger
+}
+
+// auditRecord returns a record of the type for the request, populated with
+// the request details.
+func auditRecord(ctx *Context, r *http.Request, recordType string) audit.Record {
+	return audit.Record{
+		Timestamp: time.Now().UTC(),
+		Type:      recordType,
+		RequestID: ctxu.GetRequestID(ctx),
+		Actor:     getUserName(ctx, r),
+		Addr:      ctxu.RemoteAddr(r),
+		Method:    r.Method,
+		URI:       r.URL.Path,
+	}
+}
+
+// writeAudit writes the record to the audit logger. Failures are logged and
+// do not affect the request.
+func (app *App) writeAudit(ctx *Context, record audit.Record) {
+	if err := app.audit.Log(record); err != nil {
+		ctxu.GetLogger(ctx).Errorf("error writing audit record: %v", err)
+	}
+}
+
+// auditAuthorization records the result of an authorization decision for
+// the requested access. A nil error indicates access was allowed.
+func (app *App) auditAuthorization(ctx *Context, r *http.Request, accessRecords []auth.Access, err error) {
+	if app.audit == nil {
+		return
+	}
+
+	record := authorizationRecord(ctx, r, accessRecords)
+	switch err.(type) {
+	case nil:
+		record.Result = audit.ResultAllowed
+	case auth.Challenge:
+		record.Result = audit.ResultDenied
+		record.Reason = err.Error()
+	default:
+		record.Result = audit.ResultError
+		record.Reason = err.Error()
+	}
+
+	app.writeAudit(ctx, record)
+}
+
+// auditAnonymous records access granted by the anonymous access rules to a
+// request challenged by the access controller.
+func (app *App) auditAnonymous(ctx *Context, r *http.Request, accessRecords []auth.Access) {
+	if app.audit == nil {
+		return
+	}
+
+	record := authorizationRecord(ctx, r, accessRecords)
+	record.Result = audit.ResultAllowed
+	record.Reason = "anonymous"
+
+	app.writeAudit(ctx, record)
+}
+
+// authorizationRecord returns an authorization record for the requested
+// access, without a result.
+func authorizationRecord(ctx *Context, r *http.Request, accessRecords []auth.Access) audit.Record {
+	record := auditRecord(ctx, r, audit.RecordTypeAuthorization)
+	for _, access := range accessRecords {
+		record.Access = append(record.Access, audit.Access{
+			Type:   access.Type,
+			Name:   access.Name,
+			Action: access.Action,
+		})
+	}
+
+	return record
+}
+
+// auditListener records repository mutations in the audit trail.
+type auditListener struct {
+	app *App
+	ctx *Context
+	r   *http.Request
+}
+
+var _ notifications.Listener = &auditListener{}
+
+func (al *auditListener) ManifestPushed(repo distribution.Repository, m distribution.Manifest, tag string) error {
+	return al.recordManifest(notifications.EventActionPush, repo, m, tag)
+}
+
+func (al *auditListener) ManifestPulled(repo distribution.Repository, m distribution.Manifest, tag string) error {
+	return nil
+}
+
+func (al *auditListener) ManifestDeleted(repo distribution.Repository, m distribution.Manifest) error {
+	return al.recordManifest(notifications.EventActionDelete, repo, m, "")
+}
+
+func (al *auditListener) ManifestUnverified(repo distribution.Repository, m distribution.Manifest, verification distribution.SignatureVerification) error {
+	return nil
+}
+
+func (al *auditListener) ManifestTagged(repo distribution.Repository, tag string, desc distribution.Descriptor) error {
+	return al.recordTag(notifications.EventActionTag, repo, tag, desc)
+}
+
+func (al *auditListener) ManifestUntagged(repo distribution.Repository, tag string, desc distribution.Descriptor) error {
+	return al.recordTag(notifications.EventActionUntag, repo, tag, desc)
+}
+
+func (al *auditListener) TagMoved(repo distribution.Repository, tag string, from, to distribution.Descriptor) error {
+	return al.recordTag(notifications.EventActionMove, repo, tag, to)
+}
+
+func (al *auditListener) UploadStarted(repo distribution.Repository, upload distribution.LayerUpload) error {
+	return nil
+}
+
+func (al *auditListener) UploadCancelled(repo distribution.Repository, upload distribution.LayerUpload) error {
+	return nil
+}
+
+func (al *auditListener) UploadExpired(repo, uuid string, startedAt time.Time) error {
+	return nil
+}
+
+func (al *auditListener) RepositoryCreated(repo distribution.Repository) error {
+	al.record(notifications.EventActionCreate, &audit.Target{
+		Repository: repo.Name(),
+	})
+
+	return nil
+}
+
+func (al *auditListener) LayerPushed(repo distribution.Repository, layer distribution.Layer) error {
+	return al.recordLayer(notifications.EventActionPush, repo, layer)
+}
+
+func (al *auditListener) LayerPulled(repo distribution.Repository, layer distribution.Layer) error {
+	return nil
+}
+
+func (al *auditListener) LayerDeleted(repo distribution.Repository, layer distribution.Layer) error {
+	return al.recordLayer(notifications.EventActionDelete, repo, layer)
+}
+
+func (al *auditListener) recordManifest(action string, repo distribution.Repository, m distribution.Manifest, tag string) error {
+	mediaType, p, err := m.Payload()
+	if err != nil {
+		return err
+	}
+
+	_, desc, err := distribution.UnmarshalManifest(mediaType, p)
+	if err != nil {
+		return err
+	}
+
+	// Without a tag from the listener, schema version 1 manifests name their
+	// tag; otherwise, the tag is taken from the request reference, if it is
+	// not a digest.
+	if sm, ok := m.(*manifest.SignedManifest); ok && tag == "" {
+		tag = sm.Tag
+	} else if reference := getReference(al.ctx); tag == "" && reference != "" {
+		if _, err := digest.ParseDigest(reference); err != nil {
+			tag = reference
+		}
+	}
+
+	al.record(action, &audit.Target{
+		Repository: repo.Name(),
+		MediaType:  mediaType,
+		Digest:     desc.Digest.String(),
+		Tag:        tag,
+		Length:     desc.Size,
+	})
+
+	return nil
+}
+
+func (al *auditListener) recordTag(action string, repo distribution.Repository, tag string, desc distribution.Descriptor) error {
+	al.record(action, &audit.Target{
+		Repository: repo.Name(),
+		MediaType:  desc.MediaType,
+		Digest:     desc.Digest.String(),
+		Tag:        tag,
+		Length:     desc.Size,
+	})
+
+	return nil
+}
+
+func (al *auditListener) recordLayer(action string, repo distribution.Repository, layer distribution.Layer) error {
+	al.record(action, &audit.Target{
+		Repository: repo.Name(),
+		MediaType:  notifications.LayerMediaType,
+		Digest:     layer.Digest().String(),
+		Length:     layer.Length(),
+	})
+
+	return nil
+}
+
+func (al *auditListener) record(action string, target *audit.Target) {
+	record := auditRecord(al.ctx, al.r, audit.RecordTypeMutation)
+	record.Action = action
+	record.Target = target
+
+	al.app.writeAudit(al.ctx, record)
+}
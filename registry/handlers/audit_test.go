@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/notifications"
+	"github.com/docker/distribution/registry/audit"
+	"github.com/docker/distribution/testutil"
+)
+
+// readAuditLog verifies the hash chain of the audit log at path and returns
+// its records.
+func readAuditLog(t *testing.T, path string) []audit.Record {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading audit log: %v", err)
+	}
+
+	if _, err := audit.Verify(bytes.NewReader(content), ""); err != nil {
+		t.Fatalf("unexpected error verifying audit log: %v", err)
+	}
+
+	var records []audit.Record
+	dec := json.NewDecoder(bytes.NewReader(content))
+	for {
+		var record audit.Record
+		if err := dec.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("unexpected error decoding audit record: %v", err)
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+// TestAuditLog ensures that authorization decisions and layer pushes are
+// recorded in the audit log with the request id.
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-test-")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	env := newTestEnvWithConfig(t, &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Audit: configuration.Audit{
+			Backend:   "file",
+			File:      configuration.AuditFile{Path: path},
+			HashChain: true,
+		},
+	})
+
+	layerFile, tarSumStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer file: %v", err)
+	}
+
+	uploadURLBase, _ := startPushLayer(t, env.builder, "foo/bar")
+	pushLayer(t, env.builder, "foo/bar", digest.Digest(tarSumStr), uploadURLBase, layerFile)
+
+	records := readAuditLog(t, path)
+	if len(records) != 3 {
+		t.Fatalf("unexpected number of audit records: %d != 3: %#v", len(records), records)
+	}
+
+	for i, record := range records[:2] {
+		if record.Type != audit.RecordTypeAuthorization || record.Result != audit.ResultAllowed {
+			t.Fatalf("unexpected authorization record %d: %#v", i, record)
+		}
+
+		if len(record.Access) != 2 || record.Access[0].Name != "foo/bar" || record.Access[1].Action != "push" {
+			t.Fatalf("unexpected access in record %d: %#v", i, record.Access)
+		}
+
+		if record.RequestID == "" {
+			t.Fatalf("missing request id in record %d", i)
+		}
+	}
+
+	mutation := records[2]
+	if mutation.Type != audit.RecordTypeMutation || mutation.Action != notifications.EventActionPush {
+		t.Fatalf("unexpected mutation record: %#v", mutation)
+	}
+
+	if mutation.Target == nil || mutation.Target.Repository != "foo/bar" || mutation.Target.MediaType != notifications.LayerMediaType {
+		t.Fatalf("unexpected mutation target: %#v", mutation.Target)
+	}
+
+	if mutation.RequestID != records[1].RequestID {
+		t.Fatalf("mutation not recorded with request id of push: %q != %q", mutation.RequestID, records[1].RequestID)
+	}
+}
+
+// TestAuditLogDenied ensures that denied requests are recorded with the
+// challenge reason.
+func TestAuditLogDenied(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-test-")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	env := newTestEnvWithConfig(t, &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+		},
+		Audit: configuration.Audit{
+			Backend:   "file",
+			File:      configuration.AuditFile{Path: path},
+			HashChain: true,
+		},
+	})
+
+	u, err := env.builder.BuildTagsURL("foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error building tags url: %v", err)
+	}
+
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatalf("unexpected error fetching tags: %v", err)
+	}
+	resp.Body.Close()
+
+	checkResponse(t, "fetching tags without credentials", resp, http.StatusUnauthorized)
+
+	records := readAuditLog(t, path)
+	if len(records) != 1 {
+		t.Fatalf("unexpected number of audit records: %d != 1: %#v", len(records), records)
+	}
+
+	record := records[0]
+	if record.Result != audit.ResultDenied || record.Reason == "" {
+		t.Fatalf("unexpected denied record: %#v", record)
+	}
+
+	expected := audit.Access{Type: "repository", Name: "foo/bar", Action: "pull"}
+	if len(record.Access) != 1 || record.Access[0] != expected {
+		t.Fatalf("unexpected access in denied record: %#v", record.Access)
+	}
+}
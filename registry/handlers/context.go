@@ -50,6 +50,10 @@ func getReference(ctx context.Context) (reference string) {
 	return ctxu.GetStringValue(ctx, "vars.reference")
 }
 
+func getTag(ctx context.Context) (tag string) {
+	return ctxu.GetStringValue(ctx, "vars.tag")
+}
+
 var errDigestNotAvailable = fmt.Errorf("digest not available in context")
 
 func getDigest(ctx context.Context) (dgst digest.Digest, err error) {
@@ -78,8 +82,9 @@ func getUploadUUID(ctx context.Context) (uuid string) {
 func getUserName(ctx context.Context, r *http.Request) string {
 	username := ctxu.GetStringValue(ctx, "auth.user.name")
 
-	// Fallback to request user with basic auth
-	if username == "" {
+	// Fallback to request user with basic auth, unless the user has been
+	// established by authorization, as it is for anonymous access.
+	if username == "" && ctx.Value("auth.user") == nil {
 		var ok bool
 		uname, _, ok := basicAuth(r)
 		if ok {
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/notifications"
+	"github.com/docker/distribution/testutil"
+)
+
+// recordedEvents receives the events written to endpoints of type
+// handlers-test.
+var recordedEvents = make(chan notifications.Event, 100)
+
+func init() {
+	notifications.RegisterSink("handlers-test", notifications.SinkFactoryFunc(func(config notifications.SinkConfig) (notifications.Sink, error) {
+		return eventRecorder{}, nil
+	}))
+}
+
+type eventRecorder struct{}
+
+func (eventRecorder) Write(events ...notifications.Event) error {
+	for _, event := range events {
+		recordedEvents <- event
+	}
+	return nil
+}
+
+func (eventRecorder) Close() error {
+	return nil
+}
+
+// nextEvent returns the next recorded event with the action and target
+// media type, skipping others.
+func nextEvent(t *testing.T, action, mediaType string) notifications.Event {
+	for {
+		select {
+		case event := <-recordedEvents:
+			if event.Action == action && event.Target.MediaType == mediaType {
+				return event
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event of %s received", action, mediaType)
+		}
+	}
+}
+
+// TestEventResponses ensures that events carry the tag and references of
+// manifests and describe the response to their request.
+func TestEventResponses(t *testing.T) {
+	env := newTestEnvWithConfig(t, &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Notifications: configuration.Notifications{
+			Endpoints: []configuration.Endpoint{
+				{Name: "recorder", Type: "handlers-test"},
+			},
+		},
+	})
+
+	imageName := "foo/events"
+
+	config := []byte(`{"architecture": "amd64", "os": "linux"}`)
+	configDigest, err := digest.FromBytes(config)
+	checkErr(t, err, "digesting config")
+
+	uploadURLBase, uploadUUID := startPushLayer(t, env.builder, imageName)
+
+	event := nextEvent(t, notifications.EventActionUpload, notifications.LayerMediaType)
+	if event.Target.UUID != uploadUUID || event.Target.Repository != imageName {
+		t.Fatalf("unexpected upload event: %#v", event)
+	}
+
+	pushLayer(t, env.builder, imageName, configDigest, uploadURLBase, bytes.NewReader(config))
+
+	event = nextEvent(t, notifications.EventActionPush, notifications.LayerMediaType)
+	if event.Target.Digest != configDigest || event.Response == nil || event.Response.Status != http.StatusCreated {
+		t.Fatalf("unexpected layer push event: %#v", event)
+	}
+
+	rs, dgstStr, err := testutil.CreateRandomTarFile()
+	checkErr(t, err, "creating random layer")
+	layer, err := ioutil.ReadAll(rs)
+	checkErr(t, err, "reading random layer")
+	layerDigest := digest.Digest(dgstStr)
+
+	uploadURLBase, _ = startPushLayer(t, env.builder, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, bytes.NewReader(layer))
+
+	// The image size of the event is that of the manifest and the sizes it
+	// records.
+	deserialized, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		MediaType: schema2.MediaTypeManifest,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeConfig,
+			Size:      int64(len(config)),
+			Digest:    configDigest,
+		},
+		Layers: []distribution.Descriptor{
+			{MediaType: schema2.MediaTypeLayer, Size: int64(len(layer)), Digest: layerDigest},
+		},
+	})
+	checkErr(t, err, "creating schema 2 manifest")
+
+	_, payload, err := deserialized.Payload()
+	checkErr(t, err, "getting manifest payload")
+
+	manifestURL, err := env.builder.BuildManifestURL(imageName, "latest")
+	checkErr(t, err, "building manifest url")
+
+	resp := putManifest(t, "putting manifest", manifestURL, deserialized)
+	resp.Body.Close()
+	checkResponse(t, "putting manifest", resp, http.StatusAccepted)
+
+	event = nextEvent(t, notifications.EventActionPush, schema2.MediaTypeManifest)
+	if event.Target.Tag != "latest" || event.Response == nil || event.Response.Status != http.StatusAccepted {
+		t.Fatalf("unexpected manifest push event: %#v", event)
+	}
+
+	if len(event.Target.References) != 2 ||
+		event.Target.References[0].Digest != configDigest ||
+		event.Target.References[1].Digest != layerDigest ||
+		event.Target.References[1].Size != int64(len(layer)) {
+		t.Fatalf("unexpected manifest references: %#v", event.Target.References)
+	}
+
+	if expected := int64(len(payload) + len(config) + len(layer)); event.Target.ImageSize != expected {
+		t.Fatalf("unexpected image size: %d != %d", event.Target.ImageSize, expected)
+	}
+
+	// The first tagged push creates the repository.
+	event = nextEvent(t, notifications.EventActionCreate, "")
+	if event.Target.Repository != imageName {
+		t.Fatalf("unexpected create event: %#v", event)
+	}
+
+	// Pulls record the bytes written to the client.
+	layerURL, err := env.builder.BuildBlobURL(imageName, layerDigest)
+	checkErr(t, err, "building layer url")
+
+	resp, err = http.Get(layerURL)
+	checkErr(t, err, "fetching layer")
+	_, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	checkErr(t, err, "reading layer")
+	checkResponse(t, "fetching layer", resp, http.StatusOK)
+
+	event = nextEvent(t, notifications.EventActionPull, notifications.LayerMediaType)
+	if event.Response == nil || event.Response.Status != http.StatusOK || event.Response.Written != int64(len(layer)) {
+		t.Fatalf("unexpected layer pull event response: %#v", event.Response)
+	}
+
+	req, err := http.NewRequest("GET", manifestURL, nil)
+	checkErr(t, err, "creating request")
+	req.Header.Set("Accept", schema2.MediaTypeManifest)
+
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching manifest")
+	resp.Body.Close()
+	checkResponse(t, "fetching manifest", resp, http.StatusOK)
+
+	event = nextEvent(t, notifications.EventActionPull, schema2.MediaTypeManifest)
+	if event.Target.Tag != "latest" || event.Response == nil || event.Response.Written != int64(len(payload)) {
+		t.Fatalf("unexpected manifest pull event: %#v", event)
+	}
+
+	// Signed manifests do not record the sizes of their layers, which are
+	// sized by their blobs without pulling them.
+	signedManifest, err := manifest.Sign(&manifest.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 1,
+		},
+		Name:     imageName,
+		Tag:      "signed",
+		FSLayers: []manifest.FSLayer{{BlobSum: layerDigest}},
+	}, env.pk)
+	checkErr(t, err, "signing manifest")
+
+	signedURL, err := env.builder.BuildManifestURL(imageName, "signed")
+	checkErr(t, err, "building manifest url")
+
+	resp = putManifest(t, "putting signed manifest", signedURL, signedManifest)
+	resp.Body.Close()
+	checkResponse(t, "putting signed manifest", resp, http.StatusAccepted)
+
+	for event.Action != notifications.EventActionPush || event.Target.MediaType != manifest.ManifestMediaType {
+		select {
+		case event = <-recordedEvents:
+			if event.Action == notifications.EventActionPull {
+				t.Fatalf("sizing references dispatched a pull event: %#v", event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no push event of %s received", manifest.ManifestMediaType)
+		}
+	}
+
+	if len(event.Target.References) != 1 || event.Target.References[0].Size != int64(len(layer)) {
+		t.Fatalf("unexpected signed manifest references: %#v", event.Target.References)
+	}
+
+	if expected := event.Target.Size + int64(len(layer)); event.Target.ImageSize != expected {
+		t.Fatalf("unexpected signed image size: %d != %d", event.Target.ImageSize, expected)
+	}
+}
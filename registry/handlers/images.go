@@ -1,8 +1,8 @@
 package handlers
 
 import (
-	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"strings"
 
@@ -12,7 +12,6 @@ import (
 	"github.com/docker/distribution/manifest"
 	"github.com/docker/distribution/registry/api/v2"
 	"github.com/gorilla/handlers"
-	"golang.org/x/net/context"
 )
 
 // imageManifestDispatcher takes the request context and builds the
@@ -46,20 +45,36 @@ type imageManifestHandler struct {
 	Digest digest.Digest
 }
 
-// GetImageManifest fetches the image manifest from the storage backend, if it exists.
+// GetImageManifest fetches the image manifest from the storage backend, if it
+// exists. Manifests other than schema version 1 are only returned by tag to
+// clients that accept their media type.
 func (imh *imageManifestHandler) GetImageManifest(w http.ResponseWriter, r *http.Request) {
 	ctxu.GetLogger(imh).Debug("GetImageManifest")
 	manifests := imh.Repository.Manifests()
 
 	var (
-		sm  *manifest.SignedManifest
+		m   distribution.Manifest
 		err error
 	)
 
 	if imh.Tag != "" {
-		sm, err = manifests.GetByTag(imh.Tag)
+		// Check the media type the tag references before fetching the
+		// manifest, so that refused requests are not reported as pulls.
+		var desc distribution.Descriptor
+		desc, err = imh.Repository.Tags().Get(imh.Tag)
+		if err != nil {
+			imh.Errors.Push(v2.ErrorCodeManifestUnknown, err)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if !imh.acceptable(w, r, desc.MediaType) {
+			return
+		}
+
+		m, err = manifests.GetByTag(imh.Tag)
 	} else {
-		sm, err = manifests.Get(imh.Digest)
+		m, err = manifests.Get(imh.Digest)
 	}
 
 	if err != nil {
@@ -68,57 +83,84 @@ func (imh *imageManifestHandler) GetImageManifest(w http.ResponseWriter, r *http
 		return
 	}
 
+	mediaType, p, err := m.Payload()
+	if err != nil {
+		imh.Errors.PushErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// The tag may have moved since it was checked.
+	if imh.Tag != "" && !imh.acceptable(w, r, mediaType) {
+		return
+	}
+
 	// Get the digest, if we don't already have it.
 	if imh.Digest == "" {
-		dgst, err := digestManifest(imh, sm)
+		_, desc, err := distribution.UnmarshalManifest(mediaType, p)
 		if err != nil {
+			ctxu.GetLogger(imh).Errorf("error digesting manifest: %v", err)
 			imh.Errors.Push(v2.ErrorCodeDigestInvalid, err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		imh.Digest = dgst
+		imh.Digest = desc.Digest
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.Header().Set("Content-Length", fmt.Sprint(len(sm.Raw)))
+	contentType := mediaType
+	if mediaType == manifest.ManifestMediaType {
+		// Schema version 1 manifests have always been served as plain json.
+		contentType = "application/json; charset=utf-8"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", fmt.Sprint(len(p)))
 	w.Header().Set("Docker-Content-Digest", imh.Digest.String())
-	w.Write(sm.Raw)
+	w.Write(p)
 }
 
-// PutImageManifest validates and stores and image in the registry.
+// PutImageManifest validates and stores and image in the registry. The
+// Content-Type of the request selects the registered manifest schema used to
+// unmarshal the payload.
 func (imh *imageManifestHandler) PutImageManifest(w http.ResponseWriter, r *http.Request) {
 	ctxu.GetLogger(imh).Debug("PutImageManifest")
 	manifests := imh.Repository.Manifests()
-	dec := json.NewDecoder(r.Body)
 
-	var manifest manifest.SignedManifest
-	if err := dec.Decode(&manifest); err != nil {
+	p, err := ioutil.ReadAll(r.Body)
+	if err != nil {
 		imh.Errors.Push(v2.ErrorCodeManifestInvalid, err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	dgst, err := digestManifest(imh, &manifest)
+	m, desc, err := distribution.UnmarshalManifest(r.Header.Get("Content-Type"), p)
 	if err != nil {
-		imh.Errors.Push(v2.ErrorCodeDigestInvalid, err)
+		imh.Errors.Push(v2.ErrorCodeManifestInvalid, err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	// Validate manifest tag or digest matches payload
-	if imh.Tag != "" {
-		if manifest.Tag != imh.Tag {
-			ctxu.GetLogger(imh).Errorf("invalid tag on manifest payload: %q != %q", manifest.Tag, imh.Tag)
+	// Only schema version 1 manifests carry a tag in their payload; others
+	// are tagged by the request.
+	tag := imh.Tag
+	if sm, ok := m.(*manifest.SignedManifest); ok {
+		if imh.Tag != "" && sm.Tag != imh.Tag {
+			ctxu.GetLogger(imh).Errorf("invalid tag on manifest payload: %q != %q", sm.Tag, imh.Tag)
 			imh.Errors.Push(v2.ErrorCodeTagInvalid)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		imh.Digest = dgst
+		tag = sm.Tag
+	}
+
+	// Validate manifest tag or digest matches payload
+	if imh.Tag != "" {
+		imh.Digest = desc.Digest
 	} else if imh.Digest != "" {
-		if dgst != imh.Digest {
-			ctxu.GetLogger(imh).Errorf("payload digest does match: %q != %q", dgst, imh.Digest)
+		if desc.Digest != imh.Digest {
+			ctxu.GetLogger(imh).Errorf("payload digest does match: %q != %q", desc.Digest, imh.Digest)
 			imh.Errors.Push(v2.ErrorCodeDigestInvalid)
 			w.WriteHeader(http.StatusBadRequest)
 			return
@@ -129,17 +171,25 @@ func (imh *imageManifestHandler) PutImageManifest(w http.ResponseWriter, r *http
 		return
 	}
 
-	if err := manifests.Put(&manifest); err != nil {
+	if err := manifests.Put(m, tag); err != nil {
 		// TODO(stevvooe): These error handling switches really need to be
 		// handled by an app global mapper.
 		switch err := err.(type) {
+		case distribution.ErrTagImmutable:
+			imh.Errors.Push(v2.ErrorCodeTagImmutable, map[string]string{"tag": err.Tag, "digest": err.Revision.String()})
+			w.WriteHeader(http.StatusConflict)
+			return
 		case distribution.ErrManifestVerification:
 			for _, verificationError := range err {
 				switch verificationError := verificationError.(type) {
 				case distribution.ErrUnknownLayer:
-					imh.Errors.Push(v2.ErrorCodeBlobUnknown, verificationError.FSLayer)
+					imh.Errors.Push(v2.ErrorCodeBlobUnknown, manifest.FSLayer{BlobSum: verificationError.Digest})
+				case distribution.ErrUnknownManifestRevision:
+					imh.Errors.Push(v2.ErrorCodeManifestUnknown, verificationError.Revision)
 				case distribution.ErrManifestUnverified:
 					imh.Errors.Push(v2.ErrorCodeManifestUnverified)
+				case distribution.ErrManifestUntrusted:
+					imh.Errors.Push(v2.ErrorCodeManifestUnverified, map[string]interface{}{"keys": verificationError.KeyIDs})
 				default:
 					if verificationError == digest.ErrDigestInvalidFormat {
 						// TODO(stevvooe): We need to really need to move all
@@ -185,27 +235,40 @@ func (imh *imageManifestHandler) DeleteImageManifest(w http.ResponseWriter, r *h
 	w.WriteHeader(http.StatusBadRequest)
 }
 
-// digestManifest takes a digest of the given manifest. This belongs somewhere
-// better but we'll wait for a refactoring cycle to find that real somewhere.
-func digestManifest(ctx context.Context, sm *manifest.SignedManifest) (digest.Digest, error) {
-	p, err := sm.Payload()
-	if err != nil {
-		if !strings.Contains(err.Error(), "missing signature key") {
-			ctxu.GetLogger(ctx).Errorf("error getting manifest payload: %v", err)
-			return "", err
-		}
+// acceptsMediaType returns true if mediaType, or a wildcard range covering
+// it, is listed in any of the Accept headers of the request. Media type
+// parameters are ignored.
+func acceptsMediaType(r *http.Request, mediaType string) bool {
+	mainType := mediaType
+	if i := strings.Index(mainType, "/"); i >= 0 {
+		mainType = mainType[:i]
+	}
+
+	for _, accept := range r.Header["Accept"] {
+		for _, candidate := range strings.Split(accept, ",") {
+			if i := strings.Index(candidate, ";"); i >= 0 {
+				candidate = candidate[:i]
+			}
 
-		// NOTE(stevvooe): There are no signatures but we still have a
-		// payload. The request will fail later but this is not the
-		// responsibility of this part of the code.
-		p = sm.Raw
+			switch strings.TrimSpace(candidate) {
+			case mediaType, "*/*", mainType + "/*":
+				return true
+			}
+		}
 	}
 
-	dgst, err := digest.FromBytes(p)
-	if err != nil {
-		ctxu.GetLogger(ctx).Errorf("error digesting manifest: %v", err)
-		return "", err
+	return false
+}
+
+// acceptable returns true if a manifest of mediaType may be served by tag to
+// the client. Otherwise, a 406 response is written.
+func (imh *imageManifestHandler) acceptable(w http.ResponseWriter, r *http.Request, mediaType string) bool {
+	if mediaType == manifest.ManifestMediaType || acceptsMediaType(r, mediaType) {
+		return true
 	}
 
-	return dgst, err
+	ctxu.GetLogger(imh).Debugf("client does not accept %s for tag %q", mediaType, imh.Tag)
+	imh.Errors.Push(v2.ErrorCodeManifestNotAcceptable, fmt.Sprintf("manifest for tag %q requires %s", imh.Tag, mediaType))
+	w.WriteHeader(http.StatusNotAcceptable)
+	return false
 }
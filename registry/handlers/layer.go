@@ -6,6 +6,7 @@ import (
 	"github.com/docker/distribution"
 	ctxu "github.com/docker/distribution/context"
 	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
 	"github.com/docker/distribution/registry/api/v2"
 	"github.com/gorilla/handlers"
 )
@@ -56,7 +57,7 @@ func (lh *layerHandler) GetLayer(w http.ResponseWriter, r *http.Request) {
 		switch err := err.(type) {
 		case distribution.ErrUnknownLayer:
 			w.WriteHeader(http.StatusNotFound)
-			lh.Errors.Push(v2.ErrorCodeBlobUnknown, err.FSLayer)
+			lh.Errors.Push(v2.ErrorCodeBlobUnknown, manifest.FSLayer{BlobSum: err.Digest})
 		default:
 			lh.Errors.Push(v2.ErrorCodeUnknown, err)
 		}
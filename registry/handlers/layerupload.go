@@ -211,6 +211,9 @@ func (luh *layerUploadHandler) PutLayerUploadComplete(w http.ResponseWriter, r *
 		case distribution.ErrLayerInvalidDigest:
 			w.WriteHeader(http.StatusBadRequest)
 			luh.Errors.Push(v2.ErrorCodeDigestInvalid, err)
+		case distribution.ErrQuotaExceeded:
+			w.WriteHeader(http.StatusForbidden)
+			luh.Errors.Push(v2.ErrorCodeQuotaExceeded, err)
 		default:
 			ctxu.GetLogger(luh).Errorf("unknown error completing upload: %#v", err)
 			w.WriteHeader(http.StatusInternalServerError)
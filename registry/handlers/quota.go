@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	ctxu "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/storage"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/quota"
+	"github.com/gorilla/handlers"
+)
+
+// configureQuota sets up storage quota enforcement, if limits are
+// configured.
+func (app *App) configureQuota(configuration *configuration.Configuration) {
+	if len(configuration.Quota.Limits) == 0 {
+		return
+	}
+
+	backend := configuration.Quota.Backend
+	if backend == "" {
+		backend = "inmemory"
+	}
+
+	var store quota.UsageStore
+	switch backend {
+	case "redis":
+		if app.redis == nil {
+			panic("redis configuration required to use redis quota accounting")
+		}
+		store = quota.NewRedisUsageStore(app.redis)
+	case "inmemory":
+		store = quota.NewInMemoryUsageStore()
+	default:
+		panic(fmt.Sprintf("unknown quota backend %q", backend))
+	}
+
+	var namespace bool
+	switch configuration.Quota.Scope {
+	case "", "repository":
+	case "namespace":
+		namespace = true
+	default:
+		panic(fmt.Sprintf("unknown quota scope %q", configuration.Quota.Scope))
+	}
+
+	limits := make([]quota.Limit, 0, len(configuration.Quota.Limits))
+	for _, limit := range configuration.Quota.Limits {
+		limits = append(limits, quota.Limit{
+			Patterns: limit.Scopes,
+			Size:     limit.Size,
+		})
+	}
+
+	ctxu.GetLogger(app).Infof("using %s quota accounting with %d limits", backend, len(limits))
+
+	app.quota = quota.NewEnforcer(store, namespace, limits)
+}
+
+// startQuotaRebuilder rebuilds quota usage from storage in the background,
+// once immediately and then at the interval, if it is positive.
+func startQuotaRebuilder(storageDriver storagedriver.StorageDriver, enforcer *quota.Enforcer, interval time.Duration, log ctxu.Logger) {
+	go func() {
+		for {
+			if err := storage.RebuildQuotaUsage(storageDriver, enforcer); err != nil {
+				log.Errorf("error rebuilding quota usage: %v", err)
+			}
+
+			if interval <= 0 {
+				return
+			}
+
+			log.Infof("Starting quota usage rebuild in %s", interval)
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// quotaDispatcher constructs the quota handler api endpoint.
+func quotaDispatcher(ctx *Context, r *http.Request) http.Handler {
+	quotaHandler := &quotaHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(quotaHandler.GetQuota),
+	}
+}
+
+// quotaHandler handles requests for the storage quota usage of a repository.
+type quotaHandler struct {
+	*Context
+}
+
+type quotaAPIResponse struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+	Used  int64  `json:"used"`
+	Limit int64  `json:"limit"`
+}
+
+// GetQuota returns the usage and limit of the quota scope containing the
+// repository.
+func (qh *quotaHandler) GetQuota(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if qh.App.quota == nil {
+		w.WriteHeader(http.StatusNotFound)
+		qh.Errors.Push(v2.ErrorCodeUnsupported, "storage quotas are not enabled")
+		return
+	}
+
+	usage, err := qh.App.quota.Usage(qh.Repository.Name())
+	if err != nil {
+		qh.Errors.PushErr(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(quotaAPIResponse{
+		Name:  qh.Repository.Name(),
+		Scope: usage.Scope,
+		Used:  usage.Used,
+		Limit: usage.Limit,
+	}); err != nil {
+		qh.Errors.PushErr(err)
+		return
+	}
+}
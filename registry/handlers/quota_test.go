@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/testutil"
+)
+
+// TestQuota ensures that layer pushes over the quota of a namespace are
+// rejected and that usage is reported by the quota endpoint.
+func TestQuota(t *testing.T) {
+	env := newTestEnvWithConfig(t, &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Quota: configuration.Quota{
+			Scope: "namespace",
+			Limits: []configuration.QuotaLimit{
+				{
+					Scopes: []string{"limited"},
+					Size:   1024,
+				},
+			},
+		},
+	})
+
+	layerFile, tarSumStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer file: %v", err)
+	}
+	layerDigest := digest.Digest(tarSumStr)
+
+	layerSize, err := layerFile.Seek(0, os.SEEK_END)
+	if err != nil {
+		t.Fatalf("error getting layer size: %v", err)
+	}
+
+	checkQuota := func(name string, expected quotaAPIResponse) {
+		u, err := env.builder.BuildQuotaURL(name)
+		if err != nil {
+			t.Fatalf("unexpected error building quota url: %v", err)
+		}
+
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatalf("unexpected error fetching quota: %v", err)
+		}
+		defer resp.Body.Close()
+
+		checkResponse(t, "fetching quota", resp, http.StatusOK)
+
+		var usage quotaAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+			t.Fatalf("error decoding quota response: %v", err)
+		}
+
+		if usage != expected {
+			t.Fatalf("unexpected quota usage for %q: %#v != %#v", name, usage, expected)
+		}
+	}
+
+	// A push over the limit is rejected.
+	layerFile.Seek(0, os.SEEK_SET)
+	uploadURLBase, _ := startPushLayer(t, env.builder, "limited/foo")
+	resp, err := doPushLayer(t, env.builder, "limited/foo", layerDigest, uploadURLBase, layerFile)
+	if err != nil {
+		t.Fatalf("unexpected error pushing layer: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "pushing layer over quota", resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, "pushing layer over quota", resp, v2.ErrorCodeQuotaExceeded)
+
+	checkQuota("limited/foo", quotaAPIResponse{
+		Name:  "limited/foo",
+		Scope: "limited",
+		Limit: 1024,
+	})
+
+	// Namespaces without a limit are accounted but not limited.
+	layerFile.Seek(0, os.SEEK_SET)
+	uploadURLBase, _ = startPushLayer(t, env.builder, "other/foo")
+	pushLayer(t, env.builder, "other/foo", layerDigest, uploadURLBase, layerFile)
+
+	checkQuota("other/bar", quotaAPIResponse{
+		Name:  "other/bar",
+		Scope: "other",
+		Used:  layerSize,
+	})
+}
+
+// TestQuotaDisabled ensures the quota endpoint reports that quotas are
+// unsupported when none are configured.
+func TestQuotaDisabled(t *testing.T) {
+	env := newTestEnv(t)
+
+	u, err := env.builder.BuildQuotaURL("foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error building quota url: %v", err)
+	}
+
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatalf("unexpected error fetching quota: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "fetching quota", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching quota", resp, v2.ErrorCodeUnsupported)
+}
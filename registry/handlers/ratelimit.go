@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/docker/distribution/configuration"
+	ctxu "github.com/docker/distribution/context"
+	"github.com/docker/distribution/glob"
+	"github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/ratelimit"
+	"github.com/gorilla/mux"
+)
+
+// requestClass groups requests that share a rate limit bucket.
+type requestClass int
+
+const (
+	requestClassNone     requestClass = iota // not limited
+	requestClassManifest                     // manifest and tag reads
+	requestClassBlob                         // blob reads, charged by bytes served
+	requestClassPush                         // any modification
+)
+
+func (rc requestClass) String() string {
+	switch rc {
+	case requestClassManifest:
+		return "manifest"
+	case requestClassBlob:
+		return "blob"
+	case requestClassPush:
+		return "push"
+	}
+
+	return "none"
+}
+
+// classifyRequest determines the rate limit class of the request from its
+// route and method.
+func classifyRequest(r *http.Request) requestClass {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return requestClassNone
+	}
+
+	read := r.Method == "GET" || r.Method == "HEAD"
+
+	switch route.GetName() {
+	case v2.RouteNameManifest, v2.RouteNameTags, v2.RouteNameTag, v2.RouteNameTagHistory:
+		if read {
+			return requestClassManifest
+		}
+		return requestClassPush
+	case v2.RouteNameBlob:
+		if read {
+			return requestClassBlob
+		}
+		return requestClassPush
+	case v2.RouteNameBlobUpload, v2.RouteNameBlobUploadChunk:
+		return requestClassPush
+	}
+
+	return requestClassNone
+}
+
+// rateLimiter applies the configured rate limit rules to requests.
+type rateLimiter struct {
+	limiter ratelimit.Limiter
+	rules   []configuration.RateLimitRule
+}
+
+// match returns the index of the first rule matching the identity and
+// repository, or -1 if none match.
+func (rl *rateLimiter) match(identity, repo string) int {
+	for i, rule := range rl.rules {
+		if (len(rule.Users) == 0 || glob.MatchAny(rule.Users, identity)) &&
+			(len(rule.Repositories) == 0 || glob.MatchAny(rule.Repositories, repo)) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// limit returns the limit for the class of request under the rule.
+func (rl *rateLimiter) limit(rule int, class requestClass) ratelimit.Limit {
+	var bucket configuration.RateLimitBucket
+	switch class {
+	case requestClassManifest:
+		bucket = rl.rules[rule].Manifest
+	case requestClassBlob:
+		bucket = rl.rules[rule].Blob
+	case requestClassPush:
+		bucket = rl.rules[rule].Push
+	}
+
+	return ratelimit.Limit{Rate: bucket.Rate, Burst: bucket.Burst}
+}
+
+// configureRateLimit sets up the rate limiter, if rules are configured.
+func (app *App) configureRateLimit(configuration *configuration.Configuration) {
+	if len(configuration.RateLimit.Rules) == 0 {
+		return
+	}
+
+	backend := configuration.RateLimit.Backend
+	if backend == "" {
+		backend = "inmemory"
+	}
+
+	var limiter ratelimit.Limiter
+	switch backend {
+	case "redis":
+		if app.redis == nil {
+			panic("redis configuration required to use redis rate limiting")
+		}
+		limiter = ratelimit.NewRedisLimiter(app.redis)
+	case "inmemory":
+		limiter = ratelimit.NewInMemoryLimiter()
+	default:
+		panic(fmt.Sprintf("unknown rate limit backend %q", backend))
+	}
+
+	ctxu.GetLogger(app).Infof("using %s rate limiting with %d rules", backend, len(configuration.RateLimit.Rules))
+
+	app.rateLimiter = &rateLimiter{
+		limiter: limiter,
+		rules:   configuration.RateLimit.Rules,
+	}
+}
+
+// rateLimitKey returns the bucket key and limit for the request. If the
+// request is not subject to a limit, ok is false.
+func (app *App) rateLimitKey(context *Context, r *http.Request) (key string, limit ratelimit.Limit, ok bool) {
+	if app.rateLimiter == nil {
+		return "", limit, false
+	}
+
+	class := classifyRequest(r)
+	if class == requestClassNone {
+		return "", limit, false
+	}
+
+	identity := getUserName(context, r)
+	if identity == "" {
+		identity = ctxu.RemoteIP(r)
+	}
+
+	rule := app.rateLimiter.match(identity, getName(context))
+	if rule < 0 {
+		return "", limit, false
+	}
+
+	limit = app.rateLimiter.limit(rule, class)
+	if limit.Unlimited() {
+		return "", limit, false
+	}
+
+	return fmt.Sprintf("%s:%d:%s", class, rule, identity), limit, true
+}
+
+// rateLimited checks the request against the configured rate limits. If the
+// request has exceeded its limit, the response is written and an error is
+// returned. Errors from the limiter itself are logged and the request is
+// allowed to proceed.
+func (app *App) rateLimited(w http.ResponseWriter, r *http.Request, context *Context) error {
+	key, limit, ok := app.rateLimitKey(context, r)
+	if !ok {
+		return nil
+	}
+
+	retryAfter, err := app.rateLimiter.limiter.Allow(key, limit)
+	if err != nil {
+		ctxu.GetLogger(context).Errorf("error checking rate limit: %v", err)
+		return nil
+	}
+
+	if retryAfter <= 0 {
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Retry-After", fmt.Sprint(int64(math.Ceil(retryAfter.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	var errs v2.Errors
+	errs.Push(v2.ErrorCodeTooManyRequests, map[string]interface{}{
+		"retryAfter": retryAfter.String(),
+	})
+	serveJSON(w, errs)
+
+	return fmt.Errorf("rate limit exceeded for %s", key)
+}
+
+// chargeRateLimit charges the bytes written in the response against the
+// request's rate limit, for classes limited by response size.
+func (app *App) chargeRateLimit(context *Context, r *http.Request) {
+	if classifyRequest(r) != requestClassBlob {
+		return
+	}
+
+	key, limit, ok := app.rateLimitKey(context, r)
+	if !ok {
+		return
+	}
+
+	written, _ := context.Value("http.response.written").(int64)
+
+	// One token was already taken when the request was allowed.
+	if err := app.rateLimiter.limiter.Charge(key, limit, written-1); err != nil {
+		ctxu.GetLogger(context).Errorf("error charging rate limit: %v", err)
+	}
+}
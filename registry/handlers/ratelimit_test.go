@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/registry/api/v2"
+	"golang.org/x/net/context"
+)
+
+// TestRateLimit ensures that requests over the configured rate are rejected
+// with a retry hint, while requests not matching a rule proceed.
+func TestRateLimit(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": nil,
+		},
+		RateLimit: configuration.RateLimit{
+			Rules: []configuration.RateLimitRule{
+				{
+					Repositories: []string{"limited/*"},
+					Manifest: configuration.RateLimitBucket{
+						Rate:  0.01,
+						Burst: 2,
+					},
+				},
+			},
+		},
+	}
+
+	app := NewApp(context.Background(), config)
+	server := httptest.NewServer(app)
+	builder, err := v2.NewURLBuilderFromString(server.URL)
+	if err != nil {
+		t.Fatalf("error creating urlbuilder: %v", err)
+	}
+
+	get := func(name string) *http.Response {
+		u, err := builder.BuildTagsURL(name)
+		if err != nil {
+			t.Fatalf("unexpected error building tags url: %v", err)
+		}
+
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatalf("unexpected error during GET: %v", err)
+		}
+
+		return resp
+	}
+
+	for i := 0; i < 2; i++ {
+		resp := get("limited/foo")
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusTooManyRequests {
+			t.Fatalf("request %d within burst was rate limited", i)
+		}
+	}
+
+	resp := get("limited/foo")
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status code: %v != %v", resp.StatusCode, http.StatusTooManyRequests)
+	}
+
+	if resp.Header.Get("Retry-After") != "100" {
+		t.Fatalf("unexpected Retry-After header: %q != %q", resp.Header.Get("Retry-After"), "100")
+	}
+
+	var errs v2.Errors
+	if err := json.NewDecoder(resp.Body).Decode(&errs); err != nil {
+		t.Fatalf("error decoding error response: %v", err)
+	}
+
+	if errs.Errors[0].Code != v2.ErrorCodeTooManyRequests {
+		t.Fatalf("unexpected error code: %v != %v", errs.Errors[0].Code, v2.ErrorCodeTooManyRequests)
+	}
+
+	// Repositories without a matching rule are not limited.
+	for i := 0; i < 5; i++ {
+		resp := get("unlimited/foo")
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusTooManyRequests {
+			t.Fatalf("request %d for repository without rule was rate limited", i)
+		}
+	}
+}
+
+// TestClassifyRequest ensures that requests are counted against the bucket
+// of their route and method.
+func TestClassifyRequest(t *testing.T) {
+	builder := v2.NewURLBuilder(&url.URL{Scheme: "http", Host: "registry.local"})
+	tagsURL, _ := builder.BuildTagsURL("foo/bar")
+	tagURL, _ := builder.BuildTagURL("foo/bar", "latest")
+	historyURL, _ := builder.BuildTagHistoryURL("foo/bar", "latest")
+	manifestURL, _ := builder.BuildManifestURL("foo/bar", "latest")
+
+	var class requestClass
+	router := v2.Router()
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("no route for %s %s", r.Method, r.URL)
+	})
+	for _, name := range []string{v2.RouteNameTags, v2.RouteNameTag, v2.RouteNameTagHistory, v2.RouteNameManifest} {
+		router.GetRoute(name).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			class = classifyRequest(r)
+		}))
+	}
+
+	for _, testcase := range []struct {
+		method   string
+		url      string
+		expected requestClass
+	}{
+		{method: "GET", url: tagsURL, expected: requestClassManifest},
+		{method: "GET", url: manifestURL, expected: requestClassManifest},
+		{method: "PUT", url: manifestURL, expected: requestClassPush},
+		{method: "GET", url: tagURL, expected: requestClassManifest},
+		{method: "HEAD", url: tagURL, expected: requestClassManifest},
+		{method: "PUT", url: tagURL, expected: requestClassPush},
+		{method: "DELETE", url: tagURL, expected: requestClassPush},
+		{method: "GET", url: historyURL, expected: requestClassManifest},
+		{method: "POST", url: historyURL, expected: requestClassPush},
+	} {
+		req, err := http.NewRequest(testcase.method, testcase.url, nil)
+		if err != nil {
+			t.Fatalf("error creating request: %v", err)
+		}
+
+		class = requestClassNone
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		if class != testcase.expected {
+			t.Errorf("unexpected class for %s %s: %v != %v", testcase.method, testcase.url, class, testcase.expected)
+		}
+	}
+}
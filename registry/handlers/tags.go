@@ -1,10 +1,15 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 
 	"github.com/docker/distribution"
+	ctxu "github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/registry/api/v2"
 	"github.com/gorilla/handlers"
 )
@@ -33,9 +38,8 @@ type tagsAPIResponse struct {
 // GetTags returns a json list of tags for a specific image name.
 func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	manifests := th.Repository.Manifests()
 
-	tags, err := manifests.Tags()
+	tags, err := th.Repository.Tags().All()
 	if err != nil {
 		switch err := err.(type) {
 		case distribution.ErrRepositoryUnknown:
@@ -58,3 +62,240 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// tagDispatcher constructs the handler for managing a single tag.
+func tagDispatcher(ctx *Context, r *http.Request) http.Handler {
+	tagHandler := &tagHandler{
+		Context: ctx,
+		Tag:     getTag(ctx),
+	}
+
+	return handlers.MethodHandler{
+		"GET":    http.HandlerFunc(tagHandler.GetTag),
+		"PUT":    http.HandlerFunc(tagHandler.PutTag),
+		"DELETE": http.HandlerFunc(tagHandler.DeleteTag),
+	}
+}
+
+// tagHandler handles requests for a single tag, independently of the
+// manifest it references.
+type tagHandler struct {
+	*Context
+
+	Tag string
+}
+
+// GetTag returns the descriptor of the manifest referenced by the tag.
+func (th *tagHandler) GetTag(w http.ResponseWriter, r *http.Request) {
+	ctxu.GetLogger(th).Debug("GetTag")
+
+	desc, err := th.Repository.Tags().Get(th.Tag)
+	if err != nil {
+		th.pushTagError(w, err)
+		return
+	}
+
+	p, err := json.Marshal(desc)
+	if err != nil {
+		th.Errors.PushErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", fmt.Sprint(len(p)))
+	w.Header().Set("Docker-Content-Digest", desc.Digest.String())
+	w.Write(p)
+}
+
+// PutTag points the tag at the manifest digest provided in the request body.
+func (th *tagHandler) PutTag(w http.ResponseWriter, r *http.Request) {
+	ctxu.GetLogger(th).Debug("PutTag")
+
+	var desc distribution.Descriptor
+	if err := json.NewDecoder(r.Body).Decode(&desc); err != nil {
+		th.Errors.Push(v2.ErrorCodeDigestInvalid, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if _, err := digest.ParseDigest(desc.Digest.String()); err != nil {
+		th.Errors.Push(v2.ErrorCodeDigestInvalid, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := th.Repository.Tags().Tag(th.Tag, desc); err != nil {
+		th.pushTagError(w, err)
+		return
+	}
+
+	location, err := th.urlBuilder.BuildManifestURL(th.Repository.Name(), th.Tag)
+	if err != nil {
+		ctxu.GetLogger(th).Errorf("error building manifest url from tag: %v", err)
+	}
+
+	w.Header().Set("Location", location)
+	w.Header().Set("Content-Length", "0")
+	w.Header().Set("Docker-Content-Digest", desc.Digest.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+// DeleteTag removes the tag, leaving the manifest it referenced in place.
+func (th *tagHandler) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	ctxu.GetLogger(th).Debug("DeleteTag")
+
+	if err := th.Repository.Tags().Untag(th.Tag); err != nil {
+		th.pushTagError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// tagHistoryDispatcher constructs the handler for the history of a single
+// tag.
+func tagHistoryDispatcher(ctx *Context, r *http.Request) http.Handler {
+	tagHandler := &tagHandler{
+		Context: ctx,
+		Tag:     getTag(ctx),
+	}
+
+	return handlers.MethodHandler{
+		"GET":  http.HandlerFunc(tagHandler.GetTagHistory),
+		"POST": http.HandlerFunc(tagHandler.RollbackTag),
+	}
+}
+
+type tagHistoryAPIResponse struct {
+	Name    string                         `json:"name"`
+	Tag     string                         `json:"tag"`
+	History []distribution.TagHistoryEntry `json:"history"`
+}
+
+// GetTagHistory returns the manifest revisions the tag has referenced, most
+// recently tagged first.
+func (th *tagHandler) GetTagHistory(w http.ResponseWriter, r *http.Request) {
+	ctxu.GetLogger(th).Debug("GetTagHistory")
+
+	history, err := th.Repository.Tags().History(th.Tag)
+	if err != nil {
+		th.pushTagError(w, err)
+		return
+	}
+
+	p, err := json.Marshal(tagHistoryAPIResponse{
+		Name:    th.Repository.Name(),
+		Tag:     th.Tag,
+		History: history,
+	})
+	if err != nil {
+		th.Errors.PushErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", fmt.Sprint(len(p)))
+	w.Write(p)
+}
+
+// RollbackTag points the tag at a revision from its history: the digest
+// provided in the request body or, without one, the most recently tagged
+// revision other than the current one.
+func (th *tagHandler) RollbackTag(w http.ResponseWriter, r *http.Request) {
+	ctxu.GetLogger(th).Debug("RollbackTag")
+
+	p, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		th.Errors.Push(v2.ErrorCodeDigestInvalid, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var requested distribution.Descriptor
+	if len(bytes.TrimSpace(p)) > 0 {
+		if err := json.Unmarshal(p, &requested); err != nil {
+			th.Errors.Push(v2.ErrorCodeDigestInvalid, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if _, err := digest.ParseDigest(requested.Digest.String()); err != nil {
+			th.Errors.Push(v2.ErrorCodeDigestInvalid, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	tags := th.Repository.Tags()
+	current, err := tags.Get(th.Tag)
+	if err != nil {
+		th.pushTagError(w, err)
+		return
+	}
+
+	history, err := tags.History(th.Tag)
+	if err != nil {
+		th.pushTagError(w, err)
+		return
+	}
+
+	var target digest.Digest
+	for _, entry := range history {
+		if requested.Digest != "" {
+			if entry.Digest == requested.Digest {
+				target = entry.Digest
+				break
+			}
+		} else if entry.Digest != current.Digest {
+			target = entry.Digest
+			break
+		}
+	}
+
+	if target == "" {
+		detail := map[string]string{"tag": th.Tag}
+		if requested.Digest != "" {
+			detail["digest"] = requested.Digest.String()
+		}
+
+		th.Errors.Push(v2.ErrorCodeManifestUnknown, detail)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := tags.Tag(th.Tag, distribution.Descriptor{Digest: target}); err != nil {
+		th.pushTagError(w, err)
+		return
+	}
+
+	location, err := th.urlBuilder.BuildManifestURL(th.Repository.Name(), th.Tag)
+	if err != nil {
+		ctxu.GetLogger(th).Errorf("error building manifest url from tag: %v", err)
+	}
+
+	w.Header().Set("Location", location)
+	w.Header().Set("Content-Length", "0")
+	w.Header().Set("Docker-Content-Digest", target.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+// pushTagError maps errors from the tag service onto the response.
+func (th *tagHandler) pushTagError(w http.ResponseWriter, err error) {
+	switch err := err.(type) {
+	case distribution.ErrManifestUnknown:
+		th.Errors.Push(v2.ErrorCodeManifestUnknown, map[string]string{"tag": err.Tag})
+		w.WriteHeader(http.StatusNotFound)
+	case distribution.ErrTagImmutable:
+		th.Errors.Push(v2.ErrorCodeTagImmutable, map[string]string{"tag": err.Tag, "digest": err.Revision.String()})
+		w.WriteHeader(http.StatusConflict)
+	case distribution.ErrUnknownManifestRevision:
+		th.Errors.Push(v2.ErrorCodeManifestUnknown, map[string]string{"digest": err.Revision.String()})
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		th.Errors.PushErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is the number of buckets retained before full buckets are
+// pruned from the in-memory limiter.
+const maxIdleBuckets = 10000
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// fill adds the tokens accrued since the last update to the bucket.
+func (b *bucket) fill(now time.Time, limit Limit) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(limit.capacity(), b.tokens+elapsed*limit.Rate)
+	}
+	b.last = now
+}
+
+type inMemoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	limits  map[string]Limit
+	now     func() time.Time
+}
+
+// NewInMemoryLimiter returns a Limiter that keeps its buckets in memory.
+// Buckets are not shared between registry instances.
+func NewInMemoryLimiter() Limiter {
+	return newInMemoryLimiter(time.Now)
+}
+
+func newInMemoryLimiter(now func() time.Time) *inMemoryLimiter {
+	return &inMemoryLimiter{
+		buckets: make(map[string]*bucket),
+		limits:  make(map[string]Limit),
+		now:     now,
+	}
+}
+
+func (iml *inMemoryLimiter) Allow(key string, limit Limit) (time.Duration, error) {
+	if limit.Unlimited() {
+		return 0, nil
+	}
+
+	iml.mu.Lock()
+	defer iml.mu.Unlock()
+
+	b := iml.bucket(key, limit)
+	if d := retryAfter(b.tokens, limit); d > 0 {
+		return d, nil
+	}
+
+	b.tokens--
+	return 0, nil
+}
+
+func (iml *inMemoryLimiter) Charge(key string, limit Limit, n int64) error {
+	if limit.Unlimited() || n <= 0 {
+		return nil
+	}
+
+	iml.mu.Lock()
+	defer iml.mu.Unlock()
+
+	b := iml.bucket(key, limit)
+	b.tokens -= float64(n)
+	return nil
+}
+
+// bucket returns the filled bucket for key, creating a full one if it does
+// not exist. The caller must hold the lock.
+func (iml *inMemoryLimiter) bucket(key string, limit Limit) *bucket {
+	now := iml.now()
+
+	b, ok := iml.buckets[key]
+	if !ok {
+		if len(iml.buckets) >= maxIdleBuckets {
+			iml.prune(now)
+		}
+
+		b = &bucket{tokens: limit.capacity(), last: now}
+		iml.buckets[key] = b
+		iml.limits[key] = limit
+		return b
+	}
+
+	iml.limits[key] = limit
+	b.fill(now, limit)
+	return b
+}
+
+// prune removes buckets that would be full by now. Such buckets are
+// indistinguishable from new buckets, so removing them does not change the
+// outcome of any later request.
+func (iml *inMemoryLimiter) prune(now time.Time) {
+	for key, b := range iml.buckets {
+		limit := iml.limits[key]
+		b.fill(now, limit)
+
+		if b.tokens >= limit.capacity() {
+			delete(iml.buckets, key)
+			delete(iml.limits, key)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestInMemoryLimiter checks the in memory implementation is working
+// correctly.
+func TestInMemoryLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	checkLimiter(t, newInMemoryLimiter(clock.Now), clock.Advance)
+}
+
+// TestInMemoryLimiterPrune ensures that full buckets are dropped once the
+// limiter grows large, while buckets in use are kept.
+func TestInMemoryLimiterPrune(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	limiter := newInMemoryLimiter(clock.Now)
+	limit := Limit{Rate: 1, Burst: 1}
+
+	if d, err := limiter.Allow("busy", limit); err != nil || d != 0 {
+		t.Fatalf("expected request to be allowed: %v, %v", d, err)
+	}
+
+	for i := 0; len(limiter.buckets) < maxIdleBuckets; i++ {
+		limiter.buckets["idle-"+strconv.Itoa(i)] = &bucket{tokens: 1, last: clock.Now()}
+		limiter.limits["idle-"+strconv.Itoa(i)] = limit
+	}
+
+	if err := limiter.Charge("new", limit, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(limiter.buckets) != 2 {
+		t.Fatalf("expected idle buckets to be pruned, have %d buckets", len(limiter.buckets))
+	}
+
+	if _, ok := limiter.buckets["busy"]; !ok {
+		t.Fatalf("bucket in use was pruned")
+	}
+}
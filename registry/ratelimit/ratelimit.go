@@ -0,0 +1,64 @@
+// Package ratelimit provides token bucket rate limiting for registry
+// requests. Buckets are identified by an opaque key and configured by a Limit
+// at each call, allowing the caller to decide how requests are grouped.
+//
+// Two implementations are provided: an in-memory limiter, which limits each
+// registry instance independently, and a redis limiter, which shares buckets
+// between all instances connected to the same redis database.
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Limit describes a token bucket. Tokens are added at Rate per second up to a
+// maximum of Burst. A zero Rate disables limiting.
+type Limit struct {
+	// Rate is the number of tokens added to the bucket every second.
+	Rate float64
+
+	// Burst is the capacity of the bucket. If less than one, the bucket
+	// holds a single second worth of tokens.
+	Burst int64
+}
+
+// Unlimited returns true if the limit does not restrict requests.
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// capacity returns the maximum number of tokens the bucket may hold.
+func (l Limit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+
+	return math.Max(l.Rate, 1)
+}
+
+// Limiter maintains a set of token buckets.
+type Limiter interface {
+	// Allow takes a single token from the bucket identified by key. If the
+	// bucket is empty, no token is taken and the returned duration is the
+	// time until the next token will be available. A zero duration means the
+	// request may proceed.
+	Allow(key string, limit Limit) (time.Duration, error)
+
+	// Charge takes n tokens from the bucket identified by key, regardless of
+	// whether they are available. This is used when the cost of a request is
+	// only known once it has completed, such as the number of bytes served.
+	// The bucket may go into debt, causing Allow to reject requests until
+	// it has been repaid.
+	Charge(key string, limit Limit, n int64) error
+}
+
+// retryAfter calculates the time until a bucket holding tokens will hold at
+// least one token.
+func retryAfter(tokens float64, limit Limit) time.Duration {
+	if tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// checkLimiter exercises a limiter implementation. The limiter must be empty
+// and use the provided clock, advanced by the advance function.
+func checkLimiter(t *testing.T, limiter Limiter, advance func(time.Duration)) {
+	limit := Limit{Rate: 2, Burst: 3}
+
+	// A new bucket is full, so the burst is allowed through.
+	for i := 0; i < 3; i++ {
+		d, err := limiter.Allow("user-a", limit)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if d != 0 {
+			t.Fatalf("request %d should have been allowed, retry after %v", i, d)
+		}
+	}
+
+	d, err := limiter.Allow("user-a", limit)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if d <= 0 || d > time.Second/2 {
+		t.Fatalf("expected request to be limited for at most 500ms, got %v", d)
+	}
+
+	// Other keys are unaffected.
+	if d, err := limiter.Allow("user-b", limit); err != nil || d != 0 {
+		t.Fatalf("expected request for other key to be allowed: %v, %v", d, err)
+	}
+
+	// Half a second refills one token.
+	advance(time.Second / 2)
+	if d, err := limiter.Allow("user-a", limit); err != nil || d != 0 {
+		t.Fatalf("expected request to be allowed after refill: %v, %v", d, err)
+	}
+
+	// Charging puts the bucket into debt, which must be repaid before the
+	// next request.
+	advance(10 * time.Second)
+	if err := limiter.Charge("user-a", limit, 7); err != nil {
+		t.Fatalf("unexpected error charging: %v", err)
+	}
+
+	d, err = limiter.Allow("user-a", limit)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 3 - 7 = -4 tokens, 5 short of one token at 2 per second.
+	if d != 2500*time.Millisecond {
+		t.Fatalf("unexpected retry after: %v != %v", d, 2500*time.Millisecond)
+	}
+
+	advance(d)
+	if d, err := limiter.Allow("user-a", limit); err != nil || d != 0 {
+		t.Fatalf("expected request to be allowed after debt was repaid: %v, %v", d, err)
+	}
+
+	// Unlimited limits are never restricted.
+	for i := 0; i < 10; i++ {
+		if d, err := limiter.Allow("user-c", Limit{}); err != nil || d != 0 {
+			t.Fatalf("expected unlimited request to be allowed: %v, %v", d, err)
+		}
+	}
+}
+
+// fakeClock provides a manually advanced time source.
+type fakeClock struct {
+	now time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	return fc.now
+}
+
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.now = fc.now.Add(d)
+}
@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// takeScript atomically refills the bucket stored in the hash at KEYS[1] and
+// takes ARGV[4] tokens from it. When ARGV[5] is "allow", tokens are only
+// taken if at least one is available. The key expires once the bucket would
+// be full again, since a missing key is treated as a full bucket.
+//
+// Returns a two element array: 1 if tokens were taken, 0 otherwise, and the
+// number of tokens remaining as a string to preserve the fraction.
+var takeScript = redis.NewScript(1, `
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local allow = ARGV[5] == "allow"
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+if tokens == nil or last == nil then
+	tokens = capacity
+	last = now
+end
+
+if now > last then
+	tokens = math.min(capacity, tokens + (now - last) * rate)
+	last = now
+end
+
+local taken = 0
+if not allow or tokens >= 1 then
+	tokens = tokens - n
+	taken = 1
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "last", tostring(last))
+redis.call("EXPIRE", KEYS[1], math.ceil((capacity - tokens) / rate) + 1)
+
+return {taken, tostring(tokens)}
+`)
+
+// redisLimiter keeps token buckets in redis, sharing them between all
+// registry instances using the same redis database.
+type redisLimiter struct {
+	pool *redis.Pool
+	now  func() time.Time
+}
+
+// NewRedisLimiter returns a Limiter backed by the provided redis connection
+// pool.
+func NewRedisLimiter(pool *redis.Pool) Limiter {
+	return &redisLimiter{
+		pool: pool,
+		now:  time.Now,
+	}
+}
+
+func (rl *redisLimiter) Allow(key string, limit Limit) (time.Duration, error) {
+	if limit.Unlimited() {
+		return 0, nil
+	}
+
+	taken, tokens, err := rl.take(key, limit, 1, "allow")
+	if err != nil {
+		return 0, err
+	}
+
+	if taken {
+		return 0, nil
+	}
+
+	return retryAfter(tokens, limit), nil
+}
+
+func (rl *redisLimiter) Charge(key string, limit Limit, n int64) error {
+	if limit.Unlimited() || n <= 0 {
+		return nil
+	}
+
+	_, _, err := rl.take(key, limit, n, "charge")
+	return err
+}
+
+func (rl *redisLimiter) take(key string, limit Limit, n int64, mode string) (bool, float64, error) {
+	conn := rl.pool.Get()
+	defer conn.Close()
+
+	now := float64(rl.now().UnixNano()) / float64(time.Second)
+	reply, err := redis.Values(takeScript.Do(conn,
+		rl.bucketKey(key),
+		strconv.FormatFloat(limit.Rate, 'f', -1, 64),
+		strconv.FormatFloat(limit.capacity(), 'f', -1, 64),
+		strconv.FormatFloat(now, 'f', -1, 64),
+		n, mode))
+	if err != nil {
+		return false, 0, err
+	}
+
+	if len(reply) != 2 {
+		return false, 0, fmt.Errorf("unexpected reply from rate limit script: %v", reply)
+	}
+
+	taken, err := redis.Int(reply[0], nil)
+	if err != nil {
+		return false, 0, err
+	}
+
+	tokens, err := redis.Float64(reply[1], nil)
+	if err != nil {
+		return false, 0, err
+	}
+
+	return taken == 1, tokens, nil
+}
+
+func (rl *redisLimiter) bucketKey(key string) string {
+	return "ratelimit::" + key
+}
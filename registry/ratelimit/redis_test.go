@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var redisAddr string
+
+func init() {
+	flag.StringVar(&redisAddr, "test.registry.ratelimit.redis.addr", "", "configure the address of a test instance of redis")
+}
+
+// TestRedisLimiter exercises a live redis instance using the limiter
+// implementation.
+func TestRedisLimiter(t *testing.T) {
+	if redisAddr == "" {
+		// fallback to an environement variable
+		redisAddr = os.Getenv("TEST_REGISTRY_RATELIMIT_REDIS_ADDR")
+	}
+
+	if redisAddr == "" {
+		// skip if still not set
+		t.Skip("please set -test.registry.ratelimit.redis.addr to test the rate limiter against redis")
+	}
+
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redisAddr)
+		},
+		MaxIdle:   1,
+		MaxActive: 2,
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+
+	// Clear the database
+	if _, err := pool.Get().Do("FLUSHDB"); err != nil {
+		t.Fatalf("unexpected error flushing redis db: %v", err)
+	}
+
+	clock := &fakeClock{now: time.Now()}
+	limiter := NewRedisLimiter(pool).(*redisLimiter)
+	limiter.now = clock.Now
+
+	checkLimiter(t, limiter, clock.Advance)
+}
@@ -287,6 +287,55 @@ func TestLayerUploadZeroLength(t *testing.T) {
 	}
 }
 
+// TestLayerUploadWrite ensures that buffered writes advance the offset of the
+// upload, so that uploads written in chunks are finished with their content.
+func TestLayerUploadWrite(t *testing.T) {
+	ctx := context.Background()
+	imageName := "foo/bar"
+	driver := inmemory.New()
+	registry := NewRegistryWithDriver(driver, cache.NewInMemoryLayerInfoCache())
+	repository, err := registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	ls := repository.Layers()
+
+	upload, err := ls.Upload()
+	if err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+
+	content := []byte("some layer content, written in chunks")
+	for _, chunk := range [][]byte{content[:10], content[10:20], content[20:]} {
+		if _, err := upload.Write(chunk); err != nil {
+			t.Fatalf("unexpected error writing chunk: %v", err)
+		}
+	}
+
+	offset, err := upload.Seek(0, os.SEEK_CUR)
+	if err != nil {
+		t.Fatalf("unexpected error seeking upload: %v", err)
+	}
+
+	if offset != int64(len(content)) {
+		t.Fatalf("upload not updated with correct offset: %v != %v", offset, len(content))
+	}
+
+	dgst, err := digest.FromBytes(content)
+	if err != nil {
+		t.Fatalf("error digesting content: %v", err)
+	}
+
+	layer, err := upload.Finish(dgst)
+	if err != nil {
+		t.Fatalf("unexpected error finishing upload: %v", err)
+	}
+
+	if layer.Digest() != dgst {
+		t.Fatalf("unexpected digest: %v != %v", layer.Digest(), dgst)
+	}
+}
+
 // writeRandomLayer creates a random layer under name and tarSum using driver
 // and pathMapper. An io.ReadSeeker with the data is returned, along with the
 // sha256 hex digest.
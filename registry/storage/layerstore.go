@@ -7,7 +7,6 @@ import (
 	"github.com/docker/distribution"
 	ctxu "github.com/docker/distribution/context"
 	"github.com/docker/distribution/digest"
-	"github.com/docker/distribution/manifest"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 )
 
@@ -142,7 +141,7 @@ func (ls *layerStore) newLayerUpload(uuid, path string, startedAt time.Time) (di
 		layerStore:         ls,
 		uuid:               uuid,
 		startedAt:          startedAt,
-		bufferedFileWriter: *fw,
+		bufferedFileWriter: fw,
 	}
 
 	lw.setupResumableDigester()
@@ -162,9 +161,7 @@ func (ls *layerStore) path(dgst digest.Digest) (string, error) {
 	if err != nil {
 		switch err := err.(type) {
 		case storagedriver.PathNotFoundError:
-			return "", distribution.ErrUnknownLayer{
-				FSLayer: manifest.FSLayer{BlobSum: dgst},
-			}
+			return "", distribution.ErrUnknownLayer{Digest: dgst}
 		default:
 			return "", err
 		}
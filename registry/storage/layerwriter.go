@@ -27,8 +27,9 @@ type layerWriter struct {
 	resumableDigester digest.ResumableDigester
 
 	// implementes io.WriteSeeker, io.ReaderFrom and io.Closer to satisfy
-	// LayerUpload Interface
-	bufferedFileWriter
+	// LayerUpload Interface. It must be held by pointer: the buffered writer
+	// updates the offset and size of the fileWriter it was created with.
+	*bufferedFileWriter
 }
 
 var _ distribution.LayerUpload = &layerWriter{}
@@ -53,6 +54,13 @@ func (lw *layerWriter) Finish(dgst digest.Digest) (distribution.Layer, error) {
 		return nil, err
 	}
 
+	if lw.layerStore.repository.registry.rejectTarSum && dgst.IsTarSum() {
+		return nil, distribution.ErrLayerInvalidDigest{
+			Digest: dgst,
+			Reason: fmt.Errorf("tarsum digests are not accepted"),
+		}
+	}
+
 	var (
 		canonical digest.Digest
 		err       error
@@ -79,13 +87,25 @@ func (lw *layerWriter) Finish(dgst digest.Digest) (distribution.Layer, error) {
 
 	}
 
+	// Accounting and linking are serialized per layer in the repository, so
+	// that concurrent uploads of the same layer reserve its size once.
+	unlock := lw.layerStore.repository.registry.linkLocks.lock(lw.layerStore.repository.Name(), canonical)
+	defer unlock()
+
+	reserved, err := lw.reserveQuota(canonical)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := lw.moveLayer(canonical); err != nil {
 		// TODO(stevvooe): Cleanup?
+		lw.releaseQuota(reserved)
 		return nil, err
 	}
 
 	// Link the layer blob into the repository.
 	if err := lw.linkLayer(canonical, dgst); err != nil {
+		lw.releaseQuota(reserved)
 		return nil, err
 	}
 
@@ -119,7 +139,7 @@ func (lw *layerWriter) Write(p []byte) (int, error) {
 		return 0, err
 	}
 
-	return io.MultiWriter(&lw.bufferedFileWriter, lw.resumableDigester).Write(p)
+	return io.MultiWriter(lw.bufferedFileWriter, lw.resumableDigester).Write(p)
 }
 
 func (lw *layerWriter) ReadFrom(r io.Reader) (n int64, err error) {
@@ -307,7 +327,7 @@ func (lw *layerWriter) validateLayer(dgst digest.Digest) (digest.Digest, error)
 
 		if canonical.Algorithm() == dgst.Algorithm() {
 			// Common case: client and server prefer the same canonical digest
-			// algorithm - currently SHA256.
+			// algorithm.
 			verified = dgst == canonical
 		} else {
 			// The client wants to use a different digest algorithm. They'll just
@@ -321,7 +341,10 @@ func (lw *layerWriter) validateLayer(dgst digest.Digest) (digest.Digest, error)
 	}
 
 	if fullHash {
-		digester := digest.NewCanonicalDigester()
+		digester, err := digest.NewDigesterForAlgorithm(lw.layerStore.repository.registry.digestAlgorithm)
+		if err != nil {
+			return "", err
+		}
 
 		digestVerifier, err := digest.NewDigestVerifier(dgst)
 		if err != nil {
@@ -356,6 +379,52 @@ func (lw *layerWriter) validateLayer(dgst digest.Digest) (digest.Digest, error)
 	return canonical, nil
 }
 
+// reserveQuota accounts for the size of the layer against the quota of the
+// repository, returning the number of bytes reserved. Layers already linked
+// into the repository are not accounted for again. If the layer would exceed
+// the quota, distribution.ErrQuotaExceeded is returned. Callers must hold the
+// link lock of the layer until it is linked or the reservation is released.
+func (lw *layerWriter) reserveQuota(canonical digest.Digest) (int64, error) {
+	enforcer := lw.layerStore.repository.registry.quota
+	if enforcer == nil {
+		return 0, nil
+	}
+
+	layerLinkPath, err := lw.layerStore.repository.registry.pm.path(layerLinkPathSpec{
+		name:   lw.layerStore.repository.Name(),
+		digest: canonical,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := lw.driver.Stat(layerLinkPath); err == nil {
+		return 0, nil // already accounted for in this repository
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		return 0, err
+	}
+
+	// The buffered writer has been flushed by Finish, so its size is that of
+	// the upload data.
+	if err := enforcer.Reserve(lw.layerStore.repository.Name(), lw.size); err != nil {
+		return 0, err
+	}
+
+	return lw.size, nil
+}
+
+// releaseQuota returns a reservation made by reserveQuota after a failure to
+// store the layer.
+func (lw *layerWriter) releaseQuota(reserved int64) {
+	if reserved == 0 {
+		return
+	}
+
+	if err := lw.layerStore.repository.registry.quota.Release(lw.layerStore.repository.Name(), reserved); err != nil {
+		ctxu.GetLogger(lw.layerStore.repository.ctx).Errorf("error releasing quota reservation: %v", err)
+	}
+}
+
 // moveLayer moves the data into its final, hash-qualified destination,
 // identified by dgst. The layer should be validated before commencing the
 // move.
@@ -395,8 +464,8 @@ func (lw *layerWriter) moveLayer(dgst digest.Digest) error {
 			// get a hash, then the underlying file is deleted, we risk moving
 			// a zero-length blob into a nonzero-length blob location. To
 			// prevent this horrid thing, we employ the hack of only allowing
-			// to this happen for the zero tarsum.
-			if dgst == digest.DigestSha256EmptyTar {
+			// to this happen for the digest of empty content.
+			if empty, err := digest.NewDigesterForAlgorithm(dgst.Algorithm()); err == nil && dgst == empty.Digest() {
 				return lw.driver.PutContent(blobPath, []byte{})
 			}
 
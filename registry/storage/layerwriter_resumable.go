@@ -5,5 +5,12 @@ package storage
 import "github.com/docker/distribution/digest"
 
 func (lw *layerWriter) setupResumableDigester() {
-	lw.resumableDigester = digest.NewCanonicalResumableDigester()
+	resumableDigester, err := digest.NewResumableDigester(lw.layerStore.repository.registry.digestAlgorithm)
+	if err != nil {
+		// Algorithms without a resumable implementation are hashed in full
+		// when the upload is finished.
+		return
+	}
+
+	lw.resumableDigester = resumableDigester
 }
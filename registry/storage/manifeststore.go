@@ -24,12 +24,12 @@ func (ms *manifestStore) Exists(dgst digest.Digest) (bool, error) {
 	return ms.revisionStore.exists(dgst)
 }
 
-func (ms *manifestStore) Get(dgst digest.Digest) (*manifest.SignedManifest, error) {
+func (ms *manifestStore) Get(dgst digest.Digest) (distribution.Manifest, error) {
 	ctxu.GetLogger(ms.repository.ctx).Debug("(*manifestStore).Get")
 	return ms.revisionStore.get(dgst)
 }
 
-func (ms *manifestStore) Put(manifest *manifest.SignedManifest) error {
+func (ms *manifestStore) Put(mnfst distribution.Manifest, tag string) error {
 	ctxu.GetLogger(ms.repository.ctx).Debug("(*manifestStore).Put")
 
 	// TODO(stevvooe): Add check here to see if the revision is already
@@ -38,18 +38,37 @@ func (ms *manifestStore) Put(manifest *manifest.SignedManifest) error {
 	// indicating what happened.
 
 	// Verify the manifest.
-	if err := ms.verifyManifest(manifest); err != nil {
+	if err := ms.verifyManifest(mnfst); err != nil {
 		return err
 	}
 
+	if err := ms.verifyTrust(mnfst); err != nil {
+		return err
+	}
+
+	// Sign the manifest before storing it, so that the registry signature is
+	// stored along with those of the client.
+	if sm, ok := mnfst.(*manifest.SignedManifest); ok && ms.repository.signingKey != nil {
+		signed, err := ms.sign(sm)
+		if err != nil {
+			return err
+		}
+
+		mnfst = signed
+	}
+
 	// Store the revision of the manifest
-	revision, err := ms.revisionStore.put(manifest)
+	revision, err := ms.revisionStore.put(mnfst)
 	if err != nil {
 		return err
 	}
 
+	if tag == "" {
+		return nil
+	}
+
 	// Now, tag the manifest
-	return ms.tagStore.tag(manifest.Tag, revision)
+	return ms.tagStore.tag(tag, revision)
 }
 
 // Delete removes the revision of the specified manfiest.
@@ -58,17 +77,7 @@ func (ms *manifestStore) Delete(dgst digest.Digest) error {
 	return fmt.Errorf("deletion of manifests not supported")
 }
 
-func (ms *manifestStore) Tags() ([]string, error) {
-	ctxu.GetLogger(ms.repository.ctx).Debug("(*manifestStore).Tags")
-	return ms.tagStore.tags()
-}
-
-func (ms *manifestStore) ExistsByTag(tag string) (bool, error) {
-	ctxu.GetLogger(ms.repository.ctx).Debug("(*manifestStore).ExistsByTag")
-	return ms.tagStore.exists(tag)
-}
-
-func (ms *manifestStore) GetByTag(tag string) (*manifest.SignedManifest, error) {
+func (ms *manifestStore) GetByTag(tag string) (distribution.Manifest, error) {
 	ctxu.GetLogger(ms.repository.ctx).Debug("(*manifestStore).GetByTag")
 	dgst, err := ms.tagStore.resolve(tag)
 	if err != nil {
@@ -79,11 +88,65 @@ func (ms *manifestStore) GetByTag(tag string) (*manifest.SignedManifest, error)
 }
 
 // verifyManifest ensures that the manifest content is valid from the
-// perspective of the registry. It ensures that the signature is valid for the
-// enclosed payload. As a policy, the registry only tries to store valid
-// content, leaving trust policies of that content up to consumers.
-func (ms *manifestStore) verifyManifest(mnfst *manifest.SignedManifest) error {
+// perspective of the registry. It ensures that every reference of the
+// manifest is present in the repository and, for signed manifests, that the
+// signature is valid for the enclosed payload. As a policy, the registry only
+// tries to store valid content, leaving trust policies of that content up to
+// consumers.
+func (ms *manifestStore) verifyManifest(mnfst distribution.Manifest) error {
 	var errs distribution.ErrManifestVerification
+
+	if sm, ok := mnfst.(*manifest.SignedManifest); ok {
+		errs = append(errs, ms.verifySignedManifest(sm)...)
+	}
+
+	manifestMediaTypes := make(map[string]bool)
+	for _, mediaType := range distribution.ManifestMediaTypes() {
+		manifestMediaTypes[mediaType] = true
+	}
+
+	for _, reference := range mnfst.References() {
+		if manifestMediaTypes[reference.MediaType] {
+			// The reference is another manifest, such as an entry of a
+			// manifest list.
+			exists, err := ms.revisionStore.exists(reference.Digest)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			if !exists {
+				errs = append(errs, distribution.ErrUnknownManifestRevision{
+					Name:     ms.repository.Name(),
+					Revision: reference.Digest,
+				})
+			}
+
+			continue
+		}
+
+		exists, err := ms.repository.Layers().Exists(reference.Digest)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		if !exists {
+			errs = append(errs, distribution.ErrUnknownLayer{Digest: reference.Digest})
+		}
+	}
+
+	if len(errs) != 0 {
+		// TODO(stevvooe): These need to be recoverable by a caller.
+		return errs
+	}
+
+	return nil
+}
+
+// verifySignedManifest checks the name and signature of a schema version 1
+// manifest, returning any errors found.
+func (ms *manifestStore) verifySignedManifest(mnfst *manifest.SignedManifest) []error {
+	var errs []error
 	if mnfst.Name != ms.repository.Name() {
 		// TODO(stevvooe): This needs to be an exported error
 		errs = append(errs, fmt.Errorf("repository name does not match manifest name"))
@@ -102,21 +165,47 @@ func (ms *manifestStore) verifyManifest(mnfst *manifest.SignedManifest) error {
 		}
 	}
 
-	for _, fsLayer := range mnfst.FSLayers {
-		exists, err := ms.repository.Layers().Exists(fsLayer.BlobSum)
-		if err != nil {
-			errs = append(errs, err)
+	return errs
+}
+
+// verifyTrust checks the signatures of the manifest against the trust policy
+// of the repository. Untrusted manifests are rejected if the policy is
+// enforced and logged otherwise.
+func (ms *manifestStore) verifyTrust(mnfst distribution.Manifest) error {
+	if len(ms.repository.trust.Rules) == 0 {
+		return nil
+	}
+
+	var (
+		payload    []byte
+		signatures [][]byte
+		err        error
+	)
+
+	if sm, ok := mnfst.(signedManifest); ok {
+		if payload, err = sm.Canonical(); err != nil {
+			return err
 		}
 
-		if !exists {
-			errs = append(errs, distribution.ErrUnknownLayer{FSLayer: fsLayer})
+		if signatures, err = sm.Signatures(); err != nil {
+			return err
 		}
 	}
 
-	if len(errs) != 0 {
-		// TODO(stevvooe): These need to be recoverable by a caller.
-		return errs
+	verification := ms.repository.verifySignatures(payload, signatures)
+	if verification.Trusted() {
+		return nil
 	}
 
-	return nil
+	if !ms.repository.trust.Enforce {
+		ctxu.GetLogger(ms.repository.ctx).Warnf("accepting manifest without a trusted signature, keys: %v", verification.KeyIDs)
+		return nil
+	}
+
+	return distribution.ErrManifestVerification{
+		distribution.ErrManifestUntrusted{
+			Name:   ms.repository.Name(),
+			KeyIDs: verification.KeyIDs,
+		},
+	}
 }
@@ -11,6 +11,8 @@ import (
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
 	"github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/distribution/registry/storage/driver/inmemory"
 	"github.com/docker/distribution/testutil"
@@ -50,14 +52,15 @@ func newManifestStoreTestEnv(t *testing.T, name, tag string) *manifestStoreTestE
 func TestManifestStorage(t *testing.T) {
 	env := newManifestStoreTestEnv(t, "foo/bar", "thetag")
 	ms := env.repository.Manifests()
+	ts := env.repository.Tags()
 
-	exists, err := ms.ExistsByTag(env.tag)
-	if err != nil {
-		t.Fatalf("unexpected error checking manifest existence: %v", err)
-	}
-
-	if exists {
-		t.Fatalf("manifest should not exist")
+	if _, err := ts.Get(env.tag); true {
+		switch err.(type) {
+		case distribution.ErrManifestUnknown:
+			break
+		default:
+			t.Fatalf("expected manifest unknown error: %#v", err)
+		}
 	}
 
 	if _, err := ms.GetByTag(env.tag); true {
@@ -103,7 +106,7 @@ func TestManifestStorage(t *testing.T) {
 		t.Fatalf("error signing manifest: %v", err)
 	}
 
-	err = ms.Put(sm)
+	err = ms.Put(sm, sm.Tag)
 	if err == nil {
 		t.Fatalf("expected errors putting manifest")
 	}
@@ -126,19 +129,10 @@ func TestManifestStorage(t *testing.T) {
 		}
 	}
 
-	if err = ms.Put(sm); err != nil {
+	if err = ms.Put(sm, sm.Tag); err != nil {
 		t.Fatalf("unexpected error putting manifest: %v", err)
 	}
 
-	exists, err = ms.ExistsByTag(env.tag)
-	if err != nil {
-		t.Fatalf("unexpected error checking manifest existence: %v", err)
-	}
-
-	if !exists {
-		t.Fatalf("manifest should exist")
-	}
-
 	fetchedManifest, err := ms.GetByTag(env.tag)
 	if err != nil {
 		t.Fatalf("unexpected error fetching manifest: %v", err)
@@ -148,7 +142,12 @@ func TestManifestStorage(t *testing.T) {
 		t.Fatalf("fetched manifest not equal: %#v != %#v", fetchedManifest, sm)
 	}
 
-	fetchedJWS, err := libtrust.ParsePrettySignature(fetchedManifest.Raw, "signatures")
+	fetchedSigned, ok := fetchedManifest.(*manifest.SignedManifest)
+	if !ok {
+		t.Fatalf("unexpected manifest type: %T", fetchedManifest)
+	}
+
+	fetchedJWS, err := libtrust.ParsePrettySignature(fetchedSigned.Raw, "signatures")
 	if err != nil {
 		t.Fatalf("unexpected error parsing jws: %v", err)
 	}
@@ -165,7 +164,16 @@ func TestManifestStorage(t *testing.T) {
 		t.Fatalf("error getting manifest digest: %v", err)
 	}
 
-	exists, err = ms.Exists(dgst)
+	desc, err := ts.Get(env.tag)
+	if err != nil {
+		t.Fatalf("unexpected error getting tag: %v", err)
+	}
+
+	if desc.Digest != dgst || desc.MediaType != manifest.ManifestMediaType || desc.Size != int64(len(payload)) {
+		t.Fatalf("unexpected tag descriptor: %#v", desc)
+	}
+
+	exists, err := ms.Exists(dgst)
 	if err != nil {
 		t.Fatalf("error checking manifest existence by digest: %v", err)
 	}
@@ -193,7 +201,7 @@ func TestManifestStorage(t *testing.T) {
 	}
 
 	// Grabs the tags and check that this tagged manifest is present
-	tags, err := ms.Tags()
+	tags, err := ts.All()
 	if err != nil {
 		t.Fatalf("unexpected error fetching tags: %v", err)
 	}
@@ -231,7 +239,7 @@ func TestManifestStorage(t *testing.T) {
 		t.Fatalf("unexpected number of signatures: %d != %d", len(sigs2), 1)
 	}
 
-	if err = ms.Put(sm2); err != nil {
+	if err = ms.Put(sm2, sm2.Tag); err != nil {
 		t.Fatalf("unexpected error putting manifest: %v", err)
 	}
 
@@ -240,7 +248,12 @@ func TestManifestStorage(t *testing.T) {
 		t.Fatalf("unexpected error fetching manifest: %v", err)
 	}
 
-	if _, err := manifest.Verify(fetched); err != nil {
+	fetchedSigned, ok = fetched.(*manifest.SignedManifest)
+	if !ok {
+		t.Fatalf("unexpected manifest type: %T", fetched)
+	}
+
+	if _, err := manifest.Verify(fetchedSigned); err != nil {
 		t.Fatalf("unexpected error verifying manifest: %v", err)
 	}
 
@@ -255,7 +268,7 @@ func TestManifestStorage(t *testing.T) {
 		t.Fatalf("unexpected error getting expected signatures: %v", err)
 	}
 
-	receivedJWS, err := libtrust.ParsePrettySignature(fetched.Raw, "signatures")
+	receivedJWS, err := libtrust.ParsePrettySignature(fetchedSigned.Raw, "signatures")
 	if err != nil {
 		t.Fatalf("unexpected error parsing jws: %v", err)
 	}
@@ -288,3 +301,224 @@ func TestManifestStorage(t *testing.T) {
 		t.Fatalf("unexpected an error deleting manifest by digest: %v", err)
 	}
 }
+
+func TestManifestStorageSchema2(t *testing.T) {
+	env := newManifestStoreTestEnv(t, "foo/bar", "thetag")
+	ms := env.repository.Manifests()
+
+	config := []byte(`{"architecture": "amd64", "os": "linux"}`)
+	configDigest, err := digest.FromBytes(config)
+	if err != nil {
+		t.Fatalf("unexpected error digesting config: %v", err)
+	}
+
+	m := schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		MediaType: schema2.MediaTypeManifest,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeConfig,
+			Size:      int64(len(config)),
+			Digest:    configDigest,
+		},
+	}
+
+	testBlobs := map[digest.Digest]io.ReadSeeker{
+		configDigest: bytes.NewReader(config),
+	}
+
+	for i := 0; i < 2; i++ {
+		rs, ds, err := testutil.CreateRandomTarFile()
+		if err != nil {
+			t.Fatalf("unexpected error generating test layer file")
+		}
+		dgst := digest.Digest(ds)
+
+		size, err := rs.Seek(0, 2)
+		if err != nil {
+			t.Fatalf("unexpected error seeking test layer: %v", err)
+		}
+
+		if _, err := rs.Seek(0, 0); err != nil {
+			t.Fatalf("unexpected error seeking test layer: %v", err)
+		}
+
+		testBlobs[dgst] = rs
+		m.Layers = append(m.Layers, distribution.Descriptor{
+			MediaType: schema2.MediaTypeLayer,
+			Size:      size,
+			Digest:    dgst,
+		})
+	}
+
+	deserialized, err := schema2.FromStruct(m)
+	if err != nil {
+		t.Fatalf("unexpected error creating manifest: %v", err)
+	}
+
+	if err := ms.Put(deserialized, env.tag); err == nil {
+		t.Fatalf("expected errors putting manifest with unknown blobs")
+	} else if verificationErrs, ok := err.(distribution.ErrManifestVerification); !ok {
+		t.Fatalf("unexpected error type: %#v", err)
+	} else if len(verificationErrs) != len(testBlobs) {
+		t.Fatalf("expected an error for each blob: %v", verificationErrs)
+	}
+
+	for dgst, rs := range testBlobs {
+		upload, err := env.repository.Layers().Upload()
+		if err != nil {
+			t.Fatalf("unexpected error creating test upload: %v", err)
+		}
+
+		if _, err := io.Copy(upload, rs); err != nil {
+			t.Fatalf("unexpected error copying to upload: %v", err)
+		}
+
+		if _, err := upload.Finish(dgst); err != nil {
+			t.Fatalf("unexpected error finishing upload: %v", err)
+		}
+	}
+
+	if err := ms.Put(deserialized, env.tag); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	_, payload, err := deserialized.Payload()
+	if err != nil {
+		t.Fatalf("unexpected error getting payload: %v", err)
+	}
+
+	dgst, err := digest.FromBytes(payload)
+	if err != nil {
+		t.Fatalf("unexpected error digesting payload: %v", err)
+	}
+
+	fetchedByTag, err := ms.GetByTag(env.tag)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest: %v", err)
+	}
+
+	if !reflect.DeepEqual(fetchedByTag, deserialized) {
+		t.Fatalf("fetched manifest not equal: %#v != %#v", fetchedByTag, deserialized)
+	}
+
+	fetchedByDigest, err := ms.Get(dgst)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest by digest: %v", err)
+	}
+
+	if !reflect.DeepEqual(fetchedByDigest, deserialized) {
+		t.Fatalf("fetched manifest not equal: %#v != %#v", fetchedByDigest, deserialized)
+	}
+
+	// Schema 2 manifests carry no signatures, so none should be stored.
+	if sigs, err := env.repository.Signatures().Get(dgst); err == nil && len(sigs) != 0 {
+		t.Fatalf("unexpected signatures stored for schema 2 manifest: %v", sigs)
+	}
+}
+
+func TestManifestStorageManifestList(t *testing.T) {
+	env := newManifestStoreTestEnv(t, "foo/bar", "thetag")
+	ms := env.repository.Manifests()
+
+	var descriptors []manifestlist.ManifestDescriptor
+	for _, architecture := range []string{"amd64", "arm64"} {
+		config := []byte(`{"architecture": "` + architecture + `", "os": "linux"}`)
+		configDigest, err := digest.FromBytes(config)
+		if err != nil {
+			t.Fatalf("unexpected error digesting config: %v", err)
+		}
+
+		upload, err := env.repository.Layers().Upload()
+		if err != nil {
+			t.Fatalf("unexpected error creating test upload: %v", err)
+		}
+
+		if _, err := upload.Write(config); err != nil {
+			t.Fatalf("unexpected error writing config: %v", err)
+		}
+
+		if _, err := upload.Finish(configDigest); err != nil {
+			t.Fatalf("unexpected error finishing upload: %v", err)
+		}
+
+		deserialized, err := schema2.FromStruct(schema2.Manifest{
+			Versioned: schema2.SchemaVersion,
+			MediaType: schema2.MediaTypeManifest,
+			Config: distribution.Descriptor{
+				MediaType: schema2.MediaTypeConfig,
+				Size:      int64(len(config)),
+				Digest:    configDigest,
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error creating manifest: %v", err)
+		}
+
+		_, payload, err := deserialized.Payload()
+		if err != nil {
+			t.Fatalf("unexpected error getting payload: %v", err)
+		}
+
+		dgst, err := digest.FromBytes(payload)
+		if err != nil {
+			t.Fatalf("unexpected error digesting payload: %v", err)
+		}
+
+		descriptors = append(descriptors, manifestlist.ManifestDescriptor{
+			Descriptor: distribution.Descriptor{
+				MediaType: schema2.MediaTypeManifest,
+				Size:      int64(len(payload)),
+				Digest:    dgst,
+			},
+			Platform: manifestlist.PlatformSpec{
+				Architecture: architecture,
+				OS:           "linux",
+			},
+		})
+
+		// Only the first platform manifest is pushed before the list.
+		if len(descriptors) == 1 {
+			if err := ms.Put(deserialized, ""); err != nil {
+				t.Fatalf("unexpected error putting manifest: %v", err)
+			}
+		}
+	}
+
+	list, err := manifestlist.FromDescriptors(descriptors)
+	if err != nil {
+		t.Fatalf("unexpected error creating manifest list: %v", err)
+	}
+
+	err = ms.Put(list, env.tag)
+	if err == nil {
+		t.Fatalf("expected error putting manifest list referencing unknown manifest")
+	}
+
+	verificationErrs, ok := err.(distribution.ErrManifestVerification)
+	if !ok || len(verificationErrs) != 1 {
+		t.Fatalf("unexpected error putting manifest list: %#v", err)
+	}
+
+	if unknown, ok := verificationErrs[0].(distribution.ErrUnknownManifestRevision); !ok || unknown.Revision != descriptors[1].Digest {
+		t.Fatalf("unexpected verification error: %#v", verificationErrs[0])
+	}
+
+	// Drop the missing platform and try again.
+	list, err = manifestlist.FromDescriptors(descriptors[:1])
+	if err != nil {
+		t.Fatalf("unexpected error creating manifest list: %v", err)
+	}
+
+	if err := ms.Put(list, env.tag); err != nil {
+		t.Fatalf("unexpected error putting manifest list: %v", err)
+	}
+
+	fetched, err := ms.GetByTag(env.tag)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest list: %v", err)
+	}
+
+	if !reflect.DeepEqual(fetched, list) {
+		t.Fatalf("fetched manifest list not equal: %#v != %#v", fetched, list)
+	}
+}
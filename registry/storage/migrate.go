@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+	storageDriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// MigrateLayerLinks links every layer of each repository under the digest of
+// its content with the registered digest algorithm alg, so that layers
+// previously linked only by tarsum, or by digests of another algorithm, can
+// be fetched by their canonical digest. Existing links are left in place,
+// keeping manifests that reference them valid. Blob content is only read
+// when the blob is stored under a digest of another algorithm.
+func MigrateLayerLinks(driver storageDriver.StorageDriver, alg string) error {
+	log.Infof("MigrateLayerLinks starting")
+
+	if !digest.AlgorithmAvailable(alg) {
+		return digest.ErrDigestUnsupported
+	}
+
+	root, err := defaultPathMapper.path(repositoriesRootPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	var (
+		errors   []error
+		migrated int
+	)
+
+	err = Walk(driver, root, func(fileInfo storageDriver.FileInfo) error {
+		filePath := fileInfo.Path()
+		_, file := path.Split(filePath)
+		if file[0] != '_' {
+			return nil
+		}
+
+		// Reserved directory
+		if file == "_layers" && fileInfo.IsDir() {
+			repo := strings.TrimPrefix(path.Dir(filePath), root+"/")
+			n, errs := migrateLayerLinks(driver, repo, filePath, alg)
+			if len(errs) > 0 {
+				errors = append(errors, errs...)
+			}
+			migrated += n
+		}
+
+		return ErrSkipDir
+	})
+
+	if err != nil {
+		if _, ok := err.(storageDriver.PathNotFoundError); !ok {
+			errors = pushError(errors, root, err)
+		}
+	}
+
+	if len(errors) > 0 {
+		for _, err := range errors {
+			log.Errorf("error migrating layer links: %v", err)
+		}
+		return fmt.Errorf("layer links not fully migrated: %d errors encountered", len(errors))
+	}
+
+	log.Infof("MigrateLayerLinks finished. Num links created=%d", migrated)
+	return nil
+}
+
+// migrateLayerLinks creates the missing alg links of the layers linked under
+// layersPath into the repository, returning the number of links created.
+func migrateLayerLinks(driver storageDriver.StorageDriver, repo, layersPath, alg string) (int, []error) {
+	var (
+		errors  []error
+		targets []digest.Digest
+	)
+
+	// Collect the blobs first, so that the links created below are not
+	// visited by the walk.
+	seen := make(map[digest.Digest]struct{})
+	err := Walk(driver, layersPath, func(fileInfo storageDriver.FileInfo) error {
+		filePath := fileInfo.Path()
+		if fileInfo.IsDir() || path.Base(filePath) != "link" {
+			return nil
+		}
+
+		content, err := driver.GetContent(filePath)
+		if err != nil {
+			errors = pushError(errors, filePath, err)
+			return nil
+		}
+
+		dgst, err := digest.ParseDigest(string(content))
+		if err != nil {
+			errors = pushError(errors, filePath, err)
+			return nil
+		}
+
+		if _, ok := seen[dgst]; !ok {
+			seen[dgst] = struct{}{}
+			targets = append(targets, dgst)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		errors = pushError(errors, layersPath, err)
+	}
+
+	migrated := 0
+	for _, target := range targets {
+		canonical, err := blobDigest(driver, target, alg)
+		if err != nil {
+			if _, ok := err.(storageDriver.PathNotFoundError); ok {
+				// Dangling links cannot be migrated.
+				log.Warnf("layer link in %s references missing blob %s", repo, target)
+				continue
+			}
+
+			errors = pushError(errors, target.String(), err)
+			continue
+		}
+
+		layerLinkPath, err := defaultPathMapper.path(layerLinkPathSpec{name: repo, digest: canonical})
+		if err != nil {
+			errors = pushError(errors, canonical.String(), err)
+			continue
+		}
+
+		if _, err := driver.Stat(layerLinkPath); err == nil {
+			continue
+		} else if _, ok := err.(storageDriver.PathNotFoundError); !ok {
+			errors = pushError(errors, layerLinkPath, err)
+			continue
+		}
+
+		if err := driver.PutContent(layerLinkPath, []byte(target)); err != nil {
+			errors = pushError(errors, layerLinkPath, err)
+			continue
+		}
+
+		migrated++
+	}
+
+	return migrated, errors
+}
+
+// blobDigest returns the digest with the algorithm alg of the blob stored
+// under dgst, reading the blob only if dgst uses another algorithm.
+func blobDigest(driver storageDriver.StorageDriver, dgst digest.Digest, alg string) (digest.Digest, error) {
+	blobPath, err := defaultPathMapper.path(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return "", err
+	}
+
+	if dgst.Algorithm() == alg {
+		if _, err := driver.Stat(blobPath); err != nil {
+			return "", err
+		}
+
+		return dgst, nil
+	}
+
+	digester, err := digest.NewDigesterForAlgorithm(alg)
+	if err != nil {
+		return "", err
+	}
+
+	rc, err := driver.ReadStream(blobPath, 0)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	if _, err := io.Copy(digester, rc); err != nil {
+		return "", err
+	}
+
+	return digester.Digest(), nil
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/docker/distribution/testutil"
+	"golang.org/x/net/context"
+)
+
+// createTestLayerContent returns random layer content along with its tarsum.
+func createTestLayerContent(t *testing.T) ([]byte, digest.Digest) {
+	rs, tarSumStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random reader: %v", err)
+	}
+
+	p, err := ioutil.ReadAll(rs)
+	if err != nil {
+		t.Fatalf("error reading random layer: %v", err)
+	}
+
+	return p, digest.Digest(tarSumStr)
+}
+
+func uploadTestLayer(t *testing.T, repo distribution.Repository, p []byte, dgst digest.Digest) (distribution.Layer, error) {
+	upload, err := repo.Layers().Upload()
+	if err != nil {
+		t.Fatalf("unexpected error starting layer upload: %v", err)
+	}
+
+	if _, err := io.Copy(upload, bytes.NewReader(p)); err != nil {
+		t.Fatalf("unexpected error uploading layer data: %v", err)
+	}
+
+	return upload.Finish(dgst)
+}
+
+func digestTestContent(t *testing.T, alg string, p []byte) digest.Digest {
+	digester, err := digest.NewDigesterForAlgorithm(alg)
+	if err != nil {
+		t.Fatalf("unexpected error creating digester: %v", err)
+	}
+
+	digester.Write(p)
+	return digester.Digest()
+}
+
+func checkLayerExists(t *testing.T, repo distribution.Repository, dgst digest.Digest, expected bool) {
+	exists, err := repo.Layers().Exists(dgst)
+	if err != nil {
+		t.Fatalf("unexpected error checking layer %s: %v", dgst, err)
+	}
+
+	if exists != expected {
+		t.Fatalf("unexpected existence of layer %s: %v != %v", dgst, exists, expected)
+	}
+}
+
+// TestLayerDigestAlgorithm ensures layers are identified by the configured
+// algorithm and remain available under the digests provided by clients.
+func TestLayerDigestAlgorithm(t *testing.T) {
+	registry := NewRegistryWithDriver(inmemory.New(), nil, LayerDigestAlgorithm("sha512"))
+	repo, err := registry.Repository(context.Background(), "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	for _, provided := range []string{"sha256", "sha512", "tarsum"} {
+		p, tarSum := createTestLayerContent(t)
+		dgst := tarSum
+		if provided != "tarsum" {
+			dgst = digestTestContent(t, provided, p)
+		}
+
+		layer, err := uploadTestLayer(t, repo, p, dgst)
+		if err != nil {
+			t.Fatalf("unexpected error finishing upload with %s digest: %v", provided, err)
+		}
+
+		canonical := digestTestContent(t, "sha512", p)
+		if layer.Digest() != canonical {
+			t.Fatalf("unexpected layer digest: %s != %s", layer.Digest(), canonical)
+		}
+
+		checkLayerExists(t, repo, dgst, true)
+		checkLayerExists(t, repo, canonical, true)
+	}
+}
+
+// TestRejectTarSum ensures tarsum digests are refused for uploads when
+// disabled.
+func TestRejectTarSum(t *testing.T) {
+	registry := NewRegistryWithDriver(inmemory.New(), nil, RejectTarSum())
+	repo, err := registry.Repository(context.Background(), "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	p, tarSum := createTestLayerContent(t)
+	if _, err := uploadTestLayer(t, repo, p, tarSum); err == nil {
+		t.Fatalf("expected error finishing upload with tarsum")
+	} else if _, ok := err.(distribution.ErrLayerInvalidDigest); !ok {
+		t.Fatalf("unexpected error finishing upload with tarsum: %#v", err)
+	}
+
+	checkLayerExists(t, repo, tarSum, false)
+
+	dgst := digestTestContent(t, "sha256", p)
+	if _, err := uploadTestLayer(t, repo, p, dgst); err != nil {
+		t.Fatalf("unexpected error finishing upload: %v", err)
+	}
+}
+
+// TestMigrateLayerLinks ensures layers linked only by tarsum are linked under
+// their canonical digests by the migration.
+func TestMigrateLayerLinks(t *testing.T) {
+	driver := inmemory.New()
+
+	// Migrating an empty registry is not an error.
+	if err := MigrateLayerLinks(driver, "sha256"); err != nil {
+		t.Fatalf("unexpected error migrating empty registry: %v", err)
+	}
+
+	registry := NewRegistryWithDriver(driver, nil)
+	repo, err := registry.Repository(context.Background(), "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	p, tarSum := createTestLayerContent(t)
+	if _, err := uploadTestLayer(t, repo, p, tarSum); err != nil {
+		t.Fatalf("unexpected error finishing upload: %v", err)
+	}
+
+	// Remove the canonical link, as left by registries linking layers only
+	// by tarsum.
+	sha256Digest := digestTestContent(t, "sha256", p)
+	linkPath, err := defaultPathMapper.path(layerLinkPathSpec{name: repo.Name(), digest: sha256Digest})
+	if err != nil {
+		t.Fatalf("unexpected error getting link path: %v", err)
+	}
+
+	if err := driver.Delete(linkPath); err != nil {
+		t.Fatalf("unexpected error deleting link: %v", err)
+	}
+
+	checkLayerExists(t, repo, sha256Digest, false)
+
+	if err := MigrateLayerLinks(driver, "sha256"); err != nil {
+		t.Fatalf("unexpected error migrating layer links: %v", err)
+	}
+
+	checkLayerExists(t, repo, sha256Digest, true)
+	checkLayerExists(t, repo, tarSum, true)
+
+	// Migrating to another algorithm links the digests of the content.
+	sha512Digest := digestTestContent(t, "sha512", p)
+	checkLayerExists(t, repo, sha512Digest, false)
+
+	if err := MigrateLayerLinks(driver, "sha512"); err != nil {
+		t.Fatalf("unexpected error migrating layer links: %v", err)
+	}
+
+	layer, err := repo.Layers().Fetch(sha512Digest)
+	if err != nil {
+		t.Fatalf("unexpected error fetching migrated layer: %v", err)
+	}
+
+	content, err := ioutil.ReadAll(layer)
+	if err != nil {
+		t.Fatalf("unexpected error reading migrated layer: %v", err)
+	}
+
+	if !bytes.Equal(content, p) {
+		t.Fatalf("unexpected content of migrated layer")
+	}
+
+	if err := MigrateLayerLinks(driver, "tarsum.v1+sha256"); err != digest.ErrDigestUnsupported {
+		t.Fatalf("expected unsupported algorithm error: %v", err)
+	}
+}
@@ -28,6 +28,7 @@ const storagePathVersion = "v2"
 //							-> current/link
 // 							-> index
 //								-> <algorithm>/<hex digest>/link
+// 							-> history/<id>
 // 					-> _layers/
 // 						<layer links to blob store>
 // 					-> _uploads/<uuid>
@@ -79,6 +80,8 @@ const storagePathVersion = "v2"
 // 	manifestTagIndexPathSpec:              <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/
 // 	manifestTagIndexEntryPathSpec:         <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/
 // 	manifestTagIndexEntryLinkPathSpec:     <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/link
+// 	manifestTagHistoryPathSpec:            <root>/v2/repositories/<name>/_manifests/tags/<tag>/history/
+// 	manifestTagHistoryEntryPathSpec:       <root>/v2/repositories/<name>/_manifests/tags/<tag>/history/<id>
 //
 // 	Layers:
 //
@@ -213,6 +216,26 @@ func (pm *pathMapper) path(spec pathSpec) (string, error) {
 		}
 
 		return path.Join(root, "link"), nil
+	case manifestTagHistoryPathSpec:
+		root, err := pm.path(manifestTagPathSpec{
+			name: v.name,
+			tag:  v.tag,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, "history"), nil
+	case manifestTagHistoryEntryPathSpec:
+		root, err := pm.path(manifestTagHistoryPathSpec{
+			name: v.name,
+			tag:  v.tag,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, v.id), nil
 	case manifestTagIndexEntryPathSpec:
 		root, err := pm.path(manifestTagIndexPathSpec{
 			name: v.name,
@@ -367,6 +390,27 @@ type manifestTagIndexEntryLinkPathSpec struct {
 
 func (manifestTagIndexEntryLinkPathSpec) pathSpec() {}
 
+// manifestTagHistoryPathSpec describes the directory of records of the
+// revisions a tag has been pointed at.
+type manifestTagHistoryPathSpec struct {
+	name string
+	tag  string
+}
+
+func (manifestTagHistoryPathSpec) pathSpec() {}
+
+// manifestTagHistoryEntryPathSpec describes a record of when, by whom and to
+// which revision the tag was pointed. Records are never overwritten and
+// their ids sort in the order they were written. The record is stored as
+// json.
+type manifestTagHistoryEntryPathSpec struct {
+	name string
+	tag  string
+	id   string
+}
+
+func (manifestTagHistoryEntryPathSpec) pathSpec() {}
+
 // layerLink specifies a path for a layer link, which is a file with a blob
 // id. The layer link will contain a content addressable blob id reference
 // into the blob store. The format of the contents is as follows:
@@ -88,6 +88,21 @@ func TestPathMapper(t *testing.T) {
 			},
 			expected: "/pathmapper-test/repositories/foo/bar/_manifests/tags/thetag/index/sha256/abcdef0123456789/link",
 		},
+		{
+			spec: manifestTagHistoryPathSpec{
+				name: "foo/bar",
+				tag:  "thetag",
+			},
+			expected: "/pathmapper-test/repositories/foo/bar/_manifests/tags/thetag/history",
+		},
+		{
+			spec: manifestTagHistoryEntryPathSpec{
+				name: "foo/bar",
+				tag:  "thetag",
+				id:   "00000000000000000001-abcdef",
+			},
+			expected: "/pathmapper-test/repositories/foo/bar/_manifests/tags/thetag/history/00000000000000000001-abcdef",
+		},
 		{
 			spec: layerLinkPathSpec{
 				name:   "foo/bar",
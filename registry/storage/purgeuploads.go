@@ -25,10 +25,18 @@ func newUploadData() uploadData {
 	}
 }
 
+// UploadPurgeListener is notified of the uploads deleted by PurgeUploads.
+type UploadPurgeListener interface {
+	// UploadPurged is called after the upload uuid of the named repository,
+	// started at startedAt, has been deleted.
+	UploadPurged(repo, uuid string, startedAt time.Time)
+}
+
 // PurgeUploads deletes files from the upload directory
 // created before olderThan.  The list of files deleted and errors
-// encountered are returned
-func PurgeUploads(driver storageDriver.StorageDriver, olderThan time.Time, actuallyDelete bool) ([]string, []error) {
+// encountered are returned. The listeners are notified of each deleted
+// upload.
+func PurgeUploads(driver storageDriver.StorageDriver, olderThan time.Time, actuallyDelete bool, listeners ...UploadPurgeListener) ([]string, []error) {
 	log.Infof("PurgeUploads starting: olderThan=%s, actuallyDelete=%t", olderThan, actuallyDelete)
 	uploadData, errors := getOutstandingUploads(driver)
 	var deleted []string
@@ -42,6 +50,9 @@ func PurgeUploads(driver storageDriver.StorageDriver, olderThan time.Time, actua
 			}
 			if err == nil {
 				deleted = append(deleted, uploadData.containingDir)
+				if actuallyDelete {
+					notifyUploadPurged(listeners, uploadData)
+				}
 			} else {
 				errors = append(errors, err)
 			}
@@ -52,6 +63,28 @@ func PurgeUploads(driver storageDriver.StorageDriver, olderThan time.Time, actua
 	return deleted, errors
 }
 
+// notifyUploadPurged notifies the listeners of the deletion of the upload.
+func notifyUploadPurged(listeners []UploadPurgeListener, ud uploadData) {
+	if len(listeners) == 0 {
+		return
+	}
+
+	root, err := defaultPathMapper.path(repositoriesRootPathSpec{})
+	if err != nil {
+		log.Errorf("error resolving repository of purged upload %s: %v", ud.containingDir, err)
+		return
+	}
+
+	// Upload directories are <root>/<name>/_uploads/<uuid>.
+	rel := strings.TrimPrefix(ud.containingDir, strings.TrimSuffix(root, "/")+"/")
+	name, uuid := path.Split(rel)
+	name = strings.TrimSuffix(name, "/_uploads/")
+
+	for _, listener := range listeners {
+		listener.UploadPurged(name, uuid, ud.startedAt)
+	}
+}
+
 // getOutstandingUploads walks the upload directory, collecting files
 // which could be eligible for deletion.  The only reliable way to
 // classify the age of a file is with the date stored in the startedAt
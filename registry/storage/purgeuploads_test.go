@@ -106,6 +106,40 @@ func TestPurgeSome(t *testing.T) {
 	}
 }
 
+func TestPurgeListener(t *testing.T) {
+	oneHourAgo := time.Now().Add(-1 * time.Hour).UTC().Truncate(time.Second)
+	fs := testUploadFS(t, 0, "", oneHourAgo)
+
+	oldID := uuid.New()
+	addUploads(t, fs, oldID, "library/test-repo", oneHourAgo)
+	addUploads(t, fs, uuid.New(), "library/test-repo", time.Now().Add(1*time.Hour))
+
+	var listener purgeRecorder
+	if _, errs := PurgeUploads(fs, time.Now(), false, &listener); len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+
+	if len(listener) != 0 {
+		t.Errorf("Listeners should only be notified of deleted uploads: %v", listener)
+	}
+
+	if _, errs := PurgeUploads(fs, time.Now(), true, &listener); len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+
+	expected := "library/test-repo " + oldID + " " + oneHourAgo.String()
+	if len(listener) != 1 || listener[0] != expected {
+		t.Errorf("Unexpected purged uploads: %v != [%s]", listener, expected)
+	}
+}
+
+// purgeRecorder records the uploads purged as "<repo> <uuid> <startedAt>".
+type purgeRecorder []string
+
+func (pr *purgeRecorder) UploadPurged(repo, uuid string, startedAt time.Time) {
+	*pr = append(*pr, repo+" "+uuid+" "+startedAt.String())
+}
+
 func TestPurgeOnlyUploads(t *testing.T) {
 	oldUploadCount := 5
 	oneHourAgo := time.Now().Add(-1 * time.Hour)
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+	storageDriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/quota"
+)
+
+// RebuildQuotaUsage recalculates the usage of each repository from the
+// layers linked into it and replaces the usage recorded by the enforcer.
+// Each layer is counted once per repository, matching the accounting done
+// on push. If any errors are encountered, the recorded usage is left
+// untouched.
+func RebuildQuotaUsage(driver storageDriver.StorageDriver, enforcer *quota.Enforcer) error {
+	log.Infof("RebuildQuotaUsage starting")
+
+	root, err := defaultPathMapper.path(repositoriesRootPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	var errors []error
+	usage := make(map[string]int64)
+	err = Walk(driver, root, func(fileInfo storageDriver.FileInfo) error {
+		filePath := fileInfo.Path()
+		_, file := path.Split(filePath)
+		if file[0] != '_' {
+			return nil
+		}
+
+		// Reserved directory
+		if file == "_layers" && fileInfo.IsDir() {
+			repo := strings.TrimPrefix(path.Dir(filePath), root+"/")
+			size, errs := layerLinksSize(driver, filePath)
+			if len(errs) > 0 {
+				errors = append(errors, errs...)
+			}
+			usage[repo] = size
+		}
+
+		return ErrSkipDir
+	})
+
+	if err != nil {
+		if _, ok := err.(storageDriver.PathNotFoundError); !ok {
+			errors = pushError(errors, root, err)
+		}
+	}
+
+	if len(errors) > 0 {
+		for _, err := range errors {
+			log.Errorf("error rebuilding quota usage: %v", err)
+		}
+		return fmt.Errorf("quota usage not rebuilt: %d errors encountered", len(errors))
+	}
+
+	log.Infof("RebuildQuotaUsage finished. Num repositories=%d", len(usage))
+	return enforcer.Reset(usage)
+}
+
+// layerLinksSize returns the total size of the blobs referenced by the layer
+// links under layersPath, counting each blob once.
+func layerLinksSize(driver storageDriver.StorageDriver, layersPath string) (int64, []error) {
+	var (
+		errors []error
+		size   int64
+	)
+
+	seen := make(map[digest.Digest]struct{})
+	err := Walk(driver, layersPath, func(fileInfo storageDriver.FileInfo) error {
+		filePath := fileInfo.Path()
+		if fileInfo.IsDir() || path.Base(filePath) != "link" {
+			return nil
+		}
+
+		content, err := driver.GetContent(filePath)
+		if err != nil {
+			errors = pushError(errors, filePath, err)
+			return nil
+		}
+
+		dgst, err := digest.ParseDigest(string(content))
+		if err != nil {
+			errors = pushError(errors, filePath, err)
+			return nil
+		}
+
+		if _, ok := seen[dgst]; ok {
+			return nil
+		}
+		seen[dgst] = struct{}{}
+
+		blobPath, err := defaultPathMapper.path(blobDataPathSpec{digest: dgst})
+		if err != nil {
+			errors = pushError(errors, filePath, err)
+			return nil
+		}
+
+		blobInfo, err := driver.Stat(blobPath)
+		if err != nil {
+			if _, ok := err.(storageDriver.PathNotFoundError); ok {
+				// Dangling links do not consume storage.
+				log.Warnf("layer link %s references missing blob %s", filePath, dgst)
+				return nil
+			}
+
+			errors = pushError(errors, blobPath, err)
+			return nil
+		}
+
+		size += blobInfo.Size()
+		return nil
+	})
+
+	if err != nil {
+		errors = pushError(errors, layersPath, err)
+	}
+
+	return size, errors
+}
+
+// layerLinkLocks serializes the accounting and linking of layers by
+// repository and digest. Locks only cover a single registry instance:
+// instances sharing storage may still account a layer pushed concurrently to
+// each of them twice, until usage is next rebuilt.
+type layerLinkLocks struct {
+	mu    sync.Mutex
+	locks map[string]*layerLinkLock
+}
+
+type layerLinkLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock acquires the lock of the layer dgst in the repository name, returning
+// a function releasing it.
+func (ll *layerLinkLocks) lock(name string, dgst digest.Digest) func() {
+	key := name + "@" + dgst.String()
+
+	ll.mu.Lock()
+	if ll.locks == nil {
+		ll.locks = make(map[string]*layerLinkLock)
+	}
+	l, ok := ll.locks[key]
+	if !ok {
+		l = &layerLinkLock{}
+		ll.locks[key] = l
+	}
+	l.refs++
+	ll.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		ll.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(ll.locks, key)
+		}
+		ll.mu.Unlock()
+	}
+}
@@ -0,0 +1,40 @@
+package quota
+
+import "sync"
+
+// inMemoryUsageStore keeps usage in a map, local to the registry instance.
+type inMemoryUsageStore struct {
+	mu    sync.Mutex
+	usage map[string]int64
+}
+
+// NewInMemoryUsageStore returns a UsageStore that keeps usage in memory.
+// Usage is lost on restart and should be rebuilt from storage.
+func NewInMemoryUsageStore() UsageStore {
+	return &inMemoryUsageStore{
+		usage: make(map[string]int64),
+	}
+}
+
+func (ims *inMemoryUsageStore) Usage(scope string) (int64, error) {
+	ims.mu.Lock()
+	defer ims.mu.Unlock()
+
+	return ims.usage[scope], nil
+}
+
+func (ims *inMemoryUsageStore) Add(scope string, delta int64) (int64, error) {
+	ims.mu.Lock()
+	defer ims.mu.Unlock()
+
+	ims.usage[scope] += delta
+	return ims.usage[scope], nil
+}
+
+func (ims *inMemoryUsageStore) Set(scope string, usage int64) error {
+	ims.mu.Lock()
+	defer ims.mu.Unlock()
+
+	ims.usage[scope] = usage
+	return nil
+}
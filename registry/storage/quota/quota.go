@@ -0,0 +1,152 @@
+// Package quota provides accounting and enforcement of storage quotas for
+// repositories. Usage is tracked in bytes per scope, where a scope is either
+// a single repository or a namespace, the first component of a repository
+// name. Limits are assigned to scopes by glob patterns.
+package quota
+
+import (
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/glob"
+)
+
+// UsageStore records the number of bytes stored under each scope.
+// Implementations must be safe for concurrent use.
+type UsageStore interface {
+	// Usage returns the current usage of the scope. Unknown scopes have no
+	// usage.
+	Usage(scope string) (int64, error)
+
+	// Add adjusts the usage of the scope by delta, returning the new usage.
+	Add(scope string, delta int64) (int64, error)
+
+	// Set replaces the usage of the scope.
+	Set(scope string, usage int64) error
+}
+
+// Limit assigns a maximum size, in bytes, to scopes matching any of the
+// patterns. An empty set of patterns matches all scopes.
+type Limit struct {
+	Patterns []string
+	Size     int64
+}
+
+// Usage describes the usage of a scope against its limit. A Limit of zero
+// indicates the scope is not limited.
+type Usage struct {
+	Scope string `json:"scope"`
+	Used  int64  `json:"used"`
+	Limit int64  `json:"limit"`
+}
+
+// Enforcer checks uploads against the configured limits, accounting for
+// their size in the usage store.
+type Enforcer struct {
+	store     UsageStore
+	namespace bool
+	limits    []Limit
+}
+
+// NewEnforcer returns an Enforcer recording usage in store. If namespace is
+// true, usage is shared by all repositories in a namespace. Otherwise, each
+// repository is accounted for separately. The first limit matching a scope
+// applies.
+func NewEnforcer(store UsageStore, namespace bool, limits []Limit) *Enforcer {
+	return &Enforcer{
+		store:     store,
+		namespace: namespace,
+		limits:    limits,
+	}
+}
+
+// Scope returns the scope under which the repository is accounted.
+func (e *Enforcer) Scope(repo string) string {
+	if e.namespace {
+		if i := strings.Index(repo, "/"); i >= 0 {
+			return repo[:i]
+		}
+	}
+
+	return repo
+}
+
+// Limit returns the limit for the scope, or zero if it is not limited.
+func (e *Enforcer) Limit(scope string) int64 {
+	for _, limit := range e.limits {
+		if len(limit.Patterns) == 0 || glob.MatchAny(limit.Patterns, scope) {
+			return limit.Size
+		}
+	}
+
+	return 0
+}
+
+// Usage returns the usage of the scope containing the repository.
+func (e *Enforcer) Usage(repo string) (Usage, error) {
+	scope := e.Scope(repo)
+
+	used, err := e.store.Usage(scope)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	return Usage{
+		Scope: scope,
+		Used:  used,
+		Limit: e.Limit(scope),
+	}, nil
+}
+
+// Reserve accounts for size bytes to be stored in the repository. If the
+// reservation would take the scope over its limit, it is reverted and
+// distribution.ErrQuotaExceeded is returned. Reservations for content that
+// is not stored should be returned with Release.
+func (e *Enforcer) Reserve(repo string, size int64) error {
+	scope := e.Scope(repo)
+
+	used, err := e.store.Add(scope, size)
+	if err != nil {
+		return err
+	}
+
+	limit := e.Limit(scope)
+	if limit > 0 && used > limit {
+		if _, err := e.store.Add(scope, -size); err != nil {
+			return err
+		}
+
+		return distribution.ErrQuotaExceeded{
+			Scope: scope,
+			Limit: limit,
+			Usage: used - size,
+			Size:  size,
+		}
+	}
+
+	return nil
+}
+
+// Release returns size bytes previously reserved for the repository.
+func (e *Enforcer) Release(repo string, size int64) error {
+	_, err := e.store.Add(e.Scope(repo), -size)
+	return err
+}
+
+// Reset replaces the usage of each scope with the provided totals, keyed by
+// repository name. This is used to rebuild accounting from the contents of
+// storage.
+func (e *Enforcer) Reset(repos map[string]int64) error {
+	totals := make(map[string]int64)
+	for repo, size := range repos {
+		totals[e.Scope(repo)] += size
+	}
+
+	for scope, used := range totals {
+		if err := e.store.Set(scope, used); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package quota
+
+import (
+	"testing"
+
+	"github.com/docker/distribution"
+)
+
+// checkUsageStore runs a basic test suite against the usage store
+// implementation.
+func checkUsageStore(t *testing.T, store UsageStore) {
+	if used, err := store.Usage("unknown"); err != nil || used != 0 {
+		t.Fatalf("expected no usage for unknown scope: %v, %v", used, err)
+	}
+
+	if used, err := store.Add("foo", 10); err != nil || used != 10 {
+		t.Fatalf("unexpected usage after add: %v, %v", used, err)
+	}
+
+	if used, err := store.Add("foo", -3); err != nil || used != 7 {
+		t.Fatalf("unexpected usage after negative add: %v, %v", used, err)
+	}
+
+	if err := store.Set("foo", 42); err != nil {
+		t.Fatalf("unexpected error setting usage: %v", err)
+	}
+
+	if used, err := store.Usage("foo"); err != nil || used != 42 {
+		t.Fatalf("unexpected usage after set: %v, %v", used, err)
+	}
+
+	if used, err := store.Usage("bar"); err != nil || used != 0 {
+		t.Fatalf("usage leaked between scopes: %v, %v", used, err)
+	}
+}
+
+func TestInMemoryUsageStore(t *testing.T) {
+	checkUsageStore(t, NewInMemoryUsageStore())
+}
+
+func TestEnforcerScope(t *testing.T) {
+	repository := NewEnforcer(NewInMemoryUsageStore(), false, nil)
+	namespace := NewEnforcer(NewInMemoryUsageStore(), true, nil)
+
+	for _, testcase := range []struct {
+		repo      string
+		repoScope string
+		nsScope   string
+	}{
+		{repo: "foo", repoScope: "foo", nsScope: "foo"},
+		{repo: "foo/bar", repoScope: "foo/bar", nsScope: "foo"},
+		{repo: "foo/bar/baz", repoScope: "foo/bar/baz", nsScope: "foo"},
+	} {
+		if scope := repository.Scope(testcase.repo); scope != testcase.repoScope {
+			t.Fatalf("unexpected repository scope for %q: %q != %q", testcase.repo, scope, testcase.repoScope)
+		}
+
+		if scope := namespace.Scope(testcase.repo); scope != testcase.nsScope {
+			t.Fatalf("unexpected namespace scope for %q: %q != %q", testcase.repo, scope, testcase.nsScope)
+		}
+	}
+}
+
+func TestEnforcerReserve(t *testing.T) {
+	enforcer := NewEnforcer(NewInMemoryUsageStore(), true, []Limit{
+		{Patterns: []string{"team-*"}, Size: 100},
+	})
+
+	if err := enforcer.Reserve("team-a/foo", 60); err != nil {
+		t.Fatalf("unexpected error reserving within limit: %v", err)
+	}
+
+	err := enforcer.Reserve("team-a/bar", 50)
+	quotaErr, ok := err.(distribution.ErrQuotaExceeded)
+	if !ok {
+		t.Fatalf("expected quota exceeded error, got %#v", err)
+	}
+
+	if quotaErr.Scope != "team-a" || quotaErr.Limit != 100 || quotaErr.Usage != 60 || quotaErr.Size != 50 {
+		t.Fatalf("unexpected quota error: %#v", quotaErr)
+	}
+
+	usage, err := enforcer.Usage("team-a/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting usage: %v", err)
+	}
+
+	if usage != (Usage{Scope: "team-a", Used: 60, Limit: 100}) {
+		t.Fatalf("rejected reservation was accounted: %#v", usage)
+	}
+
+	if err := enforcer.Release("team-a/foo", 20); err != nil {
+		t.Fatalf("unexpected error releasing: %v", err)
+	}
+
+	if err := enforcer.Reserve("team-a/bar", 50); err != nil {
+		t.Fatalf("unexpected error reserving after release: %v", err)
+	}
+
+	// Scopes without a matching limit are only accounted.
+	if err := enforcer.Reserve("other/foo", 1000); err != nil {
+		t.Fatalf("unexpected error reserving in unlimited scope: %v", err)
+	}
+
+	if usage, err := enforcer.Usage("other/foo"); err != nil || usage.Used != 1000 || usage.Limit != 0 {
+		t.Fatalf("unexpected usage for unlimited scope: %#v, %v", usage, err)
+	}
+}
+
+func TestEnforcerReset(t *testing.T) {
+	enforcer := NewEnforcer(NewInMemoryUsageStore(), true, nil)
+
+	if err := enforcer.Reserve("foo/stale", 1000); err != nil {
+		t.Fatalf("unexpected error reserving: %v", err)
+	}
+
+	if err := enforcer.Reset(map[string]int64{
+		"foo/a": 10,
+		"foo/b": 20,
+		"bar":   5,
+	}); err != nil {
+		t.Fatalf("unexpected error resetting: %v", err)
+	}
+
+	for repo, expected := range map[string]int64{"foo/a": 30, "bar": 5} {
+		usage, err := enforcer.Usage(repo)
+		if err != nil {
+			t.Fatalf("unexpected error getting usage: %v", err)
+		}
+
+		if usage.Used != expected {
+			t.Fatalf("unexpected usage for %q: %d != %d", repo, usage.Used, expected)
+		}
+	}
+}
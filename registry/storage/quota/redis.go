@@ -0,0 +1,48 @@
+package quota
+
+import "github.com/garyburd/redigo/redis"
+
+// redisUsageStore keeps usage in redis, sharing it between all registry
+// instances using the same redis database.
+type redisUsageStore struct {
+	pool *redis.Pool
+}
+
+// NewRedisUsageStore returns a UsageStore backed by the provided redis
+// connection pool.
+func NewRedisUsageStore(pool *redis.Pool) UsageStore {
+	return &redisUsageStore{
+		pool: pool,
+	}
+}
+
+func (rus *redisUsageStore) Usage(scope string) (int64, error) {
+	conn := rus.pool.Get()
+	defer conn.Close()
+
+	usage, err := redis.Int64(conn.Do("GET", rus.usageKey(scope)))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+
+	return usage, err
+}
+
+func (rus *redisUsageStore) Add(scope string, delta int64) (int64, error) {
+	conn := rus.pool.Get()
+	defer conn.Close()
+
+	return redis.Int64(conn.Do("INCRBY", rus.usageKey(scope), delta))
+}
+
+func (rus *redisUsageStore) Set(scope string, usage int64) error {
+	conn := rus.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("SET", rus.usageKey(scope), usage)
+	return err
+}
+
+func (rus *redisUsageStore) usageKey(scope string) string {
+	return "quota::" + scope
+}
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/docker/distribution/registry/storage/quota"
+	"github.com/docker/distribution/testutil"
+	"golang.org/x/net/context"
+)
+
+// TestQuotaEnforcedOnUpload ensures that layer uploads are accounted against
+// the quota of the repository namespace and rejected once the limit would be
+// exceeded.
+func TestQuotaEnforcedOnUpload(t *testing.T) {
+	rs, tarSumStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random reader: %v", err)
+	}
+	dgst := digest.Digest(tarSumStr)
+
+	size, err := seekerSize(rs)
+	if err != nil {
+		t.Fatalf("error getting seeker size of random data: %v", err)
+	}
+
+	ctx := context.Background()
+	driver := inmemory.New()
+	enforcer := quota.NewEnforcer(quota.NewInMemoryUsageStore(), true, []quota.Limit{
+		{Patterns: []string{"foo"}, Size: size + size/2},
+	})
+	registry := NewRegistryWithDriver(driver, nil, EnforceQuota(enforcer))
+
+	upload := func(name string) error {
+		repository, err := registry.Repository(ctx, name)
+		if err != nil {
+			t.Fatalf("unexpected error getting repo: %v", err)
+		}
+
+		if _, err := rs.Seek(0, os.SEEK_SET); err != nil {
+			t.Fatalf("unexpected error seeking: %v", err)
+		}
+
+		layerUpload, err := repository.Layers().Upload()
+		if err != nil {
+			t.Fatalf("unexpected error starting layer upload: %v", err)
+		}
+
+		if _, err := io.Copy(layerUpload, rs); err != nil {
+			t.Fatalf("unexpected error uploading layer data: %v", err)
+		}
+
+		_, err = layerUpload.Finish(dgst)
+		return err
+	}
+
+	checkUsage := func(name string, expected int64) {
+		usage, err := enforcer.Usage(name)
+		if err != nil {
+			t.Fatalf("unexpected error getting usage: %v", err)
+		}
+
+		if usage.Used != expected {
+			t.Fatalf("unexpected usage for %q: %d != %d", name, usage.Used, expected)
+		}
+	}
+
+	if err := upload("foo/aa"); err != nil {
+		t.Fatalf("unexpected error finishing upload within quota: %v", err)
+	}
+	checkUsage("foo/aa", size)
+
+	// Pushing a layer already in the repository is not charged again.
+	if err := upload("foo/aa"); err != nil {
+		t.Fatalf("unexpected error re-uploading layer: %v", err)
+	}
+	checkUsage("foo/aa", size)
+
+	err = upload("foo/bb")
+	if _, ok := err.(distribution.ErrQuotaExceeded); !ok {
+		t.Fatalf("expected quota exceeded error, got %#v", err)
+	}
+	checkUsage("foo/bb", size)
+
+	repository, err := registry.Repository(ctx, "foo/bb")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	if exists, err := repository.Layers().Exists(dgst); err != nil || exists {
+		t.Fatalf("layer rejected by quota was linked into repository: %v, %v", exists, err)
+	}
+
+	// Other namespaces are not limited.
+	if err := upload("bar/aa"); err != nil {
+		t.Fatalf("unexpected error uploading to unlimited namespace: %v", err)
+	}
+	checkUsage("bar/aa", size)
+}
+
+// TestQuotaConcurrentUploads ensures that concurrent uploads of the same
+// layer to a repository are accounted once.
+func TestQuotaConcurrentUploads(t *testing.T) {
+	rs, tarSumStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random reader: %v", err)
+	}
+	dgst := digest.Digest(tarSumStr)
+
+	size, err := seekerSize(rs)
+	if err != nil {
+		t.Fatalf("error getting seeker size of random data: %v", err)
+	}
+
+	ctx := context.Background()
+	enforcer := quota.NewEnforcer(quota.NewInMemoryUsageStore(), true, nil)
+	driver := slowMoveDriver{inmemory.New()}
+	registry := NewRegistryWithDriver(driver, nil, EnforceQuota(enforcer))
+
+	repository, err := registry.Repository(ctx, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	const uploads = 4
+	var layerUploads []distribution.LayerUpload
+	for i := 0; i < uploads; i++ {
+		if _, err := rs.Seek(0, os.SEEK_SET); err != nil {
+			t.Fatalf("unexpected error seeking: %v", err)
+		}
+
+		layerUpload, err := repository.Layers().Upload()
+		if err != nil {
+			t.Fatalf("unexpected error starting layer upload: %v", err)
+		}
+
+		if _, err := io.Copy(layerUpload, rs); err != nil {
+			t.Fatalf("unexpected error uploading layer data: %v", err)
+		}
+		layerUploads = append(layerUploads, layerUpload)
+	}
+
+	errs := make(chan error, uploads)
+	for _, layerUpload := range layerUploads {
+		go func(layerUpload distribution.LayerUpload) {
+			_, err := layerUpload.Finish(dgst)
+			errs <- err
+		}(layerUpload)
+	}
+
+	for i := 0; i < uploads; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("unexpected error finishing upload: %v", err)
+		}
+	}
+
+	usage, err := enforcer.Usage("foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting usage: %v", err)
+	}
+
+	if usage.Used != size {
+		t.Fatalf("unexpected usage: %d != %d", usage.Used, size)
+	}
+}
+
+// slowMoveDriver delays moves, widening the window between accounting for a
+// layer and linking it.
+type slowMoveDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d slowMoveDriver) Move(sourcePath string, destPath string) error {
+	time.Sleep(10 * time.Millisecond)
+	return d.StorageDriver.Move(sourcePath, destPath)
+}
+
+// TestRebuildQuotaUsage ensures usage is recalculated from layer links.
+func TestRebuildQuotaUsage(t *testing.T) {
+	driver := inmemory.New()
+	enforcer := quota.NewEnforcer(quota.NewInMemoryUsageStore(), false, nil)
+
+	// Rebuilding an empty registry is not an error.
+	if err := RebuildQuotaUsage(driver, enforcer); err != nil {
+		t.Fatalf("unexpected error rebuilding empty registry: %v", err)
+	}
+
+	expected := make(map[string]int64)
+	for _, name := range []string{"foo/bar", "foo/bar", "baz"} {
+		rs, dgst, alias, err := writeRandomLayer(driver, defaultPathMapper, name)
+		if err != nil {
+			t.Fatalf("unexpected error writing layer: %v", err)
+		}
+
+		size, err := seekerSize(rs)
+		if err != nil {
+			t.Fatalf("error getting seeker size of random data: %v", err)
+		}
+
+		// Link the layer under a second digest to ensure blobs are only
+		// counted once.
+		aliasLinkPath, err := defaultPathMapper.path(layerLinkPathSpec{name: name, digest: alias})
+		if err != nil {
+			t.Fatalf("unexpected error getting link path: %v", err)
+		}
+
+		if err := driver.PutContent(aliasLinkPath, []byte(dgst)); err != nil {
+			t.Fatalf("unexpected error writing link: %v", err)
+		}
+
+		expected[name] += size
+	}
+
+	if err := enforcer.Reserve("foo/bar", 12345); err != nil {
+		t.Fatalf("unexpected error reserving: %v", err)
+	}
+
+	if err := RebuildQuotaUsage(driver, enforcer); err != nil {
+		t.Fatalf("unexpected error rebuilding usage: %v", err)
+	}
+
+	for name, size := range expected {
+		usage, err := enforcer.Usage(name)
+		if err != nil {
+			t.Fatalf("unexpected error getting usage: %v", err)
+		}
+
+		if usage.Used != size {
+			t.Fatalf("unexpected usage for %q: %d != %d", name, usage.Used, size)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package storage
 
 import (
 	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/registry/api/v2"
 	"github.com/docker/distribution/registry/storage/cache"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/quota"
+	"github.com/docker/libtrust"
 	"golang.org/x/net/context"
 )
 
@@ -15,25 +18,93 @@ type registry struct {
 	pm             *pathMapper
 	blobStore      *blobStore
 	layerInfoCache cache.LayerInfoCache
+	quota          *quota.Enforcer
+	linkLocks      layerLinkLocks
+	immutableTags  []ImmutableTagRule
+	trust          TrustPolicy
+	signingKey     libtrust.PrivateKey
+
+	// digestAlgorithm is the algorithm of the canonical digests identifying
+	// layer blobs.
+	digestAlgorithm string
+
+	// rejectTarSum disables accepting tarsum digests for layer uploads.
+	rejectTarSum bool
+}
+
+// RegistryOption configures optional behavior of a registry created with
+// NewRegistryWithDriver.
+type RegistryOption func(*registry)
+
+// EnforceQuota returns a RegistryOption that accounts for layers pushed to
+// repositories with the enforcer, rejecting layers that would exceed the
+// quota of the repository.
+func EnforceQuota(enforcer *quota.Enforcer) RegistryOption {
+	return func(reg *registry) {
+		reg.quota = enforcer
+	}
+}
+
+// ImmutableTagRule protects tags matching any of the Tags glob patterns in
+// repositories matching any of the Repositories glob patterns. An empty list
+// of repositories matches all repositories.
+type ImmutableTagRule struct {
+	Repositories []string
+	Tags         []string
+}
+
+// ImmutableTags returns a RegistryOption that makes tags matched by any of the
+// rules write-once: once set, they cannot be moved to a different revision or
+// removed. Setting a tag to the revision it already references succeeds.
+func ImmutableTags(rules ...ImmutableTagRule) RegistryOption {
+	return func(reg *registry) {
+		reg.immutableTags = append(reg.immutableTags, rules...)
+	}
+}
+
+// LayerDigestAlgorithm returns a RegistryOption that identifies uploaded
+// layers by the digest of their content with the registered digest
+// algorithm, rather than with digest.CanonicalAlgorithm. Layers remain
+// available under the digests provided by clients.
+func LayerDigestAlgorithm(alg string) RegistryOption {
+	return func(reg *registry) {
+		reg.digestAlgorithm = alg
+	}
+}
+
+// RejectTarSum returns a RegistryOption that rejects layer uploads identified
+// by tarsum digests, so that layers are only ever verified against digests of
+// their raw content. Layers already linked by tarsum remain available.
+func RejectTarSum() RegistryOption {
+	return func(reg *registry) {
+		reg.rejectTarSum = true
+	}
 }
 
 // NewRegistryWithDriver creates a new registry instance from the provided
 // driver. The resulting registry may be shared by multiple goroutines but is
-// cheap to allocate.
-func NewRegistryWithDriver(driver storagedriver.StorageDriver, layerInfoCache cache.LayerInfoCache) distribution.Namespace {
+// cheap to allocate. Options may be provided to configure optional behavior.
+func NewRegistryWithDriver(driver storagedriver.StorageDriver, layerInfoCache cache.LayerInfoCache, options ...RegistryOption) distribution.Namespace {
 	bs := &blobStore{
 		driver: driver,
 		pm:     defaultPathMapper,
 	}
 
-	return &registry{
+	reg := &registry{
 		driver:    driver,
 		blobStore: bs,
 
 		// TODO(sday): This should be configurable.
-		pm:             defaultPathMapper,
-		layerInfoCache: layerInfoCache,
+		pm:              defaultPathMapper,
+		layerInfoCache:  layerInfoCache,
+		digestAlgorithm: digest.CanonicalAlgorithm,
+	}
+
+	for _, option := range options {
+		option(reg)
 	}
+
+	return reg
 }
 
 // Scope returns the namespace scope for a registry. The registry
@@ -87,6 +158,15 @@ func (repo *repository) Manifests() distribution.ManifestService {
 	}
 }
 
+// Tags returns an instance of the TagService. Instantiation is cheap and may be
+// context sensitive in the future. The instance should be used similar to a
+// request local.
+func (repo *repository) Tags() distribution.TagService {
+	return &tagStore{
+		repository: repo,
+	}
+}
+
 // Layers returns an instance of the LayerService. Instantiation is cheap and
 // may be context sensitive in the future. The instance should be used similar
 // to a request local.
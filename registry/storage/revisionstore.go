@@ -1,12 +1,10 @@
 package storage
 
 import (
-	"encoding/json"
-
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
-	"github.com/docker/distribution/manifest"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/libtrust"
 )
 
@@ -34,8 +32,22 @@ func (rs *revisionStore) exists(revision digest.Digest) (bool, error) {
 	return exists, nil
 }
 
-// get retrieves the manifest, keyed by revision digest.
-func (rs *revisionStore) get(revision digest.Digest) (*manifest.SignedManifest, error) {
+// signedManifest is implemented by manifests carrying detached signatures,
+// such as schema version 1. The signed content and the signatures are stored
+// separately so that signatures from several pushes can be merged.
+type signedManifest interface {
+	distribution.Manifest
+
+	// Canonical returns the signed content, without signatures.
+	Canonical() ([]byte, error)
+
+	// Signatures returns the opaque jws signatures of the content.
+	Signatures() ([][]byte, error)
+}
+
+// get retrieves the manifest, keyed by revision digest. The stored content
+// determines the type of the returned manifest.
+func (rs *revisionStore) get(revision digest.Digest) (distribution.Manifest, error) {
 	// Ensure that this revision is available in this repository.
 	if exists, err := rs.exists(revision); err != nil {
 		return nil, err
@@ -51,36 +63,46 @@ func (rs *revisionStore) get(revision digest.Digest) (*manifest.SignedManifest,
 		return nil, err
 	}
 
-	// Fetch the signatures for the manifest
+	// Fetch the signatures for the manifest, if any were stored.
 	signatures, err := rs.Signatures().Get(revision)
 	if err != nil {
-		return nil, err
-	}
-
-	jsig, err := libtrust.NewJSONSignature(content, signatures...)
-	if err != nil {
-		return nil, err
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return nil, err
+		}
 	}
 
-	// Extract the pretty JWS
-	raw, err := jsig.PrettySignature("signatures")
-	if err != nil {
-		return nil, err
-	}
+	if len(signatures) > 0 {
+		jsig, err := libtrust.NewJSONSignature(content, signatures...)
+		if err != nil {
+			return nil, err
+		}
 
-	var sm manifest.SignedManifest
-	if err := json.Unmarshal(raw, &sm); err != nil {
-		return nil, err
+		// Extract the pretty JWS
+		content, err = jsig.PrettySignature("signatures")
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return &sm, nil
+	m, _, err := distribution.UnmarshalManifest("", content)
+	return m, err
 }
 
 // put stores the manifest in the repository, if not already present. Any
 // updated signatures will be stored, as well.
-func (rs *revisionStore) put(sm *manifest.SignedManifest) (digest.Digest, error) {
+func (rs *revisionStore) put(mnfst distribution.Manifest) (digest.Digest, error) {
+	sm, signed := mnfst.(signedManifest)
+
 	// Resolve the payload in the manifest.
-	payload, err := sm.Payload()
+	var (
+		payload []byte
+		err     error
+	)
+	if signed {
+		payload, err = sm.Canonical()
+	} else {
+		_, payload, err = mnfst.Payload()
+	}
 	if err != nil {
 		return "", err
 	}
@@ -97,6 +119,10 @@ func (rs *revisionStore) put(sm *manifest.SignedManifest) (digest.Digest, error)
 		return "", err
 	}
 
+	if !signed {
+		return revision, nil
+	}
+
 	// Grab each json signature and store them.
 	signatures, err := sm.Signatures()
 	if err != nil {
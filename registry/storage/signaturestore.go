@@ -7,6 +7,7 @@ import (
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/context"
 	"github.com/docker/distribution/digest"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
 )
 
 type signatureStore struct {
@@ -113,3 +114,34 @@ func (s *signatureStore) Put(dgst digest.Digest, signatures ...[]byte) error {
 	}
 	return nil
 }
+
+// Verify checks the signatures stored for the manifest revision against the
+// trust policy of the repository.
+func (s *signatureStore) Verify(dgst digest.Digest) (distribution.SignatureVerification, error) {
+	rs := &revisionStore{repository: s.repository}
+	if exists, err := rs.exists(dgst); err != nil {
+		return distribution.SignatureVerification{}, err
+	} else if !exists {
+		return distribution.SignatureVerification{}, distribution.ErrUnknownManifestRevision{
+			Name:     s.Name(),
+			Revision: dgst,
+		}
+	}
+
+	payload, err := s.blobStore.get(dgst)
+	if err != nil {
+		return distribution.SignatureVerification{}, err
+	}
+
+	signatures, err := s.Get(dgst)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return distribution.SignatureVerification{}, err
+		}
+
+		// Manifests without detached signatures have none stored.
+		signatures = nil
+	}
+
+	return s.verifySignatures(payload, signatures), nil
+}
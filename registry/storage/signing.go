@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"encoding/json"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/libtrust"
+)
+
+// SignManifests returns a RegistryOption that adds a signature made with key
+// to every schema 1 manifest stored, alongside the signatures provided by the
+// client. Manifests are verified, including against the trust policy, before
+// they are signed.
+func SignManifests(key libtrust.PrivateKey) RegistryOption {
+	return func(reg *registry) {
+		reg.signingKey = key
+	}
+}
+
+// sign returns the manifest with a signature made with the registry signing
+// key added, unless the manifest or its stored revision already carries one.
+// Signing again on every push of the same revision would accumulate registry
+// signatures, since each signature is unique.
+func (ms *manifestStore) sign(sm *manifest.SignedManifest) (*manifest.SignedManifest, error) {
+	key := ms.repository.signingKey
+
+	payload, err := sm.Canonical()
+	if err != nil {
+		return nil, err
+	}
+
+	signatures, err := sm.Signatures()
+	if err != nil {
+		return nil, err
+	}
+
+	revision, err := digest.FromBytes(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := ms.repository.Signatures().Get(revision)
+	if _, ok := err.(storagedriver.PathNotFoundError); err != nil && !ok {
+		return nil, err
+	}
+
+	for _, signature := range append(stored, signatures...) {
+		js, err := libtrust.NewJSONSignature(payload, signature)
+		if err != nil {
+			continue
+		}
+
+		keys, err := js.Verify()
+		if err == nil && len(keys) == 1 && keys[0].KeyID() == key.KeyID() {
+			return sm, nil
+		}
+	}
+
+	js, err := libtrust.NewJSONSignature(payload, signatures...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := js.Sign(key); err != nil {
+		return nil, err
+	}
+
+	p, err := js.PrettySignature("signatures")
+	if err != nil {
+		return nil, err
+	}
+
+	var signed manifest.SignedManifest
+	if err := json.Unmarshal(p, &signed); err != nil {
+		return nil, err
+	}
+
+	return &signed, nil
+}
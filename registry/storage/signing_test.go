@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/registry/storage/cache"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/docker/libtrust"
+	"golang.org/x/net/context"
+)
+
+func TestSignManifests(t *testing.T) {
+	registryKey := generateTestKey(t)
+	clientKey := generateTestKey(t)
+
+	registry := NewRegistryWithDriver(inmemory.New(), cache.NewInMemoryLayerInfoCache(), SignManifests(registryKey))
+	repo, err := registry.Repository(context.Background(), "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	sm := createTestSignedManifest(t, repo, "latest", clientKey, nil)
+
+	// Pushing twice must not add a second registry signature.
+	for i := 0; i < 2; i++ {
+		if err := repo.Manifests().Put(sm, sm.Tag); err != nil {
+			t.Fatalf("unexpected error putting manifest: %v", err)
+		}
+	}
+
+	fetched, err := repo.Manifests().GetByTag(sm.Tag)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest: %v", err)
+	}
+
+	keys, err := manifest.Verify(fetched.(*manifest.SignedManifest))
+	if err != nil {
+		t.Fatalf("unexpected error verifying fetched manifest: %v", err)
+	}
+
+	var keyIDs []string
+	for _, key := range keys {
+		keyIDs = append(keyIDs, key.KeyID())
+	}
+
+	expected := []string{clientKey.KeyID(), registryKey.KeyID()}
+	sort.Strings(keyIDs)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(keyIDs, expected) {
+		t.Fatalf("unexpected signing keys: %v != %v", keyIDs, expected)
+	}
+}
+
+// TestSignManifestsUntrusted ensures that the registry signature is not
+// counted when verifying pushed manifests against the trust policy.
+func TestSignManifestsUntrusted(t *testing.T) {
+	registryKey := generateTestKey(t)
+
+	registry := NewRegistryWithDriver(inmemory.New(), cache.NewInMemoryLayerInfoCache(), SignManifests(registryKey), VerifyTrust(TrustPolicy{
+		Rules: []TrustRule{
+			{Keys: []libtrust.PublicKey{registryKey.PublicKey()}},
+		},
+		Enforce: true,
+	}))
+
+	repo, err := registry.Repository(context.Background(), "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	sm := createTestSignedManifest(t, repo, "latest", generateTestKey(t), nil)
+	if _, ok := repo.Manifests().Put(sm, sm.Tag).(distribution.ErrManifestVerification); !ok {
+		t.Fatalf("expected verification error putting untrusted manifest")
+	}
+
+	if _, err := repo.Tags().Get(sm.Tag); err == nil {
+		t.Fatalf("untrusted manifest was tagged")
+	}
+}
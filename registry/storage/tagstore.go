@@ -1,10 +1,17 @@
 package storage
 
 import (
+	"encoding/json"
+	"fmt"
 	"path"
+	"sort"
+	"time"
 
+	"code.google.com/p/go-uuid/uuid"
 	"github.com/docker/distribution"
+	ctxu "github.com/docker/distribution/context"
 	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/glob"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 )
 
@@ -13,8 +20,91 @@ type tagStore struct {
 	*repository
 }
 
-// tags lists the manifest tags for the specified repository.
-func (ts *tagStore) tags() ([]string, error) {
+var _ distribution.TagService = &tagStore{}
+
+// Get returns the descriptor of the manifest currently referenced by tag.
+func (ts *tagStore) Get(tag string) (distribution.Descriptor, error) {
+	ctxu.GetLogger(ts.ctx).Debug("(*tagStore).Get")
+	revision, err := ts.resolve(tag)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	rs := &revisionStore{repository: ts.repository}
+	m, err := rs.get(revision)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	mediaType, p, err := m.Payload()
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	_, desc, err := distribution.UnmarshalManifest(mediaType, p)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	return desc, nil
+}
+
+// Tag points tag at the manifest revision identified by desc, which must
+// already be stored in the repository.
+func (ts *tagStore) Tag(tag string, desc distribution.Descriptor) error {
+	ctxu.GetLogger(ts.ctx).Debug("(*tagStore).Tag")
+	rs := &revisionStore{repository: ts.repository}
+	exists, err := rs.exists(desc.Digest)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return distribution.ErrUnknownManifestRevision{
+			Name:     ts.Name(),
+			Revision: desc.Digest,
+		}
+	}
+
+	return ts.tag(tag, desc.Digest)
+}
+
+// Untag removes the tag, including the history of all revisions it has
+// referenced. The revisions themselves are left in place.
+func (ts *tagStore) Untag(tag string) error {
+	ctxu.GetLogger(ts.ctx).Debug("(*tagStore).Untag")
+	exists, err := ts.exists(tag)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return distribution.ErrManifestUnknown{Name: ts.Name(), Tag: tag}
+	}
+
+	if ts.immutable(tag) {
+		revision, err := ts.resolve(tag)
+		if err != nil {
+			return err
+		}
+
+		return distribution.ErrTagImmutable{Name: ts.Name(), Tag: tag, Revision: revision}
+	}
+
+	tagPath, err := ts.pm.path(manifestTagPathSpec{
+		name: ts.Name(),
+		tag:  tag,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ts.driver.Delete(tagPath)
+}
+
+// All lists the manifest tags for the specified repository.
+func (ts *tagStore) All() ([]string, error) {
+	ctxu.GetLogger(ts.ctx).Debug("(*tagStore).All")
 	p, err := ts.pm.path(manifestTagPathSpec{
 		name: ts.name,
 	})
@@ -42,6 +132,20 @@ func (ts *tagStore) tags() ([]string, error) {
 	return tags, nil
 }
 
+// Any returns true if the tags directory of the repository exists. Removing
+// every tag leaves the directory in place.
+func (ts *tagStore) Any() (bool, error) {
+	ctxu.GetLogger(ts.ctx).Debug("(*tagStore).Any")
+	p, err := ts.pm.path(manifestTagPathSpec{
+		name: ts.name,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return exists(ts.driver, p)
+}
+
 // exists returns true if the specified manifest tag exists in the repository.
 func (ts *tagStore) exists(tag string) (bool, error) {
 	tagPath, err := ts.pm.path(manifestTagCurrentPathSpec{
@@ -60,9 +164,67 @@ func (ts *tagStore) exists(tag string) (bool, error) {
 	return exists, nil
 }
 
+// History returns the revisions the tag has referenced, most recently tagged
+// first. A revision is listed each time the tag was pointed at it. Revisions
+// tagged before records were kept are listed last.
+func (ts *tagStore) History(tag string) ([]distribution.TagHistoryEntry, error) {
+	ctxu.GetLogger(ts.ctx).Debug("(*tagStore).History")
+	exists, err := ts.exists(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, distribution.ErrManifestUnknown{Name: ts.Name(), Tag: tag}
+	}
+
+	history, err := ts.records(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := make(map[digest.Digest]bool, len(history))
+	for _, entry := range history {
+		recorded[entry.Digest] = true
+	}
+
+	revisions, err := ts.revisions(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, revision := range revisions {
+		if !recorded[revision] {
+			history = append(history, distribution.TagHistoryEntry{Digest: revision})
+		}
+	}
+
+	return history, nil
+}
+
 // tag tags the digest with the given tag, updating the the store to point at
-// the current tag. The digest must point to a manifest.
+// the current tag. The digest must point to a manifest. Immutable tags may
+// only be set again to the revision they already reference.
+//
+// Storage drivers cannot update the tag atomically with the immutability
+// check. When an immutable tag is set for the first time, it is read back
+// after the update and the push fails if a concurrent push of another
+// revision won. A push that reads the tag back before a concurrent push
+// overwrites it still succeeds, leaving the tag at the later revision.
 func (ts *tagStore) tag(tag string, revision digest.Digest) error {
+	current, err := ts.resolve(tag)
+	switch err.(type) {
+	case nil:
+	case distribution.ErrManifestUnknown:
+		// The tag has not been set yet.
+	default:
+		return err
+	}
+
+	if ts.immutable(tag) && current != "" && current != revision {
+		return distribution.ErrTagImmutable{Name: ts.Name(), Tag: tag, Revision: current}
+	}
+
 	indexEntryPath, err := ts.pm.path(manifestTagIndexEntryLinkPathSpec{
 		name:     ts.Name(),
 		tag:      tag,
@@ -87,8 +249,31 @@ func (ts *tagStore) tag(tag string, revision digest.Digest) error {
 		return err
 	}
 
+	// Record when and by whom the tag was moved, leaving the record of
+	// repeated pushes of the current revision untouched.
+	if current != revision {
+		if err := ts.putRecord(tag, revision); err != nil {
+			return err
+		}
+	}
+
 	// Overwrite the current link
-	return ts.blobStore.link(currentPath, revision)
+	if err := ts.blobStore.link(currentPath, revision); err != nil {
+		return err
+	}
+
+	if ts.immutable(tag) && current == "" {
+		winner, err := ts.resolve(tag)
+		if err != nil {
+			return err
+		}
+
+		if winner != revision {
+			return distribution.ErrTagImmutable{Name: ts.Name(), Tag: tag, Revision: winner}
+		}
+	}
+
+	return nil
 }
 
 // resolve the current revision for name and tag.
@@ -127,32 +312,119 @@ func (ts *tagStore) revisions(tag string) ([]digest.Digest, error) {
 		return nil, err
 	}
 
-	// TODO(stevvooe): Need to append digest alg to get listing of revisions.
-	manifestTagIndexPath = path.Join(manifestTagIndexPath, "sha256")
-
-	entries, err := ts.driver.List(manifestTagIndexPath)
+	// The index is partitioned by digest algorithm.
+	algorithms, err := ts.driver.List(manifestTagIndexPath)
 	if err != nil {
 		return nil, err
 	}
 
 	var revisions []digest.Digest
-	for _, entry := range entries {
-		revisions = append(revisions, digest.NewDigestFromHex("sha256", path.Base(entry)))
+	for _, algorithmPath := range algorithms {
+		entries, err := ts.driver.List(algorithmPath)
+		if err != nil {
+			return nil, err
+		}
+
+		alg := path.Base(algorithmPath)
+		for _, entry := range entries {
+			revisions = append(revisions, digest.NewDigestFromHex(alg, path.Base(entry)))
+		}
 	}
 
 	return revisions, nil
 }
 
-// delete removes the tag from repository, including the history of all
-// revisions that have the specified tag.
-func (ts *tagStore) delete(tag string) error {
-	tagPath, err := ts.pm.path(manifestTagPathSpec{
+// tagRecord is the stored record of the tag being pointed at a revision.
+type tagRecord struct {
+	Digest    digest.Digest `json:"digest"`
+	Timestamp time.Time     `json:"timestamp"`
+	Actor     string        `json:"actor,omitempty"`
+}
+
+// putRecord appends a record that the tag was pointed at revision now, by the
+// user authenticated in the repository context, to the history of the tag.
+func (ts *tagStore) putRecord(tag string, revision digest.Digest) error {
+	record := tagRecord{
+		Digest:    revision,
+		Timestamp: time.Now().UTC(),
+		Actor:     ctxu.GetStringValue(ts.ctx, "auth.user.name"),
+	}
+
+	// Ids sort by the time of the record. The uuid keeps records made in the
+	// same instant, possibly by different instances, apart.
+	recordPath, err := ts.pm.path(manifestTagHistoryEntryPathSpec{
 		name: ts.Name(),
 		tag:  tag,
+		id:   fmt.Sprintf("%020d-%s", record.Timestamp.UnixNano(), uuid.New()),
 	})
 	if err != nil {
 		return err
 	}
 
-	return ts.driver.Delete(tagPath)
+	p, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return ts.driver.PutContent(recordPath, p)
+}
+
+// records returns the history entries recorded for the tag, most recent
+// first.
+func (ts *tagStore) records(tag string) ([]distribution.TagHistoryEntry, error) {
+	historyPath, err := ts.pm.path(manifestTagHistoryPathSpec{
+		name: ts.Name(),
+		tag:  tag,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	recordPaths, err := ts.driver.List(historyPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(recordPaths)))
+
+	history := make([]distribution.TagHistoryEntry, 0, len(recordPaths))
+	for _, recordPath := range recordPaths {
+		p, err := ts.driver.GetContent(recordPath)
+		if err != nil {
+			return nil, err
+		}
+
+		var record tagRecord
+		if err := json.Unmarshal(p, &record); err != nil {
+			return nil, err
+		}
+
+		history = append(history, distribution.TagHistoryEntry{
+			Digest:    record.Digest,
+			Timestamp: record.Timestamp,
+			Actor:     record.Actor,
+		})
+	}
+
+	return history, nil
+}
+
+// immutable returns true if an immutability rule of the registry protects the
+// tag in this repository.
+func (ts *tagStore) immutable(tag string) bool {
+	for _, rule := range ts.immutableTags {
+		if len(rule.Repositories) > 0 && !glob.MatchAny(rule.Repositories, ts.Name()) {
+			continue
+		}
+
+		if glob.MatchAny(rule.Tags, tag) {
+			return true
+		}
+	}
+
+	return false
 }
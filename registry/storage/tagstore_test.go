@@ -0,0 +1,443 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	ctxu "github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/registry/storage/cache"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"golang.org/x/net/context"
+)
+
+func TestTagService(t *testing.T) {
+	env := newManifestStoreTestEnv(t, "foo/bar", "thetag")
+	ms := env.repository.Manifests()
+	ts := env.repository.Tags()
+
+	deserialized, expected := createTestSchema2Manifest(t, env.repository, "amd64")
+	dgst := expected.Digest
+
+	// Tagging a manifest that has not been pushed must fail.
+	switch err := ts.Tag("latest", expected).(type) {
+	case distribution.ErrUnknownManifestRevision:
+		if err.Revision != dgst {
+			t.Fatalf("unexpected revision in error: %v != %v", err.Revision, dgst)
+		}
+	default:
+		t.Fatalf("expected unknown revision error tagging missing manifest: %#v", err)
+	}
+
+	// Push without a tag, then tag the revision twice.
+	if err := ms.Put(deserialized, ""); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	if any, err := ts.Any(); err != nil || any {
+		t.Fatalf("unexpected tags before tagging: %t, %v", any, err)
+	}
+
+	for _, tag := range []string{"latest", "v1"} {
+		if err := ts.Tag(tag, expected); err != nil {
+			t.Fatalf("unexpected error tagging %q: %v", tag, err)
+		}
+
+		desc, err := ts.Get(tag)
+		if err != nil {
+			t.Fatalf("unexpected error getting %q: %v", tag, err)
+		}
+
+		if !reflect.DeepEqual(desc, expected) {
+			t.Fatalf("unexpected descriptor for %q: %#v != %#v", tag, desc, expected)
+		}
+
+		fetched, err := ms.GetByTag(tag)
+		if err != nil {
+			t.Fatalf("unexpected error fetching manifest by tag %q: %v", tag, err)
+		}
+
+		if !reflect.DeepEqual(fetched, deserialized) {
+			t.Fatalf("fetched manifest not equal: %#v != %#v", fetched, deserialized)
+		}
+	}
+
+	if any, err := ts.Any(); err != nil || !any {
+		t.Fatalf("expected tags after tagging: %t, %v", any, err)
+	}
+
+	tags, err := ts.All()
+	if err != nil {
+		t.Fatalf("unexpected error listing tags: %v", err)
+	}
+
+	sort.Strings(tags)
+	if !reflect.DeepEqual(tags, []string{"latest", "v1"}) {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+
+	if err := ts.Untag("v1"); err != nil {
+		t.Fatalf("unexpected error untagging: %v", err)
+	}
+
+	if _, err := ts.Get("v1"); true {
+		if _, ok := err.(distribution.ErrManifestUnknown); !ok {
+			t.Fatalf("expected manifest unknown error after untag: %#v", err)
+		}
+	}
+
+	if _, ok := ts.Untag("v1").(distribution.ErrManifestUnknown); !ok {
+		t.Fatalf("expected manifest unknown error untagging missing tag")
+	}
+
+	// The revision must remain available after untagging.
+	if _, err := ms.Get(dgst); err != nil {
+		t.Fatalf("unexpected error fetching untagged manifest: %v", err)
+	}
+
+	tags, err = ts.All()
+	if err != nil {
+		t.Fatalf("unexpected error listing tags: %v", err)
+	}
+
+	if !reflect.DeepEqual(tags, []string{"latest"}) {
+		t.Fatalf("unexpected tags after untag: %v", tags)
+	}
+}
+
+func TestImmutableTags(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistryWithDriver(inmemory.New(), cache.NewInMemoryLayerInfoCache(), ImmutableTags(ImmutableTagRule{
+		Repositories: []string{"release/*"},
+		Tags:         []string{"v*"},
+	}))
+
+	repo, err := registry.Repository(ctx, "release/app")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	ms := repo.Manifests()
+	ts := repo.Tags()
+
+	first, firstDesc := createTestSchema2Manifest(t, repo, "amd64")
+	second, secondDesc := createTestSchema2Manifest(t, repo, "arm64")
+
+	for _, m := range []distribution.Manifest{first, second} {
+		if err := ms.Put(m, ""); err != nil {
+			t.Fatalf("unexpected error putting manifest: %v", err)
+		}
+	}
+
+	if err := ms.Put(first, "v1.0"); err != nil {
+		t.Fatalf("unexpected error tagging manifest: %v", err)
+	}
+
+	// Pushing the same revision again is idempotent.
+	if err := ms.Put(first, "v1.0"); err != nil {
+		t.Fatalf("unexpected error re-pushing manifest: %v", err)
+	}
+
+	if err := ts.Tag("v1.0", firstDesc); err != nil {
+		t.Fatalf("unexpected error re-tagging manifest: %v", err)
+	}
+
+	checkImmutable := func(err error) {
+		switch err := err.(type) {
+		case distribution.ErrTagImmutable:
+			if err.Tag != "v1.0" || err.Revision != firstDesc.Digest {
+				t.Fatalf("unexpected immutable tag error: %#v", err)
+			}
+		default:
+			t.Fatalf("expected immutable tag error: %#v", err)
+		}
+	}
+
+	// Every path moving or removing the tag must be rejected.
+	checkImmutable(ms.Put(second, "v1.0"))
+	checkImmutable(ts.Tag("v1.0", secondDesc))
+	checkImmutable(ts.Untag("v1.0"))
+
+	desc, err := ts.Get("v1.0")
+	if err != nil {
+		t.Fatalf("unexpected error getting tag: %v", err)
+	}
+
+	if desc.Digest != firstDesc.Digest {
+		t.Fatalf("immutable tag was moved: %v != %v", desc.Digest, firstDesc.Digest)
+	}
+
+	// Tags not matching the rule remain mutable.
+	if err := ms.Put(first, "latest"); err != nil {
+		t.Fatalf("unexpected error tagging manifest: %v", err)
+	}
+
+	if err := ms.Put(second, "latest"); err != nil {
+		t.Fatalf("unexpected error moving mutable tag: %v", err)
+	}
+
+	if err := ts.Untag("latest"); err != nil {
+		t.Fatalf("unexpected error removing mutable tag: %v", err)
+	}
+
+	// So are matching tags in other repositories.
+	other, err := registry.Repository(ctx, "library/app")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	first, firstDesc = createTestSchema2Manifest(t, other, "amd64")
+	second, secondDesc = createTestSchema2Manifest(t, other, "arm64")
+	for _, m := range []distribution.Manifest{first, second} {
+		if err := other.Manifests().Put(m, "v1.0"); err != nil {
+			t.Fatalf("unexpected error moving tag in unprotected repository: %v", err)
+		}
+	}
+}
+
+// racingDriver runs the hook after the first write to a path with the given
+// suffix, emulating a concurrent writer.
+type racingDriver struct {
+	storagedriver.StorageDriver
+	suffix string
+	hook   func()
+}
+
+func (d *racingDriver) PutContent(path string, content []byte) error {
+	if err := d.StorageDriver.PutContent(path, content); err != nil {
+		return err
+	}
+
+	if hook := d.hook; hook != nil && strings.HasSuffix(path, d.suffix) {
+		d.hook = nil
+		hook()
+	}
+
+	return nil
+}
+
+// TestImmutableTagConcurrentPush ensures that the first push of an immutable
+// tag fails if a concurrent push of another revision overwrites it.
+func TestImmutableTagConcurrentPush(t *testing.T) {
+	ctx := context.Background()
+	driver := &racingDriver{StorageDriver: inmemory.New(), suffix: "/_manifests/tags/v1.0/current/link"}
+	registry := NewRegistryWithDriver(driver, cache.NewInMemoryLayerInfoCache(), ImmutableTags(ImmutableTagRule{
+		Tags: []string{"v*"},
+	}))
+
+	repo, err := registry.Repository(ctx, "release/app")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	first, _ := createTestSchema2Manifest(t, repo, "amd64")
+	second, secondDesc := createTestSchema2Manifest(t, repo, "arm64")
+	for _, m := range []distribution.Manifest{first, second} {
+		if err := repo.Manifests().Put(m, ""); err != nil {
+			t.Fatalf("unexpected error putting manifest: %v", err)
+		}
+	}
+
+	// The concurrent push has already passed its immutability check, so it
+	// overwrites the link directly.
+	driver.hook = func() {
+		currentPath, err := defaultPathMapper.path(manifestTagCurrentPathSpec{name: repo.Name(), tag: "v1.0"})
+		if err != nil {
+			t.Fatalf("unexpected error building path: %v", err)
+		}
+
+		if err := driver.StorageDriver.PutContent(currentPath, []byte(secondDesc.Digest)); err != nil {
+			t.Fatalf("unexpected error overwriting tag: %v", err)
+		}
+	}
+
+	switch err := repo.Manifests().Put(first, "v1.0").(type) {
+	case distribution.ErrTagImmutable:
+		if err.Revision != secondDesc.Digest {
+			t.Fatalf("unexpected revision in immutable tag error: %v != %v", err.Revision, secondDesc.Digest)
+		}
+	default:
+		t.Fatalf("expected immutable tag error from losing push: %#v", err)
+	}
+}
+
+func TestTagHistory(t *testing.T) {
+	ctx := ctxu.WithValue(context.Background(), "auth.user.name", "alice")
+	registry := NewRegistryWithDriver(inmemory.New(), cache.NewInMemoryLayerInfoCache())
+	repo, err := registry.Repository(ctx, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	ms := repo.Manifests()
+	ts := repo.Tags()
+
+	if _, err := ts.History("latest"); true {
+		if _, ok := err.(distribution.ErrManifestUnknown); !ok {
+			t.Fatalf("expected manifest unknown error for missing tag: %#v", err)
+		}
+	}
+
+	first, firstDesc := createTestSchema2Manifest(t, repo, "amd64")
+	second, secondDesc := createTestSchema2Manifest(t, repo, "arm64")
+
+	before := time.Now()
+	for _, m := range []distribution.Manifest{first, second} {
+		if err := ms.Put(m, "latest"); err != nil {
+			t.Fatalf("unexpected error putting manifest: %v", err)
+		}
+	}
+
+	checkHistory := func(expected ...digest.Digest) []distribution.TagHistoryEntry {
+		history, err := ts.History("latest")
+		if err != nil {
+			t.Fatalf("unexpected error getting history: %v", err)
+		}
+
+		var digests []digest.Digest
+		for _, entry := range history {
+			digests = append(digests, entry.Digest)
+
+			if entry.Actor != "alice" {
+				t.Fatalf("unexpected actor: %q", entry.Actor)
+			}
+
+			if entry.Timestamp.Before(before) || entry.Timestamp.After(time.Now()) {
+				t.Fatalf("unexpected timestamp: %v", entry.Timestamp)
+			}
+		}
+
+		if !reflect.DeepEqual(digests, expected) {
+			t.Fatalf("unexpected history: %v != %v", digests, expected)
+		}
+
+		return history
+	}
+
+	history := checkHistory(secondDesc.Digest, firstDesc.Digest)
+
+	// Pushing the current revision again does not change the history.
+	if err := ms.Put(second, "latest"); err != nil {
+		t.Fatalf("unexpected error re-putting manifest: %v", err)
+	}
+
+	if !reflect.DeepEqual(checkHistory(secondDesc.Digest, firstDesc.Digest), history) {
+		t.Fatalf("history changed by repeated push")
+	}
+
+	// Moving the tag back records the revision again, keeping the earlier
+	// record.
+	if err := ts.Tag("latest", firstDesc); err != nil {
+		t.Fatalf("unexpected error tagging manifest: %v", err)
+	}
+
+	history = checkHistory(firstDesc.Digest, secondDesc.Digest, firstDesc.Digest)
+	if history[0].Timestamp.Before(history[1].Timestamp) || history[1].Timestamp.Before(history[2].Timestamp) {
+		t.Fatalf("history not ordered by time: %v", history)
+	}
+}
+
+// TestTagHistoryUnrecorded ensures that revisions in the tag index without
+// history records, under any digest algorithm, are listed last.
+func TestTagHistoryUnrecorded(t *testing.T) {
+	driver := inmemory.New()
+	registry := NewRegistryWithDriver(driver, cache.NewInMemoryLayerInfoCache())
+	repo, err := registry.Repository(context.Background(), "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	m, desc := createTestSchema2Manifest(t, repo, "amd64")
+	if err := repo.Manifests().Put(m, "latest"); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	unrecorded := digest.NewDigestFromHex("sha512", strings.Repeat("ab", 64))
+	indexEntryPath, err := defaultPathMapper.path(manifestTagIndexEntryLinkPathSpec{
+		name:     repo.Name(),
+		tag:      "latest",
+		revision: unrecorded,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error building path: %v", err)
+	}
+
+	if err := driver.PutContent(indexEntryPath, []byte(unrecorded)); err != nil {
+		t.Fatalf("unexpected error writing index entry: %v", err)
+	}
+
+	history, err := repo.Tags().History("latest")
+	if err != nil {
+		t.Fatalf("unexpected error getting history: %v", err)
+	}
+
+	if len(history) != 2 || history[0].Digest != desc.Digest || history[1].Digest != unrecorded {
+		t.Fatalf("unexpected history: %v", history)
+	}
+
+	if !history[1].Timestamp.IsZero() || history[1].Actor != "" {
+		t.Fatalf("unexpected record for unrecorded revision: %#v", history[1])
+	}
+}
+
+// createTestSchema2Manifest uploads a config blob for the architecture to the
+// repository, returning a schema 2 manifest referencing it along with the
+// descriptor of the manifest. The manifest itself is not stored.
+func createTestSchema2Manifest(t *testing.T, repo distribution.Repository, architecture string) (*schema2.DeserializedManifest, distribution.Descriptor) {
+	config := []byte(fmt.Sprintf(`{"architecture": %q, "os": "linux"}`, architecture))
+	configDigest, err := digest.FromBytes(config)
+	if err != nil {
+		t.Fatalf("unexpected error digesting config: %v", err)
+	}
+
+	upload, err := repo.Layers().Upload()
+	if err != nil {
+		t.Fatalf("unexpected error creating upload: %v", err)
+	}
+
+	if _, err := io.Copy(upload, bytes.NewReader(config)); err != nil {
+		t.Fatalf("unexpected error copying to upload: %v", err)
+	}
+
+	if _, err := upload.Finish(configDigest); err != nil {
+		t.Fatalf("unexpected error finishing upload: %v", err)
+	}
+
+	deserialized, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		MediaType: schema2.MediaTypeManifest,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeConfig,
+			Size:      int64(len(config)),
+			Digest:    configDigest,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating manifest: %v", err)
+	}
+
+	_, payload, err := deserialized.Payload()
+	if err != nil {
+		t.Fatalf("unexpected error getting payload: %v", err)
+	}
+
+	dgst, err := digest.FromBytes(payload)
+	if err != nil {
+		t.Fatalf("unexpected error digesting manifest: %v", err)
+	}
+
+	return deserialized, distribution.Descriptor{
+		MediaType: schema2.MediaTypeManifest,
+		Size:      int64(len(payload)),
+		Digest:    dgst,
+	}
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"crypto/x509"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/glob"
+	"github.com/docker/libtrust"
+)
+
+// TrustRule trusts manifest signatures made with one of Keys or by a
+// certificate chaining to one of CAs, for repositories matching any of the
+// Repositories glob patterns. An empty list of repositories matches all
+// repositories.
+type TrustRule struct {
+	Repositories []string
+	Keys         []libtrust.PublicKey
+	CAs          *x509.CertPool
+}
+
+// TrustPolicy requires manifests pushed to repositories matched by any of its
+// rules to carry at least one trusted signature.
+type TrustPolicy struct {
+	Rules []TrustRule
+
+	// Enforce rejects manifests without a trusted signature. Otherwise,
+	// such manifests are accepted and the verification result is only
+	// reported through the SignatureService.
+	Enforce bool
+}
+
+// VerifyTrust returns a RegistryOption that checks manifest signatures
+// against the policy.
+func VerifyTrust(policy TrustPolicy) RegistryOption {
+	return func(reg *registry) {
+		reg.trust = policy
+	}
+}
+
+// verifySignatures checks each of the signatures over payload against the
+// trust rules applying to the repository. Invalid signatures are ignored.
+func (repo *repository) verifySignatures(payload []byte, signatures [][]byte) distribution.SignatureVerification {
+	var rules []TrustRule
+	for _, rule := range repo.trust.Rules {
+		if len(rule.Repositories) == 0 || glob.MatchAny(rule.Repositories, repo.Name()) {
+			rules = append(rules, rule)
+		}
+	}
+
+	verification := distribution.SignatureVerification{
+		Required: len(rules) > 0,
+	}
+
+	for _, signature := range signatures {
+		js, err := libtrust.NewJSONSignature(payload, signature)
+		if err != nil {
+			continue
+		}
+
+		keys, err := js.Verify()
+		if err != nil || len(keys) != 1 {
+			continue
+		}
+
+		keyID := keys[0].KeyID()
+		verification.KeyIDs = append(verification.KeyIDs, keyID)
+
+		for _, rule := range rules {
+			if rule.trusts(js, keyID) {
+				verification.TrustedKeyIDs = append(verification.TrustedKeyIDs, keyID)
+				break
+			}
+		}
+	}
+
+	return verification
+}
+
+// trusts returns true if the verified single signature js, made with keyID,
+// was made with a trusted key or carries a chain to a trusted certificate
+// authority.
+func (rule TrustRule) trusts(js *libtrust.JSONSignature, keyID string) bool {
+	for _, key := range rule.Keys {
+		if key.KeyID() == keyID {
+			return true
+		}
+	}
+
+	if rule.CAs == nil {
+		return false
+	}
+
+	// Signatures without a chain verify without returning any chains.
+	chains, err := js.VerifyChains(rule.CAs)
+	return err == nil && len(chains) > 0
+}
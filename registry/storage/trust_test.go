@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"crypto/x509"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/registry/storage/cache"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/docker/distribution/testutil"
+	"github.com/docker/libtrust"
+	"golang.org/x/net/context"
+)
+
+func TestTrustPolicy(t *testing.T) {
+	trustedKey := generateTestKey(t)
+	untrustedKey := generateTestKey(t)
+	rootKey := generateTestKey(t)
+	leafKey := generateTestKey(t)
+
+	rootCert, err := libtrust.GenerateCACert(rootKey, rootKey.PublicKey())
+	if err != nil {
+		t.Fatalf("unexpected error generating root certificate: %v", err)
+	}
+
+	leafCert, err := libtrust.GenerateCACert(rootKey, leafKey.PublicKey())
+	if err != nil {
+		t.Fatalf("unexpected error generating leaf certificate: %v", err)
+	}
+
+	cas := x509.NewCertPool()
+	cas.AddCert(rootCert)
+
+	registry := NewRegistryWithDriver(inmemory.New(), cache.NewInMemoryLayerInfoCache(), VerifyTrust(TrustPolicy{
+		Rules: []TrustRule{
+			{
+				Repositories: []string{"signed/*"},
+				Keys:         []libtrust.PublicKey{trustedKey.PublicKey()},
+				CAs:          cas,
+			},
+		},
+		Enforce: true,
+	}))
+
+	repo, err := registry.Repository(context.Background(), "signed/app")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	// A manifest signed with an untrusted key must be rejected.
+	sm := createTestSignedManifest(t, repo, "untrusted", untrustedKey, nil)
+	switch err := repo.Manifests().Put(sm, sm.Tag).(type) {
+	case distribution.ErrManifestVerification:
+		if len(err) != 1 {
+			t.Fatalf("unexpected verification errors: %v", err)
+		}
+
+		untrusted, ok := err[0].(distribution.ErrManifestUntrusted)
+		if !ok {
+			t.Fatalf("expected untrusted manifest error: %#v", err[0])
+		}
+
+		if !reflect.DeepEqual(untrusted.KeyIDs, []string{untrustedKey.KeyID()}) {
+			t.Fatalf("unexpected key ids in error: %v", untrusted.KeyIDs)
+		}
+	default:
+		t.Fatalf("expected verification error putting untrusted manifest: %#v", err)
+	}
+
+	for _, tc := range []struct {
+		tag   string
+		key   libtrust.PrivateKey
+		chain []*x509.Certificate
+	}{
+		{tag: "key", key: trustedKey},
+		{tag: "chain", key: leafKey, chain: []*x509.Certificate{leafCert}},
+	} {
+		sm := createTestSignedManifest(t, repo, tc.tag, tc.key, tc.chain)
+		if err := repo.Manifests().Put(sm, sm.Tag); err != nil {
+			t.Fatalf("unexpected error putting manifest signed by %s: %v", tc.tag, err)
+		}
+
+		desc, err := repo.Tags().Get(tc.tag)
+		if err != nil {
+			t.Fatalf("unexpected error getting tag: %v", err)
+		}
+
+		verification, err := repo.Signatures().Verify(desc.Digest)
+		if err != nil {
+			t.Fatalf("unexpected error verifying signatures: %v", err)
+		}
+
+		expected := distribution.SignatureVerification{
+			KeyIDs:        []string{tc.key.KeyID()},
+			TrustedKeyIDs: []string{tc.key.KeyID()},
+			Required:      true,
+		}
+
+		if !reflect.DeepEqual(verification, expected) || !verification.Trusted() {
+			t.Fatalf("unexpected verification: %#v != %#v", verification, expected)
+		}
+	}
+
+	// Repositories not matched by any rule accept any signature.
+	other, err := registry.Repository(context.Background(), "library/app")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	sm = createTestSignedManifest(t, other, "untrusted", untrustedKey, nil)
+	if err := other.Manifests().Put(sm, sm.Tag); err != nil {
+		t.Fatalf("unexpected error putting manifest to unprotected repository: %v", err)
+	}
+
+	desc, err := other.Tags().Get(sm.Tag)
+	if err != nil {
+		t.Fatalf("unexpected error getting tag: %v", err)
+	}
+
+	verification, err := other.Signatures().Verify(desc.Digest)
+	if err != nil {
+		t.Fatalf("unexpected error verifying signatures: %v", err)
+	}
+
+	if verification.Required || len(verification.TrustedKeyIDs) != 0 || !verification.Trusted() {
+		t.Fatalf("unexpected verification in unprotected repository: %#v", verification)
+	}
+
+	unknown, err := digest.FromBytes([]byte("unknown"))
+	if err != nil {
+		t.Fatalf("unexpected error digesting: %v", err)
+	}
+
+	if _, err := other.Signatures().Verify(unknown); true {
+		if _, ok := err.(distribution.ErrUnknownManifestRevision); !ok {
+			t.Fatalf("expected unknown revision error verifying missing manifest: %#v", err)
+		}
+	}
+}
+
+func TestTrustPolicyNotEnforced(t *testing.T) {
+	registry := NewRegistryWithDriver(inmemory.New(), cache.NewInMemoryLayerInfoCache(), VerifyTrust(TrustPolicy{
+		Rules: []TrustRule{
+			{Keys: []libtrust.PublicKey{generateTestKey(t).PublicKey()}},
+		},
+	}))
+
+	repo, err := registry.Repository(context.Background(), "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	key := generateTestKey(t)
+	sm := createTestSignedManifest(t, repo, "latest", key, nil)
+	if err := repo.Manifests().Put(sm, sm.Tag); err != nil {
+		t.Fatalf("unexpected error putting untrusted manifest: %v", err)
+	}
+
+	desc, err := repo.Tags().Get(sm.Tag)
+	if err != nil {
+		t.Fatalf("unexpected error getting tag: %v", err)
+	}
+
+	verification, err := repo.Signatures().Verify(desc.Digest)
+	if err != nil {
+		t.Fatalf("unexpected error verifying signatures: %v", err)
+	}
+
+	if verification.Trusted() || !reflect.DeepEqual(verification.KeyIDs, []string{key.KeyID()}) {
+		t.Fatalf("unexpected verification: %#v", verification)
+	}
+}
+
+func generateTestKey(t *testing.T) libtrust.PrivateKey {
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	return pk
+}
+
+// createTestSignedManifest uploads a random layer to the repository and
+// returns a schema 1 manifest referencing it, signed with key and, if not
+// nil, the certificate chain.
+func createTestSignedManifest(t *testing.T, repo distribution.Repository, tag string, key libtrust.PrivateKey, chain []*x509.Certificate) *manifest.SignedManifest {
+	rs, ds, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("unexpected error generating test layer file: %v", err)
+	}
+	dgst := digest.Digest(ds)
+
+	upload, err := repo.Layers().Upload()
+	if err != nil {
+		t.Fatalf("unexpected error creating upload: %v", err)
+	}
+
+	if _, err := io.Copy(upload, rs); err != nil {
+		t.Fatalf("unexpected error copying to upload: %v", err)
+	}
+
+	if _, err := upload.Finish(dgst); err != nil {
+		t.Fatalf("unexpected error finishing upload: %v", err)
+	}
+
+	m := manifest.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 1,
+		},
+		Name:     repo.Name(),
+		Tag:      tag,
+		FSLayers: []manifest.FSLayer{{BlobSum: dgst}},
+	}
+
+	var sm *manifest.SignedManifest
+	if chain != nil {
+		sm, err = manifest.SignWithChain(&m, key, chain)
+	} else {
+		sm, err = manifest.Sign(&m, key)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+
+	return sm
+}